@@ -6,9 +6,15 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/http"
 	"os"
 	"time"
 
+	"cloud.google.com/go/storage"
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/minio/minio-go/v7"
+	"google.golang.org/api/googleapi"
+
 	"github.com/rudderlabs/rudder-go-kit/config"
 	"github.com/rudderlabs/rudder-go-kit/logger"
 	backendconfig "github.com/rudderlabs/rudder-server/backend-config"
@@ -208,3 +214,34 @@ func (it *ObjectIterator) Get() *FileObject {
 func (it *ObjectIterator) Err() error {
 	return it.err
 }
+
+// StatusCode returns the http status code of the response the object storage failed the request with, if the error is one of such a response.
+func StatusCode(err error) (int, bool) {
+	var minioErr minio.ErrorResponse
+	if errors.As(err, &minioErr) && minioErr.StatusCode != 0 {
+		return minioErr.StatusCode, true
+	}
+	var azureErr azblob.ResponseError
+	if errors.As(err, &azureErr) && azureErr.Response() != nil {
+		return azureErr.Response().StatusCode, true
+	}
+	var googleErr *googleapi.Error
+	if errors.As(err, &googleErr) {
+		return googleErr.Code, true
+	}
+	// e.g. the request failures of the aws sdk, for s3 and digital ocean spaces
+	var statusErr interface{ StatusCode() int }
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode(), true
+	}
+	return 0, false
+}
+
+// IsNotFound returns whether the error is the one of a missing object, whichever the object storage.
+func IsNotFound(err error) bool {
+	if errors.Is(err, ErrKeyNotFound) || errors.Is(err, storage.ErrObjectNotExist) {
+		return true
+	}
+	statusCode, ok := StatusCode(err)
+	return ok && statusCode == http.StatusNotFound
+}
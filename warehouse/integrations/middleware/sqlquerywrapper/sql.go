@@ -35,6 +35,13 @@ type DB struct {
 type Tx struct {
 	*sql.Tx
 	db *DB
+	// conn is closed once the transaction is committed or rolled back, if set
+	conn *sql.Conn
+}
+
+type Conn struct {
+	*sql.Conn
+	db *DB
 }
 
 func WithLogger(logger logger) Opt {
@@ -157,7 +164,7 @@ func (db *DB) Begin() (*Tx, error) {
 	if tx, err := db.DB.Begin(); err != nil {
 		return nil, err
 	} else {
-		return &Tx{tx, db}, nil
+		return &Tx{Tx: tx, db: db}, nil
 	}
 }
 
@@ -165,7 +172,42 @@ func (db *DB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*Tx, error) {
 	if tx, err := db.DB.BeginTx(ctx, opts); err != nil {
 		return nil, err
 	} else {
-		return &Tx{tx, db}, nil
+		return &Tx{Tx: tx, db: db}, nil
+	}
+}
+
+// Conn returns a single connection from the pool, waiting for one to be available until the context is done.
+func (db *DB) Conn(ctx context.Context) (*Conn, error) {
+	if conn, err := db.DB.Conn(ctx); err != nil {
+		return nil, err
+	} else {
+		return &Conn{conn, db}, nil
+	}
+}
+
+func (c *Conn) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	startedAt := time.Now()
+	result, err := c.Conn.ExecContext(ctx, query, args...)
+	c.db.logQuery(query, c.db.since(startedAt))
+	return result, err
+}
+
+func (c *Conn) BeginTx(ctx context.Context, opts *sql.TxOptions) (*Tx, error) {
+	if tx, err := c.Conn.BeginTx(ctx, opts); err != nil {
+		return nil, err
+	} else {
+		return &Tx{Tx: tx, db: c.db}, nil
+	}
+}
+
+// BeginTxAndRelease begins a transaction on the connection, closing the connection to return it to the pool once the transaction is committed or rolled back.
+// The connection is closed right away if the transaction could not begin.
+func (c *Conn) BeginTxAndRelease(ctx context.Context, opts *sql.TxOptions) (*Tx, error) {
+	if tx, err := c.Conn.BeginTx(ctx, opts); err != nil {
+		_ = c.Conn.Close()
+		return nil, err
+	} else {
+		return &Tx{Tx: tx, db: c.db, conn: c.Conn}, nil
 	}
 }
 
@@ -214,6 +256,7 @@ func (tx *Tx) QueryRowContext(ctx context.Context, query string, args ...interfa
 func (tx *Tx) Rollback() error {
 	startedAt := time.Now()
 	err := tx.Tx.Rollback()
+	tx.release()
 	if elapsed := tx.db.since(startedAt); elapsed > tx.db.rollbackThreshold {
 		tx.db.logger.Warnw("rollback threshold exceeded", tx.db.keysAndValues...)
 	}
@@ -223,8 +266,17 @@ func (tx *Tx) Rollback() error {
 func (tx *Tx) Commit() error {
 	startedAt := time.Now()
 	err := tx.Tx.Commit()
+	tx.release()
 	if elapsed := tx.db.since(startedAt); elapsed > tx.db.commitThreshold {
 		tx.db.logger.Warnw("commit threshold exceeded", tx.db.keysAndValues...)
 	}
 	return err
 }
+
+// release closes the connection of the transaction, if it was begun by BeginTxAndRelease.
+// Closing an already released connection, as when rolling back a committed transaction, only fails with sql.ErrConnDone.
+func (tx *Tx) release() {
+	if tx.conn != nil {
+		_ = tx.conn.Close()
+	}
+}
@@ -0,0 +1,364 @@
+package postgreslegacy
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// null representations in the load files
+const (
+	// nullModeTrim treats fields which are empty after trimming spaces as null
+	nullModeTrim = "trim"
+	// nullModeSentinel treats only the fields equal to the null sentinel as null
+	nullModeSentinel = "sentinel"
+	// nullModeQuoted treats empty unquoted fields as null, while empty quoted fields are empty strings
+	nullModeQuoted = "quoted"
+)
+
+// whitespace trimming policies of the columns, configured with TrimColumns
+const (
+	// trimPolicyNullCheck only trims the values when checking whether they are null, copying them untrimmed
+	trimPolicyNullCheck = ""
+	// trimPolicyTrim trims the leading and trailing whitespace of the values before copying them
+	trimPolicyTrim = "trim"
+	// trimPolicyNone never trims the values, not even when checking whether they are null
+	trimPolicyNone = "none"
+)
+
+// errFieldTooLarge is returned when a field of a load file exceeds the configured MaxFieldSize.
+var errFieldTooLarge = errors.New("field too large")
+
+// loadFileReader reads the records from a load file, along with which of their fields were quoted.
+type loadFileReader interface {
+	Read() (record []string, quoted []bool, err error)
+}
+
+// newLoadFileReader returns the reader of the load file, which only tracks quoting for the quoted null mode.
+// Records must have fieldsPerRecord fields if it is positive, and fields must not exceed maxFieldSize bytes if it is positive.
+func newLoadFileReader(r io.Reader, nullMode string, fieldsPerRecord, maxFieldSize int) loadFileReader {
+	var input *recordInput
+	if maxFieldSize > 0 || nullMode == nullModeQuoted {
+		input = &recordInput{r: r, line: 1, maxFieldSize: maxFieldSize, trackQuotes: nullMode == nullModeQuoted}
+		r = input
+	}
+	csvReader := csv.NewReader(r)
+	csvReader.FieldsPerRecord = fieldsPerRecord
+	return &csvLoadFileReader{r: csvReader, input: input, maxFieldSize: maxFieldSize}
+}
+
+// csvLoadFileReader reads the records of a load file with encoding/csv, telling the quoted fields apart by their first byte in the input.
+type csvLoadFileReader struct {
+	r *csv.Reader
+	// input is the input of r if tracking quoting or bounding the fields, nil otherwise
+	input        *recordInput
+	maxFieldSize int
+}
+
+func (r *csvLoadFileReader) Read() ([]string, []bool, error) {
+	record, err := r.r.Read()
+	if err != nil {
+		return nil, nil, err
+	}
+	quoted := make([]bool, len(record))
+	if r.input == nil {
+		return record, quoted, nil
+	}
+
+	for i, field := range record {
+		line, column := r.r.FieldPos(i)
+		if r.maxFieldSize > 0 && len(field) > r.maxFieldSize {
+			startLine, _ := r.r.FieldPos(0)
+			return nil, nil, &csv.ParseError{StartLine: startLine, Line: line, Column: column, Err: fmt.Errorf("%w: exceeds %d bytes", errFieldTooLarge, r.maxFieldSize)}
+		}
+		if r.input.trackQuotes {
+			quoted[i] = r.input.quoted(line, column)
+		}
+	}
+	r.input.recordRead(r.r.InputOffset())
+	return record, quoted, nil
+}
+
+// recordInput is the input of encoding/csv, keeping the bytes read since the end of the last record for telling which of its fields were quoted.
+// As encoding/csv buffers whole records before splitting them into fields, it also fails with errFieldTooLarge once a run of bytes without separators
+// can only be a field exceeding maxFieldSize, so that such fields are not buffered whole. The fields with separators are checked once read.
+type recordInput struct {
+	r io.Reader
+	// buf is the bytes read from offset on, which starts the line numbered line
+	buf    []byte
+	offset int64
+	line   int
+	// run is the number of bytes read since the last field or line separator, which are all of the same field
+	run int
+
+	maxFieldSize int
+	trackQuotes  bool
+}
+
+func (in *recordInput) Read(p []byte) (int, error) {
+	n, err := in.r.Read(p)
+	if in.trackQuotes {
+		in.buf = append(in.buf, p[:n]...)
+	}
+	if in.maxFieldSize > 0 {
+		if boundErr := in.bound(p[:n]); boundErr != nil {
+			return n, boundErr
+		}
+	}
+	return n, err
+}
+
+// bound fails once the run of bytes without separators exceeds the size of a field of maxFieldSize bytes quoted with all its quotes escaped.
+func (in *recordInput) bound(p []byte) error {
+	maxQuotedSize := 2*in.maxFieldSize + 2
+	for _, c := range p {
+		if c == ',' || c == '\n' {
+			in.run = 0
+			continue
+		}
+		if in.run++; in.run > maxQuotedSize {
+			return fmt.Errorf("%w: exceeds %d bytes", errFieldTooLarge, in.maxFieldSize)
+		}
+	}
+	return nil
+}
+
+// quoted returns whether the field starting at the line and column of the record being read is quoted.
+func (in *recordInput) quoted(line, column int) bool {
+	start := 0
+	for l := in.line; l < line; l++ {
+		i := bytes.IndexByte(in.buf[start:], '\n')
+		if i < 0 {
+			return false
+		}
+		start += i + 1
+	}
+	i := start + column - 1
+	return i < len(in.buf) && in.buf[i] == '"'
+}
+
+// recordRead drops the bytes of the record read, which ends at the offset.
+func (in *recordInput) recordRead(offset int64) {
+	if !in.trackQuotes {
+		return
+	}
+	n := int(offset - in.offset)
+	in.line += bytes.Count(in.buf[:n], []byte{'\n'})
+	in.buf = in.buf[:copy(in.buf, in.buf[n:])]
+	in.offset = offset
+}
+
+// gzipMagic are the first bytes of gzip files.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// loadFilesReader reads the decompressed load files one after the other as a single stream, separating them with a newline.
+// Files are only opened once reached, reusing the same gzip reader, so that at most one of them is open at a time.
+// Files which are not gzipped are read as they are, as object stores serving the load files with a gzip Content-Encoding
+// can have them decompressed on download already.
+type loadFilesReader struct {
+	fileNames  []string
+	index      int
+	file       *os.File
+	gzipReader *gzip.Reader
+	// reader reads the decompressed file being read, which is either gzipReader or the file itself if not gzipped
+	reader io.Reader
+	// separate is whether the newline separating the file from the next one is still to be read
+	separate bool
+	// skipHeaders is whether the files start with a header line, which is skipped
+	skipHeaders bool
+	// inHeader is whether the header line of the file being read is still to be skipped
+	inHeader bool
+}
+
+// newLoadFilesReader opens the first of the load files, returning the *os.PathError of opening it or the error of reading its gzip header.
+// With skipHeaders, the first line of every file is skipped as its header, which is expected not to contain quoted newlines.
+func newLoadFilesReader(fileNames []string, skipHeaders bool) (*loadFilesReader, error) {
+	f := &loadFilesReader{fileNames: fileNames, skipHeaders: skipHeaders, inHeader: skipHeaders}
+	if err := f.open(); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// open opens the file at the index, reading it through the gzip reader if it is gzipped.
+func (f *loadFilesReader) open() error {
+	file, err := os.Open(f.fileNames[f.index])
+	if err != nil {
+		return err
+	}
+	f.file = file
+
+	buffered := bufio.NewReader(file)
+	if magic, err := buffered.Peek(len(gzipMagic)); err != nil || !bytes.Equal(magic, gzipMagic) {
+		f.reader = buffered
+		return nil
+	}
+	if f.gzipReader == nil {
+		f.gzipReader, err = gzip.NewReader(buffered)
+	} else {
+		err = f.gzipReader.Reset(buffered)
+	}
+	if err != nil {
+		return err
+	}
+	// load files can be written as concatenated gzip members, which are all read as a single stream
+	f.gzipReader.Multistream(true)
+	f.reader = f.gzipReader
+	return nil
+}
+
+// fileIndex returns the index of the file being read.
+// As the readers of the records buffer ahead, records close to the end of a file may be reported with the next one.
+func (f *loadFilesReader) fileIndex() int {
+	return f.index
+}
+
+// fileName returns the name of the file being read, see fileIndex.
+func (f *loadFilesReader) fileName() string {
+	return f.fileNames[f.index]
+}
+
+func (f *loadFilesReader) Read(p []byte) (int, error) {
+	for f.file != nil {
+		if f.separate {
+			if len(p) == 0 {
+				return 0, nil
+			}
+			f.separate = false
+			p[0] = '\n'
+			return 1, nil
+		}
+
+		n, err := f.reader.Read(p)
+		if f.inHeader && n > 0 {
+			if n = f.skipHeader(p[:n]); n == 0 && err == nil {
+				continue
+			}
+		}
+		if err != io.EOF {
+			return n, err
+		}
+		if err := f.next(); err != nil {
+			return n, err
+		}
+		if n > 0 {
+			return n, nil
+		}
+	}
+	return 0, io.EOF
+}
+
+// next closes the file being read and opens the next one, if any.
+func (f *loadFilesReader) next() error {
+	_ = f.file.Close()
+	f.file = nil
+	if f.index == len(f.fileNames)-1 {
+		return nil
+	}
+	f.index++
+
+	if err := f.open(); err != nil {
+		var pathErr *os.PathError
+		if errors.As(err, &pathErr) {
+			return err
+		}
+		return fmt.Errorf("reading gzip load file %s: %w", f.fileNames[f.index], err)
+	}
+	f.separate = true
+	f.inHeader = f.skipHeaders
+	return nil
+}
+
+// skipHeader drops the bytes of the header line from the start of the bytes read, returning the number of bytes left.
+func (f *loadFilesReader) skipHeader(p []byte) int {
+	i := bytes.IndexByte(p, '\n')
+	if i < 0 {
+		return 0
+	}
+	f.inHeader = false
+	return copy(p, p[i+1:])
+}
+
+// Close closes the file being read.
+func (f *loadFilesReader) Close() error {
+	if f.file == nil {
+		return nil
+	}
+	if f.gzipReader != nil {
+		_ = f.gzipReader.Close()
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+// rejoinTrailingFields joins the trailing fields of a record with more than the expected number of fields back into its last field,
+// recovering records whose last field contains delimiters the producer did not quote.
+// The rejoined field is unquoted, as its delimiters were, losing the quotes of the fields it is joined from.
+func rejoinTrailingFields(record []string, quoted []bool, fields int) ([]string, []bool) {
+	if fields <= 0 || len(record) <= fields {
+		return record, quoted
+	}
+	trailing := strings.Join(record[fields-1:], ",")
+	return append(record[:fields-1:fields-1], trailing), append(quoted[:fields-1:fields-1], false)
+}
+
+// writeCopyCSVRecord writes the record in the csv format of COPY ... TO STDOUT WITH CSV,
+// where null values are empty and the empty strings are quoted to tell them apart.
+func writeCopyCSVRecord(w io.Writer, record []sql.NullString) error {
+	var b strings.Builder
+	for i, field := range record {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		if !field.Valid {
+			continue
+		}
+		// COPY also quotes the end of data marker, which would otherwise end the data when copied back in
+		if field.String == "" || field.String == `\.` || strings.ContainsAny(field.String, ",\"\r\n") {
+			b.WriteByte('"')
+			b.WriteString(strings.ReplaceAll(field.String, `"`, `""`))
+			b.WriteByte('"')
+			continue
+		}
+		b.WriteString(field.String)
+	}
+	b.WriteByte('\n')
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// loadValue returns the value to be copied for the field of a load file, which is nil if the field represents null.
+// The value is trimmed according to the trim policy of its column, before checking whether it is null.
+func (pg *Postgres) loadValue(value string, quoted bool, trimPolicy string) interface{} {
+	if trimPolicy == trimPolicyTrim {
+		value = strings.TrimSpace(value)
+	}
+
+	switch pg.NullMode {
+	case nullModeSentinel:
+		if value == pg.NullSentinel {
+			return nil
+		}
+	case nullModeQuoted:
+		if value == "" && !quoted {
+			return nil
+		}
+	default:
+		if trimPolicy == trimPolicyNone && value == "" {
+			return nil
+		}
+		if trimPolicy != trimPolicyNone && strings.TrimSpace(value) == "" {
+			return nil
+		}
+	}
+	return value
+}
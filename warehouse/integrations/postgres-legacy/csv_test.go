@@ -0,0 +1,512 @@
+package postgreslegacy
+
+import (
+	"compress/gzip"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rudderlabs/rudder-go-kit/config"
+)
+
+func TestQuotedCSVReader(t *testing.T) {
+	type row struct {
+		record []string
+		quoted []bool
+	}
+
+	testCases := []struct {
+		name    string
+		input   string
+		want    []row
+		wantErr error
+	}{
+		{
+			name:  "empty quoted and unquoted fields",
+			input: "1,,\"\"\n2,\"a\",b\n",
+			want: []row{
+				{record: []string{"1", "", ""}, quoted: []bool{false, false, true}},
+				{record: []string{"2", "a", "b"}, quoted: []bool{false, true, false}},
+			},
+		},
+		{
+			name:  "escaped quotes, separators and newlines",
+			input: "\"a \"\"b\"\"\",\"c,d\",\"e\nf\"\r\n",
+			want: []row{
+				{record: []string{`a "b"`, "c,d", "e\nf"}, quoted: []bool{true, true, true}},
+			},
+		},
+		{
+			name:  "carriage returns",
+			input: "\"a\r\nb\",c\r\n\"d\re\",f\n",
+			want: []row{
+				{record: []string{"a\nb", "c"}, quoted: []bool{true, false}},
+				{record: []string{"d\re", "f"}, quoted: []bool{true, false}},
+			},
+		},
+		{
+			name:  "quoted fields after multiline ones",
+			input: "\"a\n\n\",b,\"c\"\n\n\nd,\"e\nf\",\"\"\n",
+			want: []row{
+				{record: []string{"a\n\n", "b", "c"}, quoted: []bool{true, false, true}},
+				{record: []string{"d", "e\nf", ""}, quoted: []bool{false, true, true}},
+			},
+		},
+		{
+			name:  "empty lines and no trailing newline",
+			input: "\n1,2\n\n3,\"\"",
+			want: []row{
+				{record: []string{"1", "2"}, quoted: []bool{false, false}},
+				{record: []string{"3", ""}, quoted: []bool{false, true}},
+			},
+		},
+		{
+			name:    "bare quote",
+			input:   "a\"b,c\n",
+			wantErr: csv.ErrBareQuote,
+		},
+		{
+			name:    "unterminated quote",
+			input:   "\"a,b\n",
+			wantErr: csv.ErrQuote,
+		},
+		{
+			name:    "text after closing quote",
+			input:   "\"a\"b,c\n",
+			wantErr: csv.ErrQuote,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			r := newLoadFileReader(strings.NewReader(tc.input), nullModeQuoted, 0, 0)
+
+			var rows []row
+			for {
+				record, quoted, err := r.Read()
+				if err == io.EOF {
+					break
+				}
+				if tc.wantErr != nil {
+					require.ErrorIs(t, err, tc.wantErr)
+					return
+				}
+				require.NoError(t, err)
+				rows = append(rows, row{record: record, quoted: quoted})
+			}
+			require.Nil(t, tc.wantErr)
+			require.Equal(t, tc.want, rows)
+		})
+	}
+}
+
+func TestLoadFileReader_MultistreamGzip(t *testing.T) {
+	filePath := writeMultistreamGzip(t, "load.csv.gz", "1,first\n2,first\n", "3,second\n")
+
+	f, err := os.Open(filePath)
+	require.NoError(t, err)
+	defer func() { _ = f.Close() }()
+
+	gzipReader, err := gzip.NewReader(f)
+	require.NoError(t, err)
+
+	for _, nullMode := range []string{nullModeTrim, nullModeQuoted} {
+		_, err := f.Seek(0, io.SeekStart)
+		require.NoError(t, err)
+		require.NoError(t, gzipReader.Reset(f))
+
+		var records [][]string
+		r := newLoadFileReader(gzipReader, nullMode, 0, 0)
+		for {
+			record, _, err := r.Read()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+			records = append(records, record)
+		}
+		require.Equal(t, [][]string{{"1", "first"}, {"2", "first"}, {"3", "second"}}, records, nullMode)
+	}
+}
+
+func TestLoadFileReader_FieldsPerRecord(t *testing.T) {
+	for _, nullMode := range []string{nullModeTrim, nullModeQuoted} {
+		for name, input := range map[string]string{
+			"more fields":  "1,a\n2,b,c\n",
+			"fewer fields": "1,a\n2\n",
+		} {
+			r := newLoadFileReader(strings.NewReader(input), nullMode, 2, 0)
+
+			record, _, err := r.Read()
+			require.NoError(t, err)
+			require.Equal(t, []string{"1", "a"}, record)
+
+			_, _, err = r.Read()
+			require.ErrorIs(t, err, csv.ErrFieldCount, "%s: %s", nullMode, name)
+		}
+	}
+}
+
+func TestRejoinTrailingFields(t *testing.T) {
+	for _, nullMode := range []string{nullModeTrim, nullModeQuoted} {
+		r := newLoadFileReader(strings.NewReader("1,a\n2,hello, world,again\n3,\"quoted\",b\n4\n"), nullMode, -1, 0)
+
+		var (
+			records [][]string
+			quotes  [][]bool
+		)
+		for {
+			record, quoted, err := r.Read()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+			record, quoted = rejoinTrailingFields(record, quoted, 2)
+			records = append(records, record)
+			quotes = append(quotes, quoted)
+		}
+
+		require.Equal(t, [][]string{
+			{"1", "a"},
+			{"2", "hello, world,again"},
+			{"3", "quoted,b"},
+			{"4"},
+		}, records, nullMode)
+		require.Equal(t, []bool{false, false}, quotes[2], nullMode)
+	}
+}
+
+// countingReader counts the bytes read from it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// repeatReader endlessly repeats the byte.
+type repeatReader byte
+
+func (r repeatReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = byte(r)
+	}
+	return len(p), nil
+}
+
+func TestLoadFileReader_MaxFieldSize(t *testing.T) {
+	const maxFieldSize = 1 << 20
+
+	for _, nullMode := range []string{nullModeTrim, nullModeQuoted} {
+		for name, prefix := range map[string]string{
+			"unquoted": "1,",
+			"quoted":   "1,\"",
+		} {
+			// a single field of 1GB
+			source := &countingReader{r: io.MultiReader(strings.NewReader(prefix), io.LimitReader(repeatReader('a'), 1<<30))}
+
+			var before, after runtime.MemStats
+			runtime.ReadMemStats(&before)
+
+			r := newLoadFileReader(source, nullMode, 2, maxFieldSize)
+			_, _, err := r.Read()
+
+			runtime.ReadMemStats(&after)
+
+			require.ErrorIs(t, err, errFieldTooLarge, "%s: %s", nullMode, name)
+			require.ErrorContains(t, err, "exceeds 1048576 bytes")
+
+			// reading stops right after the limit, which is twice the field size for its quotes to be escaped, without buffering the whole field
+			require.Less(t, source.n, int64(3*maxFieldSize), "%s: %s", nullMode, name)
+			// both encoding/csv and the tracking of quotes buffer the bytes read, growing their buffers by doubling them
+			require.Less(t, after.TotalAlloc-before.TotalAlloc, uint64(32*maxFieldSize), "%s: %s", nullMode, name)
+		}
+	}
+
+	t.Run("fields within the limit", func(t *testing.T) {
+		field := strings.Repeat("a", maxFieldSize)
+
+		r := newLoadFileReader(strings.NewReader("1,"+field+"\n"), nullModeTrim, 2, maxFieldSize)
+		record, _, err := r.Read()
+		require.NoError(t, err)
+		require.Equal(t, []string{"1", field}, record)
+	})
+
+	t.Run("fields with separators", func(t *testing.T) {
+		// quoted fields with separators are only checked once read
+		field := strings.Repeat("a,", maxFieldSize)
+
+		for _, nullMode := range []string{nullModeTrim, nullModeQuoted} {
+			r := newLoadFileReader(strings.NewReader("1,\""+field+"\"\n"), nullMode, 2, maxFieldSize)
+			_, _, err := r.Read()
+			require.ErrorIs(t, err, errFieldTooLarge, nullMode)
+
+			var parseErr *csv.ParseError
+			require.ErrorAs(t, err, &parseErr)
+			require.Equal(t, 3, parseErr.Column, nullMode)
+		}
+	})
+}
+
+// readLoadFiles reads the records of the load files, either merged into a single stream or one file after the other.
+func readLoadFiles(fileNames []string, merge bool, nullMode string, fieldsPerRecord int) (records [][]string, quoted [][]bool, err error) {
+	fileGroups := [][]string{fileNames}
+	if !merge {
+		fileGroups = nil
+		for _, fileName := range fileNames {
+			fileGroups = append(fileGroups, []string{fileName})
+		}
+	}
+
+	for _, fileGroup := range fileGroups {
+		loadFiles, err := newLoadFilesReader(fileGroup, false)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		r := newLoadFileReader(loadFiles, nullMode, fieldsPerRecord, 0)
+		for {
+			record, recordQuoted, err := r.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				_ = loadFiles.Close()
+				return nil, nil, err
+			}
+			records = append(records, record)
+			quoted = append(quoted, recordQuoted)
+		}
+		if err := loadFiles.Close(); err != nil {
+			return nil, nil, err
+		}
+	}
+	return records, quoted, nil
+}
+
+func TestLoadFilesReader(t *testing.T) {
+	fileNames := []string{
+		writeGzip(t, "first.csv.gz", "1,first\n2,\"\"\n"),
+		writeGzip(t, "empty.csv.gz", ""),
+		writeGzip(t, "no-trailing-newline.csv.gz", "3,"),
+		writeGzip(t, "crlf.csv.gz", "4,\"a\nb\"\r\n\r\n5,\" \"\r\n"),
+		writeMultistreamGzip(t, "multistream.csv.gz", "6,first\n", "7,second"),
+	}
+
+	for _, nullMode := range []string{nullModeTrim, nullModeQuoted} {
+		records, quoted, err := readLoadFiles(fileNames, false, nullMode, 2)
+		require.NoError(t, err)
+		require.Equal(t, [][]string{
+			{"1", "first"}, {"2", ""}, {"3", ""}, {"4", "a\nb"}, {"5", " "}, {"6", "first"}, {"7", "second"},
+		}, records, nullMode)
+
+		mergedRecords, mergedQuoted, err := readLoadFiles(fileNames, true, nullMode, 2)
+		require.NoError(t, err)
+		require.Equal(t, records, mergedRecords, nullMode)
+		require.Equal(t, quoted, mergedQuoted, nullMode)
+	}
+
+	t.Run("column count mismatch", func(t *testing.T) {
+		fileNames := []string{
+			writeGzip(t, "first.csv.gz", "1,first\n"),
+			writeGzip(t, "second.csv.gz", "2,second,extra\n"),
+		}
+
+		for _, nullMode := range []string{nullModeTrim, nullModeQuoted} {
+			for _, merge := range []bool{false, true} {
+				_, _, err := readLoadFiles(fileNames, merge, nullMode, 2)
+				require.ErrorIs(t, err, csv.ErrFieldCount, "%s: merge %t", nullMode, merge)
+			}
+		}
+	})
+
+	t.Run("file index", func(t *testing.T) {
+		loadFiles, err := newLoadFilesReader(fileNames[:3], false)
+		require.NoError(t, err)
+		defer func() { _ = loadFiles.Close() }()
+
+		require.Equal(t, 0, loadFiles.fileIndex())
+		require.Equal(t, fileNames[0], loadFiles.fileName())
+
+		content, err := io.ReadAll(loadFiles)
+		require.NoError(t, err)
+		// files are separated by a newline, even the empty ones
+		require.Equal(t, "1,first\n2,\"\"\n\n\n3,", string(content))
+		require.Equal(t, 2, loadFiles.fileIndex())
+		require.Equal(t, fileNames[2], loadFiles.fileName())
+	})
+
+	t.Run("headers", func(t *testing.T) {
+		fileNames := []string{
+			writeGzip(t, "first.csv.gz", "id,value\n1,first\n"),
+			writeGzip(t, "header-only.csv.gz", "id,value"),
+			writeGzip(t, "crlf.csv.gz", "id,value\r\n2,second\r\n"),
+			writeMultistreamGzip(t, "multistream.csv.gz", "id,va", "lue\n3,third\n"),
+		}
+
+		for _, oneByte := range []bool{false, true} {
+			loadFiles, err := newLoadFilesReader(fileNames, true)
+			require.NoError(t, err)
+
+			var r io.Reader = loadFiles
+			if oneByte {
+				r = iotest.OneByteReader(loadFiles)
+			}
+			content, err := io.ReadAll(r)
+			require.NoError(t, err)
+			require.Equal(t, "1,first\n\n\n2,second\r\n\n3,third\n", string(content), "one byte: %t", oneByte)
+			require.NoError(t, loadFiles.Close())
+		}
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		_, err := newLoadFilesReader([]string{"testdata/random.csv.gz"}, false)
+		var pathErr *os.PathError
+		require.ErrorAs(t, err, &pathErr)
+
+		loadFiles, err := newLoadFilesReader([]string{fileNames[0], "testdata/random.csv.gz"}, false)
+		require.NoError(t, err)
+		defer func() { _ = loadFiles.Close() }()
+
+		_, err = io.ReadAll(loadFiles)
+		require.ErrorAs(t, err, &pathErr)
+	})
+
+	t.Run("decompressed files", func(t *testing.T) {
+		// object stores serving the load files with a gzip Content-Encoding can have them decompressed on download already
+		decompressedFile := filepath.Join(t.TempDir(), "decompressed.csv.gz")
+		require.NoError(t, os.WriteFile(decompressedFile, []byte("2,decompressed\n"), 0o600))
+		emptyFile := filepath.Join(t.TempDir(), "empty.csv.gz")
+		require.NoError(t, os.WriteFile(emptyFile, nil, 0o600))
+
+		for _, merge := range []bool{false, true} {
+			records, _, err := readLoadFiles([]string{decompressedFile, fileNames[0], emptyFile, decompressedFile}, merge, nullModeTrim, 2)
+			require.NoError(t, err)
+			require.Equal(t, [][]string{{"2", "decompressed"}, {"1", "first"}, {"2", ""}, {"2", "decompressed"}}, records, "merge: %t", merge)
+		}
+	})
+
+	t.Run("invalid gzip", func(t *testing.T) {
+		// the gzip magic followed by an unknown compression method
+		invalidFile := filepath.Join(t.TempDir(), "invalid.csv.gz")
+		require.NoError(t, os.WriteFile(invalidFile, []byte("\x1f\x8b\x00not a gzipped load file\n"), 0o600))
+
+		_, err := newLoadFilesReader([]string{invalidFile}, false)
+		require.ErrorIs(t, err, gzip.ErrHeader)
+
+		loadFiles, err := newLoadFilesReader([]string{fileNames[0], invalidFile}, false)
+		require.NoError(t, err)
+		defer func() { _ = loadFiles.Close() }()
+
+		_, err = io.ReadAll(loadFiles)
+		require.ErrorIs(t, err, gzip.ErrHeader)
+	})
+}
+
+// BenchmarkLoadFilesReader compares reading many tiny load files one after the other with reading them merged.
+func BenchmarkLoadFilesReader(b *testing.B) {
+	fileNames := make([]string, 1000)
+	for i := range fileNames {
+		fileNames[i] = writeGzip(b, fmt.Sprintf("%d.csv.gz", i), fmt.Sprintf("%d,2023-01-01T00:00:00Z,%d,value\n", i, i))
+	}
+
+	for _, merge := range []bool{false, true} {
+		b.Run(fmt.Sprintf("merge=%t", merge), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				records, _, err := readLoadFiles(fileNames, merge, nullModeTrim, 4)
+				require.NoError(b, err)
+				require.Len(b, records, len(fileNames))
+			}
+		})
+	}
+}
+
+func TestLoadValue(t *testing.T) {
+	testCases := []struct {
+		name       string
+		nullMode   string
+		trimPolicy string
+		value      string
+		quoted     bool
+		want       interface{}
+	}{
+		{name: "trim empty", nullMode: nullModeTrim, value: "", want: nil},
+		{name: "trim spaces", nullMode: nullModeTrim, value: "  ", want: nil},
+		{name: "trim quoted empty", nullMode: nullModeTrim, value: "", quoted: true, want: nil},
+		{name: "trim value", nullMode: nullModeTrim, value: "a", want: "a"},
+		{name: "sentinel", nullMode: nullModeSentinel, value: `\N`, want: nil},
+		{name: "sentinel empty", nullMode: nullModeSentinel, value: "", want: ""},
+		{name: "sentinel spaces", nullMode: nullModeSentinel, value: "  ", want: "  "},
+		{name: "quoted empty", nullMode: nullModeQuoted, value: "", quoted: true, want: ""},
+		{name: "quoted spaces", nullMode: nullModeQuoted, value: "  ", want: "  "},
+		{name: "unquoted empty", nullMode: nullModeQuoted, value: "", want: nil},
+		{name: "trim value untrimmed", nullMode: nullModeTrim, value: " a ", want: " a "},
+		{name: "trim policy", nullMode: nullModeTrim, trimPolicy: trimPolicyTrim, value: " a\t", want: "a"},
+		{name: "trim policy spaces", nullMode: nullModeTrim, trimPolicy: trimPolicyTrim, value: "  ", want: nil},
+		{name: "trim policy quoted spaces", nullMode: nullModeQuoted, trimPolicy: trimPolicyTrim, value: "  ", quoted: true, want: ""},
+		{name: "trim policy sentinel", nullMode: nullModeSentinel, trimPolicy: trimPolicyTrim, value: ` \N `, want: nil},
+		{name: "none policy", nullMode: nullModeTrim, trimPolicy: trimPolicyNone, value: " a ", want: " a "},
+		{name: "none policy spaces", nullMode: nullModeTrim, trimPolicy: trimPolicyNone, value: "  ", want: "  "},
+		{name: "none policy empty", nullMode: nullModeTrim, trimPolicy: trimPolicyNone, value: "", want: nil},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			c := config.New()
+			c.Set("Warehouse.postgres.nullMode", tc.nullMode)
+
+			pg := New()
+			WithConfig(pg, c)
+
+			require.Equal(t, tc.want, pg.loadValue(tc.value, tc.quoted, tc.trimPolicy))
+		})
+	}
+}
+
+func TestNullModeConfig(t *testing.T) {
+	for nullMode, want := range map[string]string{
+		nullModeTrim:     nullModeTrim,
+		nullModeSentinel: nullModeSentinel,
+		nullModeQuoted:   nullModeQuoted,
+		"Quoted":         nullModeTrim,
+		"unknown":        nullModeTrim,
+	} {
+		c := config.New()
+		c.Set("Warehouse.postgres.nullMode", nullMode)
+
+		pg := New()
+		WithConfig(pg, c)
+		require.Equal(t, want, pg.NullMode, nullMode)
+	}
+}
+
+func TestWriteCopyCSVRecord(t *testing.T) {
+	var b strings.Builder
+	require.NoError(t, writeCopyCSVRecord(&b, []sql.NullString{
+		{String: "plain", Valid: true},
+		{},
+		{String: "", Valid: true},
+		{String: `with "quotes", and commas`, Valid: true},
+		{String: "multi\nline", Valid: true},
+		{String: `\.`, Valid: true},
+	}))
+	require.Equal(t, "plain,,\"\",\"with \"\"quotes\"\", and commas\",\"multi\nline\",\"\\.\"\n", b.String())
+}
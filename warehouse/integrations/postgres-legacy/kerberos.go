@@ -0,0 +1,92 @@
+package postgreslegacy
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jcmturner/gokrb5/v8/client"
+	krb5config "github.com/jcmturner/gokrb5/v8/config"
+	"github.com/jcmturner/gokrb5/v8/credentials"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+	"github.com/lib/pq"
+)
+
+// defaultKrb5ConfigPath is the kerberos config used if KRB5_CONFIG is not set, as with github.com/lib/pq/auth/kerberos.
+const defaultKrb5ConfigPath = "/etc/krb5.conf"
+
+// kerberosGSS is the GSSAPI provider of the gssapi auth mode, the default NewGSS.
+// Unlike github.com/lib/pq/auth/kerberos, which uses the credential cache of the process, it logs in with the keytab or credential cache of the destination.
+type kerberosGSS struct {
+	client *client.Client
+}
+
+// newKerberosGSS logs in with the keytab of the credentials if set, otherwise with their credential cache.
+// The client principal of the keytab is the user, in the default realm of the kerberos config unless the user has one.
+func newKerberosGSS(cred Credentials) (pq.GSS, error) {
+	cfgPath := os.Getenv("KRB5_CONFIG")
+	if cfgPath == "" {
+		cfgPath = defaultKrb5ConfigPath
+	}
+	cfg, err := krb5config.Load(cfgPath)
+	if err != nil {
+		return nil, fmt.Errorf("loading kerberos config: %w", err)
+	}
+
+	var cl *client.Client
+	switch {
+	case cred.KeytabPath != "":
+		kt, err := keytab.Load(cred.KeytabPath)
+		if err != nil {
+			return nil, fmt.Errorf("loading kerberos keytab: %w", err)
+		}
+		username, realm := cred.User, cfg.LibDefaults.DefaultRealm
+		if i := strings.LastIndex(username, "@"); i >= 0 {
+			username, realm = username[:i], username[i+1:]
+		}
+		cl = client.NewWithKeytab(username, realm, kt, cfg, client.DisablePAFXFAST(true))
+	case cred.CCachePath != "":
+		ccache, err := credentials.LoadCCache(cred.CCachePath)
+		if err != nil {
+			return nil, fmt.Errorf("loading kerberos credential cache: %w", err)
+		}
+		if cl, err = client.NewFromCCache(ccache, cfg, client.DisablePAFXFAST(true)); err != nil {
+			return nil, fmt.Errorf("creating kerberos client from credential cache: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("gssapi auth mode requires %s or %s", krbKeytabPath, krbCCachePath)
+	}
+
+	if err := cl.Login(); err != nil {
+		return nil, fmt.Errorf("kerberos login: %w", err)
+	}
+	return &kerberosGSS{client: cl}, nil
+}
+
+func (g *kerberosGSS) GetInitToken(host, service string) ([]byte, error) {
+	return g.GetInitTokenFromSpn(service + "/" + host)
+}
+
+func (g *kerberosGSS) GetInitTokenFromSpn(spn string) ([]byte, error) {
+	token, err := spnego.SPNEGOClient(g.client, spn).InitSecContext()
+	if err != nil {
+		return nil, fmt.Errorf("kerberos init security context: %w", err)
+	}
+	b, err := token.Marshal()
+	if err != nil {
+		return nil, fmt.Errorf("kerberos marshaling token: %w", err)
+	}
+	return b, nil
+}
+
+func (*kerberosGSS) Continue(inToken []byte) (bool, []byte, error) {
+	var token spnego.SPNEGOToken
+	if err := token.Unmarshal(inToken); err != nil {
+		return true, nil, fmt.Errorf("kerberos unmarshaling token: %w", err)
+	}
+	if state := token.NegTokenResp.State(); state != spnego.NegStateAcceptCompleted {
+		return true, nil, fmt.Errorf("kerberos: expected state completed, got %d", state)
+	}
+	return true, nil, nil
+}
@@ -0,0 +1,4920 @@
+package postgreslegacy
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"cloud.google.com/go/storage"
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/lib/pq"
+	"github.com/minio/minio-go"
+	minio7 "github.com/minio/minio-go/v7"
+	"github.com/ory/dockertest/v3"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/exp/slices"
+	"google.golang.org/api/googleapi"
+
+	"github.com/rudderlabs/rudder-go-kit/config"
+	"github.com/rudderlabs/rudder-go-kit/logger"
+	"github.com/rudderlabs/rudder-go-kit/stats"
+	"github.com/rudderlabs/rudder-go-kit/stats/memstats"
+	"github.com/rudderlabs/rudder-server/services/filemanager"
+	"github.com/rudderlabs/rudder-server/testhelper/destination"
+	"github.com/rudderlabs/rudder-server/utils/misc"
+	sqlmiddleware "github.com/rudderlabs/rudder-server/warehouse/integrations/middleware/sqlquerywrapper"
+	"github.com/rudderlabs/rudder-server/warehouse/internal/model"
+	warehouseutils "github.com/rudderlabs/rudder-server/warehouse/utils"
+)
+
+type mockUploader struct {
+	schema model.Schema
+	// warehouseSchema defaults to schema if not set
+	warehouseSchema model.Schema
+	loadFiles       map[string][]warehouseutils.LoadFile
+}
+
+func (*mockUploader) GetSchemaInWarehouse() model.Schema { return model.Schema{} }
+func (*mockUploader) GetLocalSchema(context.Context) (model.Schema, error) {
+	return model.Schema{}, nil
+}
+func (*mockUploader) UpdateLocalSchema(context.Context, model.Schema) error { return nil }
+func (*mockUploader) ShouldOnDedupUseNewRecord() bool                       { return false }
+func (*mockUploader) UseRudderStorage() bool                                { return false }
+func (*mockUploader) GetLoadFileGenStartTIme() time.Time                    { return time.Time{} }
+func (*mockUploader) GetLoadFileType() string                               { return "CSV" }
+func (*mockUploader) GetFirstLastEvent() (time.Time, time.Time)             { return time.Time{}, time.Time{} }
+func (m *mockUploader) GetLoadFilesMetadata(_ context.Context, options warehouseutils.GetLoadFilesOptions) []warehouseutils.LoadFile {
+	return m.loadFiles[options.Table]
+}
+
+func (*mockUploader) GetSingleLoadFile(context.Context, string) (warehouseutils.LoadFile, error) {
+	return warehouseutils.LoadFile{}, nil
+}
+
+func (*mockUploader) GetSampleLoadFileLocation(context.Context, string) (string, error) {
+	return "", nil
+}
+
+func (m *mockUploader) GetTableSchemaInUpload(tableName string) model.TableSchema {
+	return m.schema[tableName]
+}
+
+func (m *mockUploader) GetTableSchemaInWarehouse(tableName string) model.TableSchema {
+	if m.warehouseSchema != nil {
+		return m.warehouseSchema[tableName]
+	}
+	return m.schema[tableName]
+}
+
+type mockFileManager struct {
+	filemanager.FileManager
+	objects map[string]string
+}
+
+func (m *mockFileManager) Download(_ context.Context, f *os.File, key string) error {
+	filePath, ok := m.objects[key]
+	if !ok {
+		return filemanager.ErrKeyNotFound
+	}
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(data)
+	return err
+}
+
+type mockFileManagerFactory struct {
+	fileManager filemanager.FileManager
+	err         error
+}
+
+func (m *mockFileManagerFactory) New(*filemanager.SettingsT) (filemanager.FileManager, error) {
+	return m.fileManager, m.err
+}
+
+const testBucketURL = "http://localhost:9000/testbucket/"
+
+// setupLoadFiles makes the local load files available for download through the uploader's load files metadata.
+// The metadata of missing files has no content length.
+func setupLoadFiles(pg *Postgres, uploader *mockUploader, loadFiles map[string][]string) {
+	objects := make(map[string]string)
+	uploader.loadFiles = make(map[string][]warehouseutils.LoadFile)
+
+	for tableName, filePaths := range loadFiles {
+		for i, filePath := range filePaths {
+			objectName := fmt.Sprintf("%s/%d-%s", tableName, i, filepath.Base(filePath))
+
+			var metadata json.RawMessage
+			if info, err := os.Stat(filePath); err == nil {
+				metadata = json.RawMessage(fmt.Sprintf(`{"content_length": %d}`, info.Size()))
+			}
+
+			objects[objectName] = filePath
+			uploader.loadFiles[tableName] = append(uploader.loadFiles[tableName], warehouseutils.LoadFile{
+				Location: testBucketURL + objectName,
+				Metadata: metadata,
+			})
+		}
+	}
+
+	pg.Uploader = uploader
+	pg.ObjectStorage = warehouseutils.MINIO
+	pg.Warehouse.Destination.Config = map[string]interface{}{
+		"bucketProvider": warehouseutils.MINIO,
+		"bucketName":     "testbucket",
+		"endPoint":       "localhost:9000",
+		"useSSL":         false,
+	}
+	pg.fileManagerFactory = &mockFileManagerFactory{
+		fileManager: &mockFileManager{objects: objects},
+	}
+}
+
+// writeGzipCSV writes the records as a gzipped csv file inside the test's temporary directory and returns its path.
+func writeGzipCSV(t *testing.T, name string, records [][]string) string {
+	t.Helper()
+
+	var b strings.Builder
+	require.NoError(t, csv.NewWriter(&b).WriteAll(records))
+
+	return writeGzip(t, name, b.String())
+}
+
+// writeGzip writes the content as a gzipped file inside the test's temporary directory and returns its path.
+func writeGzip(t testing.TB, name, content string) string {
+	t.Helper()
+
+	filePath := filepath.Join(t.TempDir(), name)
+
+	f, err := os.Create(filePath)
+	require.NoError(t, err)
+
+	gzWriter := gzip.NewWriter(f)
+	_, err = gzWriter.Write([]byte(content))
+	require.NoError(t, err)
+	require.NoError(t, gzWriter.Close())
+	require.NoError(t, f.Close())
+
+	return filePath
+}
+
+// writeMultistreamGzip writes every content as a separate gzip member of the same file inside the test's temporary directory and returns its path.
+func writeMultistreamGzip(t *testing.T, name string, contents ...string) string {
+	t.Helper()
+
+	filePath := filepath.Join(t.TempDir(), name)
+
+	f, err := os.Create(filePath)
+	require.NoError(t, err)
+
+	for _, content := range contents {
+		gzWriter := gzip.NewWriter(f)
+		_, err = gzWriter.Write([]byte(content))
+		require.NoError(t, err)
+		require.NoError(t, gzWriter.Close())
+	}
+	require.NoError(t, f.Close())
+
+	return filePath
+}
+
+var testTableSchema = model.TableSchema{
+	"id":          "string",
+	"received_at": "datetime",
+	"test_int":    "int",
+	"test_string": "string",
+}
+
+func createTestTable(t *testing.T, pg *Postgres, tableName string) {
+	t.Helper()
+
+	ctx := context.Background()
+
+	require.NoError(t, pg.CreateSchema(ctx))
+	require.NoError(t, pg.CreateTable(ctx, tableName, testTableSchema))
+}
+
+func TestLoadTableFromFiles(t *testing.T) {
+	misc.Init()
+	warehouseutils.Init()
+
+	const tableName = "test_table"
+
+	db := setupDB(t)
+	ctx := context.Background()
+
+	pg := newTestPostgres(db, config.New())
+	pg.Uploader = &mockUploader{
+		schema: model.Schema{
+			tableName: testTableSchema,
+		},
+	}
+	createTestTable(t, pg, tableName)
+
+	// columns are sorted: id, received_at, test_int, test_string
+	firstFile := writeGzipCSV(t, "first.csv.gz", [][]string{
+		{"1", "2023-01-01T00:00:00Z", "1", "first"},
+		{"2", "2023-01-01T00:00:00Z", "2", "first"},
+	})
+	secondFile := writeGzipCSV(t, "second.csv.gz", [][]string{
+		{"2", "2023-01-02T00:00:00Z", "2", "second"},
+		{"3", "2023-01-02T00:00:00Z", "3", "second"},
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		err := pg.LoadTableFromFiles(ctx, tableName, []string{firstFile, "testdata/random.csv.gz"})
+		require.ErrorContains(t, err, "validating load file: open testdata/random.csv.gz: no such file or directory")
+	})
+
+	t.Run("directory", func(t *testing.T) {
+		dir := t.TempDir()
+
+		err := pg.LoadTableFromFiles(ctx, tableName, []string{dir})
+		require.EqualError(t, err, fmt.Sprintf("validating load file: %s is a directory", dir))
+	})
+
+	t.Run("success", func(t *testing.T) {
+		err := pg.LoadTableFromFiles(ctx, tableName, []string{firstFile, secondFile})
+		require.NoError(t, err)
+
+		count, err := pg.GetTotalCountInTable(ctx, tableName)
+		require.NoError(t, err)
+		require.EqualValues(t, 3, count)
+
+		var value string
+		err = db.QueryRowContext(ctx, fmt.Sprintf(`SELECT test_string FROM %q.%q WHERE id = '2';`, testNamespace, tableName)).Scan(&value)
+		require.NoError(t, err)
+		require.Equal(t, "second", value)
+
+		require.FileExists(t, firstFile)
+		require.FileExists(t, secondFile)
+	})
+}
+
+// uploadLoadFiles uploads the local load files to minio, making them available for download through the uploader's load files metadata.
+// Unlike setupLoadFiles, the load files are downloaded with the file manager of the destination.
+func uploadLoadFiles(t *testing.T, pg *Postgres, uploader *mockUploader, minioResource *destination.MINIOResource, loadFiles map[string][]string) {
+	t.Helper()
+
+	uploader.loadFiles = make(map[string][]warehouseutils.LoadFile)
+
+	for tableName, filePaths := range loadFiles {
+		for _, filePath := range filePaths {
+			objectName := fmt.Sprintf("%s/%s-%s", tableName, warehouseutils.RandHex(), filepath.Base(filePath))
+
+			_, err := minioResource.Client.FPutObject(minioResource.BucketName, objectName, filePath, minio.PutObjectOptions{})
+			require.NoError(t, err)
+
+			uploader.loadFiles[tableName] = append(uploader.loadFiles[tableName], warehouseutils.LoadFile{
+				Location: fmt.Sprintf("http://%s/%s/%s", minioResource.Endpoint, minioResource.BucketName, objectName),
+			})
+		}
+	}
+
+	pg.Uploader = uploader
+	pg.ObjectStorage = warehouseutils.MINIO
+	pg.Warehouse.Destination.Config = map[string]interface{}{
+		"bucketProvider":  warehouseutils.MINIO,
+		"bucketName":      minioResource.BucketName,
+		"endPoint":        minioResource.Endpoint,
+		"accessKeyID":     minioResource.AccessKey,
+		"secretAccessKey": minioResource.SecretKey,
+		"useSSL":          false,
+	}
+}
+
+func TestLoadUserTables_MergeJSONTraits(t *testing.T) {
+	misc.Init()
+	warehouseutils.Init()
+
+	pool, err := dockertest.NewPool("")
+	require.NoError(t, err)
+
+	minioResource, err := destination.SetupMINIO(pool, t)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name       string
+		mergeJSON  bool
+		wantTraits string
+	}{
+		{
+			name:       "latest value replaces the traits",
+			wantTraits: `{"plan": "pro"}`,
+		},
+		{
+			name:       "traits are merged",
+			mergeJSON:  true,
+			wantTraits: `{"name": "alice", "plan": "pro"}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			db := setupDB(t)
+			ctx := context.Background()
+
+			c := config.New()
+			c.Set("Warehouse.postgres.mergeUsersJSONTraits", tc.mergeJSON)
+
+			pg := newTestPostgres(db, c)
+
+			uploader := &mockUploader{
+				schema: model.Schema{
+					warehouseutils.IdentifiesTable: {
+						"id":          "string",
+						"user_id":     "string",
+						"received_at": "datetime",
+						"traits":      "json",
+					},
+					warehouseutils.UsersTable: {
+						"id":          "string",
+						"received_at": "datetime",
+						"traits":      "json",
+					},
+				},
+			}
+			pg.Uploader = uploader
+
+			require.NoError(t, pg.CreateSchema(ctx))
+			for tableName, tableSchema := range uploader.schema {
+				require.NoError(t, pg.CreateTable(ctx, tableName, tableSchema))
+			}
+
+			// columns are sorted: id, received_at, traits, user_id
+			identifies := [][][]string{
+				{{"1", "2023-01-01T00:00:00Z", `{"name": "alice", "plan": "free"}`, "user_1"}},
+				{{"2", "2023-01-02T00:00:00Z", `{"plan": "pro"}`, "user_1"}},
+			}
+			for i, records := range identifies {
+				uploadLoadFiles(t, pg, uploader, minioResource, map[string][]string{
+					warehouseutils.IdentifiesTable: {writeGzipCSV(t, fmt.Sprintf("identifies-%d.csv.gz", i), records)},
+				})
+
+				errorsMap := pg.LoadUserTables(ctx)
+				require.NoError(t, errorsMap[warehouseutils.IdentifiesTable])
+				require.NoError(t, errorsMap[warehouseutils.UsersTable])
+			}
+
+			var traits string
+			err := db.QueryRowContext(ctx, fmt.Sprintf(`SELECT traits::text FROM %q.%q WHERE id = 'user_1';`, testNamespace, warehouseutils.UsersTable)).Scan(&traits)
+			require.NoError(t, err)
+			require.JSONEq(t, tc.wantTraits, traits)
+		})
+	}
+}
+
+// planLogger captures the execution plans logged for the statements.
+type planLogger struct {
+	logger.Logger
+
+	mu    sync.Mutex
+	plans []string
+}
+
+func (l *planLogger) Infof(format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if msg := fmt.Sprintf(format, args...); strings.Contains(msg, "Execution Query plan") {
+		l.plans = append(l.plans, msg)
+	}
+}
+
+func TestCreateIndexes(t *testing.T) {
+	misc.Init()
+	warehouseutils.Init()
+
+	const tableName = "test_table"
+
+	db := setupDB(t)
+	ctx := context.Background()
+
+	c := config.New()
+	c.Set("Warehouse.postgres.createIndexes", true)
+
+	pg := newTestPostgres(db, c)
+	createTestTable(t, pg, tableName)
+
+	// creating the table again should not fail on the existing indexes
+	require.NoError(t, pg.CreateTable(ctx, tableName, testTableSchema))
+
+	rows, err := db.QueryContext(ctx, `SELECT indexname FROM pg_indexes WHERE schemaname = $1 AND tablename = $2 ORDER BY indexname;`, testNamespace, tableName)
+	require.NoError(t, err)
+	defer func() { _ = rows.Close() }()
+
+	var indexes []string
+	for rows.Next() {
+		var index string
+		require.NoError(t, rows.Scan(&index))
+		indexes = append(indexes, index)
+	}
+	require.NoError(t, rows.Err())
+	require.Equal(t, []string{"test_table_id_idx", "test_table_received_at_idx"}, indexes)
+
+	// the table is too small for the planner to prefer the index over a sequential scan on its own,
+	// which is only disabled for the transaction so that the setting does not leak into the other connections
+	txn, err := db.BeginTx(ctx, &sql.TxOptions{})
+	require.NoError(t, err)
+	defer func() { _ = txn.Rollback() }()
+
+	_, err = txn.ExecContext(ctx, `SET LOCAL enable_seqscan = off;`)
+	require.NoError(t, err)
+
+	// the same join on the primary key as the dedup of the loads
+	planRows, err := txn.QueryContext(ctx, fmt.Sprintf(`EXPLAIN DELETE FROM %[1]q.%[2]q USING (VALUES ('1')) AS _source (id) WHERE _source.id = %[1]q.%[2]q.id;`, testNamespace, tableName))
+	require.NoError(t, err)
+	defer func() { _ = planRows.Close() }()
+
+	var plan []string
+	for planRows.Next() {
+		var line string
+		require.NoError(t, planRows.Scan(&line))
+		plan = append(plan, line)
+	}
+	require.NoError(t, planRows.Err())
+	require.Contains(t, strings.Join(plan, "\n"), "test_table_id_idx")
+}
+
+func TestSetLocalResourceLimits(t *testing.T) {
+	db := setupDB(t)
+	ctx := context.Background()
+
+	c := config.New()
+	c.Set("Warehouse.postgres.workMem", "64MB")
+	c.Set("Warehouse.postgres.tempFileLimit", "1GB")
+
+	pg := newTestPostgres(db, c)
+
+	var defaultWorkMem string
+	require.NoError(t, db.QueryRowContext(ctx, `SHOW work_mem;`).Scan(&defaultWorkMem))
+
+	txn, err := db.BeginTx(ctx, &sql.TxOptions{})
+	require.NoError(t, err)
+	require.NoError(t, pg.setLocalResourceLimits(ctx, txn))
+
+	var workMem, tempFileLimit string
+	require.NoError(t, txn.QueryRowContext(ctx, `SHOW work_mem;`).Scan(&workMem))
+	require.NoError(t, txn.QueryRowContext(ctx, `SHOW temp_file_limit;`).Scan(&tempFileLimit))
+	require.Equal(t, "64MB", workMem)
+	require.Equal(t, "1GB", tempFileLimit)
+	require.NoError(t, txn.Commit())
+
+	// the settings do not outlive the transaction
+	require.NoError(t, db.QueryRowContext(ctx, `SHOW work_mem;`).Scan(&workMem))
+	require.Equal(t, defaultWorkMem, workMem)
+
+	t.Run("invalid value", func(t *testing.T) {
+		pg := newTestPostgres(db, config.New())
+		pg.WorkMem = "a lot"
+
+		txn, err := db.BeginTx(ctx, &sql.TxOptions{})
+		require.NoError(t, err)
+		defer func() { _ = txn.Rollback() }()
+
+		require.ErrorContains(t, pg.setLocalResourceLimits(ctx, txn), "setting work_mem")
+	})
+}
+
+func TestTableNamePrefixAndSuffix(t *testing.T) {
+	misc.Init()
+	warehouseutils.Init()
+
+	const tableName = "tracks"
+
+	db := setupDB(t)
+	ctx := context.Background()
+
+	pg := newTestPostgres(db, config.New())
+	pg.Warehouse.Destination.Config = map[string]interface{}{
+		"tablePrefix": "stg_",
+		"tableSuffix": "_v1",
+	}
+	pg.Uploader = &mockUploader{
+		schema: model.Schema{
+			tableName: testTableSchema,
+		},
+	}
+	createTestTable(t, pg, tableName)
+	require.NoError(t, pg.AddColumns(ctx, tableName, []warehouseutils.ColumnInfo{{Name: "test_bool", Type: "boolean"}}))
+
+	// tables of other environments sharing the namespace
+	_, err := db.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE %q.%q (id text);`, testNamespace, "prod_tracks_v1"))
+	require.NoError(t, err)
+
+	schema, _, err := pg.FetchSchema(ctx)
+	require.NoError(t, err)
+	require.Equal(t, model.Schema{
+		tableName: {
+			"id":          "string",
+			"received_at": "datetime",
+			"test_int":    "int",
+			"test_string": "string",
+			"test_bool":   "boolean",
+		},
+	}, schema)
+
+	err = pg.LoadTableFromFiles(ctx, tableName, []string{
+		writeGzipCSV(t, "load.csv.gz", [][]string{
+			{"1", "2023-01-01T00:00:00Z", "1", "first"},
+			{"2", "2023-01-01T00:00:00Z", "2", "first"},
+		}),
+	})
+	require.NoError(t, err)
+
+	var count int64
+	err = db.QueryRowContext(ctx, fmt.Sprintf(`SELECT count(*) FROM %q.%q;`, testNamespace, "stg_tracks_v1")).Scan(&count)
+	require.NoError(t, err)
+	require.EqualValues(t, 2, count)
+
+	count, err = pg.GetTotalCountInTable(ctx, tableName)
+	require.NoError(t, err)
+	require.EqualValues(t, 2, count)
+
+	require.NoError(t, pg.DropTable(ctx, tableName))
+
+	schema, _, err = pg.FetchSchema(ctx)
+	require.NoError(t, err)
+	require.Empty(t, schema)
+}
+
+func TestLoadTableFromFiles_NullMode(t *testing.T) {
+	misc.Init()
+	warehouseutils.Init()
+
+	const tableName = "test_table"
+
+	db := setupDB(t)
+	ctx := context.Background()
+
+	// columns are sorted: id, received_at, test_int, test_string
+	loadFile := writeGzip(t, "load.csv.gz", `1,2023-01-01T00:00:00Z,1,""
+2,2023-01-01T00:00:00Z,2,
+3,2023-01-01T00:00:00Z,3,\N
+4,2023-01-01T00:00:00Z,4," "
+`)
+
+	testCases := []struct {
+		nullMode        string
+		wantTestStrings map[string]sql.NullString
+	}{
+		{
+			nullMode: nullModeTrim,
+			wantTestStrings: map[string]sql.NullString{
+				"1": {},
+				"2": {},
+				"3": {String: `\N`, Valid: true},
+				"4": {},
+			},
+		},
+		{
+			nullMode: nullModeSentinel,
+			wantTestStrings: map[string]sql.NullString{
+				"1": {String: "", Valid: true},
+				"2": {String: "", Valid: true},
+				"3": {},
+				"4": {String: " ", Valid: true},
+			},
+		},
+		{
+			nullMode: nullModeQuoted,
+			wantTestStrings: map[string]sql.NullString{
+				"1": {String: "", Valid: true},
+				"2": {},
+				"3": {String: `\N`, Valid: true},
+				"4": {String: " ", Valid: true},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.nullMode, func(t *testing.T) {
+			c := config.New()
+			c.Set("Warehouse.postgres.nullMode", tc.nullMode)
+
+			pg := newTestPostgres(db, c)
+			pg.Namespace = "test_namespace_" + tc.nullMode
+			pg.Uploader = &mockUploader{
+				schema: model.Schema{
+					tableName: testTableSchema,
+				},
+			}
+			createTestTable(t, pg, tableName)
+
+			require.NoError(t, pg.LoadTableFromFiles(ctx, tableName, []string{loadFile}))
+
+			rows, err := db.QueryContext(ctx, fmt.Sprintf(`SELECT id, test_string FROM %q.%q;`, pg.Namespace, tableName))
+			require.NoError(t, err)
+			defer func() { _ = rows.Close() }()
+
+			testStrings := make(map[string]sql.NullString)
+			for rows.Next() {
+				var (
+					id         string
+					testString sql.NullString
+				)
+				require.NoError(t, rows.Scan(&id, &testString))
+				testStrings[id] = testString
+			}
+			require.NoError(t, rows.Err())
+			require.Equal(t, tc.wantTestStrings, testStrings)
+		})
+	}
+}
+
+func TestLoadTableFromFiles_TrimColumns(t *testing.T) {
+	misc.Init()
+	warehouseutils.Init()
+
+	const tableName = "test_table"
+
+	db := setupDB(t)
+	ctx := context.Background()
+
+	// columns are sorted: id, received_at, test_int, test_string
+	loadFile := writeGzip(t, "load.csv.gz", `1,2023-01-01T00:00:00Z,1," padded "
+2,2023-01-01T00:00:00Z,2,"  "
+3,2023-01-01T00:00:00Z,3,unpadded
+`)
+
+	testCases := []struct {
+		name            string
+		trimPolicy      string
+		wantTestStrings map[string]sql.NullString
+	}{
+		{
+			name: "default",
+			wantTestStrings: map[string]sql.NullString{
+				"1": {String: " padded ", Valid: true},
+				"2": {},
+				"3": {String: "unpadded", Valid: true},
+			},
+		},
+		{
+			name:       "trim",
+			trimPolicy: trimPolicyTrim,
+			wantTestStrings: map[string]sql.NullString{
+				"1": {String: "padded", Valid: true},
+				"2": {},
+				"3": {String: "unpadded", Valid: true},
+			},
+		},
+		{
+			name:       "none",
+			trimPolicy: trimPolicyNone,
+			wantTestStrings: map[string]sql.NullString{
+				"1": {String: " padded ", Valid: true},
+				"2": {String: "  ", Valid: true},
+				"3": {String: "unpadded", Valid: true},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			c := config.New()
+			if tc.trimPolicy != "" {
+				c.Set("Warehouse.postgres.trimColumns", map[string]interface{}{
+					tableName: map[string]interface{}{"test_string": tc.trimPolicy},
+				})
+			}
+
+			pg := newTestPostgres(db, c)
+			pg.Namespace = "test_namespace_" + tc.name
+			pg.Uploader = &mockUploader{
+				schema: model.Schema{
+					tableName: testTableSchema,
+				},
+			}
+			createTestTable(t, pg, tableName)
+
+			require.NoError(t, pg.LoadTableFromFiles(ctx, tableName, []string{loadFile}))
+
+			rows, err := db.QueryContext(ctx, fmt.Sprintf(`SELECT id, test_string FROM %q.%q;`, pg.Namespace, tableName))
+			require.NoError(t, err)
+			defer func() { _ = rows.Close() }()
+
+			testStrings := make(map[string]sql.NullString)
+			for rows.Next() {
+				var (
+					id         string
+					testString sql.NullString
+				)
+				require.NoError(t, rows.Scan(&id, &testString))
+				testStrings[id] = testString
+			}
+			require.NoError(t, rows.Err())
+			require.Equal(t, tc.wantTestStrings, testStrings)
+		})
+	}
+}
+
+// crashLogger simulates the process getting killed, by cancelling the context once the message is logged.
+// Any cleanup running afterwards with the same context fails, as it would never run after a crash.
+type crashLogger struct {
+	logger.Logger
+
+	message string
+	cancel  context.CancelFunc
+}
+
+func (l *crashLogger) Debugf(format string, args ...interface{}) {
+	if strings.Contains(fmt.Sprintf(format, args...), l.message) {
+		l.cancel()
+	}
+}
+
+func TestLoadUserTables_Crash(t *testing.T) {
+	misc.Init()
+	warehouseutils.Init()
+
+	db := setupDB(t)
+
+	pg := newTestPostgres(db, config.New())
+
+	uploader := &mockUploader{
+		schema: model.Schema{
+			warehouseutils.IdentifiesTable: {
+				"id":          "string",
+				"user_id":     "string",
+				"received_at": "datetime",
+				"name":        "string",
+			},
+			warehouseutils.UsersTable: {
+				"id":          "string",
+				"received_at": "datetime",
+				"name":        "string",
+			},
+		},
+	}
+	pg.Uploader = uploader
+
+	require.NoError(t, pg.CreateSchema(context.Background()))
+	for tableName, tableSchema := range uploader.schema {
+		require.NoError(t, pg.CreateTable(context.Background(), tableName, tableSchema))
+	}
+
+	// columns are sorted: id, name, received_at, user_id
+	setupLoadFiles(pg, uploader, map[string][]string{
+		warehouseutils.IdentifiesTable: {writeGzipCSV(t, "identifies.csv.gz", [][]string{
+			{"1", "alice", "2023-01-01T00:00:00Z", "user_1"},
+		})},
+	})
+
+	stagingTables := func() []string {
+		rows, err := db.QueryContext(context.Background(), `SELECT table_name FROM information_schema.tables WHERE table_schema = $1 AND table_name LIKE $2 ORDER BY table_name;`,
+			testNamespace,
+			warehouseutils.StagingTablePrefix(provider)+"%",
+		)
+		require.NoError(t, err)
+		defer func() { _ = rows.Close() }()
+
+		var tableNames []string
+		for rows.Next() {
+			var tableName string
+			require.NoError(t, rows.Scan(&tableName))
+			tableNames = append(tableNames, tableName)
+		}
+		require.NoError(t, rows.Err())
+		return tableNames
+	}
+
+	// crash once the union staging table is created
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pg.logger = &crashLogger{
+		Logger:  logger.NOP,
+		message: "Creating staging table for users",
+		cancel:  cancel,
+	}
+
+	errorsMap := pg.LoadUserTables(ctx)
+	require.NoError(t, errorsMap[warehouseutils.IdentifiesTable])
+	// depending on whether the transaction got rolled back already, it is either context canceled or transaction done
+	require.Error(t, errorsMap[warehouseutils.UsersTable])
+
+	// only the committed identifies staging table is left behind
+	leftovers := stagingTables()
+	require.Len(t, leftovers, 1)
+	require.Contains(t, leftovers[0], warehouseutils.IdentifiesTable)
+
+	count, err := pg.GetTotalCountInTable(context.Background(), warehouseutils.UsersTable)
+	require.NoError(t, err)
+	require.Zero(t, count)
+
+	pg.logger = logger.NOP
+	pg.CrashRecover(context.Background())
+	require.Empty(t, stagingTables())
+}
+
+func TestLoadUserTables_NoUserIDs(t *testing.T) {
+	misc.Init()
+	warehouseutils.Init()
+
+	db := setupDB(t)
+	ctx := context.Background()
+
+	store := memstats.New()
+
+	pg := newTestPostgres(db, config.New())
+	pg.stats = store
+
+	uploader := &mockUploader{
+		schema: model.Schema{
+			warehouseutils.IdentifiesTable: {
+				"id":          "string",
+				"user_id":     "string",
+				"received_at": "datetime",
+				"name":        "string",
+			},
+			warehouseutils.UsersTable: {
+				"id":          "string",
+				"received_at": "datetime",
+				"name":        "string",
+			},
+		},
+	}
+	pg.Uploader = uploader
+
+	require.NoError(t, pg.CreateSchema(ctx))
+	for tableName, tableSchema := range uploader.schema {
+		require.NoError(t, pg.CreateTable(ctx, tableName, tableSchema))
+	}
+
+	// columns are sorted: id, name, received_at, user_id
+	setupLoadFiles(pg, uploader, map[string][]string{
+		warehouseutils.IdentifiesTable: {writeGzipCSV(t, "identifies.csv.gz", [][]string{
+			{"1", "anonymous", "2023-01-01T00:00:00Z", ""},
+		})},
+	})
+
+	errorsMap := pg.LoadUserTables(ctx)
+	require.NoError(t, errorsMap[warehouseutils.IdentifiesTable])
+	require.NoError(t, errorsMap[warehouseutils.UsersTable])
+
+	count, err := pg.GetTotalCountInTable(ctx, warehouseutils.IdentifiesTable)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, count)
+
+	// neither the union nor the users staging tables were created
+	require.Nil(t, store.Get(stagingTableCreated, stats.Tags{
+		"workspaceId":   testWorkspaceID,
+		"namespace":     testNamespace,
+		"destinationID": testDestID,
+		"tableName":     warehouseutils.UsersTable,
+	}))
+}
+
+func TestLoadTable_DecompressedObjects(t *testing.T) {
+	misc.Init()
+	warehouseutils.Init()
+
+	const tableName = "test_table"
+
+	db := setupDB(t)
+	ctx := context.Background()
+
+	pg := newTestPostgres(db, config.New())
+	uploader := &mockUploader{
+		schema: model.Schema{
+			tableName: testTableSchema,
+		},
+	}
+	pg.Uploader = uploader
+	createTestTable(t, pg, tableName)
+
+	// the second object is served decompressed, as by object stores with a gzip Content-Encoding
+	decompressedFile := filepath.Join(t.TempDir(), "decompressed.csv.gz")
+	require.NoError(t, os.WriteFile(decompressedFile, []byte("2,2023-01-01T00:00:00Z,2,decompressed\n"), 0o600))
+
+	// columns are sorted: id, received_at, test_int, test_string
+	setupLoadFiles(pg, uploader, map[string][]string{
+		tableName: {
+			writeGzipCSV(t, "gzipped.csv.gz", [][]string{{"1", "2023-01-01T00:00:00Z", "1", "gzipped"}}),
+			decompressedFile,
+		},
+	})
+	require.NoError(t, pg.LoadTable(ctx, tableName))
+
+	count, err := pg.GetTotalCountInTable(ctx, tableName)
+	require.NoError(t, err)
+	require.EqualValues(t, 2, count)
+}
+
+func TestLoadTable_MaxFilesPerTxn(t *testing.T) {
+	misc.Init()
+	warehouseutils.Init()
+
+	const (
+		tableName = "test_table"
+		numFiles  = 9
+	)
+
+	db := setupDB(t)
+	ctx := context.Background()
+
+	c := config.New()
+	c.Set("Warehouse.postgres.maxFilesPerTxn", 3)
+
+	pg := newTestPostgres(db, c)
+
+	uploader := &mockUploader{
+		schema: model.Schema{
+			tableName: testTableSchema,
+		},
+	}
+	pg.Uploader = uploader
+	createTestTable(t, pg, tableName)
+
+	// every file updates the record with id 0 and adds a new one
+	var filePaths []string
+	for i := 1; i <= numFiles; i++ {
+		receivedAt := time.Date(2023, 1, i, 0, 0, 0, 0, time.UTC).Format(time.RFC3339)
+
+		// columns are sorted: id, received_at, test_int, test_string
+		filePaths = append(filePaths, writeGzipCSV(t, fmt.Sprintf("load-%d.csv.gz", i), [][]string{
+			{"0", receivedAt, strconv.Itoa(i), "updated"},
+			{strconv.Itoa(i), receivedAt, strconv.Itoa(i), "new"},
+		}))
+	}
+	// the last batch, of this file only, has an older record than the one of id 0 committed by the earlier batches
+	filePaths = append(filePaths, writeGzipCSV(t, "load-older.csv.gz", [][]string{
+		{"0", time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC).Format(time.RFC3339), "0", "older"},
+	}))
+	setupLoadFiles(pg, uploader, map[string][]string{
+		tableName: filePaths,
+	})
+
+	require.NoError(t, pg.LoadTable(ctx, tableName))
+
+	count, err := pg.GetTotalCountInTable(ctx, tableName)
+	require.NoError(t, err)
+	require.EqualValues(t, numFiles+1, count)
+
+	var testInt int
+	err = db.QueryRowContext(ctx, fmt.Sprintf(`SELECT test_int FROM %q.%q WHERE id = '0';`, testNamespace, tableName)).Scan(&testInt)
+	require.NoError(t, err)
+	require.Equal(t, numFiles, testInt)
+
+	var stagingTables int
+	err = db.QueryRowContext(ctx, `SELECT count(*) FROM information_schema.tables WHERE table_schema = $1 AND table_name LIKE $2;`,
+		testNamespace,
+		warehouseutils.StagingTablePrefix(provider)+"%",
+	).Scan(&stagingTables)
+	require.NoError(t, err)
+	require.Zero(t, stagingTables)
+}
+
+func TestLoadTableFromFiles_DedupKeys(t *testing.T) {
+	misc.Init()
+	warehouseutils.Init()
+
+	const tableName = "test_table"
+
+	testCases := []struct {
+		name      string
+		dedupKeys map[string]interface{}
+		want      map[string]string
+	}{
+		{
+			name: "default dedup key",
+			want: map[string]string{
+				"1": "third",
+			},
+		},
+		{
+			name: "composite dedup key",
+			dedupKeys: map[string]interface{}{
+				tableName: []interface{}{"id", "test_int"},
+			},
+			want: map[string]string{
+				"1": "third",
+				"2": "second",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			db := setupDB(t)
+			ctx := context.Background()
+
+			c := config.New()
+			c.Set("Warehouse.postgres.dedupKeys", tc.dedupKeys)
+
+			pg := newTestPostgres(db, c)
+			pg.Uploader = &mockUploader{
+				schema: model.Schema{
+					tableName: testTableSchema,
+				},
+			}
+			createTestTable(t, pg, tableName)
+
+			// columns are sorted: id, received_at, test_int, test_string
+			err := pg.LoadTableFromFiles(ctx, tableName, []string{
+				writeGzipCSV(t, "first.csv.gz", [][]string{
+					{"1", "2023-01-01T00:00:00Z", "1", "first"},
+					{"1", "2023-01-01T00:00:00Z", "2", "second"},
+				}),
+			})
+			require.NoError(t, err)
+
+			err = pg.LoadTableFromFiles(ctx, tableName, []string{
+				writeGzipCSV(t, "second.csv.gz", [][]string{
+					{"1", "2023-01-02T00:00:00Z", "1", "third"},
+				}),
+			})
+			require.NoError(t, err)
+
+			rows, err := db.QueryContext(ctx, fmt.Sprintf(`SELECT test_int, test_string FROM %q.%q;`, testNamespace, tableName))
+			require.NoError(t, err)
+			defer func() { _ = rows.Close() }()
+
+			got := make(map[string]string)
+			for rows.Next() {
+				var testInt, testString string
+				require.NoError(t, rows.Scan(&testInt, &testString))
+				got[testInt] = testString
+			}
+			require.NoError(t, rows.Err())
+			require.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestLoadTableFromFiles_RouteUnknownColumnsToDiscards(t *testing.T) {
+	misc.Init()
+	warehouseutils.Init()
+
+	const tableName = "test_table"
+
+	db := setupDB(t)
+	ctx := context.Background()
+
+	c := config.New()
+	c.Set("Warehouse.postgres.routeUnknownColumnsToDiscards", true)
+
+	pg := newTestPostgres(db, c)
+
+	uploadSchema := model.TableSchema{"extra": "string"}
+	for column, dataType := range testTableSchema {
+		uploadSchema[column] = dataType
+	}
+	pg.Uploader = &mockUploader{
+		schema: model.Schema{
+			tableName: uploadSchema,
+		},
+		warehouseSchema: model.Schema{
+			tableName: testTableSchema,
+		},
+	}
+	createTestTable(t, pg, tableName)
+
+	// columns are sorted: extra, id, received_at, test_int, test_string
+	err := pg.LoadTableFromFiles(ctx, tableName, []string{
+		writeGzipCSV(t, "load.csv.gz", [][]string{
+			{"extra_1", "1", "2023-01-01T00:00:00Z", "1", "first"},
+			{"", "2", "2023-01-01T00:00:00Z", "2", "second"},
+		}),
+	})
+	require.NoError(t, err)
+
+	count, err := pg.GetTotalCountInTable(ctx, tableName)
+	require.NoError(t, err)
+	require.EqualValues(t, 2, count)
+
+	var (
+		tableNameInDiscards, rowID, columnName, columnValue string
+		receivedAt                                          time.Time
+	)
+	err = db.QueryRowContext(ctx, fmt.Sprintf(`SELECT table_name, row_id, column_name, column_value, received_at FROM %q.%q;`, testNamespace, warehouseutils.DiscardsTable)).
+		Scan(&tableNameInDiscards, &rowID, &columnName, &columnValue, &receivedAt)
+	require.NoError(t, err)
+	require.Equal(t, tableName, tableNameInDiscards)
+	require.Equal(t, "1", rowID)
+	require.Equal(t, "extra", columnName)
+	require.Equal(t, "extra_1", columnValue)
+	require.Equal(t, time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC), receivedAt.UTC())
+
+	// null values are not discarded
+	count, err = pg.GetTotalCountInTable(ctx, warehouseutils.DiscardsTable)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, count)
+}
+
+func TestLoadTableFromFiles_ColumnSubset(t *testing.T) {
+	misc.Init()
+	warehouseutils.Init()
+
+	const tableName = "test_table"
+
+	ctx := context.Background()
+
+	t.Run("table with more columns", func(t *testing.T) {
+		db := setupDB(t)
+
+		pg := newTestPostgres(db, config.New())
+		pg.Uploader = &mockUploader{
+			schema: model.Schema{
+				tableName: testTableSchema,
+			},
+		}
+
+		tableSchema := model.TableSchema{"older_column": "string"}
+		for column, dataType := range testTableSchema {
+			tableSchema[column] = dataType
+		}
+		require.NoError(t, pg.CreateSchema(ctx))
+		require.NoError(t, pg.CreateTable(ctx, tableName, tableSchema))
+
+		_, err := db.ExecContext(ctx, fmt.Sprintf(`INSERT INTO %q.%q (id, received_at, older_column) VALUES ('3', '2023-01-01T00:00:00Z', 'older');`, testNamespace, tableName))
+		require.NoError(t, err)
+
+		// columns are sorted: id, received_at, test_int, test_string
+		err = pg.LoadTableFromFiles(ctx, tableName, []string{
+			writeGzipCSV(t, "load.csv.gz", [][]string{
+				{"1", "2023-01-01T00:00:00Z", "1", "first"},
+				{"2", "2023-01-01T00:00:00Z", "2", "second"},
+			}),
+		})
+		require.NoError(t, err)
+
+		count, err := pg.GetTotalCountInTable(ctx, tableName)
+		require.NoError(t, err)
+		require.EqualValues(t, 3, count)
+	})
+
+	t.Run("table with missing columns", func(t *testing.T) {
+		db := setupDB(t)
+
+		pg := newTestPostgres(db, config.New())
+		pg.Uploader = &mockUploader{
+			schema: model.Schema{
+				tableName: testTableSchema,
+			},
+		}
+		require.NoError(t, pg.CreateSchema(ctx))
+		require.NoError(t, pg.CreateTable(ctx, tableName, model.TableSchema{
+			"id":          "string",
+			"received_at": "datetime",
+		}))
+
+		err := pg.LoadTableFromFiles(ctx, tableName, []string{
+			writeGzipCSV(t, "load.csv.gz", [][]string{
+				{"1", "2023-01-01T00:00:00Z", "1", "first"},
+			}),
+		})
+
+		var missingColumnsErr *MissingColumnsError
+		require.ErrorAs(t, err, &missingColumnsErr)
+		require.Equal(t, []string{"test_int", "test_string"}, missingColumnsErr.ColumnNames)
+		require.EqualError(t, err, "columns test_int, test_string not found in table test_table")
+
+		count, err := pg.GetTotalCountInTable(ctx, tableName)
+		require.NoError(t, err)
+		require.Zero(t, count)
+	})
+
+	t.Run("missing table", func(t *testing.T) {
+		db := setupDB(t)
+
+		pg := newTestPostgres(db, config.New())
+		pg.Uploader = &mockUploader{
+			schema: model.Schema{
+				tableName: testTableSchema,
+			},
+		}
+		require.NoError(t, pg.CreateSchema(ctx))
+
+		err := pg.LoadTableFromFiles(ctx, tableName, []string{
+			writeGzipCSV(t, "load.csv.gz", [][]string{
+				{"1", "2023-01-01T00:00:00Z", "1", "first"},
+			}),
+		})
+
+		var tableNotFoundErr *TableNotFoundError
+		require.ErrorAs(t, err, &tableNotFoundErr)
+	})
+}
+
+func TestLoadTableFromFiles_JSONValidation(t *testing.T) {
+	misc.Init()
+	warehouseutils.Init()
+
+	const tableName = "test_table"
+
+	tableSchema := model.TableSchema{
+		"id":          "string",
+		"received_at": "datetime",
+		"traits":      "json",
+	}
+
+	// columns are sorted: id, received_at, traits
+	validRecords := [][]string{
+		{"1", "2023-01-01T00:00:00Z", `{"name": "alice"}`},
+		{"2", "2023-01-01T00:00:00Z", ""},
+	}
+	malformedRecords := [][]string{
+		{"1", "2023-01-01T00:00:00Z", `{"name": "alice"}`},
+		{"2", "2023-01-01T00:00:00Z", `{"name": "bob"`},
+	}
+
+	setup := func(t *testing.T, jsonValidation string) (*Postgres, *sqlmiddleware.DB) {
+		t.Helper()
+
+		db := setupDB(t)
+
+		c := config.New()
+		c.Set("Warehouse.postgres.jsonValidation", jsonValidation)
+
+		pg := newTestPostgres(db, c)
+		pg.Uploader = &mockUploader{
+			schema: model.Schema{
+				tableName: tableSchema,
+			},
+		}
+		require.NoError(t, pg.CreateSchema(context.Background()))
+		require.NoError(t, pg.CreateTable(context.Background(), tableName, tableSchema))
+		return pg, db
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		pg, _ := setup(t, jsonValidationFail)
+
+		err := pg.LoadTableFromFiles(context.Background(), tableName, []string{writeGzipCSV(t, "load.csv.gz", validRecords)})
+		require.NoError(t, err)
+
+		count, err := pg.GetTotalCountInTable(context.Background(), tableName)
+		require.NoError(t, err)
+		require.EqualValues(t, 2, count)
+	})
+
+	t.Run("malformed without validation", func(t *testing.T) {
+		pg, _ := setup(t, jsonValidationNone)
+
+		err := pg.LoadTableFromFiles(context.Background(), tableName, []string{writeGzipCSV(t, "load.csv.gz", malformedRecords)})
+		require.Error(t, err)
+
+		var invalidJSONErr *InvalidJSONError
+		require.False(t, errors.As(err, &invalidJSONErr))
+	})
+
+	t.Run("malformed with fail", func(t *testing.T) {
+		pg, _ := setup(t, jsonValidationFail)
+
+		loadFile := writeGzipCSV(t, "load.csv.gz", malformedRecords)
+		err := pg.LoadTableFromFiles(context.Background(), tableName, []string{loadFile})
+		require.Equal(t, &InvalidJSONError{TableName: tableName, ColumnName: "traits", FileName: loadFile, Row: 2}, err)
+
+		count, err := pg.GetTotalCountInTable(context.Background(), tableName)
+		require.NoError(t, err)
+		require.Zero(t, count)
+	})
+
+	t.Run("malformed with discard", func(t *testing.T) {
+		pg, db := setup(t, jsonValidationDiscard)
+		ctx := context.Background()
+
+		err := pg.LoadTableFromFiles(ctx, tableName, []string{writeGzipCSV(t, "load.csv.gz", malformedRecords)})
+		require.NoError(t, err)
+
+		var nullTraits int
+		err = db.QueryRowContext(ctx, fmt.Sprintf(`SELECT count(*) FROM %q.%q WHERE traits IS NULL;`, testNamespace, tableName)).Scan(&nullTraits)
+		require.NoError(t, err)
+		require.Equal(t, 1, nullTraits)
+
+		var rowID, columnName, columnValue string
+		err = db.QueryRowContext(ctx, fmt.Sprintf(`SELECT row_id, column_name, column_value FROM %q.%q;`, testNamespace, warehouseutils.DiscardsTable)).
+			Scan(&rowID, &columnName, &columnValue)
+		require.NoError(t, err)
+		require.Equal(t, "2", rowID)
+		require.Equal(t, "traits", columnName)
+		require.Equal(t, `{"name": "bob"`, columnValue)
+	})
+}
+
+func TestLoadTableFromFiles_TimestampOverflow(t *testing.T) {
+	misc.Init()
+	warehouseutils.Init()
+
+	const tableName = "test_table"
+
+	ctx := context.Background()
+
+	tableSchema := model.TableSchema{
+		"id":            "string",
+		"received_at":   "datetime",
+		"test_datetime": "datetime",
+	}
+
+	// columns are sorted: id, received_at, test_datetime
+	records := [][]string{
+		{"1", "2023-01-01T00:00:00Z", "2023-01-01T00:00:00Z"},
+		{"2", "2023-01-01T00:00:00Z", "300000-01-01T00:00:00Z"},
+		{"3", "2023-01-01T00:00:00Z", "-0001-01-01T00:00:00Z"},
+		// years past 9999 within the timestamptz range are loaded as is
+		{"4", "2023-01-01T00:00:00Z", "10000-01-01T00:00:00Z"},
+	}
+
+	setup := func(t *testing.T, timestampOverflow string) (*Postgres, *sqlmiddleware.DB) {
+		db := setupDB(t)
+
+		c := config.New()
+		c.Set("Warehouse.postgres.timestampOverflow", timestampOverflow)
+
+		pg := newTestPostgres(db, c)
+		pg.Uploader = &mockUploader{
+			schema: model.Schema{
+				tableName: tableSchema,
+			},
+		}
+		require.NoError(t, pg.CreateSchema(ctx))
+		require.NoError(t, pg.CreateTable(ctx, tableName, tableSchema))
+		return pg, db
+	}
+
+	testDatetimes := func(t *testing.T, db *sqlmiddleware.DB) map[string]sql.NullTime {
+		rows, err := db.QueryContext(ctx, fmt.Sprintf(`SELECT id, test_datetime FROM %q.%q;`, testNamespace, tableName))
+		require.NoError(t, err)
+		defer func() { _ = rows.Close() }()
+
+		datetimes := make(map[string]sql.NullTime)
+		for rows.Next() {
+			var (
+				id       string
+				datetime sql.NullTime
+			)
+			require.NoError(t, rows.Scan(&id, &datetime))
+			datetimes[id] = datetime
+		}
+		require.NoError(t, rows.Err())
+		return datetimes
+	}
+
+	t.Run("strict", func(t *testing.T) {
+		pg, _ := setup(t, timestampOverflowStrict)
+
+		err := pg.LoadTableFromFiles(ctx, tableName, []string{writeGzipCSV(t, "load.csv.gz", records)})
+		require.Error(t, err)
+
+		count, err := pg.GetTotalCountInTable(ctx, tableName)
+		require.NoError(t, err)
+		require.Zero(t, count)
+	})
+
+	t.Run("clamp", func(t *testing.T) {
+		pg, db := setup(t, timestampOverflowClamp)
+
+		err := pg.LoadTableFromFiles(ctx, tableName, []string{writeGzipCSV(t, "load.csv.gz", records)})
+		require.NoError(t, err)
+
+		datetimes := testDatetimes(t, db)
+		require.Equal(t, time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC), datetimes["1"].Time.UTC())
+		require.Equal(t, time.Date(294276, 12, 31, 23, 59, 59, 999999000, time.UTC), datetimes["2"].Time.UTC())
+		require.Equal(t, time.Date(1, 1, 1, 0, 0, 0, 0, time.UTC), datetimes["3"].Time.UTC())
+		require.Equal(t, time.Date(10000, 1, 1, 0, 0, 0, 0, time.UTC), datetimes["4"].Time.UTC())
+	})
+
+	t.Run("discard", func(t *testing.T) {
+		pg, db := setup(t, timestampOverflowDiscard)
+
+		err := pg.LoadTableFromFiles(ctx, tableName, []string{writeGzipCSV(t, "load.csv.gz", records)})
+		require.NoError(t, err)
+
+		datetimes := testDatetimes(t, db)
+		require.Len(t, datetimes, 4)
+		require.True(t, datetimes["1"].Valid)
+		require.False(t, datetimes["2"].Valid)
+		require.False(t, datetimes["3"].Valid)
+		require.True(t, datetimes["4"].Valid)
+
+		rows, err := db.QueryContext(ctx, fmt.Sprintf(`SELECT row_id, column_name, column_value FROM %q.%q ORDER BY row_id;`, testNamespace, warehouseutils.DiscardsTable))
+		require.NoError(t, err)
+		defer func() { _ = rows.Close() }()
+
+		var discards [][]string
+		for rows.Next() {
+			var rowID, columnName, columnValue string
+			require.NoError(t, rows.Scan(&rowID, &columnName, &columnValue))
+			discards = append(discards, []string{rowID, columnName, columnValue})
+		}
+		require.NoError(t, rows.Err())
+		require.Equal(t, [][]string{
+			{"2", "test_datetime", "300000-01-01T00:00:00Z"},
+			{"3", "test_datetime", "-0001-01-01T00:00:00Z"},
+		}, discards)
+	})
+}
+
+func TestLoadTableFromFiles_FloatSpecialValues(t *testing.T) {
+	misc.Init()
+	warehouseutils.Init()
+
+	const tableName = "test_table"
+
+	ctx := context.Background()
+
+	tableSchema := model.TableSchema{
+		"id":          "string",
+		"received_at": "datetime",
+		"test_float":  "float",
+	}
+
+	// columns are sorted: id, received_at, test_float
+	records := [][]string{
+		{"1", "2023-01-01T00:00:00Z", "1.5"},
+		{"2", "2023-01-01T00:00:00Z", "NaN"},
+		{"3", "2023-01-01T00:00:00Z", "Infinity"},
+		{"4", "2023-01-01T00:00:00Z", "-Infinity"},
+	}
+
+	setup := func(t *testing.T, floatSpecialValues string) (*Postgres, *sqlmiddleware.DB) {
+		db := setupDB(t)
+
+		c := config.New()
+		c.Set("Warehouse.postgres.floatSpecialValues", floatSpecialValues)
+
+		pg := newTestPostgres(db, c)
+		pg.Uploader = &mockUploader{
+			schema: model.Schema{
+				tableName: tableSchema,
+			},
+		}
+		require.NoError(t, pg.CreateSchema(ctx))
+		require.NoError(t, pg.CreateTable(ctx, tableName, tableSchema))
+		return pg, db
+	}
+
+	testFloats := func(t *testing.T, db *sqlmiddleware.DB) map[string]sql.NullString {
+		rows, err := db.QueryContext(ctx, fmt.Sprintf(`SELECT id, test_float::text FROM %q.%q;`, testNamespace, tableName))
+		require.NoError(t, err)
+		defer func() { _ = rows.Close() }()
+
+		floats := make(map[string]sql.NullString)
+		for rows.Next() {
+			var (
+				id    string
+				float sql.NullString
+			)
+			require.NoError(t, rows.Scan(&id, &float))
+			floats[id] = float
+		}
+		require.NoError(t, rows.Err())
+		return floats
+	}
+
+	t.Run("strict", func(t *testing.T) {
+		pg, db := setup(t, floatSpecialValuesStrict)
+
+		err := pg.LoadTableFromFiles(ctx, tableName, []string{writeGzipCSV(t, "load.csv.gz", records)})
+		require.NoError(t, err)
+
+		// numeric accepts the special values from postgres 14 on
+		require.Equal(t, map[string]sql.NullString{
+			"1": {String: "1.5", Valid: true},
+			"2": {String: "NaN", Valid: true},
+			"3": {String: "Infinity", Valid: true},
+			"4": {String: "-Infinity", Valid: true},
+		}, testFloats(t, db))
+	})
+
+	t.Run("null", func(t *testing.T) {
+		pg, db := setup(t, floatSpecialValuesNull)
+
+		err := pg.LoadTableFromFiles(ctx, tableName, []string{writeGzipCSV(t, "load.csv.gz", records)})
+		require.NoError(t, err)
+
+		require.Equal(t, map[string]sql.NullString{
+			"1": {String: "1.5", Valid: true},
+			"2": {},
+			"3": {},
+			"4": {},
+		}, testFloats(t, db))
+
+		var discardsTable sql.NullString
+		require.NoError(t, db.QueryRowContext(ctx, `SELECT to_regclass($1)::text;`, fmt.Sprintf(`%q.%q`, testNamespace, warehouseutils.DiscardsTable)).Scan(&discardsTable))
+		require.False(t, discardsTable.Valid)
+	})
+
+	t.Run("discard", func(t *testing.T) {
+		pg, db := setup(t, floatSpecialValuesDiscard)
+
+		err := pg.LoadTableFromFiles(ctx, tableName, []string{writeGzipCSV(t, "load.csv.gz", records)})
+		require.NoError(t, err)
+
+		require.Equal(t, map[string]sql.NullString{
+			"1": {String: "1.5", Valid: true},
+			"2": {},
+			"3": {},
+			"4": {},
+		}, testFloats(t, db))
+
+		rows, err := db.QueryContext(ctx, fmt.Sprintf(`SELECT row_id, column_name, column_value FROM %q.%q ORDER BY row_id;`, testNamespace, warehouseutils.DiscardsTable))
+		require.NoError(t, err)
+		defer func() { _ = rows.Close() }()
+
+		var discards [][]string
+		for rows.Next() {
+			var rowID, columnName, columnValue string
+			require.NoError(t, rows.Scan(&rowID, &columnName, &columnValue))
+			discards = append(discards, []string{rowID, columnName, columnValue})
+		}
+		require.NoError(t, rows.Err())
+		require.Equal(t, [][]string{
+			{"2", "test_float", "NaN"},
+			{"3", "test_float", "Infinity"},
+			{"4", "test_float", "-Infinity"},
+		}, discards)
+	})
+}
+
+func TestLoadTableFromFiles_LongIndexedText(t *testing.T) {
+	misc.Init()
+	warehouseutils.Init()
+
+	const tableName = "test_table"
+
+	ctx := context.Background()
+
+	// random hex values, which postgres can't compress below the size limit of the btree index entries
+	data := make([]byte, 4096)
+	_, err := rand.Read(data)
+	require.NoError(t, err)
+	longValue := hex.EncodeToString(data)
+
+	// columns are sorted: id, received_at, test_int, test_string
+	records := [][]string{
+		{"1", "2023-01-01T00:00:00Z", "1", "short"},
+		{"2", "2023-01-01T00:00:00Z", "2", longValue},
+	}
+
+	setup := func(t *testing.T, longIndexedText string) (*Postgres, *sqlmiddleware.DB) {
+		db := setupDB(t)
+
+		c := config.New()
+		c.Set("Warehouse.postgres.longIndexedText", longIndexedText)
+		c.Set("Warehouse.postgres.indexColumns", map[string]interface{}{
+			tableName: []interface{}{"test_string"},
+		})
+
+		pg := newTestPostgres(db, c)
+		pg.Uploader = &mockUploader{
+			schema: model.Schema{
+				tableName: testTableSchema,
+			},
+		}
+		createTestTable(t, pg, tableName)
+		return pg, db
+	}
+
+	testStrings := func(t *testing.T, db *sqlmiddleware.DB) map[string]sql.NullString {
+		rows, err := db.QueryContext(ctx, fmt.Sprintf(`SELECT id, test_string FROM %q.%q;`, testNamespace, tableName))
+		require.NoError(t, err)
+		defer func() { _ = rows.Close() }()
+
+		testStrings := make(map[string]sql.NullString)
+		for rows.Next() {
+			var (
+				id         string
+				testString sql.NullString
+			)
+			require.NoError(t, rows.Scan(&id, &testString))
+			testStrings[id] = testString
+		}
+		require.NoError(t, rows.Err())
+		return testStrings
+	}
+
+	t.Run("strict", func(t *testing.T) {
+		pg, _ := setup(t, longIndexedTextStrict)
+
+		err := pg.LoadTableFromFiles(ctx, tableName, []string{writeGzipCSV(t, "load.csv.gz", records)})
+		require.ErrorContains(t, err, "index row size")
+	})
+
+	t.Run("truncate", func(t *testing.T) {
+		pg, db := setup(t, longIndexedTextTruncate)
+
+		err := pg.LoadTableFromFiles(ctx, tableName, []string{writeGzipCSV(t, "load.csv.gz", records)})
+		require.NoError(t, err)
+
+		require.Equal(t, map[string]sql.NullString{
+			"1": {String: "short", Valid: true},
+			"2": {String: longValue[:pg.IndexedTextMaxBytes], Valid: true},
+		}, testStrings(t, db))
+	})
+
+	t.Run("hash", func(t *testing.T) {
+		pg, db := setup(t, longIndexedTextHash)
+
+		err := pg.LoadTableFromFiles(ctx, tableName, []string{writeGzipCSV(t, "load.csv.gz", records)})
+		require.NoError(t, err)
+
+		sum := sha256.Sum256([]byte(longValue))
+		require.Equal(t, map[string]sql.NullString{
+			"1": {String: "short", Valid: true},
+			"2": {String: hex.EncodeToString(sum[:]), Valid: true},
+		}, testStrings(t, db))
+	})
+
+	t.Run("discard", func(t *testing.T) {
+		pg, db := setup(t, longIndexedTextDiscard)
+
+		err := pg.LoadTableFromFiles(ctx, tableName, []string{writeGzipCSV(t, "load.csv.gz", records)})
+		require.NoError(t, err)
+
+		require.Equal(t, map[string]sql.NullString{
+			"1": {String: "short", Valid: true},
+			"2": {},
+		}, testStrings(t, db))
+
+		var rowID, columnName, columnValue string
+		err = db.QueryRowContext(ctx, fmt.Sprintf(`SELECT row_id, column_name, column_value FROM %q.%q;`, testNamespace, warehouseutils.DiscardsTable)).Scan(&rowID, &columnName, &columnValue)
+		require.NoError(t, err)
+		require.Equal(t, []string{"2", "test_string", longValue}, []string{rowID, columnName, columnValue})
+	})
+
+	t.Run("trimmed", func(t *testing.T) {
+		pg, db := setup(t, longIndexedTextHash)
+		pg.TrimColumns = map[string]map[string]string{tableName: {"test_string": trimPolicyTrim}}
+
+		// the values are checked and hashed once trimmed, leaving the ones only exceeding the limit with their whitespace as is
+		limitValue := longValue[:pg.IndexedTextMaxBytes]
+		err := pg.LoadTableFromFiles(ctx, tableName, []string{writeGzipCSV(t, "load.csv.gz", [][]string{
+			{"1", "2023-01-01T00:00:00Z", "1", "  " + limitValue + "  "},
+			{"2", "2023-01-01T00:00:00Z", "2", "  " + longValue + "  "},
+		})})
+		require.NoError(t, err)
+
+		sum := sha256.Sum256([]byte(longValue))
+		require.Equal(t, map[string]sql.NullString{
+			"1": {String: limitValue, Valid: true},
+			"2": {String: hex.EncodeToString(sum[:]), Valid: true},
+		}, testStrings(t, db))
+	})
+
+	t.Run("not indexed", func(t *testing.T) {
+		pg, db := setup(t, longIndexedTextTruncate)
+		pg.IndexColumns = nil
+		_, err := db.ExecContext(ctx, fmt.Sprintf(`DROP INDEX %q.%q;`, testNamespace, indexName(tableName, "test_string")))
+		require.NoError(t, err)
+
+		err = pg.LoadTableFromFiles(ctx, tableName, []string{writeGzipCSV(t, "load.csv.gz", records)})
+		require.NoError(t, err)
+
+		require.Equal(t, longValue, testStrings(t, db)["2"].String)
+	})
+}
+
+func TestLoadTableFromFiles_BadRows(t *testing.T) {
+	misc.Init()
+	warehouseutils.Init()
+
+	const tableName = "test_table"
+
+	ctx := context.Background()
+
+	// columns are sorted: id, received_at, test_int, test_string
+	records := [][]string{
+		{"1", "2023-01-01T00:00:00Z", "1", "first"},
+		{"2", "2023-01-01T00:00:00Z", "2", "second", "extra"},
+		{"3", "2023-01-01T00:00:00Z", "three", "third"},
+		{"4", "2023-01-01T00:00:00Z", "4"},
+		{"5", "2023-01-01T00:00:00Z", "5", "fifth"},
+	}
+
+	setup := func(t *testing.T, maxBadRows int) (*Postgres, *sqlmiddleware.DB, *memstats.Store) {
+		db := setupDB(t)
+
+		c := config.New()
+		c.Set("Warehouse.postgres.maxBadRows", maxBadRows)
+
+		store := memstats.New()
+
+		pg := newTestPostgres(db, c)
+		pg.stats = store
+		pg.Uploader = &mockUploader{
+			schema: model.Schema{
+				tableName: testTableSchema,
+			},
+		}
+		createTestTable(t, pg, tableName)
+		return pg, db, store
+	}
+
+	skippedRows := func(store *memstats.Store, reason string) float64 {
+		m := store.Get("pg_skipped_rows", stats.Tags{
+			"workspaceId":   testWorkspaceID,
+			"namespace":     testNamespace,
+			"destinationID": testDestID,
+			"tableName":     tableName,
+			"reason":        reason,
+		})
+		if m == nil {
+			return 0
+		}
+		return m.LastValue()
+	}
+
+	t.Run("under the threshold", func(t *testing.T) {
+		pg, db, store := setup(t, 3)
+
+		_, loadStats, err := pg.loadTableFromFiles(ctx, tableName, testTableSchema, []string{writeGzipCSV(t, "load.csv.gz", records)}, "", false, false)
+		require.NoError(t, err)
+		require.EqualValues(t, 2, loadStats.RowsProcessed)
+		require.EqualValues(t, 3, loadStats.RowsSkipped)
+
+		count, err := pg.GetTotalCountInTable(ctx, tableName)
+		require.NoError(t, err)
+		require.EqualValues(t, 2, count)
+
+		require.EqualValues(t, 2, skippedRows(store, badRowColumnCountMismatch))
+		require.EqualValues(t, 1, skippedRows(store, badRowInvalidValue))
+
+		rows, err := db.QueryContext(ctx, fmt.Sprintf(`SELECT coalesce(row_id, ''), column_name, column_value FROM %q.%q ORDER BY column_value;`, testNamespace, warehouseutils.DiscardsTable))
+		require.NoError(t, err)
+		defer func() { _ = rows.Close() }()
+
+		var discards [][]string
+		for rows.Next() {
+			var rowID, columnName, columnValue string
+			require.NoError(t, rows.Scan(&rowID, &columnName, &columnValue))
+			discards = append(discards, []string{rowID, columnName, columnValue})
+		}
+		require.NoError(t, rows.Err())
+		require.Equal(t, [][]string{
+			{"", "", "2,2023-01-01T00:00:00Z,2,second,extra"},
+			{"", "", "4,2023-01-01T00:00:00Z,4"},
+			{"3", "test_int", "three"},
+		}, discards)
+	})
+
+	t.Run("over the threshold", func(t *testing.T) {
+		pg, db, store := setup(t, 2)
+
+		_, _, err := pg.loadTableFromFiles(ctx, tableName, testTableSchema, []string{writeGzipCSV(t, "load.csv.gz", records)}, "", false, false)
+		var badRowsErr *TooManyBadRowsError
+		require.ErrorAs(t, err, &badRowsErr)
+		require.EqualError(t, err, "more than 2 malformed rows in the load files of table test_table")
+
+		count, err := pg.GetTotalCountInTable(ctx, tableName)
+		require.NoError(t, err)
+		require.Zero(t, count)
+
+		var discardsTable sql.NullString
+		require.NoError(t, db.QueryRowContext(ctx, `SELECT to_regclass($1)::text;`, fmt.Sprintf(`%q.%q`, testNamespace, warehouseutils.DiscardsTable)).Scan(&discardsTable))
+		require.False(t, discardsTable.Valid)
+
+		require.EqualValues(t, 2, skippedRows(store, badRowColumnCountMismatch))
+		require.EqualValues(t, 1, skippedRows(store, badRowInvalidValue))
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		pg, _, _ := setup(t, 0)
+
+		_, _, err := pg.loadTableFromFiles(ctx, tableName, testTableSchema, []string{writeGzipCSV(t, "load.csv.gz", records)}, "", false, false)
+		require.ErrorIs(t, err, csv.ErrFieldCount)
+	})
+}
+
+func TestLoadTableFromFiles_PartitionedTable(t *testing.T) {
+	misc.Init()
+	warehouseutils.Init()
+
+	const tableName = "test_table"
+
+	db := setupDB(t)
+	ctx := context.Background()
+
+	c := config.New()
+	c.Set("Warehouse.postgres.enableSQLStatementExecutionPlan", true)
+
+	pg := newTestPostgres(db, c)
+	pg.Uploader = &mockUploader{
+		schema: model.Schema{
+			tableName: testTableSchema,
+		},
+	}
+
+	log := &planLogger{Logger: logger.NOP}
+	pg.logger = log
+
+	require.NoError(t, pg.CreateSchema(ctx))
+	_, err := db.ExecContext(ctx, fmt.Sprintf(`
+		CREATE TABLE %[1]q.%[2]q (
+		  id text,
+		  received_at timestamptz,
+		  test_int bigint,
+		  test_string text
+		) PARTITION BY RANGE (received_at);
+		CREATE TABLE %[1]q.%[2]q_2023_01 PARTITION OF %[1]q.%[2]q FOR VALUES FROM ('2023-01-01') TO ('2023-02-01');
+		CREATE TABLE %[1]q.%[2]q_2023_02 PARTITION OF %[1]q.%[2]q FOR VALUES FROM ('2023-02-01') TO ('2023-03-01');
+	`,
+		testNamespace,
+		tableName,
+	))
+	require.NoError(t, err)
+
+	// columns are sorted: id, received_at, test_int, test_string
+	err = pg.LoadTableFromFiles(ctx, tableName, []string{
+		writeGzipCSV(t, "first.csv.gz", [][]string{
+			{"1", "2023-01-10T00:00:00Z", "1", "first"},
+			{"2", "2023-02-10T00:00:00Z", "2", "first"},
+		}),
+	})
+	require.NoError(t, err)
+
+	log.plans = nil
+
+	err = pg.LoadTableFromFiles(ctx, tableName, []string{
+		writeGzipCSV(t, "second.csv.gz", [][]string{
+			{"1", "2023-01-10T00:00:00Z", "1", "second"},
+			{"3", "2023-01-20T00:00:00Z", "3", "second"},
+		}),
+	})
+	require.NoError(t, err)
+
+	// only the partition of the staged records is scanned by the dedup delete
+	require.NotEmpty(t, log.plans)
+	require.Contains(t, log.plans[0], "DELETE FROM")
+	require.Contains(t, log.plans[0], tableName+"_2023_01")
+	require.NotContains(t, log.plans[0], tableName+"_2023_02")
+
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(`SELECT id, test_string FROM %q.%q;`, testNamespace, tableName))
+	require.NoError(t, err)
+	defer func() { _ = rows.Close() }()
+
+	got := make(map[string]string)
+	for rows.Next() {
+		var id, testString string
+		require.NoError(t, rows.Scan(&id, &testString))
+		got[id] = testString
+	}
+	require.NoError(t, rows.Err())
+	require.Equal(t, map[string]string{
+		"1": "second",
+		"2": "first",
+		"3": "second",
+	}, got)
+}
+
+func TestLoadTableFromFiles_Progress(t *testing.T) {
+	misc.Init()
+	warehouseutils.Init()
+
+	const tableName = "test_table"
+
+	db := setupDB(t)
+	ctx := context.Background()
+
+	c := config.New()
+	c.Set("Warehouse.postgres.loadProgressInterval", 4)
+
+	store := memstats.New()
+
+	pg := newTestPostgres(db, c)
+	pg.stats = store
+	pg.Uploader = &mockUploader{
+		schema: model.Schema{
+			tableName: testTableSchema,
+		},
+	}
+	createTestTable(t, pg, tableName)
+
+	// 3 files of 5 rows each
+	var filePaths []string
+	for i := 0; i < 3; i++ {
+		var records [][]string
+		for j := 0; j < 5; j++ {
+			id := strconv.Itoa(i*5 + j)
+
+			// columns are sorted: id, received_at, test_int, test_string
+			records = append(records, []string{id, "2023-01-01T00:00:00Z", id, "test"})
+		}
+		filePaths = append(filePaths, writeGzipCSV(t, fmt.Sprintf("load-%d.csv.gz", i), records))
+	}
+
+	require.NoError(t, pg.LoadTableFromFiles(ctx, tableName, filePaths))
+
+	tags := stats.Tags{
+		"workspaceId":     testWorkspaceID,
+		"destinationID":   testDestID,
+		"tableName":       tableName,
+		"storageProvider": "",
+		"region":          "",
+		connectionModeKey: connectionModeDirect,
+	}
+	require.Equal(t, []float64{4, 8, 12}, store.Get("pg_load_progress", tags).Values())
+	require.Equal(t, []float64{1, 2, 3}, store.Get("pg_load_progress_files", tags).Values())
+}
+
+// concurrentFileManager tracks the maximum number of downloads running at once, failing the downloads of failKey.
+type concurrentFileManager struct {
+	filemanager.FileManager
+	failKey string
+
+	inFlight    atomic.Int64
+	maxInFlight atomic.Int64
+}
+
+func (m *concurrentFileManager) Download(ctx context.Context, f *os.File, key string) error {
+	inFlight := m.inFlight.Add(1)
+	defer m.inFlight.Add(-1)
+	for {
+		maxInFlight := m.maxInFlight.Load()
+		if inFlight <= maxInFlight || m.maxInFlight.CompareAndSwap(maxInFlight, inFlight) {
+			break
+		}
+	}
+
+	time.Sleep(10 * time.Millisecond)
+
+	if key == m.failKey {
+		return errors.New("download failed")
+	}
+	return m.FileManager.Download(ctx, f, key)
+}
+
+func TestDownloadLoadFiles_Concurrency(t *testing.T) {
+	misc.Init()
+	warehouseutils.Init()
+
+	const (
+		tableName   = "test_table"
+		numObjects  = 50
+		concurrency = 4
+	)
+
+	ctx := context.Background()
+
+	setup := func(t *testing.T, failKey string) (*Postgres, *concurrentFileManager, string) {
+		t.Helper()
+
+		tmpDir := t.TempDir()
+
+		c := config.New()
+		c.Set("Warehouse.postgres.tmpDir", tmpDir)
+		c.Set("Warehouse.postgres.downloadConcurrency", concurrency)
+		c.Set("Warehouse.postgres.downloadRetryInterval", "1ms")
+
+		var filePaths []string
+		for i := 0; i < numObjects; i++ {
+			filePaths = append(filePaths, writeGzip(t, fmt.Sprintf("load-%d.csv.gz", i), fmt.Sprintf("%d\n", i)))
+		}
+
+		pg := newTestPostgres(nil, c)
+		setupLoadFiles(pg, &mockUploader{}, map[string][]string{
+			tableName: filePaths,
+		})
+
+		fm := &concurrentFileManager{
+			FileManager: pg.fileManagerFactory.(*mockFileManagerFactory).fileManager,
+			failKey:     failKey,
+		}
+		pg.fileManagerFactory = &mockFileManagerFactory{fileManager: fm}
+		return pg, fm, tmpDir
+	}
+
+	t.Run("success", func(t *testing.T) {
+		pg, fm, _ := setup(t, "")
+
+		fileNames, err := pg.DownloadLoadFiles(ctx, tableName)
+		require.NoError(t, err)
+		defer misc.RemoveFilePaths(fileNames...)
+
+		require.Len(t, fileNames, numObjects)
+		for i, fileName := range fileNames {
+			require.True(t, strings.HasSuffix(fileName, fmt.Sprintf("%s/%d-load-%d.csv.gz", tableName, i, i)), fileName)
+			require.FileExists(t, fileName)
+		}
+
+		require.Greater(t, fm.maxInFlight.Load(), int64(1))
+		require.LessOrEqual(t, fm.maxInFlight.Load(), int64(concurrency))
+	})
+
+	t.Run("partial failure", func(t *testing.T) {
+		pg, _, tmpDir := setup(t, fmt.Sprintf("%s/%d-load-%d.csv.gz", tableName, numObjects/2, numObjects/2))
+
+		fileNames, err := pg.DownloadLoadFiles(ctx, tableName)
+		require.EqualError(t, err, "download failed")
+		require.Nil(t, fileNames)
+
+		// the emptied directories are removed along with the files
+		var remaining []string
+		err = filepath.WalkDir(tmpDir, func(path string, d os.DirEntry, err error) error {
+			if err == nil && !d.IsDir() {
+				remaining = append(remaining, path)
+			}
+			return err
+		})
+		if !errors.Is(err, os.ErrNotExist) {
+			require.NoError(t, err)
+		}
+		require.Empty(t, remaining)
+	})
+}
+
+// flakyFileManager fails the first downloads of every key with err, after writing part of the object.
+type flakyFileManager struct {
+	filemanager.FileManager
+	failures int
+	err      error
+
+	mu        sync.Mutex
+	downloads map[string]int
+}
+
+func (m *flakyFileManager) Download(ctx context.Context, f *os.File, key string) error {
+	m.mu.Lock()
+	m.downloads[key]++
+	downloads := m.downloads[key]
+	m.mu.Unlock()
+
+	if downloads <= m.failures {
+		_, _ = f.WriteString("partial")
+		return m.err
+	}
+	return m.FileManager.Download(ctx, f, key)
+}
+
+// awsRequestFailure is an object storage error with the status code of the response, as returned by the aws sdk.
+type awsRequestFailure struct {
+	statusCode int
+}
+
+func (e awsRequestFailure) Error() string   { return fmt.Sprintf("status code: %d", e.statusCode) }
+func (e awsRequestFailure) StatusCode() int { return e.statusCode }
+
+// azureResponseError is an error response of azure blob storage.
+type azureResponseError struct {
+	azblob.ResponseError
+	statusCode int
+}
+
+func (e azureResponseError) Error() string { return fmt.Sprintf("status code: %d", e.statusCode) }
+func (e azureResponseError) Response() *http.Response {
+	return &http.Response{StatusCode: e.statusCode}
+}
+
+func TestDownloadLoadFiles_Retries(t *testing.T) {
+	misc.Init()
+	warehouseutils.Init()
+
+	const tableName = "test_table"
+
+	ctx := context.Background()
+
+	setup := func(t *testing.T, failures int, downloadErr error) (*Postgres, *flakyFileManager, *memstats.Store, string) {
+		t.Helper()
+
+		tmpDir := t.TempDir()
+
+		c := config.New()
+		c.Set("Warehouse.postgres.tmpDir", tmpDir)
+		c.Set("Warehouse.postgres.downloadRetries", 3)
+		c.Set("Warehouse.postgres.downloadRetryInterval", "1ms")
+
+		store := memstats.New()
+
+		pg := newTestPostgres(nil, c)
+		pg.stats = store
+		setupLoadFiles(pg, &mockUploader{}, map[string][]string{
+			tableName: {writeGzip(t, "load.csv.gz", "1\n")},
+		})
+
+		fm := &flakyFileManager{
+			FileManager: pg.fileManagerFactory.(*mockFileManagerFactory).fileManager,
+			failures:    failures,
+			err:         downloadErr,
+			downloads:   make(map[string]int),
+		}
+		pg.fileManagerFactory = &mockFileManagerFactory{fileManager: fm}
+		return pg, fm, store, tmpDir
+	}
+
+	remainingFiles := func(t *testing.T, dir string) []string {
+		var remaining []string
+		err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+			if err == nil && !d.IsDir() {
+				remaining = append(remaining, path)
+			}
+			return err
+		})
+		if !errors.Is(err, os.ErrNotExist) {
+			require.NoError(t, err)
+		}
+		return remaining
+	}
+
+	// memstats keeps the last measurement only, so the retries are counted by the downloads of the file manager
+	retried := func(store *memstats.Store) bool {
+		measurement := store.Get("pg_download_retries", stats.Tags{
+			"workspaceId":   testWorkspaceID,
+			"namespace":     testNamespace,
+			"destinationID": testDestID,
+			"tableName":     tableName,
+		})
+		return measurement != nil
+	}
+
+	t.Run("transient errors", func(t *testing.T) {
+		pg, fm, store, _ := setup(t, 2, &net.OpError{Op: "read", Net: "tcp", Err: os.ErrDeadlineExceeded})
+
+		fileNames, err := pg.DownloadLoadFiles(ctx, tableName)
+		require.NoError(t, err)
+		defer misc.RemoveFilePaths(fileNames...)
+
+		require.Len(t, fileNames, 1)
+		require.Equal(t, map[string]int{tableName + "/0-load.csv.gz": 3}, fm.downloads)
+		require.True(t, retried(store))
+
+		// the partial downloads are discarded
+		f, err := os.Open(fileNames[0])
+		require.NoError(t, err)
+		defer func() { _ = f.Close() }()
+
+		gzipReader, err := gzip.NewReader(f)
+		require.NoError(t, err)
+		content, err := io.ReadAll(gzipReader)
+		require.NoError(t, err)
+		require.Equal(t, "1\n", string(content))
+	})
+
+	t.Run("retries exhausted", func(t *testing.T) {
+		pg, fm, store, tmpDir := setup(t, 10, awsRequestFailure{statusCode: http.StatusServiceUnavailable})
+
+		fileNames, err := pg.DownloadLoadFiles(ctx, tableName)
+		require.ErrorIs(t, err, awsRequestFailure{statusCode: http.StatusServiceUnavailable})
+		require.Nil(t, fileNames)
+
+		require.Equal(t, map[string]int{tableName + "/0-load.csv.gz": 4}, fm.downloads)
+		require.True(t, retried(store))
+		require.Empty(t, remainingFiles(t, tmpDir))
+	})
+
+	t.Run("missing object", func(t *testing.T) {
+		pg, fm, store, tmpDir := setup(t, 10, filemanager.ErrKeyNotFound)
+
+		fileNames, err := pg.DownloadLoadFiles(ctx, tableName)
+		require.ErrorIs(t, err, filemanager.ErrKeyNotFound)
+		require.Nil(t, fileNames)
+
+		require.Equal(t, map[string]int{tableName + "/0-load.csv.gz": 1}, fm.downloads)
+		require.False(t, retried(store))
+		require.Empty(t, remainingFiles(t, tmpDir))
+	})
+}
+
+func TestIsTransientDownloadError(t *testing.T) {
+	testCases := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "timeout", err: &net.OpError{Op: "read", Net: "tcp", Err: os.ErrDeadlineExceeded}, want: true},
+		{name: "deadline exceeded", err: context.DeadlineExceeded, want: true},
+		{name: "unknown", err: errors.New("connection reset by peer"), want: true},
+		{name: "internal server error", err: awsRequestFailure{statusCode: http.StatusInternalServerError}, want: true},
+		{name: "throttled", err: fmt.Errorf("downloading: %w", awsRequestFailure{statusCode: http.StatusTooManyRequests}), want: true},
+		{name: "request timeout", err: awsRequestFailure{statusCode: http.StatusRequestTimeout}, want: true},
+		{name: "not found", err: awsRequestFailure{statusCode: http.StatusNotFound}},
+		{name: "forbidden", err: awsRequestFailure{statusCode: http.StatusForbidden}},
+		{name: "missing key", err: filemanager.ErrKeyNotFound},
+		{name: "missing gcs object", err: storage.ErrObjectNotExist},
+		{name: "gcs not found", err: &googleapi.Error{Code: http.StatusNotFound}},
+		{name: "gcs internal server error", err: &googleapi.Error{Code: http.StatusInternalServerError}, want: true},
+		{name: "missing minio object", err: minio7.ErrorResponse{Code: "NoSuchKey", StatusCode: http.StatusNotFound}},
+		{name: "minio forbidden", err: fmt.Errorf("downloading: %w", minio7.ErrorResponse{StatusCode: http.StatusForbidden})},
+		{name: "minio service unavailable", err: minio7.ErrorResponse{StatusCode: http.StatusServiceUnavailable}, want: true},
+		{name: "missing azure blob", err: azureResponseError{statusCode: http.StatusNotFound}},
+		{name: "azure internal server error", err: azureResponseError{statusCode: http.StatusInternalServerError}, want: true},
+		{name: "canceled", err: context.Canceled},
+		{name: "local file", err: &fs.PathError{Op: "write", Path: "load.csv.gz", Err: syscall.ENOSPC}},
+	}
+
+	for _, tc := range testCases {
+		require.Equal(t, tc.want, isTransientDownloadError(tc.err), tc.name)
+	}
+}
+
+func TestDownloadLoadFiles_TmpDir(t *testing.T) {
+	misc.Init()
+	warehouseutils.Init()
+
+	const tableName = "test_table"
+
+	ctx := context.Background()
+
+	t.Run("custom dir", func(t *testing.T) {
+		tmpDir := t.TempDir()
+
+		c := config.New()
+		c.Set("Warehouse.postgres.tmpDir", tmpDir)
+
+		pg := newTestPostgres(nil, c)
+		setupLoadFiles(pg, &mockUploader{}, map[string][]string{
+			tableName: {
+				writeGzip(t, "a.csv.gz", "1\n"),
+				writeGzip(t, "b.csv.gz", "2\n"),
+			},
+		})
+
+		fileNames, err := pg.DownloadLoadFiles(ctx, tableName)
+		require.NoError(t, err)
+		require.Len(t, fileNames, 2)
+
+		for _, fileName := range fileNames {
+			require.True(t, strings.HasPrefix(fileName, filepath.Join(tmpDir, misc.RudderWarehouseLoadUploadsTmp)), fileName)
+			require.FileExists(t, fileName)
+		}
+
+		misc.RemoveFilePaths(fileNames...)
+		for _, fileName := range fileNames {
+			require.NoFileExists(t, fileName)
+		}
+	})
+
+	t.Run("default dir", func(t *testing.T) {
+		defaultTmpDir, err := misc.CreateTMPDIR()
+		require.NoError(t, err)
+
+		pg := newTestPostgres(nil, config.New())
+		setupLoadFiles(pg, &mockUploader{}, map[string][]string{
+			tableName: {writeGzip(t, "a.csv.gz", "1\n")},
+		})
+
+		fileNames, err := pg.DownloadLoadFiles(ctx, tableName)
+		require.NoError(t, err)
+		defer misc.RemoveFilePaths(fileNames...)
+
+		require.Len(t, fileNames, 1)
+		require.True(t, strings.HasPrefix(fileNames[0], defaultTmpDir), fileNames[0])
+	})
+
+	t.Run("setup validation", func(t *testing.T) {
+		filePath := filepath.Join(t.TempDir(), "file")
+		require.NoError(t, os.WriteFile(filePath, nil, 0o600))
+
+		readOnlyDir := filepath.Join(t.TempDir(), "read-only")
+		require.NoError(t, os.Mkdir(readOnlyDir, 0o500))
+
+		testCases := []struct {
+			name       string
+			tmpDir     string
+			wantErr    string
+			skipAsRoot bool
+		}{
+			{
+				name:    "missing",
+				tmpDir:  filepath.Join(t.TempDir(), "missing"),
+				wantErr: "checking tmp directory",
+			},
+			{
+				name:    "not a directory",
+				tmpDir:  filePath,
+				wantErr: "is not a directory",
+			},
+			{
+				name:       "not writable",
+				tmpDir:     readOnlyDir,
+				wantErr:    "is writable",
+				skipAsRoot: true,
+			},
+		}
+
+		for _, tc := range testCases {
+			tc := tc
+
+			t.Run(tc.name, func(t *testing.T) {
+				if tc.skipAsRoot && os.Geteuid() == 0 {
+					t.Skip("directory permissions are not enforced for root")
+				}
+
+				c := config.New()
+				c.Set("Warehouse.postgres.tmpDir", tc.tmpDir)
+
+				pg := newTestPostgres(nil, c)
+				err := pg.Setup(ctx, pg.Warehouse, &mockUploader{})
+				require.ErrorContains(t, err, tc.wantErr)
+			})
+		}
+
+		require.NoError(t, validateTmpDir(t.TempDir()))
+	})
+}
+
+func TestDownloadLoadFiles_DiskSpace(t *testing.T) {
+	misc.Init()
+	warehouseutils.Init()
+
+	const tableName = "test_table"
+
+	ctx := context.Background()
+
+	// requireNothingDownloaded checks that no load file got downloaded into the tmp directory.
+	requireNothingDownloaded := func(t *testing.T, tmpDir string) {
+		t.Helper()
+		require.NoDirExists(t, filepath.Join(tmpDir, misc.RudderWarehouseLoadUploadsTmp))
+	}
+
+	t.Run("tmpfs", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		if err := exec.Command("mount", "-t", "tmpfs", "-o", "size=1m", "tmpfs", tmpDir).Run(); err != nil {
+			t.Skipf("mounting tmpfs: %v", err)
+		}
+		t.Cleanup(func() { _ = exec.Command("umount", tmpDir).Run() })
+
+		// random data doesn't compress, leaving a load file of 2MB
+		data := make([]byte, 2<<20)
+		_, err := rand.Read(data)
+		require.NoError(t, err)
+		largeFile := filepath.Join(t.TempDir(), "large.csv.gz")
+		require.NoError(t, os.WriteFile(largeFile, data, 0o600))
+
+		c := config.New()
+		c.Set("Warehouse.postgres.tmpDir", tmpDir)
+
+		pg := newTestPostgres(nil, c)
+		setupLoadFiles(pg, &mockUploader{}, map[string][]string{
+			tableName: {writeGzip(t, "small.csv.gz", "1\n"), largeFile},
+		})
+
+		_, err = pg.DownloadLoadFiles(ctx, tableName)
+		var diskFullErr *DiskFullError
+		require.ErrorAs(t, err, &diskFullErr)
+		require.Equal(t, tmpDir, diskFullErr.Dir)
+		require.Greater(t, diskFullErr.Required, uint64(2<<20))
+		require.LessOrEqual(t, diskFullErr.Available, uint64(1<<20))
+		requireNothingDownloaded(t, tmpDir)
+
+		var errTypes []model.JobErrorType
+		for _, em := range pg.ErrorMappings() {
+			if em.Format.MatchString(err.Error()) {
+				errTypes = append(errTypes, em.Type)
+			}
+		}
+		require.Equal(t, []model.JobErrorType{model.ResourceExhaustedError}, errTypes)
+
+		t.Run("fitting files", func(t *testing.T) {
+			setupLoadFiles(pg, &mockUploader{}, map[string][]string{
+				tableName: {writeGzip(t, "small.csv.gz", "1\n")},
+			})
+
+			fileNames, err := pg.DownloadLoadFiles(ctx, tableName)
+			require.NoError(t, err)
+			require.Len(t, fileNames, 1)
+			misc.RemoveFilePaths(fileNames...)
+		})
+	})
+
+	t.Run("decompression factor", func(t *testing.T) {
+		for factor, wantErr := range map[float64]bool{
+			0:    false,
+			1:    false,
+			1e18: true,
+		} {
+			tmpDir := t.TempDir()
+
+			c := config.New()
+			c.Set("Warehouse.postgres.tmpDir", tmpDir)
+			c.Set("Warehouse.postgres.diskSpaceFactor", factor)
+
+			pg := newTestPostgres(nil, c)
+			setupLoadFiles(pg, &mockUploader{}, map[string][]string{
+				tableName: {writeGzip(t, "small.csv.gz", "1\n")},
+			})
+
+			fileNames, err := pg.DownloadLoadFiles(ctx, tableName)
+			if !wantErr {
+				require.NoError(t, err, factor)
+				misc.RemoveFilePaths(fileNames...)
+				continue
+			}
+			var diskFullErr *DiskFullError
+			require.ErrorAs(t, err, &diskFullErr, factor)
+			require.ErrorContains(t, err, fmt.Sprintf("not enough disk space in %s to download the load files", tmpDir))
+		}
+	})
+
+	t.Run("unknown sizes", func(t *testing.T) {
+		c := config.New()
+		c.Set("Warehouse.postgres.diskSpaceFactor", 1e18)
+
+		pg := newTestPostgres(nil, c)
+		uploader := &mockUploader{}
+		setupLoadFiles(pg, uploader, map[string][]string{
+			tableName: {writeGzip(t, "small.csv.gz", "1\n")},
+		})
+		uploader.loadFiles[tableName][0].Metadata = nil
+
+		fileNames, err := pg.DownloadLoadFiles(ctx, tableName)
+		require.NoError(t, err)
+		misc.RemoveFilePaths(fileNames...)
+	})
+}
+
+func TestLoadTable_SpecialTableNames(t *testing.T) {
+	misc.Init()
+	warehouseutils.Init()
+
+	ctx := context.Background()
+
+	t.Run("table", func(t *testing.T) {
+		const tableName = "123-events"
+
+		db := setupDB(t)
+
+		pg := newTestPostgres(db, config.New())
+		uploader := &mockUploader{
+			schema: model.Schema{
+				tableName: testTableSchema,
+			},
+		}
+		pg.Uploader = uploader
+		createTestTable(t, pg, tableName)
+		require.NoError(t, pg.AddColumns(ctx, tableName, []warehouseutils.ColumnInfo{{Name: "test_bool", Type: "boolean"}}))
+
+		// columns are sorted: id, received_at, test_int, test_string
+		setupLoadFiles(pg, uploader, map[string][]string{
+			tableName: {
+				writeGzipCSV(t, "first.csv.gz", [][]string{
+					{"1", "2023-01-01T00:00:00Z", "1", "first"},
+					{"2", "2023-01-01T00:00:00Z", "2", "first"},
+				}),
+				writeGzipCSV(t, "second.csv.gz", [][]string{
+					{"2", "2023-01-02T00:00:00Z", "2", "second"},
+				}),
+			},
+		})
+		require.NoError(t, pg.LoadTable(ctx, tableName))
+
+		count, err := pg.GetTotalCountInTable(ctx, tableName)
+		require.NoError(t, err)
+		require.EqualValues(t, 2, count)
+
+		var value string
+		err = db.QueryRowContext(ctx, fmt.Sprintf(`SELECT test_string FROM %q.%q WHERE id = '2';`, testNamespace, tableName)).Scan(&value)
+		require.NoError(t, err)
+		require.Equal(t, "second", value)
+
+		schema, _, err := pg.FetchSchema(ctx)
+		require.NoError(t, err)
+		require.Contains(t, schema, tableName)
+		require.Contains(t, schema[tableName], "test_bool")
+
+		require.NoError(t, pg.DropTable(ctx, tableName))
+	})
+
+	t.Run("users", func(t *testing.T) {
+		db := setupDB(t)
+
+		pg := newTestPostgres(db, config.New())
+		uploader := &mockUploader{
+			schema: model.Schema{
+				warehouseutils.IdentifiesTable: {
+					"id":          "string",
+					"user_id":     "string",
+					"received_at": "datetime",
+					"plan":        "string",
+				},
+				warehouseutils.UsersTable: {
+					"id":          "string",
+					"received_at": "datetime",
+					"plan":        "string",
+				},
+			},
+		}
+		pg.Uploader = uploader
+
+		// columns are sorted: id, plan, received_at, user_id
+		setupLoadFiles(pg, uploader, map[string][]string{
+			warehouseutils.IdentifiesTable: {writeGzipCSV(t, "identifies.csv.gz", [][]string{
+				{"1", "free", "2023-01-01T00:00:00Z", "user_1"},
+				{"2", "pro", "2023-01-02T00:00:00Z", "user_1"},
+			})},
+		})
+		pg.Warehouse.Destination.Config[tablePrefix] = "123-"
+
+		require.NoError(t, pg.CreateSchema(ctx))
+		for tableName, tableSchema := range uploader.schema {
+			require.NoError(t, pg.CreateTable(ctx, tableName, tableSchema))
+		}
+
+		errorsMap := pg.LoadUserTables(ctx)
+		require.NoError(t, errorsMap[warehouseutils.IdentifiesTable])
+		require.NoError(t, errorsMap[warehouseutils.UsersTable])
+
+		var plan string
+		err := db.QueryRowContext(ctx, fmt.Sprintf(`SELECT plan FROM %q.%q WHERE id = 'user_1';`, testNamespace, "123-users")).Scan(&plan)
+		require.NoError(t, err)
+		require.Equal(t, "pro", plan)
+	})
+}
+
+func TestLoadTable_DedupOrderColumn(t *testing.T) {
+	misc.Init()
+	warehouseutils.Init()
+
+	ctx := context.Background()
+
+	t.Run("table", func(t *testing.T) {
+		const tableName = "tracks"
+
+		db := setupDB(t)
+
+		c := config.New()
+		c.Set("Warehouse.postgres.dedupOrderColumns", map[string]interface{}{
+			tableName: "timestamp",
+		})
+
+		pg := newTestPostgres(db, c)
+		tableSchema := model.TableSchema{
+			"id":          "string",
+			"received_at": "datetime",
+			"test_string": "string",
+			"timestamp":   "datetime",
+		}
+		pg.Uploader = &mockUploader{
+			schema: model.Schema{
+				tableName: tableSchema,
+			},
+		}
+		require.NoError(t, pg.CreateSchema(ctx))
+		require.NoError(t, pg.CreateTable(ctx, tableName, tableSchema))
+
+		// columns are sorted: id, received_at, test_string, timestamp
+		err := pg.LoadTableFromFiles(ctx, tableName, []string{
+			writeGzipCSV(t, "load.csv.gz", [][]string{
+				{"1", "2023-01-02T00:00:00Z", "older", "2023-01-01T00:00:00Z"},
+				{"1", "2023-01-01T00:00:00Z", "newer", "2023-01-02T00:00:00Z"},
+			}),
+		})
+		require.NoError(t, err)
+
+		var value string
+		err = db.QueryRowContext(ctx, fmt.Sprintf(`SELECT test_string FROM %q.%q WHERE id = '1';`, testNamespace, tableName)).Scan(&value)
+		require.NoError(t, err)
+		require.Equal(t, "newer", value)
+	})
+
+	t.Run("users", func(t *testing.T) {
+		db := setupDB(t)
+
+		c := config.New()
+		c.Set("Warehouse.postgres.dedupOrderColumns", map[string]interface{}{
+			warehouseutils.UsersTable: "timestamp",
+		})
+
+		pg := newTestPostgres(db, c)
+		uploader := &mockUploader{
+			schema: model.Schema{
+				warehouseutils.IdentifiesTable: {
+					"id":          "string",
+					"user_id":     "string",
+					"received_at": "datetime",
+					"plan":        "string",
+					"timestamp":   "datetime",
+				},
+				warehouseutils.UsersTable: {
+					"id":          "string",
+					"received_at": "datetime",
+					"plan":        "string",
+					"timestamp":   "datetime",
+				},
+			},
+		}
+		pg.Uploader = uploader
+
+		require.NoError(t, pg.CreateSchema(ctx))
+		for tableName, tableSchema := range uploader.schema {
+			require.NoError(t, pg.CreateTable(ctx, tableName, tableSchema))
+		}
+
+		// columns are sorted: id, plan, received_at, timestamp, user_id
+		setupLoadFiles(pg, uploader, map[string][]string{
+			warehouseutils.IdentifiesTable: {writeGzipCSV(t, "identifies.csv.gz", [][]string{
+				{"1", "free", "2023-01-02T00:00:00Z", "2023-01-01T00:00:00Z", "user_1"},
+				{"2", "pro", "2023-01-01T00:00:00Z", "2023-01-02T00:00:00Z", "user_1"},
+			})},
+		})
+
+		errorsMap := pg.LoadUserTables(ctx)
+		require.NoError(t, errorsMap[warehouseutils.IdentifiesTable])
+		require.NoError(t, errorsMap[warehouseutils.UsersTable])
+
+		var plan string
+		err := db.QueryRowContext(ctx, fmt.Sprintf(`SELECT plan FROM %q.%q WHERE id = 'user_1';`, testNamespace, warehouseutils.UsersTable)).Scan(&plan)
+		require.NoError(t, err)
+		require.Equal(t, "pro", plan)
+	})
+
+	t.Run("missing column", func(t *testing.T) {
+		const tableName = "tracks"
+
+		c := config.New()
+		c.Set("Warehouse.postgres.dedupOrderColumns", map[string]interface{}{
+			tableName: "timestamp",
+		})
+
+		pg := newTestPostgres(setupDB(t), c)
+		pg.Uploader = &mockUploader{
+			schema: model.Schema{
+				tableName: testTableSchema,
+			},
+		}
+		createTestTable(t, pg, tableName)
+
+		err := pg.LoadTableFromFiles(ctx, tableName, []string{
+			writeGzipCSV(t, "load.csv.gz", [][]string{
+				{"1", "2023-01-01T00:00:00Z", "1", "first"},
+			}),
+		})
+		require.EqualError(t, err, "dedup order column timestamp not found in table tracks")
+	})
+}
+
+func TestLoadTable_DedupTieBreakColumn(t *testing.T) {
+	misc.Init()
+	warehouseutils.Init()
+
+	ctx := context.Background()
+
+	const tableName = "tracks"
+
+	t.Run("ties", func(t *testing.T) {
+		db := setupDB(t)
+
+		c := config.New()
+		c.Set("Warehouse.postgres.dedupTieBreakColumns", map[string]interface{}{
+			tableName: "test_int",
+		})
+
+		pg := newTestPostgres(db, c)
+		pg.Uploader = &mockUploader{
+			schema: model.Schema{
+				tableName: testTableSchema,
+			},
+		}
+		createTestTable(t, pg, tableName)
+
+		// columns are sorted: id, received_at, test_int, test_string
+		records := [][]string{
+			{"1", "2023-01-01T00:00:00Z", "2", "winner"},
+			{"1", "2023-01-01T00:00:00Z", "1", "loser"},
+		}
+		reversed := [][]string{records[1], records[0]}
+
+		for _, load := range [][][]string{records, reversed, records} {
+			err := pg.LoadTableFromFiles(ctx, tableName, []string{
+				writeGzipCSV(t, "load.csv.gz", load),
+			})
+			require.NoError(t, err)
+
+			var value string
+			err = db.QueryRowContext(ctx, fmt.Sprintf(`SELECT test_string FROM %q.%q WHERE id = '1';`, testNamespace, tableName)).Scan(&value)
+			require.NoError(t, err)
+			require.Equal(t, "winner", value)
+		}
+	})
+
+	t.Run("missing column", func(t *testing.T) {
+		c := config.New()
+		c.Set("Warehouse.postgres.dedupTieBreakColumns", map[string]interface{}{
+			tableName: "sequence",
+		})
+
+		pg := newTestPostgres(setupDB(t), c)
+		pg.Uploader = &mockUploader{
+			schema: model.Schema{
+				tableName: testTableSchema,
+			},
+		}
+		createTestTable(t, pg, tableName)
+
+		err := pg.LoadTableFromFiles(ctx, tableName, []string{
+			writeGzipCSV(t, "load.csv.gz", [][]string{
+				{"1", "2023-01-01T00:00:00Z", "1", "first"},
+			}),
+		})
+		require.EqualError(t, err, "dedup tie break column sequence not found in table tracks")
+	})
+}
+
+// killLogger terminates the connections of the load, the first time the message is logged.
+type killLogger struct {
+	logger.Logger
+
+	t       *testing.T
+	db      *sql.DB
+	message string
+	once    sync.Once
+}
+
+func (l *killLogger) Debugf(format string, args ...interface{}) {
+	if !strings.Contains(fmt.Sprintf(format, args...), l.message) {
+		return
+	}
+	l.once.Do(func() {
+		_, err := l.db.Exec(`
+			SELECT
+			  pg_terminate_backend(pid)
+			FROM
+			  pg_stat_activity
+			WHERE
+			  datname = current_database()
+			  AND pid <> pg_backend_pid()
+			  AND backend_type = 'client backend';
+		`)
+		require.NoError(l.t, err)
+	})
+}
+
+func TestLoadTable_BadConnection(t *testing.T) {
+	misc.Init()
+	warehouseutils.Init()
+
+	const tableName = "tracks"
+
+	ctx := context.Background()
+
+	pg := newTestPostgres(nil, config.New())
+	uploader := &mockUploader{
+		schema: model.Schema{
+			tableName: testTableSchema,
+		},
+	}
+	setupLoadFiles(pg, uploader, map[string][]string{
+		tableName: {writeGzipCSV(t, "load.csv.gz", [][]string{
+			{"1", "2023-01-01T00:00:00Z", "1", "first"},
+			{"2", "2023-01-01T00:00:00Z", "2", "first"},
+		})},
+	})
+	pgResource := setupCredentials(t, pg)
+	pg.logger = &killLogger{
+		Logger:  logger.NOP,
+		t:       t,
+		db:      pgResource.DB,
+		message: "Creating temporary table for table:" + tableName,
+	}
+
+	var err error
+	pg.DB, err = pg.connect()
+	require.NoError(t, err)
+	createTestTable(t, pg, tableName)
+
+	// the staging tables of a concurrent load of another table whose name starts with the one of the table,
+	// and of a concurrent load of the table by another instance, are left alone by the retry
+	otherStagingTableNames := []string{"rudder_staging_tracks_other_" + warehouseutils.RandHex(), newStagingTableName(tableName)}
+	slices.Sort(otherStagingTableNames)
+	for _, stagingTableName := range otherStagingTableNames {
+		_, err := pgResource.DB.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE %q.%q (id text);`, testNamespace, stagingTableName))
+		require.NoError(t, err)
+	}
+
+	previousDB := pg.DB
+	require.NoError(t, pg.LoadTable(ctx, tableName))
+	require.NotSame(t, previousDB, pg.DB)
+
+	count, err := pg.GetTotalCountInTable(ctx, tableName)
+	require.NoError(t, err)
+	require.EqualValues(t, 2, count)
+
+	var stagingTableNames []string
+	rows, err := pgResource.DB.QueryContext(ctx, `SELECT table_name FROM information_schema.tables WHERE table_schema = $1 AND table_name LIKE 'rudder\_staging\_%' ORDER BY table_name;`, testNamespace)
+	require.NoError(t, err)
+	defer func() { _ = rows.Close() }()
+	for rows.Next() {
+		var stagingTableName string
+		require.NoError(t, rows.Scan(&stagingTableName))
+		stagingTableNames = append(stagingTableNames, stagingTableName)
+	}
+	require.NoError(t, rows.Err())
+	require.Equal(t, otherStagingTableNames, stagingTableNames)
+	require.Empty(t, pg.createdStagingTablesOf(tableName))
+}
+
+func TestLoadTables_ConcurrentBadConnection(t *testing.T) {
+	misc.Init()
+	warehouseutils.Init()
+
+	ctx := context.Background()
+
+	c := config.New()
+	c.Set("Warehouse.postgres.tableLoadConcurrency", 2)
+
+	tableNames := []string{"tracks", "pages"}
+
+	pg := newTestPostgres(nil, c)
+	uploader := &mockUploader{
+		schema: model.Schema{
+			"tracks": testTableSchema,
+			"pages":  testTableSchema,
+		},
+	}
+	setupLoadFiles(pg, uploader, map[string][]string{
+		"tracks": {writeGzipCSV(t, "tracks.csv.gz", [][]string{
+			{"1", "2023-01-01T00:00:00Z", "1", "first"},
+		})},
+		"pages": {writeGzipCSV(t, "pages.csv.gz", [][]string{
+			{"1", "2023-01-01T00:00:00Z", "1", "first"},
+		})},
+	})
+	pgResource := setupCredentials(t, pg)
+
+	var err error
+	pg.DB, err = pg.connect()
+	require.NoError(t, err)
+	for _, tableName := range tableNames {
+		createTestTable(t, pg, tableName)
+	}
+
+	// the connections of both loads are terminated while loading the tracks table
+	pg.logger = &killLogger{
+		Logger:  logger.NOP,
+		t:       t,
+		db:      pgResource.DB,
+		message: "Creating temporary table for table:tracks",
+	}
+
+	previousDB := pg.DB
+	errorMap := pg.LoadTables(ctx, tableNames)
+	require.Equal(t, map[string]error{"tracks": nil, "pages": nil}, errorMap)
+	// the connection pool shared by the loads is kept
+	require.Same(t, previousDB, pg.DB)
+
+	for _, tableName := range tableNames {
+		count, err := pg.GetTotalCountInTable(ctx, tableName)
+		require.NoError(t, err)
+		require.EqualValues(t, 1, count)
+	}
+}
+
+func TestLoadTableFromFiles_DedupQuery(t *testing.T) {
+	misc.Init()
+	warehouseutils.Init()
+
+	const tableName = "test_table"
+
+	// columns are sorted: id, received_at, test_int, test_string
+	records := [][]string{
+		{"1", "2023-01-02T00:00:00Z", "1", "latest"},
+		{"1", "2023-01-01T00:00:00Z", "1", "older"},
+		// ties on received_at are broken by the order of the records, the last one winning
+		{"2", "2023-01-01T00:00:00Z", "2", "first tie"},
+		{"2", "2023-01-01T00:00:00Z", "2", "second tie"},
+		{"2", "2023-01-01T00:00:00Z", "2", "last tie"},
+		{"3", "2023-01-01T00:00:00Z", "3", "only"},
+	}
+
+	for _, dedupQuery := range []string{dedupQueryRowNumber, dedupQueryDistinctOn} {
+		dedupQuery := dedupQuery
+
+		t.Run(dedupQuery, func(t *testing.T) {
+			db := setupDB(t)
+			ctx := context.Background()
+
+			c := config.New()
+			c.Set("Warehouse.postgres.dedupQuery", dedupQuery)
+
+			pg := newTestPostgres(db, c)
+			pg.Uploader = &mockUploader{
+				schema: model.Schema{
+					tableName: testTableSchema,
+				},
+			}
+			createTestTable(t, pg, tableName)
+
+			err := pg.LoadTableFromFiles(ctx, tableName, []string{
+				writeGzipCSV(t, "first.csv.gz", records[:3]),
+				writeGzipCSV(t, "second.csv.gz", records[3:]),
+			})
+			require.NoError(t, err)
+
+			rows, err := db.QueryContext(ctx, fmt.Sprintf(`SELECT id, test_string FROM %q.%q ORDER BY id;`, testNamespace, tableName))
+			require.NoError(t, err)
+			defer func() { _ = rows.Close() }()
+
+			values := make(map[string]string)
+			for rows.Next() {
+				var id, value string
+				require.NoError(t, rows.Scan(&id, &value))
+				values[id] = value
+			}
+			require.NoError(t, rows.Err())
+			require.Equal(t, map[string]string{
+				"1": "latest",
+				"2": "last tie",
+				"3": "only",
+			}, values)
+		})
+	}
+}
+
+func TestLoadTableFromFiles_MultistreamGzip(t *testing.T) {
+	misc.Init()
+	warehouseutils.Init()
+
+	const tableName = "test_table"
+
+	db := setupDB(t)
+	ctx := context.Background()
+
+	pg := newTestPostgres(db, config.New())
+	pg.Uploader = &mockUploader{
+		schema: model.Schema{
+			tableName: testTableSchema,
+		},
+	}
+	createTestTable(t, pg, tableName)
+
+	// columns are sorted: id, received_at, test_int, test_string
+	err := pg.LoadTableFromFiles(ctx, tableName, []string{
+		writeMultistreamGzip(t, "load.csv.gz",
+			"1,2023-01-01T00:00:00Z,1,first\n2,2023-01-01T00:00:00Z,2,first\n",
+			"3,2023-01-01T00:00:00Z,3,second\n",
+		),
+	})
+	require.NoError(t, err)
+
+	count, err := pg.GetTotalCountInTable(ctx, tableName)
+	require.NoError(t, err)
+	require.EqualValues(t, 3, count)
+}
+
+func TestLoadTableFromFiles_TimeZone(t *testing.T) {
+	misc.Init()
+	warehouseutils.Init()
+
+	const tableName = "test_table"
+
+	testCases := []struct {
+		name           string
+		loadTimeZone   string
+		wantReceivedAt time.Time
+	}{
+		{
+			name:           "utc by default",
+			loadTimeZone:   "UTC",
+			wantReceivedAt: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:           "configured time zone",
+			loadTimeZone:   "Asia/Kolkata",
+			wantReceivedAt: time.Date(2022, 12, 31, 18, 30, 0, 0, time.UTC),
+		},
+		{
+			name:           "server time zone",
+			loadTimeZone:   "",
+			wantReceivedAt: time.Date(2023, 1, 1, 5, 0, 0, 0, time.UTC),
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			db := setupDB(t)
+			ctx := context.Background()
+
+			// new sessions use the time zone of the role
+			_, err := db.ExecContext(ctx, `ALTER ROLE CURRENT_USER SET TimeZone = 'America/New_York';`)
+			require.NoError(t, err)
+			db.SetMaxIdleConns(0)
+
+			c := config.New()
+			c.Set("Warehouse.postgres.loadTimeZone", tc.loadTimeZone)
+
+			pg := newTestPostgres(db, c)
+			pg.Uploader = &mockUploader{
+				schema: model.Schema{
+					tableName: testTableSchema,
+				},
+			}
+			createTestTable(t, pg, tableName)
+
+			// columns are sorted: id, received_at, test_int, test_string
+			err = pg.LoadTableFromFiles(ctx, tableName, []string{
+				writeGzipCSV(t, "load.csv.gz", [][]string{
+					{"1", "2023-01-01 00:00:00", "1", "first"},
+				}),
+			})
+			require.NoError(t, err)
+
+			var receivedAt time.Time
+			err = db.QueryRowContext(ctx, fmt.Sprintf(`SELECT received_at FROM %q.%q WHERE id = '1';`, testNamespace, tableName)).Scan(&receivedAt)
+			require.NoError(t, err)
+			require.True(t, tc.wantReceivedAt.Equal(receivedAt), receivedAt)
+		})
+	}
+}
+
+func TestLoadIDOf(t *testing.T) {
+	loadID := loadIDOf([]string{"s3://bucket/a.csv.gz", "s3://bucket/b.csv.gz"})
+	require.Len(t, loadID, 32)
+	require.Equal(t, loadID, loadIDOf([]string{"s3://bucket/b.csv.gz", "s3://bucket/a.csv.gz"}))
+	require.NotEqual(t, loadID, loadIDOf([]string{"s3://bucket/a.csv.gz"}))
+	require.NotEqual(t, loadIDOf([]string{"a", "b"}), loadIDOf([]string{"ab"}))
+}
+
+func TestLoadTable_LoadIDColumn(t *testing.T) {
+	misc.Init()
+	warehouseutils.Init()
+
+	const tableName = "test_table"
+
+	db := setupDB(t)
+	ctx := context.Background()
+
+	c := config.New()
+	c.Set("Warehouse.postgres.enableLoadIDColumn", true)
+
+	pg := newTestPostgres(db, c)
+
+	warehouseTableSchema := model.TableSchema{loadIDColumn: "string"}
+	for columnName, columnType := range testTableSchema {
+		warehouseTableSchema[columnName] = columnType
+	}
+	uploader := &mockUploader{
+		schema: model.Schema{
+			tableName: testTableSchema,
+		},
+		warehouseSchema: model.Schema{
+			tableName: warehouseTableSchema,
+		},
+	}
+	pg.Uploader = uploader
+	createTestTable(t, pg, tableName)
+
+	// columns are sorted: id, received_at, test_int, test_string
+	setupLoadFiles(pg, uploader, map[string][]string{
+		tableName: {
+			writeGzipCSV(t, "first.csv.gz", [][]string{
+				{"1", "2023-01-01T00:00:00Z", "1", "first"},
+				{"2", "2023-01-01T00:00:00Z", "2", "first"},
+			}),
+			writeGzipCSV(t, "second.csv.gz", [][]string{
+				{"3", "2023-01-01T00:00:00Z", "3", "second"},
+			}),
+		},
+	})
+
+	// the versions of the rows, which change whenever they are deleted and inserted again
+	rowVersions := func(t *testing.T, tableName string) map[string]string {
+		t.Helper()
+
+		rows, err := db.QueryContext(ctx, fmt.Sprintf(`SELECT id, xmin::text FROM %q.%q;`, testNamespace, tableName))
+		require.NoError(t, err)
+		defer func() { _ = rows.Close() }()
+
+		versions := make(map[string]string)
+		for rows.Next() {
+			var id, xmin string
+			require.NoError(t, rows.Scan(&id, &xmin))
+			versions[id] = xmin
+		}
+		require.NoError(t, rows.Err())
+		return versions
+	}
+
+	require.NoError(t, pg.LoadTable(ctx, tableName))
+	versions := rowVersions(t, tableName)
+	require.Len(t, versions, 3)
+
+	// the retried load keeps the rows it already inserted
+	require.NoError(t, pg.LoadTable(ctx, tableName))
+	require.Equal(t, versions, rowVersions(t, tableName))
+
+	var count, distinctIDs, distinctLoadIDs int
+	err := db.QueryRowContext(ctx, fmt.Sprintf(`SELECT count(*), count(DISTINCT id), count(DISTINCT %q) FROM %q.%q;`, loadIDColumn, testNamespace, tableName)).Scan(&count, &distinctIDs, &distinctLoadIDs)
+	require.NoError(t, err)
+	require.Equal(t, 3, count)
+	require.Equal(t, 3, distinctIDs)
+	require.Equal(t, 1, distinctLoadIDs)
+
+	t.Run("other load", func(t *testing.T) {
+		// columns are sorted: id, received_at, test_int, test_string
+		setupLoadFiles(pg, uploader, map[string][]string{
+			tableName: {writeGzipCSV(t, "other.csv.gz", [][]string{
+				{"1", "2023-01-02T00:00:00Z", "1", "other"},
+			})},
+		})
+		require.NoError(t, pg.LoadTable(ctx, tableName))
+
+		var count int
+		var value string
+		err := db.QueryRowContext(ctx, fmt.Sprintf(`SELECT count(*), max(test_string) FROM %q.%q WHERE id = '1';`, testNamespace, tableName)).Scan(&count, &value)
+		require.NoError(t, err)
+		require.Equal(t, 1, count)
+		require.Equal(t, "other", value)
+	})
+
+	t.Run("table created before opting in", func(t *testing.T) {
+		const tableName = "existing_table"
+
+		createTestTable(t, newTestPostgres(db, config.New()), tableName)
+		uploader.schema[tableName] = testTableSchema
+		uploader.warehouseSchema[tableName] = testTableSchema
+
+		// columns are sorted: id, received_at, test_int, test_string
+		setupLoadFiles(pg, uploader, map[string][]string{
+			tableName: {writeGzipCSV(t, "existing.csv.gz", [][]string{
+				{"1", "2023-01-01T00:00:00Z", "1", "first"},
+			})},
+		})
+		require.NoError(t, pg.LoadTable(ctx, tableName))
+
+		var loadIDs, indexes int
+		err := db.QueryRowContext(ctx, fmt.Sprintf(`SELECT count(%q) FROM %q.%q;`, loadIDColumn, testNamespace, tableName)).Scan(&loadIDs)
+		require.NoError(t, err)
+		require.Equal(t, 1, loadIDs)
+		err = db.QueryRowContext(ctx, `SELECT count(*) FROM pg_indexes WHERE schemaname = $1 AND tablename = $2 AND indexdef LIKE '%UNIQUE%';`, testNamespace, tableName).Scan(&indexes)
+		require.NoError(t, err)
+		require.Equal(t, 1, indexes)
+	})
+}
+
+func TestLoadTable_AnalyzeAfterLoad(t *testing.T) {
+	misc.Init()
+	warehouseutils.Init()
+
+	const tableName = "test_table"
+
+	for _, analyzeAfterLoad := range []bool{true, false} {
+		analyzeAfterLoad := analyzeAfterLoad
+
+		t.Run(fmt.Sprintf("analyzeAfterLoad=%t", analyzeAfterLoad), func(t *testing.T) {
+			db := setupDB(t)
+			ctx := context.Background()
+
+			c := config.New()
+			c.Set("Warehouse.postgres.analyzeAfterLoad", analyzeAfterLoad)
+
+			pg := newTestPostgres(db, c)
+
+			uploader := &mockUploader{
+				schema: model.Schema{
+					tableName: testTableSchema,
+				},
+			}
+			pg.Uploader = uploader
+			createTestTable(t, pg, tableName)
+
+			// columns are sorted: id, received_at, test_int, test_string
+			setupLoadFiles(pg, uploader, map[string][]string{
+				tableName: {writeGzipCSV(t, "load.csv.gz", [][]string{
+					{"1", "2023-01-01T00:00:00Z", "1", "first"},
+				})},
+			})
+			require.NoError(t, pg.LoadTable(ctx, tableName))
+
+			analyzeCount := func() int64 {
+				var count int64
+				err := db.QueryRowContext(ctx, `SELECT analyze_count FROM pg_stat_user_tables WHERE schemaname = $1 AND relname = $2;`, testNamespace, tableName).Scan(&count)
+				require.NoError(t, err)
+				return count
+			}
+
+			if !analyzeAfterLoad {
+				require.Never(t, func() bool { return analyzeCount() > 0 }, time.Second, 100*time.Millisecond)
+				return
+			}
+			// the statistics are reported asynchronously
+			require.Eventually(t, func() bool { return analyzeCount() == 1 }, 5*time.Second, 100*time.Millisecond)
+		})
+	}
+}
+
+func TestLoadTable_ReconnectAfterCleanup(t *testing.T) {
+	misc.Init()
+	warehouseutils.Init()
+
+	const tableName = "tracks"
+
+	ctx := context.Background()
+
+	pg := newTestPostgres(nil, config.New())
+	uploader := &mockUploader{
+		schema: model.Schema{
+			tableName: testTableSchema,
+		},
+	}
+	// columns are sorted: id, received_at, test_int, test_string
+	setupLoadFiles(pg, uploader, map[string][]string{
+		tableName: {writeGzipCSV(t, "load.csv.gz", [][]string{
+			{"1", "2023-01-01T00:00:00Z", "1", "first"},
+			{"2", "2023-01-01T00:00:00Z", "2", "first"},
+		})},
+	})
+	setupCredentials(t, pg)
+
+	require.NoError(t, pg.Reconnect(ctx))
+	createTestTable(t, pg, tableName)
+
+	pg.Cleanup(ctx)
+	closedDB := pg.DB
+	require.Error(t, closedDB.PingContext(ctx))
+
+	require.NoError(t, pg.LoadTable(ctx, tableName))
+	require.NotSame(t, closedDB, pg.DB)
+
+	count, err := pg.GetTotalCountInTable(ctx, tableName)
+	require.NoError(t, err)
+	require.EqualValues(t, 2, count)
+
+	t.Run("without connection", func(t *testing.T) {
+		pg.Cleanup(ctx)
+		pg.DB = nil
+
+		require.NoError(t, pg.LoadTableFromFiles(ctx, tableName, []string{
+			writeGzipCSV(t, "more.csv.gz", [][]string{
+				{"3", "2023-01-01T00:00:00Z", "3", "second"},
+			}),
+		}))
+
+		count, err := pg.GetTotalCountInTable(ctx, tableName)
+		require.NoError(t, err)
+		require.EqualValues(t, 3, count)
+	})
+}
+
+func TestLoadTable_DeadlockRetry(t *testing.T) {
+	misc.Init()
+	warehouseutils.Init()
+
+	const tableName = "tracks"
+
+	ctx := context.Background()
+
+	c := config.New()
+	c.Set("Warehouse.postgres.deadlockRetryInterval", "10ms")
+
+	store := memstats.New()
+
+	pg := newTestPostgres(nil, c)
+	pg.stats = store
+	uploader := &mockUploader{
+		schema: model.Schema{
+			tableName: testTableSchema,
+		},
+	}
+	// columns are sorted: id, received_at, test_int, test_string
+	setupLoadFiles(pg, uploader, map[string][]string{
+		tableName: {writeGzipCSV(t, "load.csv.gz", [][]string{
+			{"1", "2023-01-01T00:00:00Z", "1", "first"},
+		})},
+	})
+	pgResource := setupCredentials(t, pg)
+
+	require.NoError(t, pg.Reconnect(ctx))
+	createTestTable(t, pg, tableName)
+
+	// the concurrent load takes the locks on the table in the opposite order of the load:
+	// it blocks the load from writing to the table, then waits for the lock the load took while creating its staging table.
+	concurrentTxn, err := pgResource.DB.BeginTx(ctx, &sql.TxOptions{})
+	require.NoError(t, err)
+	defer func() { _ = concurrentTxn.Rollback() }()
+
+	// the load detects the deadlock first, so that it is the one aborted
+	_, err = concurrentTxn.ExecContext(ctx, `SET LOCAL deadlock_timeout = '10s';`)
+	require.NoError(t, err)
+	_, err = concurrentTxn.ExecContext(ctx, fmt.Sprintf(`LOCK TABLE %q.%q IN SHARE MODE;`, testNamespace, tableName))
+	require.NoError(t, err)
+
+	loadErr := make(chan error, 1)
+	go func() {
+		loadErr <- pg.LoadTable(ctx, tableName)
+	}()
+
+	require.Eventually(t, func() bool {
+		var waiting int
+		err := pgResource.DB.QueryRowContext(ctx, `
+			SELECT
+			  count(*)
+			FROM
+			  pg_locks
+			WHERE
+			  NOT granted
+			  AND relation = $1::text::regclass;
+		`,
+			fmt.Sprintf(`%q.%q`, testNamespace, tableName),
+		).Scan(&waiting)
+		return err == nil && waiting > 0
+	}, 10*time.Second, 10*time.Millisecond, "load should wait for the lock of the concurrent load")
+
+	_, err = concurrentTxn.ExecContext(ctx, fmt.Sprintf(`LOCK TABLE %q.%q IN ACCESS EXCLUSIVE MODE;`, testNamespace, tableName))
+	require.NoError(t, err)
+	require.NoError(t, concurrentTxn.Commit())
+
+	require.NoError(t, <-loadErr)
+
+	count, err := pg.GetTotalCountInTable(ctx, tableName)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, count)
+
+	require.EqualValues(t, 1, store.Get("pg_deadlock_retries", stats.Tags{
+		"workspaceId":   pg.Warehouse.WorkspaceID,
+		"namespace":     pg.Namespace,
+		"destinationID": pg.Warehouse.Destination.ID,
+		"tableName":     tableName,
+	}).LastValue())
+
+	var stagingTables int
+	err = pgResource.DB.QueryRowContext(ctx, `SELECT count(*) FROM information_schema.tables WHERE table_schema = $1 AND table_name LIKE 'rudder\_staging\_%';`,
+		testNamespace,
+	).Scan(&stagingTables)
+	require.NoError(t, err)
+	require.Zero(t, stagingTables)
+	require.Empty(t, pg.createdStagingTablesOf(tableName))
+}
+
+func TestLoadTable_SerializationFailureRetry(t *testing.T) {
+	misc.Init()
+	warehouseutils.Init()
+
+	const tableName = "tracks"
+
+	ctx := context.Background()
+
+	c := config.New()
+	c.Set("Warehouse.postgres.isolationLevel", "serializable")
+	c.Set("Warehouse.postgres.deadlockRetryInterval", "10ms")
+
+	store := memstats.New()
+
+	pg := newTestPostgres(nil, c)
+	pg.stats = store
+	uploader := &mockUploader{
+		schema: model.Schema{
+			tableName: testTableSchema,
+		},
+	}
+	// columns are sorted: id, received_at, test_int, test_string
+	setupLoadFiles(pg, uploader, map[string][]string{
+		tableName: {writeGzipCSV(t, "load.csv.gz", [][]string{
+			{"1", "2023-01-01T00:00:00Z", "1", "first"},
+		})},
+	})
+	pgResource := setupCredentials(t, pg)
+
+	require.NoError(t, pg.Reconnect(ctx))
+	createTestTable(t, pg, tableName)
+	require.NoError(t, pg.LoadTable(ctx, tableName))
+
+	// the concurrent transaction updates the row the load replaces while deduplicating:
+	// the load waits for its row lock, then can't be serialized once it commits.
+	concurrentTxn, err := pgResource.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	require.NoError(t, err)
+	defer func() { _ = concurrentTxn.Rollback() }()
+
+	_, err = concurrentTxn.ExecContext(ctx, fmt.Sprintf(`UPDATE %q.%q SET test_string = 'concurrent' WHERE id = '1';`, testNamespace, tableName))
+	require.NoError(t, err)
+
+	loadErr := make(chan error, 1)
+	go func() {
+		loadErr <- pg.LoadTable(ctx, tableName)
+	}()
+
+	require.Eventually(t, func() bool {
+		var waiting int
+		err := pgResource.DB.QueryRowContext(ctx, `SELECT count(*) FROM pg_locks WHERE NOT granted;`).Scan(&waiting)
+		return err == nil && waiting > 0
+	}, 10*time.Second, 10*time.Millisecond, "load should wait for the row lock of the concurrent transaction")
+
+	require.NoError(t, concurrentTxn.Commit())
+
+	require.NoError(t, <-loadErr)
+
+	count, err := pg.GetTotalCountInTable(ctx, tableName)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, count)
+
+	var testString string
+	err = pgResource.DB.QueryRowContext(ctx, fmt.Sprintf(`SELECT test_string FROM %q.%q WHERE id = '1';`, testNamespace, tableName)).Scan(&testString)
+	require.NoError(t, err)
+	require.Equal(t, "first", testString)
+
+	require.EqualValues(t, 1, store.Get("pg_serialization_failure_retries", stats.Tags{
+		"workspaceId":   pg.Warehouse.WorkspaceID,
+		"namespace":     pg.Namespace,
+		"destinationID": pg.Warehouse.Destination.ID,
+		"tableName":     tableName,
+	}).LastValue())
+	require.Nil(t, store.Get("pg_deadlock_retries", stats.Tags{
+		"workspaceId":   pg.Warehouse.WorkspaceID,
+		"namespace":     pg.Namespace,
+		"destinationID": pg.Warehouse.Destination.ID,
+		"tableName":     tableName,
+	}))
+
+	var stagingTables int
+	err = pgResource.DB.QueryRowContext(ctx, `SELECT count(*) FROM information_schema.tables WHERE table_schema = $1 AND table_name LIKE 'rudder\_staging\_%';`,
+		testNamespace,
+	).Scan(&stagingTables)
+	require.NoError(t, err)
+	require.Zero(t, stagingTables)
+	require.Empty(t, pg.createdStagingTablesOf(tableName))
+}
+
+func TestLoadTableWithStats(t *testing.T) {
+	misc.Init()
+	warehouseutils.Init()
+
+	const tableName = "tracks"
+
+	ctx := context.Background()
+
+	pg := newTestPostgres(nil, config.New())
+	uploader := &mockUploader{
+		schema: model.Schema{
+			tableName: testTableSchema,
+		},
+	}
+	// columns are sorted: id, received_at, test_int, test_string
+	setupLoadFiles(pg, uploader, map[string][]string{
+		tableName: {
+			writeGzipCSV(t, "first.csv.gz", [][]string{
+				{"1", "2023-01-01T00:00:00Z", "1", "first"},
+				{"1", "2023-01-02T00:00:00Z", "1", "second"},
+				{"2", "2023-01-01T00:00:00Z", "2", "first"},
+			}),
+			writeGzipCSV(t, "second.csv.gz", [][]string{
+				{"2", "2023-01-02T00:00:00Z", "2", "second"},
+				{"3", "2023-01-01T00:00:00Z", "3", "first"},
+			}),
+		},
+	})
+	setupCredentials(t, pg)
+
+	require.NoError(t, pg.Reconnect(ctx))
+	createTestTable(t, pg, tableName)
+
+	loadStats, err := pg.LoadTableWithStats(ctx, tableName)
+	require.NoError(t, err)
+	require.Equal(t, LoadStats{
+		FilesProcessed:   2,
+		RowsProcessed:    5,
+		RowsInserted:     3,
+		RowsDeduplicated: 2,
+	}, loadStats)
+
+	count, err := pg.GetTotalCountInTable(ctx, tableName)
+	require.NoError(t, err)
+	require.EqualValues(t, 3, count)
+
+	t.Run("batches", func(t *testing.T) {
+		pg.MaxFilesPerTxn = 1
+
+		// every batch is deduplicated on its own, the second one replacing the row of id 2 inserted by the first one
+		loadStats, err := pg.LoadTableWithStats(ctx, tableName)
+		require.NoError(t, err)
+		require.Equal(t, LoadStats{
+			FilesProcessed:   2,
+			RowsProcessed:    5,
+			RowsInserted:     4,
+			RowsDeduplicated: 1,
+		}, loadStats)
+
+		count, err := pg.GetTotalCountInTable(ctx, tableName)
+		require.NoError(t, err)
+		require.EqualValues(t, 3, count)
+	})
+}
+
+func TestLoadTableFromFiles_CockroachDB(t *testing.T) {
+	misc.Init()
+	warehouseutils.Init()
+
+	const tableName = "test_table"
+
+	db := setupCockroachDB(t)
+	ctx := context.Background()
+
+	c := config.New()
+	c.Set("Warehouse.postgres.dialect", dialectAuto)
+
+	pg := newTestPostgres(db, c)
+	pg.Uploader = &mockUploader{
+		schema: model.Schema{
+			tableName: testTableSchema,
+		},
+	}
+	createTestTable(t, pg, tableName)
+
+	// columns are sorted: id, received_at, test_int, test_string
+	firstFile := writeGzipCSV(t, "first.csv.gz", [][]string{
+		{"1", "2023-01-01T00:00:00Z", "1", "first"},
+		{"2", "2023-01-01T00:00:00Z", "2", "first"},
+	})
+	secondFile := writeGzipCSV(t, "second.csv.gz", [][]string{
+		{"2", "2023-01-02T00:00:00Z", "2", "second"},
+		{"3", "2023-01-02T00:00:00Z", "3", "second"},
+		{"4", "2023-01-02T00:00:00Z", "4", "second"},
+		{"4", "2023-01-02T00:00:00Z", "4", "second again"},
+	})
+	// the records tied on the order column are deduplicated by the position they were copied in, the last one winning
+	thirdFile := writeGzipCSV(t, "third.csv.gz", [][]string{
+		{"3", "2023-01-02T00:00:00Z", "3", "third"},
+	})
+
+	err := pg.LoadTableFromFiles(ctx, tableName, []string{firstFile, secondFile, thirdFile})
+	require.NoError(t, err)
+	require.Equal(t, dialectCockroachDB, pg.dialect)
+
+	count, err := pg.GetTotalCountInTable(ctx, tableName)
+	require.NoError(t, err)
+	require.EqualValues(t, 4, count)
+
+	for id, want := range map[string]string{
+		"1": "first",
+		"2": "second",
+		"3": "third",
+		"4": "second again",
+	} {
+		var value string
+		err = db.QueryRowContext(ctx, fmt.Sprintf(`SELECT test_string FROM %q.%q WHERE id = $1;`, testNamespace, tableName), id).Scan(&value)
+		require.NoError(t, err)
+		require.Equal(t, want, value, id)
+	}
+}
+
+func TestLoadTableFromFiles_MergeLoadFiles(t *testing.T) {
+	misc.Init()
+	warehouseutils.Init()
+
+	const fileCount = 200
+
+	db := setupDB(t)
+	ctx := context.Background()
+
+	// columns are sorted: id, received_at, test_int, test_string
+	fileNames := make([]string, fileCount)
+	for i := range fileNames {
+		fileNames[i] = writeGzipCSV(t, fmt.Sprintf("%d.csv.gz", i), [][]string{
+			{strconv.Itoa(i % 50), fmt.Sprintf("2023-01-01T00:00:%02dZ", i%60), strconv.Itoa(i), ""},
+		})
+	}
+
+	contents := make(map[bool][][]string)
+	for _, merge := range []bool{false, true} {
+		tableName := fmt.Sprintf("test_table_merge_%t", merge)
+
+		c := config.New()
+		c.Set("Warehouse.postgres.mergeLoadFiles", merge)
+
+		pg := newTestPostgres(db, c)
+		pg.Uploader = &mockUploader{
+			schema: model.Schema{
+				tableName: testTableSchema,
+			},
+		}
+		createTestTable(t, pg, tableName)
+
+		stats, err := pg.loadTableFromFilesInBatches(ctx, tableName, testTableSchema, fileNames, "")
+		require.NoError(t, err, merge)
+		require.Equal(t, fileCount, stats.FilesProcessed, merge)
+		require.EqualValues(t, fileCount, stats.RowsProcessed, merge)
+		require.EqualValues(t, 50, stats.RowsInserted, merge)
+
+		rows, err := db.QueryContext(ctx, fmt.Sprintf(`SELECT id, test_int, test_string IS NULL FROM %q.%q ORDER BY id;`, testNamespace, tableName))
+		require.NoError(t, err)
+		for rows.Next() {
+			var id, testInt, isNull string
+			require.NoError(t, rows.Scan(&id, &testInt, &isNull))
+			contents[merge] = append(contents[merge], []string{id, testInt, isNull})
+		}
+		require.NoError(t, rows.Err())
+		_ = rows.Close()
+	}
+	require.Len(t, contents[true], 50)
+	require.Equal(t, contents[false], contents[true])
+}
+
+func TestLoadTableFromFiles_CreateMissingTables(t *testing.T) {
+	misc.Init()
+	warehouseutils.Init()
+
+	const tableName = "test_table"
+
+	db := setupDB(t)
+	ctx := context.Background()
+
+	// columns are sorted: id, received_at, test_int, test_string
+	loadFile := writeGzipCSV(t, "load.csv.gz", [][]string{
+		{"1", "2023-01-01T00:00:00Z", "1", "first"},
+		{"2", "2023-01-01T00:00:00Z", "2", "first"},
+	})
+
+	for _, createMissingTables := range []bool{false, true} {
+		c := config.New()
+		c.Set("Warehouse.postgres.createMissingTables", createMissingTables)
+
+		store := memstats.New()
+
+		pg := newTestPostgres(db, c)
+		pg.stats = store
+		pg.Uploader = &mockUploader{
+			schema: model.Schema{
+				tableName: testTableSchema,
+			},
+		}
+		createTestTable(t, pg, tableName)
+
+		// the table is dropped outside of rudder
+		_, err := db.ExecContext(ctx, fmt.Sprintf(`DROP TABLE %q.%q;`, testNamespace, tableName))
+		require.NoError(t, err)
+
+		err = pg.LoadTableFromFiles(ctx, tableName, []string{loadFile})
+		if !createMissingTables {
+			var tableNotFoundErr *TableNotFoundError
+			require.ErrorAs(t, err, &tableNotFoundErr)
+			continue
+		}
+		require.NoError(t, err)
+
+		count, err := pg.GetTotalCountInTable(ctx, tableName)
+		require.NoError(t, err)
+		require.EqualValues(t, 2, count)
+
+		require.EqualValues(t, 1, store.Get("pg_missing_table_created", stats.Tags{
+			"workspaceId":   testWorkspaceID,
+			"namespace":     testNamespace,
+			"destinationID": testDestID,
+			"tableName":     tableName,
+		}).LastValue())
+
+		// the table is only created once
+		require.NoError(t, pg.LoadTableFromFiles(ctx, tableName, []string{loadFile}))
+		require.EqualValues(t, 1, store.Get("pg_missing_table_created", stats.Tags{
+			"workspaceId":   testWorkspaceID,
+			"namespace":     testNamespace,
+			"destinationID": testDestID,
+			"tableName":     tableName,
+		}).LastValue())
+	}
+}
+
+func TestLoadTableFromFiles_FreezeInitialLoads(t *testing.T) {
+	misc.Init()
+	warehouseutils.Init()
+
+	const tableName = "test_table"
+
+	db := setupDB(t)
+	ctx := context.Background()
+
+	_, err := db.ExecContext(ctx, `CREATE EXTENSION IF NOT EXISTS pageinspect;`)
+	require.NoError(t, err)
+
+	c := config.New()
+	c.Set("Warehouse.postgres.freezeInitialLoads", true)
+
+	store := memstats.New()
+
+	pg := newTestPostgres(db, c)
+	pg.stats = store
+	pg.Uploader = &mockUploader{
+		schema: model.Schema{
+			tableName: testTableSchema,
+		},
+	}
+	createTestTable(t, pg, tableName)
+
+	// frozenRows counts the row versions of the table which were inserted frozen, including the deleted ones
+	frozenRows := func() int {
+		var count int
+		require.NoError(t, db.QueryRowContext(ctx, fmt.Sprintf(`
+			SELECT count(*) FROM heap_page_items(get_raw_page('%[1]s.%[2]s', 0)) WHERE t_infomask & 768 = 768;
+		`, testNamespace, tableName)).Scan(&count))
+		return count
+	}
+	stagingTablesCreated := func() float64 {
+		measurement := store.Get("pg_staging_table_created", stats.Tags{
+			"workspaceId":   testWorkspaceID,
+			"namespace":     testNamespace,
+			"destinationID": testDestID,
+			"tableName":     tableName,
+		})
+		if measurement == nil {
+			return 0
+		}
+		return measurement.LastValue()
+	}
+	requireRows := func(want map[string]string) {
+		rows, err := db.QueryContext(ctx, fmt.Sprintf(`SELECT id, test_string FROM %q.%q;`, testNamespace, tableName))
+		require.NoError(t, err)
+		defer func() { _ = rows.Close() }()
+
+		got := make(map[string]string)
+		for rows.Next() {
+			var id, testString string
+			require.NoError(t, rows.Scan(&id, &testString))
+			got[id] = testString
+		}
+		require.NoError(t, rows.Err())
+		require.Equal(t, want, got)
+	}
+
+	// columns are sorted: id, received_at, test_int, test_string
+	initialLoadFile := writeGzipCSV(t, "initial.csv.gz", [][]string{
+		{"1", "2023-01-01T00:00:00Z", "1", "first"},
+		{"2", "2023-01-01T00:00:00Z", "2", "first"},
+		{"1", "2023-01-02T00:00:00Z", "1", "second"},
+	})
+	require.NoError(t, pg.LoadTableFromFiles(ctx, tableName, []string{initialLoadFile}))
+
+	// the empty table is copied into directly, deduplicating in place
+	requireRows(map[string]string{"1": "second", "2": "first"})
+	require.Equal(t, 3, frozenRows())
+	require.Zero(t, stagingTablesCreated())
+
+	// loads into the non empty table fall back to the staging table
+	loadFile := writeGzipCSV(t, "load.csv.gz", [][]string{
+		{"2", "2023-01-03T00:00:00Z", "2", "third"},
+		{"3", "2023-01-03T00:00:00Z", "3", "third"},
+	})
+	require.NoError(t, pg.LoadTableFromFiles(ctx, tableName, []string{loadFile}))
+
+	requireRows(map[string]string{"1": "second", "2": "third", "3": "third"})
+	require.Equal(t, 3, frozenRows())
+	require.EqualValues(t, 1, stagingTablesCreated())
+
+	t.Run("load id column", func(t *testing.T) {
+		const tableName = "test_table_load_id"
+
+		c := config.New()
+		c.Set("Warehouse.postgres.freezeInitialLoads", true)
+		c.Set("Warehouse.postgres.enableLoadIDColumn", true)
+
+		store := memstats.New()
+
+		pg := newTestPostgres(db, c)
+		pg.stats = store
+		pg.Uploader = &mockUploader{
+			schema: model.Schema{
+				tableName: testTableSchema,
+			},
+		}
+		createTestTable(t, pg, tableName)
+
+		// the empty table is loaded through the staging table, deduplicating the records before inserting them
+		require.NoError(t, pg.LoadTableFromFiles(ctx, tableName, []string{initialLoadFile}))
+
+		count, err := pg.GetTotalCountInTable(ctx, tableName)
+		require.NoError(t, err)
+		require.EqualValues(t, 2, count)
+		require.NotNil(t, store.Get("pg_staging_table_created", stats.Tags{
+			"workspaceId":   testWorkspaceID,
+			"namespace":     testNamespace,
+			"destinationID": testDestID,
+			"tableName":     tableName,
+		}))
+	})
+}
+
+func TestLoadTableFromFiles_RejoinTrailingColumns(t *testing.T) {
+	misc.Init()
+	warehouseutils.Init()
+
+	const tableName = "test_table"
+
+	db := setupDB(t)
+	ctx := context.Background()
+
+	// columns are sorted: id, received_at, test_int, test_string
+	loadFile := writeGzip(t, "load.csv.gz", "1,2023-01-01T00:00:00Z,1,hello, world\n2,2023-01-01T00:00:00Z,2,plain\n3,2023-01-01T00:00:00Z,3,a,b,c\n")
+
+	for _, rejoin := range []bool{false, true} {
+		c := config.New()
+		if rejoin {
+			c.Set("Warehouse.postgres.rejoinTrailingColumns", map[string]interface{}{
+				tableName: "test_string",
+			})
+		}
+
+		pg := newTestPostgres(db, c)
+		pg.Uploader = &mockUploader{
+			schema: model.Schema{
+				tableName: testTableSchema,
+			},
+		}
+		createTestTable(t, pg, tableName)
+
+		err := pg.LoadTableFromFiles(ctx, tableName, []string{loadFile})
+		if !rejoin {
+			require.ErrorIs(t, err, csv.ErrFieldCount)
+			continue
+		}
+		require.NoError(t, err)
+
+		rows, err := db.QueryContext(ctx, fmt.Sprintf(`SELECT id, test_string FROM %q.%q ORDER BY id;`, testNamespace, tableName))
+		require.NoError(t, err)
+
+		var got [][]string
+		for rows.Next() {
+			var id, testString string
+			require.NoError(t, rows.Scan(&id, &testString))
+			got = append(got, []string{id, testString})
+		}
+		require.NoError(t, rows.Err())
+		require.NoError(t, rows.Close())
+		require.Equal(t, [][]string{{"1", "hello, world"}, {"2", "plain"}, {"3", "a,b,c"}}, got)
+	}
+}
+
+func TestExportTable(t *testing.T) {
+	misc.Init()
+	warehouseutils.Init()
+
+	db := setupDB(t)
+	ctx := context.Background()
+
+	c := config.New()
+	c.Set("Warehouse.postgres.nullMode", nullModeQuoted)
+
+	pg := newTestPostgres(db, c)
+	pg.Uploader = &mockUploader{
+		schema: model.Schema{
+			"source": testTableSchema,
+			"target": testTableSchema,
+		},
+	}
+	require.NoError(t, pg.CreateSchema(ctx))
+	// the columns are in the order of the load files, so that the exported rows can be loaded back
+	for _, tableName := range []string{"source", "target"} {
+		_, err := db.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE %q.%q (id text, received_at timestamptz, test_int bigint, test_string text);`, testNamespace, tableName))
+		require.NoError(t, err)
+	}
+
+	// columns are sorted: id, received_at, test_int, test_string
+	loadFile := writeGzip(t, "load.csv.gz", "1,2023-01-01T00:00:00Z,1,\"hello, \"\"world\"\"\"\n"+
+		"2,2023-01-01T00:00:00Z,,\"\"\n"+
+		"3,2023-01-01T00:00:00Z,3,\"multi\nline\"\n"+
+		"4,2023-01-01T00:00:00Z,4,\n")
+	require.NoError(t, pg.LoadTableFromFiles(ctx, "source", []string{loadFile}))
+
+	want := "id,received_at,test_int,test_string\n" +
+		"1,2023-01-01 00:00:00+00,1,\"hello, \"\"world\"\"\"\n" +
+		"2,2023-01-01 00:00:00+00,,\"\"\n" +
+		"3,2023-01-01 00:00:00+00,3,\"multi\nline\"\n" +
+		"4,2023-01-01 00:00:00+00,4,\n"
+
+	var exported strings.Builder
+	require.NoError(t, pg.ExportTable(ctx, "source", &exported))
+	require.Equal(t, want, exported.String())
+
+	// the exported rows round trip through a load
+	_, rows, _ := strings.Cut(exported.String(), "\n")
+	require.NoError(t, pg.LoadTableFromFiles(ctx, "target", []string{writeGzip(t, "exported.csv.gz", rows)}))
+
+	exported.Reset()
+	require.NoError(t, pg.ExportTable(ctx, "target", &exported))
+	require.Equal(t, want, exported.String())
+
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		cancel()
+
+		require.ErrorIs(t, pg.ExportTable(ctx, "source", io.Discard), context.Canceled)
+	})
+}
+
+func TestLoadTableFromFiles_GeneratedColumns(t *testing.T) {
+	misc.Init()
+	warehouseutils.Init()
+
+	const tableName = "test_table"
+
+	db := setupDB(t)
+	ctx := context.Background()
+
+	tableSchema := model.TableSchema{
+		"id":          "string",
+		"received_at": "datetime",
+		"test_int":    "int",
+		"test_length": "int",
+		"test_string": "string",
+	}
+
+	pg := newTestPostgres(db, config.New())
+	pg.Uploader = &mockUploader{
+		schema: model.Schema{
+			tableName: tableSchema,
+		},
+	}
+	require.NoError(t, pg.CreateSchema(ctx))
+	_, err := db.ExecContext(ctx, fmt.Sprintf(`
+		CREATE TABLE %q.%q (
+		  id text,
+		  received_at timestamptz,
+		  test_int bigint,
+		  test_string text,
+		  test_length bigint GENERATED ALWAYS AS (length(test_string)) STORED,
+		  seq bigint GENERATED ALWAYS AS IDENTITY
+		);
+	`, testNamespace, tableName))
+	require.NoError(t, err)
+
+	// columns are sorted: id, received_at, test_int, test_length, test_string, where the values of test_length are not its lengths
+	require.NoError(t, pg.LoadTableFromFiles(ctx, tableName, []string{writeGzipCSV(t, "first.csv.gz", [][]string{
+		{"1", "2023-01-01T00:00:00Z", "1", "100", "first"},
+		{"2", "2023-01-01T00:00:00Z", "2", "", "second"},
+	})}))
+	require.NoError(t, pg.LoadTableFromFiles(ctx, tableName, []string{writeGzipCSV(t, "second.csv.gz", [][]string{
+		{"2", "2023-01-02T00:00:00Z", "3", "100", "updated"},
+	})}))
+
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(`SELECT id, test_string, test_length, seq FROM %q.%q ORDER BY id;`, testNamespace, tableName))
+	require.NoError(t, err)
+	defer func() { _ = rows.Close() }()
+
+	var got [][]interface{}
+	for rows.Next() {
+		var (
+			id, testString string
+			testLength     int
+			seq            int
+		)
+		require.NoError(t, rows.Scan(&id, &testString, &testLength, &seq))
+		got = append(got, []interface{}{id, testString, testLength, seq})
+	}
+	require.NoError(t, rows.Err())
+	require.Equal(t, [][]interface{}{
+		{"1", "first", 5, 1},
+		{"2", "updated", 7, 3},
+	}, got)
+}
+
+func TestExcludeGeneratedColumnsStatement(t *testing.T) {
+	pg := newTestPostgres(nil, config.New())
+
+	require.Empty(t, pg.excludeGeneratedColumnsStatement("staging", nil, []string{"id"}))
+	require.Equal(t,
+		`ALTER TABLE "test_namespace"."staging" ALTER COLUMN "test_length" DROP NOT NULL, DROP COLUMN "seq"`,
+		pg.excludeGeneratedColumnsStatement("staging", []string{"test_length", "seq"}, []string{"id", "test_length"}),
+	)
+
+	pg.dialect = dialectCockroachDB
+	require.Empty(t, pg.excludeGeneratedColumnsStatement("staging", []string{"test_length", "seq"}, []string{"id", "test_length"}))
+}
+
+func TestLoadTableFromFiles_ColumnMappings(t *testing.T) {
+	misc.Init()
+	warehouseutils.Init()
+
+	const tableName = "test_table"
+
+	db := setupDB(t)
+	ctx := context.Background()
+
+	c := config.New()
+	c.Set("Warehouse.postgres.columnMappings", map[string]interface{}{
+		tableName: map[string]interface{}{
+			"old_string": "test_string",
+		},
+	})
+
+	pg := newTestPostgres(db, c)
+	pg.Uploader = &mockUploader{
+		warehouseSchema: model.Schema{
+			tableName: testTableSchema,
+		},
+	}
+	createTestTable(t, pg, tableName)
+
+	// the load files of the upload still have the column being renamed, which the target table sorts after the others:
+	// columns are sorted: id, old_string, received_at, test_int
+	uploadSchema := model.TableSchema{
+		"id":          "string",
+		"old_string":  "string",
+		"received_at": "datetime",
+		"test_int":    "int",
+	}
+	loadFile := writeGzipCSV(t, "load.csv.gz", [][]string{
+		{"1", "first", "2023-01-01T00:00:00Z", "1"},
+		{"2", "second", "2023-01-02T00:00:00Z", "2"},
+	})
+	_, _, err := pg.loadTableFromFiles(ctx, tableName, uploadSchema, []string{loadFile}, "", false, false)
+	require.NoError(t, err)
+
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(`SELECT id, received_at, test_int, test_string FROM %q.%q ORDER BY id;`, testNamespace, tableName))
+	require.NoError(t, err)
+	defer func() { _ = rows.Close() }()
+
+	var records [][]string
+	for rows.Next() {
+		var (
+			id, testString string
+			receivedAt     time.Time
+			testInt        int
+		)
+		require.NoError(t, rows.Scan(&id, &receivedAt, &testInt, &testString))
+		records = append(records, []string{id, receivedAt.UTC().Format(time.RFC3339), strconv.Itoa(testInt), testString})
+	}
+	require.NoError(t, rows.Err())
+	require.Equal(t, [][]string{
+		{"1", "2023-01-01T00:00:00Z", "1", "first"},
+		{"2", "2023-01-02T00:00:00Z", "2", "second"},
+	}, records)
+}
+
+func TestLoadTableFromFiles_CoalesceUpsert(t *testing.T) {
+	misc.Init()
+	warehouseutils.Init()
+
+	const (
+		upsertTableName  = "test_table_upsert"
+		replaceTableName = "test_table_replace"
+	)
+
+	db := setupDB(t)
+	ctx := context.Background()
+
+	c := config.New()
+	c.Set("Warehouse.postgres.coalesceUpsertTables", []string{upsertTableName})
+
+	// the late event has no test_int
+	partialSchema := model.TableSchema{
+		"id":          "string",
+		"received_at": "datetime",
+		"test_string": "string",
+	}
+
+	pg := newTestPostgres(db, c)
+	uploader := &mockUploader{
+		schema: model.Schema{
+			upsertTableName:  testTableSchema,
+			replaceTableName: testTableSchema,
+		},
+	}
+	pg.Uploader = uploader
+	require.NoError(t, pg.CreateSchema(ctx))
+
+	testRows := func(t *testing.T, tableName string) map[string][]sql.NullString {
+		rows, err := db.QueryContext(ctx, fmt.Sprintf(`SELECT id, test_int::text, test_string FROM %q.%q;`, testNamespace, tableName))
+		require.NoError(t, err)
+		defer func() { _ = rows.Close() }()
+
+		testRows := make(map[string][]sql.NullString)
+		for rows.Next() {
+			var (
+				id                  string
+				testInt, testString sql.NullString
+			)
+			require.NoError(t, rows.Scan(&id, &testInt, &testString))
+			testRows[id] = []sql.NullString{testInt, testString}
+		}
+		require.NoError(t, rows.Err())
+		return testRows
+	}
+
+	for _, tableName := range []string{upsertTableName, replaceTableName} {
+		require.NoError(t, pg.CreateTable(ctx, tableName, testTableSchema))
+
+		// columns are sorted: id, received_at, test_int, test_string
+		uploader.schema[tableName] = testTableSchema
+		err := pg.LoadTableFromFiles(ctx, tableName, []string{writeGzipCSV(t, "load.csv.gz", [][]string{
+			{"1", "2023-01-01T00:00:00Z", "1", "first"},
+			{"2", "2023-01-01T00:00:00Z", "2", "second"},
+		})})
+		require.NoError(t, err)
+
+		// columns are sorted: id, received_at, test_string
+		uploader.schema[tableName] = partialSchema
+		err = pg.LoadTableFromFiles(ctx, tableName, []string{writeGzipCSV(t, "late.csv.gz", [][]string{
+			{"1", "2023-01-02T00:00:00Z", "updated"},
+			{"1", "2023-01-01T12:00:00Z", "superseded"},
+			{"3", "2023-01-02T00:00:00Z", "third"},
+		})})
+		require.NoError(t, err)
+	}
+
+	require.Equal(t, map[string][]sql.NullString{
+		"1": {{String: "1", Valid: true}, {String: "updated", Valid: true}},
+		"2": {{String: "2", Valid: true}, {String: "second", Valid: true}},
+		"3": {{}, {String: "third", Valid: true}},
+	}, testRows(t, upsertTableName))
+
+	// the rows of the other tables are replaced
+	require.Equal(t, map[string][]sql.NullString{
+		"1": {{}, {String: "updated", Valid: true}},
+		"2": {{String: "2", Valid: true}, {String: "second", Valid: true}},
+		"3": {{}, {String: "third", Valid: true}},
+	}, testRows(t, replaceTableName))
+
+	t.Run("upsert index", func(t *testing.T) {
+		var indexDef string
+		err := db.QueryRowContext(ctx, `SELECT indexdef FROM pg_indexes WHERE schemaname = $1 AND indexname = $2;`,
+			testNamespace, indexName(upsertTableName, "upsert_key"),
+		).Scan(&indexDef)
+		require.NoError(t, err)
+		require.Contains(t, indexDef, "CREATE UNIQUE INDEX")
+	})
+}
+
+func TestCoalesceOnConflict(t *testing.T) {
+	pg := New()
+	WithConfig(pg, config.New())
+	pg.Namespace = testNamespace
+
+	require.Equal(t,
+		`ON CONFLICT ("id") DO UPDATE SET "received_at" = COALESCE(EXCLUDED."received_at", "test_namespace"."test_table"."received_at"), "test_string" = COALESCE(EXCLUDED."test_string", "test_namespace"."test_table"."test_string")`,
+		pg.coalesceOnConflict("test_table", []string{"id"}, []string{"id", "received_at", "test_string"}),
+	)
+	require.Equal(t, `ON CONFLICT ("id","row_id") DO NOTHING`, pg.coalesceOnConflict("test_table", []string{"id", "row_id"}, []string{"id", "row_id"}))
+
+	pg.CoalesceUpsertTables = []string{"test_table", warehouseutils.UsersTable, warehouseutils.IdentifiesTable, warehouseutils.DiscardsTable}
+	require.True(t, pg.coalesceUpserts("test_table"))
+	require.False(t, pg.coalesceUpserts("other_table"))
+	require.False(t, pg.coalesceUpserts(warehouseutils.UsersTable))
+	require.False(t, pg.coalesceUpserts(warehouseutils.IdentifiesTable))
+	require.False(t, pg.coalesceUpserts(warehouseutils.DiscardsTable))
+}
+
+func TestLoadTableFromFiles_StagingTableSample(t *testing.T) {
+	misc.Init()
+	warehouseutils.Init()
+
+	const tableName = "test_table"
+
+	db := setupDB(t)
+	ctx := context.Background()
+
+	tmpDir := t.TempDir()
+
+	c := config.New()
+	c.Set("Warehouse.postgres.tmpDir", tmpDir)
+	c.Set("Warehouse.postgres.stagingTableSampleRows", 2)
+
+	pg := newTestPostgres(db, c)
+	pg.Uploader = &mockUploader{
+		schema: model.Schema{
+			tableName: testTableSchema,
+		},
+	}
+	pg.RedactStagingTableSample = func(tableName, columnName, value string) string {
+		if columnName == "test_string" {
+			return "redacted"
+		}
+		return value
+	}
+	createTestTable(t, pg, tableName)
+
+	// the dedup insertion fails
+	_, err := db.ExecContext(ctx, fmt.Sprintf(`
+		CREATE FUNCTION %[1]q.fail_insert() RETURNS trigger AS $$
+		BEGIN
+		  RAISE EXCEPTION 'insert failed';
+		END;
+		$$ LANGUAGE plpgsql;
+		CREATE TRIGGER fail_insert BEFORE INSERT ON %[1]q.%[2]q FOR EACH STATEMENT EXECUTE FUNCTION %[1]q.fail_insert();
+	`, testNamespace, tableName))
+	require.NoError(t, err)
+
+	// columns are sorted: id, received_at, test_int, test_string
+	err = pg.LoadTableFromFiles(ctx, tableName, []string{writeGzipCSV(t, "load.csv.gz", [][]string{
+		{"1", "2023-01-01T00:00:00Z", "1", "first"},
+		{"2", "2023-01-01T00:00:00Z", "2", "second"},
+		{"3", "2023-01-01T00:00:00Z", "3", "third"},
+	})})
+	require.ErrorContains(t, err, "insert failed")
+
+	samples, err := filepath.Glob(filepath.Join(tmpDir, "*_sample_*.csv"))
+	require.NoError(t, err)
+	require.Len(t, samples, 1)
+
+	f, err := os.Open(samples[0])
+	require.NoError(t, err)
+	defer func() { _ = f.Close() }()
+
+	records, err := csv.NewReader(f).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+	require.ElementsMatch(t, []string{"id", "received_at", "test_int", "test_string"}, records[0])
+
+	testStringIndex := slices.Index(records[0], "test_string")
+	for _, record := range records[1:] {
+		require.Equal(t, "redacted", record[testStringIndex])
+	}
+
+	// the staging table is rolled back along with the load
+	var stagingTables int
+	err = db.QueryRowContext(ctx, `SELECT count(*) FROM pg_tables WHERE schemaname = $1 AND tablename LIKE $2;`,
+		testNamespace, warehouseutils.StagingTablePrefix(provider)+"%",
+	).Scan(&stagingTables)
+	require.NoError(t, err)
+	require.Zero(t, stagingTables)
+
+	t.Run("disabled", func(t *testing.T) {
+		require.NoError(t, os.Remove(samples[0]))
+		pg.StagingTableSampleRows = 0
+
+		err := pg.LoadTableFromFiles(ctx, tableName, []string{writeGzipCSV(t, "load.csv.gz", [][]string{
+			{"1", "2023-01-01T00:00:00Z", "1", "first"},
+		})})
+		require.ErrorContains(t, err, "insert failed")
+
+		samples, err := filepath.Glob(filepath.Join(tmpDir, "*_sample_*.csv"))
+		require.NoError(t, err)
+		require.Empty(t, samples)
+	})
+}
+
+func TestLoadTableFromFiles_SkipDedupForEmptyTables(t *testing.T) {
+	misc.Init()
+	warehouseutils.Init()
+
+	const tableName = "test_table"
+
+	db := setupDB(t)
+	ctx := context.Background()
+
+	c := config.New()
+	c.Set("Warehouse.postgres.skipDedupForEmptyTables", true)
+
+	pg := newTestPostgres(db, c)
+	pg.Uploader = &mockUploader{
+		schema: model.Schema{
+			tableName: testTableSchema,
+		},
+	}
+	createTestTable(t, pg, tableName)
+
+	// statement level triggers fire even if no rows are deleted, recording every DELETE issued against the table
+	_, err := db.ExecContext(ctx, fmt.Sprintf(`
+		CREATE TABLE %[1]q.deletes (deleted_at timestamptz);
+		CREATE FUNCTION %[1]q.record_delete() RETURNS trigger AS $$
+		BEGIN
+		  INSERT INTO %[1]q.deletes VALUES (now());
+		  RETURN NULL;
+		END;
+		$$ LANGUAGE plpgsql;
+		CREATE TRIGGER record_delete AFTER DELETE ON %[1]q.%[2]q FOR EACH STATEMENT EXECUTE FUNCTION %[1]q.record_delete();
+	`, testNamespace, tableName))
+	require.NoError(t, err)
+
+	deletes := func() int {
+		var count int
+		require.NoError(t, db.QueryRowContext(ctx, fmt.Sprintf(`SELECT count(*) FROM %q.deletes;`, testNamespace)).Scan(&count))
+		return count
+	}
+	testStrings := func() map[string]string {
+		rows, err := db.QueryContext(ctx, fmt.Sprintf(`SELECT id, test_string FROM %q.%q;`, testNamespace, tableName))
+		require.NoError(t, err)
+		defer func() { _ = rows.Close() }()
+
+		got := make(map[string]string)
+		for rows.Next() {
+			var id, testString string
+			require.NoError(t, rows.Scan(&id, &testString))
+			got[id] = testString
+		}
+		require.NoError(t, rows.Err())
+		return got
+	}
+
+	// columns are sorted: id, received_at, test_int, test_string
+	loadFile := writeGzipCSV(t, "first.csv.gz", [][]string{
+		{"1", "2023-01-01T00:00:00Z", "1", "first"},
+		{"1", "2023-01-02T00:00:00Z", "1", "duplicate"},
+		{"2", "2023-01-01T00:00:00Z", "2", "second"},
+	})
+	loadStats, err := pg.loadTableFromFilesInBatches(ctx, tableName, testTableSchema, []string{loadFile}, "")
+	require.NoError(t, err)
+	require.EqualValues(t, 2, loadStats.RowsInserted)
+	require.Zero(t, deletes())
+	require.Equal(t, map[string]string{"1": "duplicate", "2": "second"}, testStrings())
+
+	loadFile = writeGzipCSV(t, "second.csv.gz", [][]string{
+		{"2", "2023-01-03T00:00:00Z", "2", "updated"},
+	})
+	_, err = pg.loadTableFromFilesInBatches(ctx, tableName, testTableSchema, []string{loadFile}, "")
+	require.NoError(t, err)
+	require.Equal(t, 1, deletes())
+	require.Equal(t, map[string]string{"1": "duplicate", "2": "updated"}, testStrings())
+}
+
+func TestLoadTableFromFiles_Header(t *testing.T) {
+	misc.Init()
+	warehouseutils.Init()
+
+	const tableName = "test_table"
+
+	db := setupDB(t)
+	ctx := context.Background()
+
+	c := config.New()
+	c.Set("Warehouse.postgres.loadFilesHaveHeader", true)
+
+	pg := newTestPostgres(db, c)
+	pg.Uploader = &mockUploader{
+		schema: model.Schema{
+			tableName: testTableSchema,
+		},
+	}
+	createTestTable(t, pg, tableName)
+
+	loadFiles := []string{
+		writeGzip(t, "first.csv.gz", "id,received_at,test_int,test_string\n1,2023-01-01T00:00:00Z,1,first\n"),
+		writeGzip(t, "second.csv.gz", "id,received_at,test_int,test_string\n2,2023-01-01T00:00:00Z,2,second\n"),
+	}
+	require.NoError(t, pg.LoadTableFromFiles(ctx, tableName, loadFiles))
+
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(`SELECT id, test_string FROM %q.%q ORDER BY id;`, testNamespace, tableName))
+	require.NoError(t, err)
+	defer func() { _ = rows.Close() }()
+
+	var got [][]string
+	for rows.Next() {
+		var id, testString string
+		require.NoError(t, rows.Scan(&id, &testString))
+		got = append(got, []string{id, testString})
+	}
+	require.NoError(t, rows.Err())
+	require.Equal(t, [][]string{{"1", "first"}, {"2", "second"}}, got)
+}
+
+func TestLoadTables(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("bounded concurrency", func(t *testing.T) {
+		const concurrency = 3
+
+		var (
+			inFlight, maxInFlight atomic.Int32
+			release               = make(chan struct{})
+		)
+		loadTable := func(context.Context, string) error {
+			n := inFlight.Add(1)
+			defer inFlight.Add(-1)
+			for {
+				m := maxInFlight.Load()
+				if n <= m || maxInFlight.CompareAndSwap(m, n) {
+					break
+				}
+			}
+			<-release
+			return nil
+		}
+
+		tableNames := []string{"tracks", "pages", "screens", "groups", "aliases", "product_viewed"}
+		done := make(chan map[string]error)
+		go func() {
+			done <- loadTables(ctx, tableNames, concurrency, loadTable, nil)
+		}()
+
+		require.Eventually(t, func() bool { return inFlight.Load() == concurrency }, 5*time.Second, time.Millisecond)
+		close(release)
+
+		errorMap := <-done
+		require.Len(t, errorMap, len(tableNames))
+		for _, tableName := range tableNames {
+			require.NoError(t, errorMap[tableName])
+		}
+		require.EqualValues(t, concurrency, maxInFlight.Load())
+	})
+
+	t.Run("per table errors", func(t *testing.T) {
+		errPages := errors.New("loading pages")
+		errUsers := errors.New("loading users")
+
+		var loaded sync.Map
+		loadTable := func(_ context.Context, tableName string) error {
+			loaded.Store(tableName, true)
+			if tableName == "pages" {
+				return errPages
+			}
+			return nil
+		}
+		var userTableLoads atomic.Int32
+		loadUserTables := func(context.Context) map[string]error {
+			userTableLoads.Add(1)
+			return map[string]error{warehouseutils.IdentifiesTable: nil, warehouseutils.UsersTable: errUsers}
+		}
+
+		errorMap := loadTables(ctx, []string{"tracks", warehouseutils.IdentifiesTable, "pages", warehouseutils.UsersTable}, 2, loadTable, loadUserTables)
+		require.Equal(t, map[string]error{
+			"tracks":                       nil,
+			"pages":                        errPages,
+			warehouseutils.IdentifiesTable: nil,
+			warehouseutils.UsersTable:      errUsers,
+		}, errorMap)
+		require.EqualValues(t, 1, userTableLoads.Load())
+
+		_, ok := loaded.Load(warehouseutils.IdentifiesTable)
+		require.False(t, ok)
+		_, ok = loaded.Load(warehouseutils.UsersTable)
+		require.False(t, ok)
+	})
+}
+
+func TestStagingTableOf(t *testing.T) {
+	pg := newTestPostgres(nil, config.New())
+
+	require.Equal(t, "tracks", pg.stagingTableOf(warehouseutils.StagingTableName(provider, "tracks", tableNameLimit)))
+	require.Equal(t, "rudder_staging_dangling", pg.stagingTableOf("rudder_staging_dangling"))
+
+	pg.Warehouse.Destination.Config = map[string]interface{}{"tablePrefix": "stg_"}
+	require.Equal(t, "tracks", pg.stagingTableOf(warehouseutils.StagingTableName(provider, "stg_tracks", tableNameLimit)))
+}
+
+func TestNewStagingTableName(t *testing.T) {
+	for _, tableName := range []string{"tracks", strings.Repeat("a", 100), strings.Repeat("é", 30)} {
+		first, second := newStagingTableName(tableName), newStagingTableName(tableName)
+		require.NotEqual(t, first, second)
+
+		for _, stagingTableName := range []string{first, second} {
+			require.LessOrEqual(t, len(stagingTableName), identifierLimit)
+			require.True(t, utf8.ValidString(stagingTableName))
+			require.Regexp(t, stagingTableNameRegex, stagingTableName)
+		}
+	}
+	require.Equal(t, "tracks", newTestPostgres(nil, config.New()).stagingTableOf(newStagingTableName("tracks")))
+}
+
+func TestLoadTableFromFiles_LingeringStagingTable(t *testing.T) {
+	misc.Init()
+	warehouseutils.Init()
+
+	// long enough for the random suffix of its staging tables to be truncated by postgres, if not truncating the table name instead
+	tableName := "test_table_" + strings.Repeat("x", identifierLimit-len("test_table_"))
+
+	db := setupDB(t)
+	ctx := context.Background()
+
+	pg := newTestPostgres(db, config.New())
+	pg.Uploader = &mockUploader{
+		schema: model.Schema{
+			tableName: testTableSchema,
+		},
+	}
+	createTestTable(t, pg, tableName)
+
+	// the staging table left over by a previous attempt of the load, whose name postgres truncated to its prefix and table name
+	_, err := db.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE %q.%q (LIKE %[1]q.%[3]q);`, testNamespace, warehouseutils.StagingTableName(provider, tableName, tableNameLimit), tableName))
+	require.NoError(t, err)
+
+	// columns are sorted: id, received_at, test_int, test_string
+	loadFile := writeGzipCSV(t, "load.csv.gz", [][]string{
+		{"1", "2023-01-01T00:00:00Z", "1", "first"},
+	})
+	require.NoError(t, pg.LoadTableFromFiles(ctx, tableName, []string{loadFile}))
+
+	count, err := pg.GetTotalCountInTable(ctx, tableName)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, count)
+
+	// the left over staging table is still dropped as dangling
+	require.True(t, pg.dropDanglingStagingTables(ctx))
+	var stagingTables int
+	require.NoError(t, db.QueryRowContext(ctx, `SELECT count(*) FROM information_schema.tables WHERE table_schema = $1 AND table_name LIKE $2;`,
+		testNamespace, warehouseutils.StagingTablePrefix(provider)+"%",
+	).Scan(&stagingTables))
+	require.Zero(t, stagingTables)
+}
+
+func TestStagingTableLifecycleStats(t *testing.T) {
+	misc.Init()
+	warehouseutils.Init()
+
+	const tableName = "test_table"
+
+	db := setupDB(t)
+	ctx := context.Background()
+
+	statValue := func(store *memstats.Store, name, tableName string) float64 {
+		t.Helper()
+
+		m := store.Get(name, stats.Tags{
+			"workspaceId":   testWorkspaceID,
+			"namespace":     testNamespace,
+			"destinationID": testDestID,
+			"tableName":     tableName,
+		})
+		if m == nil {
+			return 0
+		}
+		return m.LastValue()
+	}
+
+	t.Run("load", func(t *testing.T) {
+		store := memstats.New()
+
+		pg := newTestPostgres(db, config.New())
+		pg.stats = store
+		pg.Uploader = &mockUploader{
+			schema: model.Schema{
+				tableName: testTableSchema,
+			},
+		}
+		createTestTable(t, pg, tableName)
+
+		// columns are sorted: id, received_at, test_int, test_string
+		loadFile := writeGzipCSV(t, "load.csv.gz", [][]string{
+			{"1", "2023-01-01T00:00:00Z", "1", "first"},
+		})
+		require.NoError(t, pg.LoadTableFromFiles(ctx, tableName, []string{loadFile}))
+		require.NoError(t, pg.LoadTableFromFiles(ctx, tableName, []string{loadFile}))
+
+		require.EqualValues(t, 2, statValue(store, stagingTableCreated, tableName))
+		require.EqualValues(t, 2, statValue(store, stagingTableDropped, tableName))
+		require.EqualValues(t, 0, statValue(store, stagingTableDropFailed, tableName))
+	})
+
+	t.Run("dangling staging tables", func(t *testing.T) {
+		store := memstats.New()
+
+		pg := newTestPostgres(db, config.New())
+		pg.stats = store
+		require.NoError(t, pg.CreateSchema(ctx))
+
+		droppedTable := warehouseutils.StagingTableName(provider, "tracks", tableNameLimit)
+		failingTable := warehouseutils.StagingTableName(provider, "pages", tableNameLimit)
+		for _, stagingTableName := range []string{droppedTable, failingTable} {
+			_, err := db.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE %q.%q (id text);`, testNamespace, stagingTableName))
+			require.NoError(t, err)
+		}
+		// a dependent view makes dropping the staging table fail
+		_, err := db.ExecContext(ctx, fmt.Sprintf(`CREATE VIEW %q.failing_view AS SELECT id FROM %q.%q;`, testNamespace, testNamespace, failingTable))
+		require.NoError(t, err)
+
+		require.False(t, pg.dropDanglingStagingTables(ctx))
+		require.EqualValues(t, 1, statValue(store, stagingTableDropped, "tracks"))
+		require.EqualValues(t, 0, statValue(store, stagingTableDropFailed, "tracks"))
+		require.EqualValues(t, 0, statValue(store, stagingTableDropped, "pages"))
+		require.EqualValues(t, 1, statValue(store, stagingTableDropFailed, "pages"))
+
+		pg.dropStagingTable(ctx, "pages", failingTable)
+		require.EqualValues(t, 2, statValue(store, stagingTableDropFailed, "pages"))
+
+		_, err = db.ExecContext(ctx, fmt.Sprintf(`DROP VIEW %q.failing_view;`, testNamespace))
+		require.NoError(t, err)
+
+		pg.dropStagingTable(ctx, "pages", failingTable)
+		require.EqualValues(t, 1, statValue(store, stagingTableDropped, "pages"))
+	})
+}
+
+func TestIsDanglingStagingTable(t *testing.T) {
+	now := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	commentOf := func(owner string, createdAt time.Time) sql.NullString {
+		comment, err := json.Marshal(stagingTableComment{CreatedAt: createdAt, Owner: owner})
+		require.NoError(t, err)
+		return sql.NullString{String: string(comment), Valid: true}
+	}
+
+	pg := newTestPostgres(nil, config.New())
+	pg.owner = "self"
+
+	testCases := []struct {
+		name      string
+		retention time.Duration
+		comment   sql.NullString
+		want      bool
+	}{
+		{name: "no retention", comment: commentOf("other", now), want: true},
+		{name: "no comment", retention: time.Hour, want: true},
+		{name: "invalid comment", retention: time.Hour, comment: sql.NullString{String: "created by hand", Valid: true}, want: true},
+		{name: "comment without creation time", retention: time.Hour, comment: sql.NullString{String: `{"owner":"other"}`, Valid: true}, want: true},
+		{name: "own", retention: time.Hour, comment: commentOf("self", now), want: true},
+		{name: "recent of another owner", retention: time.Hour, comment: commentOf("other", now.Add(-time.Minute)), want: false},
+		{name: "expired of another owner", retention: time.Hour, comment: commentOf("other", now.Add(-time.Hour)), want: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			pg.StagingTableRetention = tc.retention
+			require.Equal(t, tc.want, pg.isDanglingStagingTable(tc.comment, now))
+		})
+	}
+}
+
+func TestDropDanglingStagingTables_Retention(t *testing.T) {
+	misc.Init()
+	warehouseutils.Init()
+
+	db := setupDB(t)
+	ctx := context.Background()
+
+	c := config.New()
+	c.Set("Warehouse.postgres.stagingTableRetention", "1h")
+
+	pg := newTestPostgres(db, c)
+	require.NoError(t, pg.CreateSchema(ctx))
+	other := newTestPostgres(db, c)
+
+	createStagingTable := func(t *testing.T, pg *Postgres, tableName string) string {
+		t.Helper()
+
+		stagingTableName := warehouseutils.StagingTableName(provider, tableName, tableNameLimit)
+		txn, err := pg.beginTx(ctx)
+		require.NoError(t, err)
+		_, err = txn.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE %q.%q (id text);`, testNamespace, stagingTableName))
+		require.NoError(t, err)
+		require.NoError(t, pg.commentStagingTable(ctx, txn, stagingTableName))
+		require.NoError(t, txn.Commit())
+		return stagingTableName
+	}
+
+	ownTable := createStagingTable(t, pg, "tracks")
+	recentTable := createStagingTable(t, other, "pages")
+	expiredTable := createStagingTable(t, other, "identifies")
+	uncommentedTable := warehouseutils.StagingTableName(provider, "screens", tableNameLimit)
+	_, err := db.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE %q.%q (id text);`, testNamespace, uncommentedTable))
+	require.NoError(t, err)
+
+	var comment string
+	err = db.QueryRowContext(ctx, `SELECT obj_description(format('%I.%I', $1::text, $2::text)::regclass, 'pg_class');`, testNamespace, ownTable).Scan(&comment)
+	require.NoError(t, err)
+	var ownComment stagingTableComment
+	require.NoError(t, json.Unmarshal([]byte(comment), &ownComment))
+	require.Equal(t, pg.owner, ownComment.Owner)
+	require.WithinDuration(t, time.Now(), ownComment.CreatedAt, time.Minute)
+
+	expiredComment, err := json.Marshal(stagingTableComment{CreatedAt: time.Now().Add(-2 * time.Hour), Owner: other.owner})
+	require.NoError(t, err)
+	_, err = db.ExecContext(ctx, fmt.Sprintf(`COMMENT ON TABLE %q.%q IS %s;`, testNamespace, expiredTable, pq.QuoteLiteral(string(expiredComment))))
+	require.NoError(t, err)
+
+	require.True(t, pg.dropDanglingStagingTables(ctx))
+
+	var remaining []string
+	rows, err := db.QueryContext(ctx, `SELECT table_name FROM information_schema.tables WHERE table_schema = $1 AND table_name LIKE $2;`, testNamespace, warehouseutils.StagingTablePrefix(provider)+"%")
+	require.NoError(t, err)
+	defer func() { _ = rows.Close() }()
+	for rows.Next() {
+		var tableName string
+		require.NoError(t, rows.Scan(&tableName))
+		remaining = append(remaining, tableName)
+	}
+	require.NoError(t, rows.Err())
+	require.Equal(t, []string{recentTable}, remaining)
+}
+
+func TestCrashRecover_ReportOnly(t *testing.T) {
+	misc.Init()
+	warehouseutils.Init()
+
+	ctx := context.Background()
+
+	c := config.New()
+	c.Set("Warehouse.postgres.crashRecoveryReportOnly", true)
+
+	store := memstats.New()
+
+	pg := newTestPostgres(nil, c)
+	pg.stats = store
+	db := setupCredentials(t, pg).DB
+	require.NoError(t, pg.Reconnect(ctx))
+	require.NoError(t, pg.CreateSchema(ctx))
+
+	var stagingTableNames []string
+	for _, tableName := range []string{"tracks", "pages"} {
+		stagingTableName := warehouseutils.StagingTableName(provider, tableName, tableNameLimit)
+		_, err := db.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE %q.%q (id text);`, testNamespace, stagingTableName))
+		require.NoError(t, err)
+		stagingTableNames = append(stagingTableNames, stagingTableName)
+	}
+
+	stagingTables := func() []string {
+		var remaining []string
+		rows, err := db.QueryContext(ctx, `SELECT table_name FROM information_schema.tables WHERE table_schema = $1 AND table_name LIKE $2;`, testNamespace, warehouseutils.StagingTablePrefix(provider)+"%")
+		require.NoError(t, err)
+		defer func() { _ = rows.Close() }()
+		for rows.Next() {
+			var tableName string
+			require.NoError(t, rows.Scan(&tableName))
+			remaining = append(remaining, tableName)
+		}
+		require.NoError(t, rows.Err())
+		return remaining
+	}
+
+	pg.CrashRecover(ctx)
+
+	require.ElementsMatch(t, stagingTableNames, stagingTables())
+	require.EqualValues(t, 2, store.Get("pg_dangling_staging_tables", stats.Tags{
+		"workspaceId":   testWorkspaceID,
+		"namespace":     testNamespace,
+		"destinationID": testDestID,
+	}).LastValue())
+	require.Nil(t, store.Get(stagingTableDropped, stats.Tags{
+		"workspaceId":   testWorkspaceID,
+		"namespace":     testNamespace,
+		"destinationID": testDestID,
+		"tableName":     "tracks",
+	}))
+
+	// the tables are not dropped by the cleanup after the upload either
+	store = memstats.New()
+	pg.stats = store
+	pg.Cleanup(ctx)
+
+	require.ElementsMatch(t, stagingTableNames, stagingTables())
+	require.EqualValues(t, 2, store.Get("pg_dangling_staging_tables", stats.Tags{
+		"workspaceId":   testWorkspaceID,
+		"namespace":     testNamespace,
+		"destinationID": testDestID,
+	}).LastValue())
+
+	// the tables are dropped once the report only mode is disabled
+	require.NoError(t, pg.Reconnect(ctx))
+	pg.CrashRecoveryReportOnly = false
+	pg.CrashRecover(ctx)
+
+	require.Empty(t, stagingTables())
+}
+
+func TestExecWithResult_ExplainAnalyze(t *testing.T) {
+	misc.Init()
+	warehouseutils.Init()
+
+	const tableName = "test_table"
+
+	db := setupDB(t)
+	ctx := context.Background()
+
+	store := memstats.New()
+
+	pg := newTestPostgres(db, config.New())
+	pg.stats = store
+	pg.Uploader = &mockUploader{
+		schema: model.Schema{
+			tableName: testTableSchema,
+		},
+	}
+	createTestTable(t, pg, tableName)
+
+	log := &planLogger{Logger: logger.NOP}
+	pg.logger = log
+
+	_, err := db.ExecContext(ctx, fmt.Sprintf(`INSERT INTO %q.%q (id, test_int) VALUES ('1', 1), ('2', 2);`, testNamespace, tableName))
+	require.NoError(t, err)
+
+	testInts := func() map[string]int {
+		t.Helper()
+
+		rows, err := db.QueryContext(ctx, fmt.Sprintf(`SELECT id, test_int FROM %q.%q;`, testNamespace, tableName))
+		require.NoError(t, err)
+		defer func() { _ = rows.Close() }()
+
+		testInts := make(map[string]int)
+		for rows.Next() {
+			var (
+				id      string
+				testInt int
+			)
+			require.NoError(t, rows.Scan(&id, &testInt))
+			testInts[id] = testInt
+		}
+		require.NoError(t, rows.Err())
+		return testInts
+	}
+
+	tags := stats.Tags{
+		"workspaceId":   testWorkspaceID,
+		"namespace":     testNamespace,
+		"destinationID": testDestID,
+	}
+
+	t.Run("transaction", func(t *testing.T) {
+		txn, err := db.BeginTx(ctx, &sql.TxOptions{})
+		require.NoError(t, err)
+
+		// the statement is only applied once, by its actual execution
+		result, err := pg.execWithResult(ctx, &QueryParams{
+			txn:            txn,
+			query:          fmt.Sprintf(`UPDATE %q.%q SET test_int = test_int + 10;`, testNamespace, tableName),
+			explainAnalyze: true,
+		})
+		require.NoError(t, err)
+		rowsAffected, err := result.RowsAffected()
+		require.NoError(t, err)
+		require.EqualValues(t, 2, rowsAffected)
+		require.NoError(t, txn.Commit())
+
+		require.Equal(t, map[string]int{"1": 11, "2": 12}, testInts())
+
+		require.Len(t, log.plans, 1)
+		require.Contains(t, log.plans[0], "EXPLAIN (ANALYZE, BUFFERS, FORMAT JSON)")
+		require.Contains(t, log.plans[0], `"Actual Total Time"`)
+		require.Contains(t, log.plans[0], `"Shared Hit Blocks"`)
+		require.Len(t, store.Get("pg_explain_analyze_execution_time", tags).Durations(), 1)
+		require.Len(t, store.Get("pg_explain_analyze_planning_time", tags).Durations(), 1)
+		require.NotNil(t, store.Get("pg_explain_analyze_shared_hit_blocks", tags))
+	})
+
+	t.Run("no transaction", func(t *testing.T) {
+		_, err := pg.execWithResult(ctx, &QueryParams{
+			db:             db,
+			query:          fmt.Sprintf(`DELETE FROM %q.%q WHERE id = '1';`, testNamespace, tableName),
+			explainAnalyze: true,
+		})
+		require.NoError(t, err)
+
+		require.Equal(t, map[string]int{"2": 12}, testInts())
+		require.Len(t, log.plans, 2)
+		require.Len(t, store.Get("pg_explain_analyze_execution_time", tags).Durations(), 2)
+	})
+
+	t.Run("failing analysis", func(t *testing.T) {
+		txn, err := db.BeginTx(ctx, &sql.TxOptions{})
+		require.NoError(t, err)
+		defer func() { _ = txn.Rollback() }()
+
+		// the analysis of statements which aren't explainable fails, without aborting the transaction
+		_, err = pg.execWithResult(ctx, &QueryParams{
+			txn:            txn,
+			query:          fmt.Sprintf(`TRUNCATE %q.%q;`, testNamespace, tableName),
+			explainAnalyze: true,
+		})
+		require.NoError(t, err)
+		require.Len(t, log.plans, 2)
+	})
+}
+
+// BenchmarkLoadTable_SocketBufferSize compares loading the same load file with different sizes of the socket buffers.
+func BenchmarkLoadTable_SocketBufferSize(b *testing.B) {
+	misc.Init()
+	warehouseutils.Init()
+
+	const (
+		tableName = "test_table"
+		numRows   = 100000
+	)
+
+	var content strings.Builder
+	for i := 0; i < numRows; i++ {
+		_, _ = fmt.Fprintf(&content, "%d,2023-01-01T00:00:00Z,%d,%s\n", i, i, strings.Repeat("x", 100))
+	}
+	loadFile := writeGzip(b, "load.csv.gz", content.String())
+
+	for _, bufferSize := range []int{0, 64 * 1024, 4 * 1024 * 1024} {
+		bufferSize := bufferSize
+
+		b.Run(fmt.Sprintf("size=%d", bufferSize), func(b *testing.B) {
+			ctx := context.Background()
+
+			c := config.New()
+			c.Set("Warehouse.postgres.socketWriteBufferSize", bufferSize)
+			c.Set("Warehouse.postgres.socketReadBufferSize", bufferSize)
+
+			pg := newTestPostgres(nil, c)
+			uploader := &mockUploader{
+				schema: model.Schema{
+					tableName: testTableSchema,
+				},
+			}
+			setupLoadFiles(pg, uploader, map[string][]string{tableName: {loadFile}})
+			setupCredentials(b, pg)
+
+			db, err := pg.connect()
+			require.NoError(b, err)
+			defer func() { _ = db.Close() }()
+			pg.DB = db
+
+			require.NoError(b, pg.CreateSchema(ctx))
+			require.NoError(b, pg.CreateTable(ctx, tableName, testTableSchema))
+
+			b.SetBytes(int64(content.Len()))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				require.NoError(b, pg.LoadTable(ctx, tableName))
+			}
+		})
+	}
+}
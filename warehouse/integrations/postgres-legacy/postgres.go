@@ -1,27 +1,46 @@
 package postgreslegacy
 
 import (
-	"compress/gzip"
+	"bufio"
 	"context"
+	"crypto/sha256"
 	"database/sql"
+	"database/sql/driver"
 	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"net"
+	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	sqlmiddleware "github.com/rudderlabs/rudder-server/warehouse/integrations/middleware/sqlquerywrapper"
 	"github.com/rudderlabs/rudder-server/warehouse/internal/model"
 	"github.com/rudderlabs/rudder-server/warehouse/logfield"
 
 	"golang.org/x/exp/slices"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/cenkalti/backoff/v4"
 
 	"github.com/lib/pq"
+	"github.com/tidwall/gjson"
+
 	"github.com/rudderlabs/rudder-go-kit/config"
 	"github.com/rudderlabs/rudder-go-kit/logger"
 	"github.com/rudderlabs/rudder-go-kit/stats"
@@ -33,20 +52,173 @@ import (
 )
 
 const (
-	host     = "host"
-	dbName   = "database"
-	user     = "user"
-	password = "password"
-	port     = "port"
-	sslMode  = "sslMode"
-	verifyCA = "verify-ca"
+	host       = "host"
+	dbName     = "database"
+	user       = "user"
+	password   = "password"
+	port       = "port"
+	sslMode    = "sslMode"
+	verifyCA   = "verify-ca"
+	verifyFull = "verify-full"
+
+	sslModePrefer         = "prefer"
+	sslModeRequire        = "require"
+	sslModeDisable        = "disable"
+	allowInsecureFallback = "allowInsecureFallback"
+	sslInline             = "sslInline"
+	clientCert            = "clientCert"
+	clientKey             = "clientKey"
+	serverCA              = "serverCA"
+
+	tablePrefix = "tablePrefix"
+	tableSuffix = "tableSuffix"
+
+	readReplicaDSN = "readReplicaDSN"
+	connectionDSN  = "connectionDSN"
+
+	authMode      = "authMode"
+	krbSrvName    = "krbSrvName"
+	krbSPN        = "krbSPN"
+	krbKeytabPath = "krbKeytabPath"
+	krbCCachePath = "krbCCachePath"
+
+	applicationName    = "applicationName"
+	connectionSettings = "connectionSettings"
+
+	storageRegion = "region"
+)
+
+// authentication modes
+const (
+	authModePassword = "password"
+	authModeGSSAPI   = "gssapi"
+
+	defaultKrbSrvName = "postgres"
+)
+
+const (
+	provider             = warehouseutils.POSTGRES
+	usersIdentifiesUnion = "users_identifies_union"
+	tableNameLimit       = 127
+	identifierLimit      = 63
+
+	// sourceIDPlaceholder is replaced by the id of the source in the NamespaceTemplate
+	sourceIDPlaceholder = "{sourceID}"
+
+	// loadIDColumn holds the id of the load which inserted the row, when EnableLoadIDColumn is set
+	loadIDColumn = "_rudder_load_id"
+	// stagingPositionColumn numbers the records of the staging tables of compatible databases in the order they are copied in,
+	// breaking the ties when deduplicating as the ctid does for vanilla postgres
+	stagingPositionColumn = "_rudder_staging_position"
+)
+
+// dedup queries, picking the latest record of every partition of the staging table
+const (
+	// dedupQueryRowNumber numbers the records of every partition using row_number
+	dedupQueryRowNumber = "row_number"
+	// dedupQueryDistinctOn picks the first record of every partition using DISTINCT ON, which is often faster and uses less memory
+	dedupQueryDistinctOn = "distinct_on"
+)
+
+// validations of the values of the json columns before copying them
+const (
+	// jsonValidationNone copies the values as is, leaving invalid values to fail the copy
+	jsonValidationNone = "none"
+	// jsonValidationFail fails the load with an InvalidJSONError identifying the invalid value
+	jsonValidationFail = "fail"
+	// jsonValidationDiscard routes the invalid values to the discards table, loading null instead
+	jsonValidationDiscard = "discard"
+)
+
+// handling of the values of the datetime columns out of the range postgres accepts, from year 1 to 294276
+const (
+	// timestampOverflowStrict copies the values as is, leaving the values postgres rejects to fail the copy
+	timestampOverflowStrict = "strict"
+	// timestampOverflowClamp replaces the out of range values with the closest bound of the range
+	timestampOverflowClamp = "clamp"
+	// timestampOverflowDiscard routes the out of range values to the discards table, loading null instead
+	timestampOverflowDiscard = "discard"
+)
+
+// handling of the special values NaN, Infinity and -Infinity of the float columns
+const (
+	// floatSpecialValuesStrict copies the values as is. The numeric columns accept NaN, and Infinity from postgres 14 on,
+	// so the values fail the copy into the numeric columns of older versions.
+	floatSpecialValuesStrict = "strict"
+	// floatSpecialValuesNull loads null instead of the special values
+	floatSpecialValuesNull = "null"
+	// floatSpecialValuesDiscard routes the special values to the discards table, loading null instead
+	floatSpecialValuesDiscard = "discard"
+)
+
+// handling of the values of the indexed text columns longer than IndexedTextMaxBytes, which may exceed the size limit of the btree index entries
+const (
+	// longIndexedTextStrict copies the values as is, leaving the values exceeding the limit to fail the load
+	longIndexedTextStrict = "strict"
+	// longIndexedTextTruncate truncates the values to the limit, at a character boundary
+	longIndexedTextTruncate = "truncate"
+	// longIndexedTextHash replaces the values with their hex encoded sha256, keeping distinct values distinct for the dedup keys
+	longIndexedTextHash = "hash"
+	// longIndexedTextDiscard routes the values to the discards table, loading null instead
+	longIndexedTextDiscard = "discard"
 )
 
+// modes of connecting to the destination, tagging the stats and logs under connectionModeKey
 const (
-	provider       = warehouseutils.POSTGRES
-	tableNameLimit = 127
+	connectionModeKey = "connection_mode"
+	// connectionModeTunnel connects through the ssh tunnel
+	connectionModeTunnel = "tunnel"
+	// connectionModeDirect connects to the destination directly
+	connectionModeDirect = "direct"
 )
 
+// modes of setting the search path to the namespace
+const (
+	// searchPathModeSession sets the search path of the session, which lasts as long as the connection
+	searchPathModeSession = "session"
+	// searchPathModeLocal sets the search path within each transaction, e.g. for PgBouncer in transaction pooling mode
+	searchPathModeLocal = "local"
+	// searchPathModeNone never sets the search path, relying on the table references being qualified with the namespace
+	searchPathModeNone = "none"
+)
+
+// dialects of the destination, which can be a postgres compatible database
+const (
+	// dialectPostgres is vanilla postgres
+	dialectPostgres = "postgres"
+	// dialectCockroachDB is CockroachDB, whose rows have no ctid and whose CREATE TABLE LIKE differs
+	dialectCockroachDB = "cockroachdb"
+	// dialectYugabyteDB is YugabyteDB, whose rows have no ctid either
+	dialectYugabyteDB = "yugabytedb"
+	// dialectAuto detects the dialect from the version of the destination
+	dialectAuto = "auto"
+)
+
+// column storage strategies, see https://www.postgresql.org/docs/current/storage-toast.html
+var columnStorages = map[string]struct{}{
+	"PLAIN":    {},
+	"MAIN":     {},
+	"EXTERNAL": {},
+	"EXTENDED": {},
+}
+
+// bounds of the timestamps the load files can hold, as postgres rejects the years past its timestamptz range and the signed or zero years
+const (
+	minTimestamp = "0001-01-01T00:00:00Z"
+	maxTimestamp = "294276-12-31T23:59:59.999999Z"
+	maxYear      = 294276
+)
+
+// staging table lifecycle stats, whose difference over time shows the leaked staging tables
+const (
+	stagingTableCreated    = "pg_staging_table_created"
+	stagingTableDropped    = "pg_staging_table_dropped"
+	stagingTableDropFailed = "pg_staging_table_drop_failed"
+)
+
+// stagingTableNameRegex matches the names of the staging tables, capturing the name of their table.
+var stagingTableNameRegex = regexp.MustCompile(`^` + regexp.QuoteMeta(warehouseutils.StagingTablePrefix(provider)) + `(.+)_[0-9a-f]{32}$`)
+
 // load table transaction stages
 const (
 	createStagingTable       = "staging_table_creation"
@@ -60,6 +232,31 @@ const (
 	deleteDedup              = "dedup_deletion"
 	insertDedup              = "dedup_insertion"
 	dedupStage               = "dedup_stage"
+	setResourceLimits        = "resource_limits_setting"
+	setTimeZone              = "time_zone_setting"
+	setSearchPath            = "search_path_setting"
+	truncateForFreeze        = "freeze_truncation"
+	commentStagingTable      = "staging_table_commenting"
+	excludeGeneratedColumns  = "generated_columns_exclusion"
+	dropStagingTables        = "staging_tables_dropping"
+	loadDiscards             = "discards_loading"
+	validateJSON             = "json_validation"
+	verifyColumns            = "columns_verification"
+	takeSampleSavepoint      = "sample_savepoint_taking"
+	skipBadRows              = "bad_rows_skipping"
+)
+
+// reasons of skipping the malformed rows of the load files, tagging the pg_skipped_rows stat
+const (
+	badRowColumnCountMismatch = "column_count_mismatch"
+	badRowInvalidValue        = "invalid_value"
+)
+
+const (
+	// maxStagingTableSampleRows bounds the rows of the staging table samples
+	maxStagingTableSampleRows = 1000
+	// stagingTableSampleSavepoint is the savepoint taken before deduplicating, which the aborted transaction is rolled back to for sampling the staging table
+	stagingTableSampleSavepoint = "rudder_staging_table_sample"
 )
 
 var errorsMappings = []model.JobError{
@@ -103,8 +300,102 @@ var errorsMappings = []model.JobError{
 		Type:   model.PermissionError,
 		Format: regexp.MustCompile(`pq: permission denied`),
 	},
+	{
+		Type:   model.PermissionError,
+		Format: regexp.MustCompile(`insufficient privileges on tables in namespace`),
+	},
+	{
+		Type:   model.ResourceNotFoundError,
+		Format: regexp.MustCompile(`table .* not found in namespace`),
+	},
+	{
+		Type:   model.ResourceNotFoundError,
+		Format: regexp.MustCompile(`columns .* not found in table`),
+	},
+	{
+		Type:   model.StatementTimeoutError,
+		Format: regexp.MustCompile(`pq: canceling statement due to (user request|statement timeout)`),
+	},
+	{
+		Type:   model.ConcurrentQueriesError,
+		Format: regexp.MustCompile(`pq: canceling statement due to lock timeout`),
+	},
+	{
+		Type:   model.ResourceExhaustedError,
+		Format: regexp.MustCompile(`no connection available within .*: connection pool exhausted`),
+	},
+	{
+		Type:   model.ConcurrentQueriesError,
+		Format: regexp.MustCompile(`pq: deadlock detected`),
+	},
+	{
+		Type:   model.ConcurrentQueriesError,
+		Format: regexp.MustCompile(`pq: could not serialize access due to (concurrent update|read/write dependencies among transactions)`),
+	},
+	{
+		Type:   model.ResourceExhaustedError,
+		Format: regexp.MustCompile(`not enough disk space in .* to download the load files`),
+	},
+}
+
+// DiskFullError is returned when the load files are not expected to fit in the available space of the tmp directory.
+type DiskFullError struct {
+	Dir       string
+	Required  uint64
+	Available uint64
+}
+
+func (e *DiskFullError) Error() string {
+	return fmt.Sprintf("not enough disk space in %s to download the load files: %d bytes required, %d bytes available", e.Dir, e.Required, e.Available)
+}
+
+// ResourceExhaustedError is returned when no connection could be acquired from the pool within the AcquireTimeout.
+type ResourceExhaustedError struct {
+	Timeout time.Duration
 }
 
+func (e *ResourceExhaustedError) Error() string {
+	return fmt.Sprintf("no connection available within %s: connection pool exhausted", e.Timeout)
+}
+
+// ErrStatementCanceled is returned when a statement got canceled, either because the context is done or the statement timed out.
+var ErrStatementCanceled = errors.New("statement canceled")
+
+// queryCanceledCode is the postgres error code for a canceled statement.
+const queryCanceledCode = "57014"
+
+// deadlockDetectedCode is the postgres error code for a transaction aborted to resolve a deadlock.
+const deadlockDetectedCode = "40P01"
+
+// serializationFailureCode is the postgres error code for a transaction aborted as it could not be serialized with the concurrent ones,
+// under the repeatable read and serializable isolation levels.
+const serializationFailureCode = "40001"
+
+// connectionExceptionClass is the class of the postgres error codes for the errors of the connection, e.g. a connection failure.
+const connectionExceptionClass = "08"
+
+// isolation levels of the transactions, the default one of the server being used if not set
+const (
+	isolationLevelReadCommitted  = "read_committed"
+	isolationLevelRepeatableRead = "repeatable_read"
+	isolationLevelSerializable   = "serializable"
+)
+
+var isolationLevels = map[string]sql.IsolationLevel{
+	"":                           sql.LevelDefault,
+	isolationLevelReadCommitted:  sql.LevelReadCommitted,
+	isolationLevelRepeatableRead: sql.LevelRepeatableRead,
+	isolationLevelSerializable:   sql.LevelSerializable,
+}
+
+// postgres error codes for missing and duplicate relations and columns
+const (
+	undefinedTableCode  = "42P01"
+	undefinedColumnCode = "42703"
+	duplicateTableCode  = "42P07"
+	duplicateColumnCode = "42701"
+)
+
 var rudderDataTypesMapToPostgres = map[string]string{
 	"int":      "bigint",
 	"float":    "numeric",
@@ -115,20 +406,30 @@ var rudderDataTypesMapToPostgres = map[string]string{
 }
 
 var postgresDataTypesMapToRudder = map[string]string{
-	"integer":                  "int",
-	"smallint":                 "int",
-	"bigint":                   "int",
-	"double precision":         "float",
-	"numeric":                  "float",
-	"real":                     "float",
-	"text":                     "string",
-	"varchar":                  "string",
-	"char":                     "string",
-	"timestamptz":              "datetime",
-	"timestamp with time zone": "datetime",
-	"timestamp":                "datetime",
-	"boolean":                  "boolean",
-	"jsonb":                    "json",
+	"integer":                     "int",
+	"smallint":                    "int",
+	"bigint":                      "int",
+	"double precision":            "float",
+	"numeric":                     "float",
+	"real":                        "float",
+	"text":                        "string",
+	"varchar":                     "string",
+	"char":                        "string",
+	"timestamptz":                 "datetime",
+	"timestamp with time zone":    "datetime",
+	"timestamp":                   "datetime",
+	"boolean":                     "boolean",
+	"jsonb":                       "json",
+	"json":                        "json",
+	"character varying":           "string",
+	"character":                   "string",
+	"timestamp without time zone": "datetime",
+	"uuid":                        "string",
+	"bytea":                       "string",
+	"inet":                        "string",
+	"cidr":                        "string",
+	"macaddr":                     "string",
+	"ARRAY":                       "json",
 }
 
 type Postgres struct {
@@ -143,12 +444,156 @@ type Postgres struct {
 	EnableSQLStatementExecutionPlan             bool
 	TxnRollbackTimeout                          time.Duration
 	EnableDeleteByJobs                          bool
+	EnableDeleteByJobsWorkspaceIDs              []string
 	SkipComputingUserLatestTraitsWorkspaceIDs   []string
 	EnableSQLStatementExecutionPlanWorkspaceIDs []string
 	SlowQueryThreshold                          time.Duration
+	AdditionalDataTypesMapToRudder              map[string]string
+	MergeUsersJSONTraits                        bool
+	CreateIndexes                               bool
+	IndexColumns                                map[string][]string
+	WorkMem                                     string
+	TempFileLimit                               string
+	NullMode                                    string
+	NullSentinel                                string
+	MaxFilesPerTxn                              int
+	DedupKeys                                   map[string][]string
+	RouteUnknownColumnsToDiscards               bool
+	LockTimeout                                 time.Duration
+	LoadProgressInterval                        int
+	TmpDir                                      string
+	MaxOpenConns                                int
+	AcquireTimeout                              time.Duration
+	DedupOrderColumns                           map[string]string
+	DedupQuery                                  string
+	DeleteByChunkSize                           int
+	LoadTimeZone                                string
+	EnableLoadIDColumn                          bool
+	DownloadConcurrency                         int
+	DiskSpaceFactor                             float64
+	TCPUserTimeout                              time.Duration
+	KeepalivesIdle                              time.Duration
+	KeepalivesInterval                          time.Duration
+	KeepalivesCount                             int
+	ConnectionSettings                          map[string]string
+	AnalyzeAfterLoad                            bool
+	NamespaceTemplate                           string
+	MaxFieldSize                                int
+	JSONValidation                              string
+	AllowDropSchema                             bool
+	DedupTieBreakColumns                        map[string]string
+	DeadlockRetries                             int
+	DeadlockRetryInterval                       time.Duration
+	SerializationFailureRetries                 int
+	IsolationLevel                              string
+	DownloadRetries                             int
+	DownloadRetryInterval                       time.Duration
+	CacheSchema                                 bool
+	SharedSchemaCacheSize                       int
+	SharedSchemaCacheTTL                        time.Duration
+	EphemeralSSLKeys                            bool
+	TimestampOverflow                           string
+	FloatSpecialValues                          string
+	LongIndexedText                             string
+	IndexedTextMaxBytes                         int
+	Dialect                                     string
+	FetchSize                                   int
+	ColumnStorage                               map[string]map[string]string
+	ColumnDefaults                              map[string]map[string]string
+	ColumnMappings                              map[string]map[string]string
+	CoalesceUpsertTables                        []string
+	MergeLoadFiles                              bool
+	CreateMissingTables                         bool
+	TrimColumns                                 map[string]map[string]string
+	EnableSQLStatementExplainAnalyze            bool
+	// SearchPathMode is how the search path is set to the namespace, see searchPathModeSession.
+	// Poolers multiplexing transactions over server connections, like PgBouncer in transaction pooling mode, require local or none,
+	// as the session settings would leak to the other clients of the connection.
+	SearchPathMode string
+	// FreezeInitialLoads copies the loads into empty tables with COPY FREEZE, sparing the later vacuum freezing their rows.
+	FreezeInitialLoads bool
+	// RejoinTrailingColumns are the free text columns of the tables whose values can contain unquoted delimiters.
+	// If such a column is the trailing one of a load file, the extra fields of its rows are joined back into it instead of failing the load.
+	RejoinTrailingColumns map[string][]string
+	// DeleteByLegacyNulls keeps the rows without a job or task run id on DeleteBy, as its predicate did before handling nulls.
+	DeleteByLegacyNulls bool
+	// StagingTableRetention is how long the staging tables of other loads are kept when dropping the dangling ones,
+	// as they can belong to loads still in progress. All of them are dropped if not positive.
+	StagingTableRetention time.Duration
+	// CrashRecoveryReportOnly makes CrashRecover and Cleanup only log and count the dangling staging tables without dropping them,
+	// for auditing which tables it would drop in environments with several workers loading into the same namespace.
+	CrashRecoveryReportOnly bool
+	// TableLoadConcurrency is the number of tables LoadTables loads at once.
+	TableLoadConcurrency int
+	// SkipDedupForEmptyTables skips deleting the records to deduplicate from the tables found empty, e.g. on their first load.
+	SkipDedupForEmptyTables bool
+	// LowercaseIdentifiers lowercases the names of the tables and columns, as postgres folds the unquoted identifiers,
+	// so that names differing in case only are the same table or column. It applies to every table, whose mixed case names
+	// created beforehand are to be renamed to lowercase.
+	LowercaseIdentifiers bool
+	// LoadFilesHaveHeader skips the first line of every load file as its header, for load files produced by backfill tooling.
+	LoadFilesHaveHeader bool
+	// ColumnCountWarningThreshold is the number of columns of a table above which AddColumns warns, ahead of the limit of 1600 columns.
+	// It is disabled if not positive.
+	ColumnCountWarningThreshold int
+	// SocketWriteBufferSize and SocketReadBufferSize are the sizes in bytes of the send and receive buffers of the client sockets,
+	// bounding the data in flight e.g. while copying the load files. The OS defaults are kept if not positive.
+	// They are not applied to the connections through the ssh tunnel.
+	SocketWriteBufferSize int
+	SocketReadBufferSize  int
+	// StagingTableSampleRows is the number of rows of the staging table written to a csv file in the tmp directory when deduplicating it fails,
+	// for inspecting the staged records. It is capped at maxStagingTableSampleRows, and disabled if not positive.
+	StagingTableSampleRows int
+	// MaxBadRows is the number of malformed rows of the load files of a table skipped by a load, routing them to the discards table:
+	// the rows with a wrong number of columns, and the ones with a value of an int, float or boolean column which postgres would reject.
+	// The load fails with a TooManyBadRowsError once more rows are malformed, and on the first malformed row if not positive.
+	MaxBadRows int
+	// RedactStagingTableSample redacts the values of the staging table samples, e.g. masking the columns holding personal data.
+	// The values are written as is if not set.
+	RedactStagingTableSample func(tableName, columnName, value string) string
+	// ReplicaRetryInterval is how long the read queries skip the read replica after one of them failed on it, running on the primary instead.
+	ReplicaRetryInterval time.Duration
+	// MaxInFlightRollbacksToAwait is the number of in-flight rollbacks of the process, including the ones which outlived their timeout,
+	// up to which the rollbacks are waited for. The rollbacks above it are still issued, but the loads don't wait for them.
+	MaxInFlightRollbacksToAwait int
+	// NewGSS creates the GSSAPI provider used for the gssapi auth mode, logging in with the kerberos keytab or credential cache of the destination by default.
+	NewGSS             func(cred Credentials) (pq.GSS, error)
+	fileManagerFactory filemanager.FileManagerFactory
+	stats              stats.Stats
+	// replicaDB is the optional read replica for read-only queries.
+	replicaDB *sqlmiddleware.DB
+	// replicaUnhealthyUntil is until when the read queries skip the read replica, guarded by replicaMu.
+	replicaUnhealthyUntil time.Time
+	replicaMu             sync.Mutex
+	// closed is set once Cleanup closed the connections, which are re-established by the next load.
+	closed bool
+	// reconnectMu prevents concurrent loads from reconnecting at the same time, guarding DB, replicaDB and closed,
+	// which are replaced and closed by Reconnect and Cleanup.
+	reconnectMu sync.RWMutex
+	// schemaCache is the schema of the namespace last fetched with CacheSchema.
+	schemaCache   *schemaCache
+	schemaCacheMu sync.Mutex
+	// sharedSchemaCache is the schema cache of the process used with SharedSchemaCacheTTL, replaced in tests.
+	sharedSchemaCache *sharedSchemaCache
+	// sslDir is the temporary directory the SSL keys are written to with EphemeralSSLKeys, which is removed by Cleanup.
+	sslDir string
+	// dialect is the resolved Dialect, detected on the first load with dialectAuto.
+	dialect   string
+	dialectMu sync.Mutex
+	// owner identifies the staging tables created by this instance in their comment, see stagingTableComment.
+	owner string
+	// stagingTables are the staging tables created by this instance which weren't dropped yet, along with their table, see dropStagingTablesOf.
+	stagingTables   map[string]string
+	stagingTablesMu sync.Mutex
+	// gssRegistrations are the registrations of NewGSS in gssProviders by server principal, guarded by its mutex.
+	gssRegistrations map[string]*gssRegistration
+	// connectionInfo is the ConnectionInfo of connectionInfoDB, the connection it was queried on.
+	connectionInfo   *ConnectionInfo
+	connectionInfoDB *sqlmiddleware.DB
+	connectionInfoMu sync.Mutex
 }
 
-func (pg *Postgres) getNewMiddleWare(db *sql.DB) *sqlmiddleware.DB {
+func (pg *Postgres) getNewMiddleWare(db *sql.DB, connectionMode string) *sqlmiddleware.DB {
 	middleware := sqlmiddleware.New(
 		db,
 		sqlmiddleware.WithLogger(pg.logger),
@@ -159,6 +604,9 @@ func (pg *Postgres) getNewMiddleWare(db *sql.DB) *sqlmiddleware.DB {
 			logfield.DestinationType, pg.Warehouse.Destination.DestinationDefinition.Name,
 			logfield.WorkspaceID, pg.Warehouse.WorkspaceID,
 			logfield.Schema, pg.Namespace,
+			logfield.Provider, pg.ObjectStorage,
+			logfield.Region, pg.storageRegion(),
+			connectionModeKey, connectionMode,
 		),
 		sqlmiddleware.WithSlowQueryThreshold(pg.SlowQueryThreshold),
 	)
@@ -175,6 +623,37 @@ type Credentials struct {
 	SSLDir     string
 	TunnelInfo *tunnelling.TunnelInfo
 	timeout    time.Duration
+	// AuthMode is either password (default) or gssapi.
+	AuthMode string
+	// KrbSrvName is the kerberos service name of the server, postgres by default.
+	KrbSrvName string
+	// KrbSPN is the kerberos service principal name, overriding the one derived from KrbSrvName and Host.
+	KrbSPN string
+	// KeytabPath and CCachePath are passed to the GSSAPI provider to acquire the client credentials.
+	KeytabPath string
+	CCachePath string
+	// ApplicationName is reported by the server in pg_stat_activity.
+	ApplicationName string
+	// AllowInsecureFallback allows connecting without SSL for the prefer sslmode, if the server does not support SSL.
+	AllowInsecureFallback bool
+	// TCPUserTimeout, KeepalivesIdle, KeepalivesInterval and KeepalivesCount set up the detection of dropped connections on both the client
+	// and the server sockets, zero meaning the system default. They are not applied to the client sockets of the connections through the ssh tunnel,
+	// and outside of linux only KeepalivesIdle is supported on the client sockets, connecting failing if the others are set.
+	// tcp_user_timeout is only supported by the server from postgres 12.
+	TCPUserTimeout     time.Duration
+	KeepalivesIdle     time.Duration
+	KeepalivesInterval time.Duration
+	KeepalivesCount    int
+	// DSN overrides the connection string assembled from the other credentials, being used verbatim once validated.
+	DSN string
+	// SSLInline passes the PEM encoded SSLCert, SSLKey and SSLRootCert of the destination config to lib/pq, instead of it reading them from SSLDir.
+	SSLInline   bool
+	SSLCert     string
+	SSLKey      string
+	SSLRootCert string
+	// Settings are the runtime parameters of the sessions, e.g. rudder.purpose=warehouse-load for the connection policies of the server,
+	// passed in the options startup parameter. They are not added to the dsn override.
+	Settings map[string]string
 }
 
 var primaryKeyMap = map[string]string{
@@ -191,770 +670,4643 @@ var partitionKeyMap = map[string]string{
 
 func New() *Postgres {
 	return &Postgres{
-		logger: logger.NewLogger().Child("warehouse").Child("integrations").Child("postgres"),
+		logger:             logger.NewLogger().Child("warehouse").Child("integrations").Child("postgres"),
+		fileManagerFactory: filemanager.DefaultFileManagerFactory,
+		stats:              stats.Default,
+		owner:              misc.FastUUID().String(),
+		NewGSS:             newKerberosGSS,
 	}
 }
 
 func WithConfig(h *Postgres, config *config.Config) {
 	h.SkipComputingUserLatestTraits = config.GetBool("Warehouse.postgres.skipComputingUserLatestTraits", false)
 	h.TxnRollbackTimeout = config.GetDuration("Warehouse.postgres.txnRollbackTimeout", 30, time.Second)
+	h.MaxInFlightRollbacksToAwait = config.GetInt("Warehouse.postgres.maxInFlightRollbacksToAwait", 10)
 	h.EnableSQLStatementExecutionPlan = config.GetBool("Warehouse.postgres.enableSQLStatementExecutionPlan", false)
 	h.EnableDeleteByJobs = config.GetBool("Warehouse.postgres.enableDeleteByJobs", false)
+	h.EnableDeleteByJobsWorkspaceIDs = config.GetStringSlice("Warehouse.postgres.EnableDeleteByJobsWorkspaceIDs", nil)
 	h.SkipComputingUserLatestTraitsWorkspaceIDs = config.GetStringSlice("Warehouse.postgres.SkipComputingUserLatestTraitsWorkspaceIDs", nil)
 	h.EnableSQLStatementExecutionPlanWorkspaceIDs = config.GetStringSlice("Warehouse.postgres.EnableSQLStatementExecutionPlanWorkspaceIDs", nil)
 	h.SlowQueryThreshold = config.GetDuration("Warehouse.postgres.slowQueryThreshold", 5, time.Minute)
+	h.AdditionalDataTypesMapToRudder = additionalDataTypesMapToRudder(h.logger, config.GetStringMap("Warehouse.postgres.additionalDataTypesMapToRudder", nil))
+	h.MergeUsersJSONTraits = config.GetBool("Warehouse.postgres.mergeUsersJSONTraits", false)
+	h.CreateIndexes = config.GetBool("Warehouse.postgres.createIndexes", false)
+	h.IndexColumns = stringSliceMap(config.GetStringMap("Warehouse.postgres.indexColumns", nil))
+	h.WorkMem = config.GetString("Warehouse.postgres.workMem", "")
+	h.TempFileLimit = config.GetString("Warehouse.postgres.tempFileLimit", "")
+	h.NullMode = enumConfig(h.logger, config, "Warehouse.postgres.nullMode", nullModeTrim, nullModeSentinel, nullModeQuoted)
+	h.NullSentinel = config.GetString("Warehouse.postgres.nullSentinel", `\N`)
+	h.MaxFilesPerTxn = config.GetInt("Warehouse.postgres.maxFilesPerTxn", 0)
+	h.DedupKeys = stringSliceMap(config.GetStringMap("Warehouse.postgres.dedupKeys", nil))
+	h.RouteUnknownColumnsToDiscards = config.GetBool("Warehouse.postgres.routeUnknownColumnsToDiscards", false)
+	h.LockTimeout = config.GetDuration("Warehouse.postgres.lockTimeout", 0, time.Millisecond)
+	h.LoadProgressInterval = config.GetInt("Warehouse.postgres.loadProgressInterval", 100000)
+	h.TmpDir = config.GetString("Warehouse.postgres.tmpDir", "")
+	h.MaxOpenConns = config.GetInt("Warehouse.postgres.maxOpenConns", 0)
+	h.AcquireTimeout = config.GetDuration("Warehouse.postgres.acquireTimeout", 0, time.Second)
+	h.DedupOrderColumns = stringMap(config.GetStringMap("Warehouse.postgres.dedupOrderColumns", nil))
+	h.DedupQuery = enumConfig(h.logger, config, "Warehouse.postgres.dedupQuery", dedupQueryRowNumber, dedupQueryDistinctOn)
+	h.DeleteByChunkSize = config.GetInt("Warehouse.postgres.deleteByChunkSize", 0)
+	h.LoadTimeZone = config.GetString("Warehouse.postgres.loadTimeZone", "UTC")
+	h.EnableLoadIDColumn = config.GetBool("Warehouse.postgres.enableLoadIDColumn", false)
+	h.DownloadConcurrency = config.GetInt("Warehouse.postgres.downloadConcurrency", 1)
+	h.DiskSpaceFactor = config.GetFloat64("Warehouse.postgres.diskSpaceFactor", 1)
+	h.TCPUserTimeout = config.GetDuration("Warehouse.postgres.tcpUserTimeout", 0, time.Second)
+	h.KeepalivesIdle = config.GetDuration("Warehouse.postgres.keepalivesIdle", 0, time.Second)
+	h.KeepalivesInterval = config.GetDuration("Warehouse.postgres.keepalivesInterval", 0, time.Second)
+	h.KeepalivesCount = config.GetInt("Warehouse.postgres.keepalivesCount", 0)
+	h.ConnectionSettings = stringMap(config.GetStringMap("Warehouse.postgres.connectionSettings", nil))
+	h.AnalyzeAfterLoad = config.GetBool("Warehouse.postgres.analyzeAfterLoad", false)
+	h.NamespaceTemplate = config.GetString("Warehouse.postgres.namespaceTemplate", "")
+	h.MaxFieldSize = config.GetInt("Warehouse.postgres.maxFieldSize", 0)
+	h.JSONValidation = enumConfig(h.logger, config, "Warehouse.postgres.jsonValidation", jsonValidationNone, jsonValidationFail, jsonValidationDiscard)
+	h.AllowDropSchema = config.GetBool("Warehouse.postgres.allowDropSchema", false)
+	h.DedupTieBreakColumns = stringMap(config.GetStringMap("Warehouse.postgres.dedupTieBreakColumns", nil))
+	h.DeadlockRetries = config.GetInt("Warehouse.postgres.deadlockRetries", 3)
+	h.DeadlockRetryInterval = config.GetDuration("Warehouse.postgres.deadlockRetryInterval", 1, time.Second)
+	h.SerializationFailureRetries = config.GetInt("Warehouse.postgres.serializationFailureRetries", 3)
+	h.IsolationLevel = config.GetString("Warehouse.postgres.isolationLevel", "")
+	h.DownloadRetries = config.GetInt("Warehouse.postgres.downloadRetries", 3)
+	h.DownloadRetryInterval = config.GetDuration("Warehouse.postgres.downloadRetryInterval", 1, time.Second)
+	h.CacheSchema = config.GetBool("Warehouse.postgres.cacheSchema", false)
+	h.SharedSchemaCacheSize = config.GetInt("Warehouse.postgres.sharedSchemaCacheSize", 100)
+	h.SharedSchemaCacheTTL = config.GetDuration("Warehouse.postgres.sharedSchemaCacheTTL", 0, time.Second)
+	h.EphemeralSSLKeys = config.GetBool("Warehouse.postgres.ephemeralSSLKeys", false)
+	h.TimestampOverflow = enumConfig(h.logger, config, "Warehouse.postgres.timestampOverflow", timestampOverflowStrict, timestampOverflowClamp, timestampOverflowDiscard)
+	h.FloatSpecialValues = enumConfig(h.logger, config, "Warehouse.postgres.floatSpecialValues", floatSpecialValuesStrict, floatSpecialValuesNull, floatSpecialValuesDiscard)
+	h.LongIndexedText = enumConfig(h.logger, config, "Warehouse.postgres.longIndexedText", longIndexedTextStrict, longIndexedTextTruncate, longIndexedTextHash, longIndexedTextDiscard)
+	h.IndexedTextMaxBytes = config.GetInt("Warehouse.postgres.indexedTextMaxBytes", 2048)
+	h.Dialect = enumConfig(h.logger, config, "Warehouse.postgres.dialect", dialectPostgres, dialectCockroachDB, dialectYugabyteDB, dialectAuto)
+	h.FetchSize = config.GetInt("Warehouse.postgres.fetchSize", 0)
+	h.ColumnStorage = columnStorage(h.logger, config.GetStringMap("Warehouse.postgres.columnStorage", nil))
+	h.ColumnDefaults = nestedStringMap(config.GetStringMap("Warehouse.postgres.columnDefaults", nil))
+	h.ColumnMappings = nestedStringMap(config.GetStringMap("Warehouse.postgres.columnMappings", nil))
+	h.CoalesceUpsertTables = config.GetStringSlice("Warehouse.postgres.coalesceUpsertTables", nil)
+	h.MergeLoadFiles = config.GetBool("Warehouse.postgres.mergeLoadFiles", false)
+	h.CreateMissingTables = config.GetBool("Warehouse.postgres.createMissingTables", false)
+	h.TrimColumns = trimColumns(h.logger, config.GetStringMap("Warehouse.postgres.trimColumns", nil))
+	h.EnableSQLStatementExplainAnalyze = config.GetBool("Warehouse.postgres.enableSQLStatementExplainAnalyze", false)
+	h.SearchPathMode = enumConfig(h.logger, config, "Warehouse.postgres.searchPathMode", searchPathModeSession, searchPathModeLocal, searchPathModeNone)
+	h.FreezeInitialLoads = config.GetBool("Warehouse.postgres.freezeInitialLoads", false)
+	h.RejoinTrailingColumns = stringSliceMap(config.GetStringMap("Warehouse.postgres.rejoinTrailingColumns", nil))
+	h.DeleteByLegacyNulls = config.GetBool("Warehouse.postgres.deleteByLegacyNulls", false)
+	h.StagingTableRetention = config.GetDuration("Warehouse.postgres.stagingTableRetention", 0, time.Minute)
+	h.CrashRecoveryReportOnly = config.GetBool("Warehouse.postgres.crashRecoveryReportOnly", false)
+	h.TableLoadConcurrency = config.GetInt("Warehouse.postgres.tableLoadConcurrency", 1)
+	h.SkipDedupForEmptyTables = config.GetBool("Warehouse.postgres.skipDedupForEmptyTables", false)
+	h.LowercaseIdentifiers = config.GetBool("Warehouse.postgres.lowercaseIdentifiers", false)
+	h.LoadFilesHaveHeader = config.GetBool("Warehouse.postgres.loadFilesHaveHeader", false)
+	h.ColumnCountWarningThreshold = config.GetInt("Warehouse.postgres.columnCountWarningThreshold", 1400)
+	h.SocketWriteBufferSize = config.GetInt("Warehouse.postgres.socketWriteBufferSize", 0)
+	h.SocketReadBufferSize = config.GetInt("Warehouse.postgres.socketReadBufferSize", 0)
+	h.StagingTableSampleRows = config.GetInt("Warehouse.postgres.stagingTableSampleRows", 0)
+	h.MaxBadRows = config.GetInt("Warehouse.postgres.maxBadRows", 0)
+	h.ReplicaRetryInterval = config.GetDuration("Warehouse.postgres.replicaRetryInterval", 60, time.Second)
 }
 
-func (pg *Postgres) connect() (*sqlmiddleware.DB, error) {
-	cred := pg.getConnectionCredentials()
-	dsn := url.URL{
-		Scheme: "postgres",
-		Host:   fmt.Sprintf("%s:%s", cred.Host, cred.Port),
-		User:   url.UserPassword(cred.User, cred.Password),
-		Path:   cred.DBName,
+// nestedStringMap converts a config map of maps into a map of string maps.
+// Values which aren't maps are ignored.
+func nestedStringMap(configMap map[string]interface{}) map[string]map[string]string {
+	result := make(map[string]map[string]string, len(configMap))
+	for key, value := range configMap {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			result[key] = stringMap(v)
+		case map[string]string:
+			result[key] = v
+		}
 	}
+	return result
+}
 
-	values := url.Values{}
-	values.Add("sslmode", cred.SSLMode)
-
-	if cred.timeout > 0 {
-		values.Add("connect_timeout", fmt.Sprintf("%d", cred.timeout/time.Second))
+// stringSliceMap converts a config map of lists into a map of string slices.
+// A value can either be a list or a comma separated string.
+func stringSliceMap(configMap map[string]interface{}) map[string][]string {
+	result := make(map[string][]string, len(configMap))
+	for key, value := range configMap {
+		switch v := value.(type) {
+		case string:
+			for _, item := range strings.Split(v, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					result[key] = append(result[key], item)
+				}
+			}
+		case []string:
+			result[key] = v
+		case []interface{}:
+			for _, item := range v {
+				result[key] = append(result[key], fmt.Sprint(item))
+			}
+		}
 	}
+	return result
+}
 
-	if cred.SSLMode == verifyCA {
-		values.Add("sslrootcert", fmt.Sprintf("%s/server-ca.pem", cred.SSLDir))
-		values.Add("sslcert", fmt.Sprintf("%s/client-cert.pem", cred.SSLDir))
-		values.Add("sslkey", fmt.Sprintf("%s/client-key.pem", cred.SSLDir))
+// stringMap converts a config map into a map of strings.
+func stringMap(configMap map[string]interface{}) map[string]string {
+	result := make(map[string]string, len(configMap))
+	for key, value := range configMap {
+		if v := strings.TrimSpace(fmt.Sprint(value)); v != "" {
+			result[key] = v
+		}
 	}
+	return result
+}
 
-	dsn.RawQuery = values.Encode()
-
-	var (
-		err error
-		db  *sql.DB
-	)
-
-	if cred.TunnelInfo != nil {
-
-		db, err = tunnelling.SQLConnectThroughTunnel(dsn.String(), cred.TunnelInfo.Config)
-		if err != nil {
-			return nil, fmt.Errorf("opening connection to postgres through tunnelling: %w", err)
+// additionalDataTypesMapToRudder returns the operator registered postgres to rudder data type mappings.
+// Mappings pointing to an unknown rudder data type are ignored.
+func additionalDataTypesMapToRudder(log logger.Logger, dataTypesMap map[string]interface{}) map[string]string {
+	additionalDataTypes := make(map[string]string, len(dataTypesMap))
+	for postgresDataType, v := range dataTypesMap {
+		rudderDataType, ok := v.(string)
+		if _, isValid := rudderDataTypesMapToPostgres[rudderDataType]; !ok || !isValid {
+			log.Warnf("PG: Ignoring invalid data type mapping for %s: %v", postgresDataType, v)
+			continue
 		}
-		return pg.getNewMiddleWare(db), nil
+		additionalDataTypes[postgresDataType] = rudderDataType
 	}
+	return additionalDataTypes
+}
 
-	if db, err = sql.Open("postgres", dsn.String()); err != nil {
-		return nil, fmt.Errorf("opening connection to postgres: %w", err)
+// enumConfig returns the value of the config key, which is one of the default value and the other values.
+// Unknown values are ignored, falling back to the default value.
+func enumConfig(log logger.Logger, config *config.Config, key, defaultValue string, otherValues ...string) string {
+	value := config.GetString(key, defaultValue)
+	if value != defaultValue && !slices.Contains(otherValues, value) {
+		log.Warnf("PG: Ignoring unknown value %q of %s, expected one of %q, using %q", value, key, append([]string{defaultValue}, otherValues...), defaultValue)
+		return defaultValue
 	}
-
-	return pg.getNewMiddleWare(db), nil
+	return value
 }
 
-func (pg *Postgres) getConnectionCredentials() Credentials {
-	sslMode := warehouseutils.GetConfigValue(sslMode, pg.Warehouse)
-	creds := Credentials{
-		Host:     warehouseutils.GetConfigValue(host, pg.Warehouse),
-		DBName:   warehouseutils.GetConfigValue(dbName, pg.Warehouse),
-		User:     warehouseutils.GetConfigValue(user, pg.Warehouse),
-		Password: warehouseutils.GetConfigValue(password, pg.Warehouse),
-		Port:     warehouseutils.GetConfigValue(port, pg.Warehouse),
-		SSLMode:  sslMode,
-		SSLDir:   warehouseutils.GetSSLKeyDirPath(pg.Warehouse.Destination.ID),
-		timeout:  pg.ConnectTimeout,
-		TunnelInfo: warehouseutils.ExtractTunnelInfoFromDestinationConfig(
-			pg.Warehouse.Destination.Config,
-		),
+// columnStorage converts the config map of the storage strategies of the columns by table, uppercased, ignoring the unknown strategies.
+func columnStorage(log logger.Logger, configMap map[string]interface{}) map[string]map[string]string {
+	columnStorage := nestedStringMap(configMap)
+	for tableName, storages := range columnStorage {
+		for column, storage := range storages {
+			if _, ok := columnStorages[strings.ToUpper(storage)]; !ok {
+				log.Warnf("PG: Ignoring unknown storage %q of column %s of table %s", storage, column, tableName)
+				delete(storages, column)
+				continue
+			}
+			storages[column] = strings.ToUpper(storage)
+		}
 	}
-
-	return creds
+	return columnStorage
 }
 
-func ColumnsWithDataTypes(columns map[string]string, prefix string) string {
-	var arr []string
-	for name, dataType := range columns {
-		arr = append(arr, fmt.Sprintf(`"%s%s" %s`, prefix, name, rudderDataTypesMapToPostgres[dataType]))
+// trimColumns converts the config map of the trim policies of the columns by table, ignoring the unknown policies.
+func trimColumns(log logger.Logger, configMap map[string]interface{}) map[string]map[string]string {
+	trimColumns := nestedStringMap(configMap)
+	for tableName, policies := range trimColumns {
+		for column, policy := range policies {
+			switch policy {
+			case trimPolicyNullCheck, trimPolicyTrim, trimPolicyNone:
+			default:
+				log.Warnf("PG: Ignoring unknown trim policy %q of column %s of table %s", policy, column, tableName)
+				delete(policies, column)
+			}
+		}
 	}
-	return strings.Join(arr, ",")
+	return trimColumns
 }
 
-func (*Postgres) IsEmpty(context.Context, model.Warehouse) (empty bool, err error) {
-	return
+// rudderDataType returns the rudder data type for the given postgres data type.
+// Operator registered mappings take precedence over the default ones.
+func (pg *Postgres) rudderDataType(columnType string) (string, bool) {
+	if datatype, ok := pg.AdditionalDataTypesMapToRudder[columnType]; ok {
+		return datatype, true
+	}
+	datatype, ok := postgresDataTypesMapToRudder[columnType]
+	return datatype, ok
 }
 
-func (pg *Postgres) DownloadLoadFiles(ctx context.Context, tableName string) ([]string, error) {
-	objects := pg.Uploader.GetLoadFilesMetadata(ctx, warehouseutils.GetLoadFilesOptions{Table: tableName})
-	storageProvider := warehouseutils.ObjectStorageType(pg.Warehouse.Destination.DestinationDefinition.Name, pg.Warehouse.Destination.Config, pg.Uploader.UseRudderStorage())
-	downloader, err := filemanager.DefaultFileManagerFactory.New(&filemanager.SettingsT{
-		Provider: storageProvider,
-		Config: misc.GetObjectStorageConfig(misc.ObjectStorageOptsT{
-			Provider:         storageProvider,
-			Config:           pg.Warehouse.Destination.Config,
-			UseRudderStorage: pg.Uploader.UseRudderStorage(),
-			WorkspaceID:      pg.Warehouse.Destination.WorkspaceID,
-		}),
-	})
-	if err != nil {
-		pg.logger.Errorf("PG: Error in setting up a downloader for destinationID : %s Error : %v", pg.Warehouse.Destination.ID, err)
+func (pg *Postgres) connect() (_ *sqlmiddleware.DB, err error) {
+	// the errors of opening the connection, e.g. through the ssh tunnel, can include the dsn
+	defer func() { err = pg.redactError(err) }()
+
+	if err := pg.writeEphemeralSSLKeys(); err != nil {
 		return nil, err
 	}
-	var fileNames []string
-	for _, object := range objects {
-		objectName, err := warehouseutils.GetObjectName(object.Location, pg.Warehouse.Destination.Config, pg.ObjectStorage)
-		if err != nil {
-			pg.logger.Errorf("PG: Error in converting object location to object key for table:%s: %s,%v", tableName, object.Location, err)
-			return nil, err
-		}
-		dirName := fmt.Sprintf(`/%s/`, misc.RudderWarehouseLoadUploadsTmp)
-		tmpDirPath, err := misc.CreateTMPDIR()
-		if err != nil {
-			pg.logger.Errorf("PG: Error in creating tmp directory for downloading load file for table:%s: %s, %v", tableName, object.Location, err)
-			return nil, err
-		}
-		ObjectPath := tmpDirPath + dirName + fmt.Sprintf(`%s_%s_%d/`, pg.Warehouse.Destination.DestinationDefinition.Name, pg.Warehouse.Destination.ID, time.Now().Unix()) + objectName
-		err = os.MkdirAll(filepath.Dir(ObjectPath), os.ModePerm)
-		if err != nil {
-			pg.logger.Errorf("PG: Error in making tmp directory for downloading load file for table:%s: %s, %s %v", tableName, object.Location, err)
-			return nil, err
-		}
-		objectFile, err := os.Create(ObjectPath)
-		if err != nil {
-			pg.logger.Errorf("PG: Error in creating file in tmp directory for downloading load file for table:%s: %s, %v", tableName, object.Location, err)
-			return nil, err
+	return pg.connectWith(pg.getConnectionCredentials())
+}
+
+// connectWith opens the connection pool for the credentials, negotiating ssl if preferred.
+func (pg *Postgres) connectWith(cred Credentials) (*sqlmiddleware.DB, error) {
+	if cred.DSN != "" {
+		if err := validateDSN(cred.DSN); err != nil {
+			return nil, fmt.Errorf("invalid connection dsn override: %w", err)
 		}
-		err = downloader.Download(ctx, objectFile, objectName)
-		if err != nil {
-			pg.logger.Errorf("PG: Error in downloading file in tmp directory for downloading load file for table:%s: %s, %v", tableName, object.Location, err)
-			return nil, err
+	}
+	if err := validateSettings(cred.Settings); err != nil {
+		return nil, fmt.Errorf("invalid connection settings: %w", err)
+	}
+	if cred.SSLInline && (cred.SSLCert == "" || cred.SSLKey == "" || cred.SSLRootCert == "") {
+		return nil, fmt.Errorf("inline ssl certificates: %s, %s and %s are required", clientCert, clientKey, serverCA)
+	}
+
+	if cred.AuthMode == authModeGSSAPI {
+		if pg.NewGSS == nil {
+			return nil, errors.New("gssapi auth mode requires a GSSAPI provider")
 		}
-		fileName := objectFile.Name()
-		if err = objectFile.Close(); err != nil {
-			pg.logger.Errorf("PG: Error in closing downloaded file in tmp directory for downloading load file for table:%s: %s, %v", tableName, object.Location, err)
+		if err := pg.registerGSSProvider(cred); err != nil {
 			return nil, err
 		}
-		fileNames = append(fileNames, fileName)
 	}
-	return fileNames, nil
-}
 
-func handleRollbackTimeout(tags stats.Tags) {
-	stats.Default.NewTaggedStat("pg_rollback_timeout", stats.CountType, tags).Count(1)
+	// the sslmode of the dsn override is used as is, as negotiating it would mean rewriting the dsn
+	if cred.SSLMode == sslModePrefer && cred.DSN == "" {
+		return pg.negotiateSSL(cred)
+	}
+	return pg.open(cred)
 }
 
-func (pg *Postgres) runRollbackWithTimeout(f func() error, onTimeout func(tags stats.Tags), d time.Duration, tags stats.Tags) {
-	c := make(chan struct{})
-	go func() {
-		defer close(c)
-		err := f()
-		if err != nil {
-			pg.logger.Errorf("PG: Error in rolling back transaction : %v", err)
-		}
-	}()
+// gssProviders are the GSSAPI providers of the destinations using the gssapi auth mode, by the server principal they authenticate to.
+// lib/pq only supports a single global provider, so the registered one dispatches to the provider of the server of every connection.
+// The destinations authenticating to the same server principal must use the same client credentials, as the server is all the connection tells apart.
+var gssProviders = struct {
+	sync.Mutex
+	byPrincipal map[string][]*gssRegistration
+	once        sync.Once
+}{byPrincipal: make(map[string][]*gssRegistration)}
 
-	select {
-	case <-c:
-	case <-time.After(d):
-		pg.logger.Errorf("PG: Timed out rolling back transaction after %v", d)
-		onTimeout(tags)
-	}
+// gssRegistration is the GSSAPI provider of a destination instance for a server principal, registered in gssProviders by registerGSSProvider until Cleanup.
+type gssRegistration struct {
+	principal string
+	identity  gssIdentity
+	newGSS    func() (pq.GSS, error)
 }
 
-func (pg *Postgres) loadTable(ctx context.Context, tableName string, tableSchemaInUpload model.TableSchema, skipTempTableDelete bool) (stagingTableName string, err error) {
-	sqlStatement := fmt.Sprintf(`SET search_path to %q`, pg.Namespace)
-	_, err = pg.DB.ExecContext(ctx, sqlStatement)
+// gssIdentity is the client credentials the GSSAPI provider authenticates with.
+type gssIdentity struct {
+	User       string
+	KeytabPath string
+	CCachePath string
+}
+
+// gssServerPrincipal returns the server principal of the connections of the credentials, as lib/pq derives it from the dsn:
+// krbspn if set, otherwise krbsrvname (postgres by default) at the host.
+func gssServerPrincipal(dsn string) (string, error) {
+	u, err := url.Parse(dsn)
 	if err != nil {
-		return
+		return "", errors.New("parsing connection dsn")
 	}
-	pg.logger.Infof("PG: Updated search_path to %s in postgres for PG:%s : %v", pg.Namespace, pg.Warehouse.Destination.ID, sqlStatement)
-	pg.logger.Infof("PG: Starting load for table:%s", tableName)
-
-	// tags
-	tags := stats.Tags{
-		"workspaceId":   pg.Warehouse.WorkspaceID,
-		"namepsace":     pg.Namespace,
-		"destinationID": pg.Warehouse.Destination.ID,
-		"tableName":     tableName,
+	if spn := u.Query().Get("krbspn"); spn != "" {
+		return spn, nil
 	}
-	// sort column names
-	sortedColumnKeys := warehouseutils.SortColumnKeysFromColumnMap(tableSchemaInUpload)
+	service := u.Query().Get("krbsrvname")
+	if service == "" {
+		service = defaultKrbSrvName
+	}
+	return service + "/" + u.Hostname(), nil
+}
 
-	fileNames, err := pg.DownloadLoadFiles(ctx, tableName)
-	defer misc.RemoveFilePaths(fileNames...)
+// registerGSSProvider registers the GSSAPI provider of the credentials for their server principal, replacing the previous one of the instance for it.
+// It fails if another destination authenticates to the same server principal with different client credentials.
+func (pg *Postgres) registerGSSProvider(cred Credentials) error {
+	principal, err := gssServerPrincipal(cred.dsn())
 	if err != nil {
-		return
+		return err
+	}
+	registration := &gssRegistration{
+		principal: principal,
+		identity:  gssIdentity{User: cred.User, KeytabPath: cred.KeytabPath, CCachePath: cred.CCachePath},
+		newGSS: func() (pq.GSS, error) {
+			return pg.NewGSS(cred)
+		},
 	}
 
-	txn, err := pg.DB.BeginTx(ctx, &sql.TxOptions{})
-	if err != nil {
-		pg.logger.Errorf("PG: Error while beginning a transaction in db for loading in table:%s: %v", tableName, err)
-		return
+	gssProviders.Lock()
+	defer gssProviders.Unlock()
+
+	previous := pg.gssRegistrations[principal]
+	for _, other := range gssProviders.byPrincipal[principal] {
+		if other != previous && other.identity != registration.identity {
+			return fmt.Errorf("gssapi server principal %s is already authenticated to by another destination with different credentials", principal)
+		}
 	}
-	// create temporary table
-	stagingTableName = warehouseutils.StagingTableName(provider, tableName, tableNameLimit)
-	sqlStatement = fmt.Sprintf(`CREATE TABLE "%[1]s".%[2]s (LIKE "%[1]s"."%[3]s")`, pg.Namespace, stagingTableName, tableName)
-	pg.logger.Debugf("PG: Creating temporary table for table:%s at %s\n", tableName, sqlStatement)
-	_, err = txn.ExecContext(ctx, sqlStatement)
-	if err != nil {
-		pg.logger.Errorf("PG: Error creating temporary table for table:%s: %v\n", tableName, err)
-		tags["stage"] = createStagingTable
-		pg.runRollbackWithTimeout(txn.Rollback, handleRollbackTimeout, pg.TxnRollbackTimeout, tags)
-		return
+	if previous != nil {
+		pg.unregisterGSSProviderLocked(previous)
 	}
-	if !skipTempTableDelete {
-		defer pg.dropStagingTable(ctx, stagingTableName)
+	gssProviders.byPrincipal[principal] = append(gssProviders.byPrincipal[principal], registration)
+	if pg.gssRegistrations == nil {
+		pg.gssRegistrations = make(map[string]*gssRegistration)
 	}
+	pg.gssRegistrations[principal] = registration
 
-	stmt, err := txn.PrepareContext(ctx, pq.CopyInSchema(pg.Namespace, stagingTableName, sortedColumnKeys...))
-	if err != nil {
-		pg.logger.Errorf("PG: Error while preparing statement for  transaction in db for loading in staging table:%s: %v\nstmt: %v", stagingTableName, err, stmt)
-		tags["stage"] = copyInSchemaStagingTable
-		pg.runRollbackWithTimeout(txn.Rollback, handleRollbackTimeout, pg.TxnRollbackTimeout, tags)
-		return
-	}
-	for _, objectFileName := range fileNames {
-		var gzipFile *os.File
-		gzipFile, err = os.Open(objectFileName)
-		if err != nil {
-			pg.logger.Errorf("PG: Error opening file using os.Open for file:%s while loading to table %s", objectFileName, tableName)
-			tags["stage"] = openLoadFiles
-			pg.runRollbackWithTimeout(txn.Rollback, handleRollbackTimeout, pg.TxnRollbackTimeout, tags)
-			return
-		}
+	gssProviders.once.Do(func() {
+		pq.RegisterGSSProvider(func() (pq.GSS, error) {
+			return &dispatchingGSS{}, nil
+		})
+	})
+	return nil
+}
 
-		var gzipReader *gzip.Reader
-		gzipReader, err = gzip.NewReader(gzipFile)
+// unregisterGSSProviders unregisters the GSSAPI providers of the instance, if any.
+func (pg *Postgres) unregisterGSSProviders() {
+	gssProviders.Lock()
+	defer gssProviders.Unlock()
+
+	for _, registration := range pg.gssRegistrations {
+		pg.unregisterGSSProviderLocked(registration)
+	}
+}
+
+func (pg *Postgres) unregisterGSSProviderLocked(registration *gssRegistration) {
+	var registrations []*gssRegistration
+	for _, other := range gssProviders.byPrincipal[registration.principal] {
+		if other != registration {
+			registrations = append(registrations, other)
+		}
+	}
+	if len(registrations) == 0 {
+		delete(gssProviders.byPrincipal, registration.principal)
+	} else {
+		gssProviders.byPrincipal[registration.principal] = registrations
+	}
+	delete(pg.gssRegistrations, registration.principal)
+}
+
+// dispatchingGSS is the GSSAPI provider registered with lib/pq, creating the one of the destination once the server principal of the connection is known.
+type dispatchingGSS struct {
+	pq.GSS
+}
+
+func (g *dispatchingGSS) GetInitToken(host, service string) ([]byte, error) {
+	if err := g.dispatch(service + "/" + host); err != nil {
+		return nil, err
+	}
+	return g.GSS.GetInitToken(host, service)
+}
+
+func (g *dispatchingGSS) GetInitTokenFromSpn(spn string) ([]byte, error) {
+	if err := g.dispatch(spn); err != nil {
+		return nil, err
+	}
+	return g.GSS.GetInitTokenFromSpn(spn)
+}
+
+func (g *dispatchingGSS) dispatch(principal string) error {
+	gssProviders.Lock()
+	registrations := gssProviders.byPrincipal[principal]
+	gssProviders.Unlock()
+
+	// the registrations of the principal share the same client credentials
+	if len(registrations) == 0 {
+		return fmt.Errorf("no gssapi provider registered for server principal %s", principal)
+	}
+	gss, err := registrations[0].newGSS()
+	if err != nil {
+		return err
+	}
+	g.GSS = gss
+	return nil
+}
+
+// validateDSN checks that the dsn override is a postgres connection url with a host.
+// The url form is required as the ssh tunnel forwards to the host of the url, opening the driver named by its scheme.
+// The errors do not include the dsn, as it can contain the password.
+func validateDSN(dsn string) error {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("parsing url: %w", err)
+	}
+	if u.Scheme != "postgres" {
+		return fmt.Errorf("unsupported scheme %q, expected postgres", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return errors.New("missing host")
+	}
+	// the ssh tunnel re-encodes the query, silently dropping the parameters which cannot be parsed
+	if _, err := url.ParseQuery(u.RawQuery); err != nil {
+		return fmt.Errorf("parsing query: %w", err)
+	}
+	if _, err := pq.ParseURL(dsn); err != nil {
+		return fmt.Errorf("parsing url: %w", err)
+	}
+	return nil
+}
+
+// settingNameRegex matches the names of the runtime parameters, optionally qualified by the prefix of their extension, e.g. rudder.purpose
+var settingNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_$]*(\.[A-Za-z_][A-Za-z0-9_$]*)?$`)
+
+// validateSettings checks that the runtime parameters can be passed in the options startup parameter as is:
+// the names can't add other options and the values can't contain control characters or whitespace other than spaces, which are escaped by optionsParameter.
+func validateSettings(settings map[string]string) error {
+	for name, value := range settings {
+		if !settingNameRegex.MatchString(name) {
+			return fmt.Errorf("invalid name %q", name)
+		}
+		if !utf8.ValidString(value) || strings.IndexFunc(value, isUnescapedOptionRune) >= 0 {
+			return fmt.Errorf("invalid value of %s", name)
+		}
+	}
+	return nil
+}
+
+// isUnescapedOptionRune returns whether the character of a value can't be passed in the options startup parameter, as the server could split the options on it.
+func isUnescapedOptionRune(r rune) bool {
+	return unicode.IsControl(r) || (unicode.IsSpace(r) && r != ' ')
+}
+
+// optionsEscaper escapes the backslashes and spaces of the values of the options startup parameter.
+var optionsEscaper = strings.NewReplacer(`\`, `\\`, ` `, `\ `)
+
+// optionsParameter returns the options startup parameter setting the runtime parameters, sorted by name.
+// The server splits the options on whitespace, so the spaces and backslashes of the values are escaped with a backslash.
+func optionsParameter(settings map[string]string) string {
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	options := make([]string, 0, len(names))
+	for _, name := range names {
+		options = append(options, fmt.Sprintf("-c %s=%s", name, optionsEscaper.Replace(settings[name])))
+	}
+	return strings.Join(options, " ")
+}
+
+// negotiateSSL connects using SSL, falling back to an unencrypted connection if the server does not support SSL.
+// lib/pq does not support the prefer sslmode, so the fallback is only allowed with allowInsecureFallback.
+func (pg *Postgres) negotiateSSL(cred Credentials) (*sqlmiddleware.DB, error) {
+	cred.SSLMode = sslModeRequire
+	db, err := pg.open(cred)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	if cred.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cred.timeout)
+		defer cancel()
+	}
+
+	conn, err := db.Conn(ctx)
+	if err == nil {
+		_ = conn.Close()
+		return db, nil
+	}
+	_ = db.Close()
+
+	if !errors.Is(err, pq.ErrSSLNotSupported) {
+		return nil, fmt.Errorf("connecting using ssl: %w", err)
+	}
+	if !cred.AllowInsecureFallback {
+		return nil, fmt.Errorf("connecting using ssl, insecure fallback not allowed: %w", err)
+	}
+
+	pg.logger.Warnf("PG: INSECURE: Server does not support SSL, falling back to an unencrypted connection for destination:%s", pg.Warehouse.Destination.ID)
+	cred.SSLMode = sslModeDisable
+	return pg.open(cred)
+}
+
+// open opens the connection pool for the credentials, through the ssh tunnel if configured.
+func (pg *Postgres) open(cred Credentials) (*sqlmiddleware.DB, error) {
+	dsn := cred.dsn()
+
+	var (
+		err error
+		db  *sql.DB
+	)
+
+	if cred.TunnelInfo != nil {
+
+		db, err = tunnelling.SQLConnectThroughTunnel(dsn, cred.TunnelInfo.Config)
+		if err != nil {
+			return nil, fmt.Errorf("opening connection to postgres through tunnelling: %w", err)
+		}
+		db.SetMaxOpenConns(pg.MaxOpenConns)
+		return pg.getNewMiddleWare(db, connectionModeTunnel), nil
+	}
+
+	if pg.SocketWriteBufferSize > 0 || pg.SocketReadBufferSize > 0 || cred.TCPUserTimeout > 0 || cred.KeepalivesIdle > 0 || cred.KeepalivesInterval > 0 || cred.KeepalivesCount > 0 {
+		connector, err := pq.NewConnector(dsn)
+		if err != nil {
+			return nil, fmt.Errorf("opening connection to postgres: %w", err)
+		}
+		connector.Dialer(socketDialer{
+			dialer:             net.Dialer{KeepAlive: cred.KeepalivesIdle},
+			writeBufferSize:    pg.SocketWriteBufferSize,
+			readBufferSize:     pg.SocketReadBufferSize,
+			userTimeout:        cred.TCPUserTimeout,
+			keepalivesInterval: cred.KeepalivesInterval,
+			keepalivesCount:    cred.KeepalivesCount,
+		})
+		db = sql.OpenDB(connector)
+	} else if db, err = sql.Open("postgres", dsn); err != nil {
+		return nil, fmt.Errorf("opening connection to postgres: %w", err)
+	}
+	db.SetMaxOpenConns(pg.MaxOpenConns)
+
+	return pg.getNewMiddleWare(db, connectionModeDirect), nil
+}
+
+// socketDialer is the lib/pq dialer setting the sizes of the send and receive buffers of the tcp sockets, and the detection of their dropped connections.
+// lib/pq flushes the rows being copied every 63KB, so larger buffers let it keep on writing on high latency networks.
+// The keepalive idle time is the KeepAlive of the dialer, while the user timeout and the keepalive interval and count are left as is if not positive.
+type socketDialer struct {
+	dialer             net.Dialer
+	writeBufferSize    int
+	readBufferSize     int
+	userTimeout        time.Duration
+	keepalivesInterval time.Duration
+	keepalivesCount    int
+}
+
+func (d socketDialer) Dial(network, address string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, address)
+}
+
+func (d socketDialer) DialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return d.DialContext(ctx, network, address)
+}
+
+func (d socketDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	conn, err := d.dialer.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	// unix sockets are left as is
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return conn, nil
+	}
+	if d.writeBufferSize > 0 {
+		if err := tcpConn.SetWriteBuffer(d.writeBufferSize); err != nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("setting socket write buffer size: %w", err)
+		}
+	}
+	if d.readBufferSize > 0 {
+		if err := tcpConn.SetReadBuffer(d.readBufferSize); err != nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("setting socket read buffer size: %w", err)
+		}
+	}
+	if err := setTCPTimeouts(tcpConn, d.userTimeout, d.keepalivesInterval, d.keepalivesCount); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("setting socket timeouts: %w", err)
+	}
+	return conn, nil
+}
+
+// sslInline returns whether the ssl certificates of the destination config are passed to lib/pq in the dsn, instead of being written to SSLDir.
+func (pg *Postgres) sslInline() bool {
+	return warehouseutils.ReadAsBool(sslInline, pg.Warehouse.Destination.Config)
+}
+
+// formatInlineSSL formats the PEM of the destination config like the ssl files written to SSLDir, keeping it empty if unset.
+func formatInlineSSL(content string) string {
+	if content == "" {
+		return ""
+	}
+	return warehouseutils.FormatSSLFile(content)
+}
+
+// inlineSSL returns whether the inline ssl certificates are used, which they are not without ssl.
+func (cred Credentials) inlineSSL() bool {
+	return cred.SSLInline && cred.SSLMode != sslModeDisable
+}
+
+// addInlineSSL adds the inline ssl certificates to the dsn values, as supported by lib/pq with sslinline.
+func (cred Credentials) addInlineSSL(values url.Values) {
+	values.Set("sslinline", "true")
+	values.Set("sslcert", cred.SSLCert)
+	values.Set("sslkey", cred.SSLKey)
+	values.Set("sslrootcert", cred.SSLRootCert)
+}
+
+// dsn returns the connection string for the credentials.
+// For the gssapi auth mode no password is sent, and the server principal is set using krbsrvname or krbspn.
+// lib/pq does not support gssencmode, so the transport is still encrypted using sslmode.
+// The dsn override is returned as is, except for the inline ssl certificates which are added to it.
+func (cred Credentials) dsn() string {
+	if cred.DSN != "" {
+		if !cred.SSLInline {
+			return cred.DSN
+		}
+		u, err := url.Parse(cred.DSN)
+		if err != nil {
+			return cred.DSN
+		}
+		values := u.Query()
+		if values.Get("sslmode") == sslModeDisable {
+			return cred.DSN
+		}
+		cred.addInlineSSL(values)
+		u.RawQuery = values.Encode()
+		return u.String()
+	}
+
+	dsn := url.URL{
+		Scheme: "postgres",
+		Host:   fmt.Sprintf("%s:%s", cred.Host, cred.Port),
+		User:   url.UserPassword(cred.User, cred.Password),
+		Path:   cred.DBName,
+	}
+
+	values := url.Values{}
+	values.Add("sslmode", cred.SSLMode)
+
+	if cred.ApplicationName != "" {
+		values.Add("application_name", cred.ApplicationName)
+	}
+
+	if cred.timeout > 0 {
+		values.Add("connect_timeout", fmt.Sprintf("%d", cred.timeout/time.Second))
+	}
+
+	// lib/pq sends the parameters it does not know as runtime parameters, so these configure the socket of the server session.
+	// The client socket is configured by socketDialer.
+	if cred.TCPUserTimeout > 0 {
+		values.Add("tcp_user_timeout", fmt.Sprintf("%d", cred.TCPUserTimeout/time.Millisecond))
+	}
+	if cred.KeepalivesIdle > 0 {
+		values.Add("tcp_keepalives_idle", fmt.Sprintf("%d", cred.KeepalivesIdle/time.Second))
+	}
+	if cred.KeepalivesInterval > 0 {
+		values.Add("tcp_keepalives_interval", fmt.Sprintf("%d", cred.KeepalivesInterval/time.Second))
+	}
+	if cred.KeepalivesCount > 0 {
+		values.Add("tcp_keepalives_count", fmt.Sprintf("%d", cred.KeepalivesCount))
+	}
+
+	if len(cred.Settings) > 0 {
+		values.Add("options", optionsParameter(cred.Settings))
+	}
+
+	if cred.inlineSSL() {
+		cred.addInlineSSL(values)
+	} else if cred.SSLMode == verifyCA {
+		values.Add("sslrootcert", fmt.Sprintf("%s/server-ca.pem", cred.SSLDir))
+		values.Add("sslcert", fmt.Sprintf("%s/client-cert.pem", cred.SSLDir))
+		values.Add("sslkey", fmt.Sprintf("%s/client-key.pem", cred.SSLDir))
+	}
+
+	if cred.AuthMode == authModeGSSAPI {
+		dsn.User = url.User(cred.User)
+
+		krbSrvName := cred.KrbSrvName
+		if krbSrvName == "" {
+			krbSrvName = defaultKrbSrvName
+		}
+		values.Add("krbsrvname", krbSrvName)
+
+		if cred.KrbSPN != "" {
+			values.Add("krbspn", cred.KrbSPN)
+		}
+	}
+
+	dsn.RawQuery = values.Encode()
+	return dsn.String()
+}
+
+// redactedCredential replaces the credentials of the destination in the errors and logs.
+const redactedCredential = "****"
+
+var (
+	// urlPasswordRegex matches the password in the user info of connection urls,
+	// including the one of the dsn encoded into the path of the url of the ssh tunnel
+	urlPasswordRegex = regexp.MustCompile(`((?:://|/)[^:@/\s]*:)[^@\s]*@`)
+	// keyValuePasswordRegex matches the password of key value connection strings
+	keyValuePasswordRegex = regexp.MustCompile(`(?i)(password\s*=\s*)('(?:[^'\\]|\\.)*'|\S+)`)
+	// urlKeyRegex matches the private keys in the query of connection urls: the inline ssl key and the one of the ssh tunnel
+	urlKeyRegex = regexp.MustCompile(`([?&](?:sslkey|ssh_private_key)=)[^&\s"]*`)
+)
+
+// redact replaces the passwords and inline ssl keys of the connection strings in the text with redactedCredential.
+// Only the parts of the connection strings are replaced, so that the rest of the text is kept as is even if it happens to contain a password.
+func redact(text string) string {
+	text = urlPasswordRegex.ReplaceAllString(text, "${1}"+redactedCredential+"@")
+	text = urlKeyRegex.ReplaceAllString(text, "${1}"+redactedCredential)
+	return keyValuePasswordRegex.ReplaceAllString(text, "${1}"+redactedCredential)
+}
+
+// redactedError is an error whose message has the credentials redacted, still wrapping the original error.
+type redactedError struct {
+	message string
+	err     error
+}
+
+func (e *redactedError) Error() string {
+	return e.message
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
+
+// redactError returns the error with the credentials of the connection strings redacted from its message.
+func (pg *Postgres) redactError(err error) error {
+	if err == nil {
+		return nil
+	}
+	message := err.Error()
+	if redacted := redact(message); redacted != message {
+		return &redactedError{message: redacted, err: err}
+	}
+	return err
+}
+
+// connectReplica opens the connection to the read replica, if one is configured for the destination.
+// The read replica dsn overrides the one of the primary, with the same options: the ssh tunnel, the inline ssl certificates and the auth mode.
+func (pg *Postgres) connectReplica() (_ *sqlmiddleware.DB, err error) {
+	dsn := warehouseutils.GetConfigValue(readReplicaDSN, pg.Warehouse)
+	if dsn == "" {
+		return nil, nil
+	}
+	defer func() { err = pg.redactError(err) }()
+
+	cred := pg.getConnectionCredentials()
+	cred.DSN = dsn
+
+	db, err := pg.connectWith(cred)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to postgres read replica: %w", err)
+	}
+	return db, nil
+}
+
+// readDB returns the read replica for read-only queries.
+// Falls back to the primary if the read replica is not configured or skipped after a query failed on it.
+func (pg *Postgres) readDB() *sqlmiddleware.DB {
+	pg.reconnectMu.RLock()
+	db, replicaDB := pg.DB, pg.replicaDB
+	pg.reconnectMu.RUnlock()
+
+	if replicaDB == nil {
+		return db
+	}
+
+	pg.replicaMu.Lock()
+	defer pg.replicaMu.Unlock()
+
+	if time.Now().Before(pg.replicaUnhealthyUntil) {
+		return db
+	}
+	return replicaDB
+}
+
+// queryReadDB runs the read-only query on the database returned by readDB.
+// If it fails on the read replica because of the connection, it is run again on the primary and the read replica is skipped for ReplicaRetryInterval.
+// The other errors are returned as is, as they would fail on the primary too.
+func (pg *Postgres) queryReadDB(ctx context.Context, query func(db *sqlmiddleware.DB) error) error {
+	db := pg.readDB()
+	err := query(db)
+	if err == nil || db == pg.connectedDB() || ctx.Err() != nil || !isConnectionError(err) {
+		return err
+	}
+
+	pg.logger.Warnf("PG: Query failed on the read replica for PG:%s, falling back to primary for %s: %v", pg.Warehouse.Destination.ID, pg.ReplicaRetryInterval, pg.redactError(err))
+
+	pg.replicaMu.Lock()
+	pg.replicaUnhealthyUntil = time.Now().Add(pg.ReplicaRetryInterval)
+	pg.replicaMu.Unlock()
+
+	return query(pg.connectedDB())
+}
+
+// fetchCursor is the server side cursor the rows of the introspection queries are fetched through with FetchSize.
+const fetchCursor = "rudder_fetch_cursor"
+
+// queryRows runs the query, calling scan for each of its rows.
+// With FetchSize, the rows are fetched through a server side cursor FetchSize rows at a time, so that only a batch of them is buffered.
+func (pg *Postgres) queryRows(ctx context.Context, db *sqlmiddleware.DB, query string, args []interface{}, scan func(*sql.Rows) error) error {
+	if pg.FetchSize <= 0 {
+		rows, err := db.QueryContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = rows.Close() }()
+
+		for rows.Next() {
+			if err := scan(rows); err != nil {
+				return err
+			}
+		}
+		return rows.Err()
+	}
+
+	// cursors only live within a transaction
+	txn, err := db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %w", err)
+	}
+	defer func() { _ = txn.Rollback() }()
+
+	query = strings.TrimSuffix(strings.TrimSpace(query), ";")
+	if _, err := txn.ExecContext(ctx, fmt.Sprintf(`DECLARE %s NO SCROLL CURSOR FOR %s;`, fetchCursor, query), args...); err != nil {
+		return fmt.Errorf("declaring cursor: %w", err)
+	}
+
+	for {
+		fetched, err := pg.fetchRows(ctx, txn, scan)
+		if err != nil {
+			return err
+		}
+		if fetched < pg.FetchSize {
+			break
+		}
+	}
+	return txn.Commit()
+}
+
+// fetchRows fetches the next FetchSize rows of the cursor, calling scan for each of them, and returns the number of rows fetched.
+func (pg *Postgres) fetchRows(ctx context.Context, txn *sqlmiddleware.Tx, scan func(*sql.Rows) error) (int, error) {
+	rows, err := txn.QueryContext(ctx, fmt.Sprintf(`FETCH FORWARD %d FROM %s;`, pg.FetchSize, fetchCursor))
+	if err != nil {
+		return 0, fmt.Errorf("fetching from cursor: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var fetched int
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return 0, err
+		}
+		fetched++
+	}
+	return fetched, rows.Err()
+}
+
+// sslKeyDir returns the directory of the SSL keys, which is the temporary one if written with EphemeralSSLKeys.
+func (pg *Postgres) sslKeyDir() string {
+	if pg.sslDir != "" {
+		return pg.sslDir
+	}
+	return warehouseutils.GetSSLKeyDirPath(pg.Warehouse.Destination.ID)
+}
+
+// writeEphemeralSSLKeys writes the SSL keys of the destination to a temporary directory with EphemeralSSLKeys, unless already written.
+// Unlike the directory shared across runs, it is removed by Cleanup, so that the keys do not stay on disk after the upload.
+func (pg *Postgres) writeEphemeralSSLKeys() error {
+	if !pg.EphemeralSSLKeys || pg.sslDir != "" || warehouseutils.GetConfigValue(sslMode, pg.Warehouse) != verifyCA || pg.sslInline() {
+		return nil
+	}
+
+	sslDir, err := os.MkdirTemp(pg.TmpDir, "rudder-pg-ssl-")
+	if err != nil {
+		return fmt.Errorf("creating ssl keys directory: %w", err)
+	}
+	if sslKeyError := warehouseutils.WriteSSLKeysToDir(pg.Warehouse.Destination, sslDir); sslKeyError.IsError() {
+		_ = os.RemoveAll(sslDir)
+		return fmt.Errorf("writing ssl keys: %s", sslKeyError.Error())
+	}
+	pg.sslDir = sslDir
+	return nil
+}
+
+func (pg *Postgres) getConnectionCredentials() Credentials {
+	sslMode := warehouseutils.GetConfigValue(sslMode, pg.Warehouse)
+	creds := Credentials{
+		Host:     warehouseutils.GetConfigValue(host, pg.Warehouse),
+		DBName:   warehouseutils.GetConfigValue(dbName, pg.Warehouse),
+		User:     warehouseutils.GetConfigValue(user, pg.Warehouse),
+		Password: warehouseutils.GetConfigValue(password, pg.Warehouse),
+		Port:     warehouseutils.GetConfigValue(port, pg.Warehouse),
+		SSLMode:  sslMode,
+		SSLDir:   pg.sslKeyDir(),
+		timeout:  pg.ConnectTimeout,
+		TunnelInfo: warehouseutils.ExtractTunnelInfoFromDestinationConfig(
+			pg.Warehouse.Destination.Config,
+		),
+		AuthMode:              authModePassword,
+		ApplicationName:       warehouseutils.GetConfigValue(applicationName, pg.Warehouse),
+		AllowInsecureFallback: warehouseutils.ReadAsBool(allowInsecureFallback, pg.Warehouse.Destination.Config),
+		TCPUserTimeout:        pg.TCPUserTimeout,
+		KeepalivesIdle:        pg.KeepalivesIdle,
+		KeepalivesInterval:    pg.KeepalivesInterval,
+		KeepalivesCount:       pg.KeepalivesCount,
+		DSN:                   warehouseutils.GetConfigValue(connectionDSN, pg.Warehouse),
+		Settings:              pg.connectionSettings(),
+	}
+	if creds.ApplicationName == "" {
+		creds.ApplicationName = fmt.Sprintf("rudder-warehouse-%s", pg.Warehouse.Destination.ID)
+	}
+
+	if pg.sslInline() {
+		creds.SSLInline = true
+		creds.SSLCert = formatInlineSSL(warehouseutils.GetConfigValue(clientCert, pg.Warehouse))
+		creds.SSLKey = formatInlineSSL(warehouseutils.GetConfigValue(clientKey, pg.Warehouse))
+		creds.SSLRootCert = formatInlineSSL(warehouseutils.GetConfigValue(serverCA, pg.Warehouse))
+	}
+
+	if warehouseutils.GetConfigValue(authMode, pg.Warehouse) == authModeGSSAPI {
+		creds.AuthMode = authModeGSSAPI
+		creds.Password = ""
+		creds.KrbSrvName = warehouseutils.GetConfigValue(krbSrvName, pg.Warehouse)
+		creds.KrbSPN = warehouseutils.GetConfigValue(krbSPN, pg.Warehouse)
+		creds.KeytabPath = warehouseutils.GetConfigValue(krbKeytabPath, pg.Warehouse)
+		creds.CCachePath = warehouseutils.GetConfigValue(krbCCachePath, pg.Warehouse)
+	}
+
+	return creds
+}
+
+// connectionSettings returns the runtime parameters of the sessions, the ones of the destination taking precedence over the configured ones.
+func (pg *Postgres) connectionSettings() map[string]string {
+	settings := make(map[string]string, len(pg.ConnectionSettings))
+	for name, value := range pg.ConnectionSettings {
+		settings[name] = value
+	}
+	if destinationSettings, ok := pg.Warehouse.Destination.Config[connectionSettings].(map[string]interface{}); ok {
+		for name, value := range stringMap(destinationSettings) {
+			settings[name] = value
+		}
+	}
+	return settings
+}
+
+func ColumnsWithDataTypes(columns map[string]string, prefix string) string {
+	var arr []string
+	for name, dataType := range columns {
+		arr = append(arr, fmt.Sprintf(`"%s%s" %s`, prefix, name, rudderDataTypesMapToPostgres[dataType]))
+	}
+	return strings.Join(arr, ",")
+}
+
+func (*Postgres) IsEmpty(context.Context, model.Warehouse) (empty bool, err error) {
+	return
+}
+
+// tmpDirPath returns the directory for downloading the load files, which is the configured TmpDir if set.
+func (pg *Postgres) tmpDirPath() (string, error) {
+	if pg.TmpDir != "" {
+		return pg.TmpDir, nil
+	}
+	return misc.CreateTMPDIR()
+}
+
+// validateTmpDir checks that the directory exists and is writable.
+func validateTmpDir(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("checking tmp directory %s: %w", dir, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("tmp directory %s is not a directory", dir)
+	}
+
+	f, err := os.CreateTemp(dir, "write-check-*")
+	if err != nil {
+		return fmt.Errorf("checking tmp directory %s is writable: %w", dir, err)
+	}
+	_ = f.Close()
+	return os.Remove(f.Name())
+}
+
+// checkDiskSpace checks that the load files fit in the available space of the tmp directory, returning a DiskFullError otherwise.
+// The sizes of the load files are multiplied by the DiskSpaceFactor, accounting for their decompression. A factor of 0 disables the check.
+func (pg *Postgres) checkDiskSpace(objects []warehouseutils.LoadFile) error {
+	if pg.DiskSpaceFactor <= 0 {
+		return nil
+	}
+
+	var size int64
+	for _, object := range objects {
+		size += gjson.GetBytes(object.Metadata, "content_length").Int()
+	}
+	if size <= 0 {
+		return nil
+	}
+
+	tmpDirPath, err := pg.tmpDirPath()
+	if err != nil {
+		return fmt.Errorf("checking disk space: %w", err)
+	}
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(tmpDirPath, &stat); err != nil {
+		return fmt.Errorf("checking disk space of %s: %w", tmpDirPath, err)
+	}
+
+	required := uint64(float64(size) * pg.DiskSpaceFactor)
+	available := uint64(stat.Bavail) * uint64(stat.Bsize)
+	if required > available {
+		return &DiskFullError{Dir: tmpDirPath, Required: required, Available: available}
+	}
+	return nil
+}
+
+// DownloadLoadFiles downloads the load files of the table, with at most DownloadConcurrency downloads running at once.
+// Nothing is downloaded if the load files are not expected to fit in the tmp directory, see checkDiskSpace.
+// The returned files follow the order of the load files. If any download fails, the files already downloaded are removed.
+func (pg *Postgres) DownloadLoadFiles(ctx context.Context, tableName string) ([]string, error) {
+	objects := pg.Uploader.GetLoadFilesMetadata(ctx, warehouseutils.GetLoadFilesOptions{Table: tableName})
+	if err := pg.checkDiskSpace(objects); err != nil {
+		pg.logger.Errorf("PG: Error in checking disk space for downloading load files for table:%s: %v", tableName, err)
+		return nil, err
+	}
+	storageProvider := warehouseutils.ObjectStorageType(pg.Warehouse.Destination.DestinationDefinition.Name, pg.Warehouse.Destination.Config, pg.Uploader.UseRudderStorage())
+	downloader, err := pg.fileManagerFactory.New(&filemanager.SettingsT{
+		Provider: storageProvider,
+		Config: misc.GetObjectStorageConfig(misc.ObjectStorageOptsT{
+			Provider:         storageProvider,
+			Config:           pg.Warehouse.Destination.Config,
+			UseRudderStorage: pg.Uploader.UseRudderStorage(),
+			WorkspaceID:      pg.Warehouse.Destination.WorkspaceID,
+		}),
+	})
+	if err != nil {
+		pg.logger.Errorf("PG: Error in setting up a %s downloader for destinationID : %s Error : %v", storageProvider, pg.Warehouse.Destination.ID, err)
+		return nil, err
+	}
+
+	fileNames := make([]string, len(objects))
+
+	concurrency := pg.DownloadConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	g, gCtx := errgroup.WithContext(ctx)
+	g.SetLimit(concurrency)
+	for i, object := range objects {
+		i, object := i, object
+
+		g.Go(func() error {
+			fileName, err := pg.downloadLoadFile(gCtx, downloader, tableName, object)
+			if err != nil {
+				return err
+			}
+			fileNames[i] = fileName
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		var downloadedFileNames []string
+		for _, fileName := range fileNames {
+			if fileName != "" {
+				downloadedFileNames = append(downloadedFileNames, fileName)
+			}
+		}
+		misc.RemoveFilePaths(downloadedFileNames...)
+		return nil, err
+	}
+	return fileNames, nil
+}
+
+// downloadLoadFile downloads the load file into the tmp directory and returns the path of the downloaded file.
+// Transient download errors are retried up to DownloadRetries times with a jittered exponential backoff, downloading the object anew.
+// The file is removed if the download fails.
+func (pg *Postgres) downloadLoadFile(ctx context.Context, downloader filemanager.FileManager, tableName string, object warehouseutils.LoadFile) (string, error) {
+	objectName, err := warehouseutils.GetObjectName(object.Location, pg.Warehouse.Destination.Config, pg.ObjectStorage)
+	if err != nil {
+		pg.logger.Errorf("PG: Error in converting object location to object key for table:%s: %s,%v", tableName, object.Location, err)
+		return "", err
+	}
+	dirName := fmt.Sprintf(`/%s/`, misc.RudderWarehouseLoadUploadsTmp)
+	tmpDirPath, err := pg.tmpDirPath()
+	if err != nil {
+		pg.logger.Errorf("PG: Error in creating tmp directory for downloading load file for table:%s: %s, %v", tableName, object.Location, err)
+		return "", err
+	}
+	ObjectPath := tmpDirPath + dirName + fmt.Sprintf(`%s_%s_%d/`, pg.Warehouse.Destination.DestinationDefinition.Name, pg.Warehouse.Destination.ID, time.Now().Unix()) + objectName
+
+	b := backoff.NewExponentialBackOff()
+	b.InitialInterval = pg.DownloadRetryInterval
+	b.MaxElapsedTime = 0
+
+	var fileName string
+	operation := func() error {
+		// the directory is made on every attempt, as removing the file of a failed attempt removes its emptied directories
+		err := os.MkdirAll(filepath.Dir(ObjectPath), os.ModePerm)
+		if err != nil {
+			pg.logger.Errorf("PG: Error in making tmp directory for downloading load file for table:%s: %s, %v", tableName, object.Location, err)
+			return backoff.Permanent(err)
+		}
+		objectFile, err := os.Create(ObjectPath)
+		if err != nil {
+			pg.logger.Errorf("PG: Error in creating file in tmp directory for downloading load file for table:%s: %s, %v", tableName, object.Location, err)
+			return backoff.Permanent(err)
+		}
+		fileName = objectFile.Name()
+		err = downloader.Download(ctx, objectFile, objectName)
+		if err != nil {
+			_ = objectFile.Close()
+			misc.RemoveFilePaths(fileName)
+			if !isTransientDownloadError(err) {
+				return backoff.Permanent(err)
+			}
+			return err
+		}
+		if err = objectFile.Close(); err != nil {
+			pg.logger.Errorf("PG: Error in closing downloaded file in tmp directory for downloading load file for table:%s: %s, %v", tableName, object.Location, err)
+			misc.RemoveFilePaths(fileName)
+			return backoff.Permanent(err)
+		}
+		return nil
+	}
+	err = backoff.RetryNotify(
+		operation,
+		backoff.WithContext(backoff.WithMaxRetries(b, uint64(pg.DownloadRetries)), ctx),
+		func(err error, d time.Duration) {
+			pg.logger.Warnf("PG: Error in downloading load file for table:%s: %s, retrying in %v: %v", tableName, object.Location, d, err)
+			pg.stats.NewTaggedStat("pg_download_retries", stats.CountType, stats.Tags{
+				"workspaceId":   pg.Warehouse.WorkspaceID,
+				"namespace":     pg.Namespace,
+				"destinationID": pg.Warehouse.Destination.ID,
+				"tableName":     tableName,
+			}).Count(1)
+		},
+	)
+	if err != nil {
+		pg.logger.Errorf("PG: Error in downloading file in tmp directory for downloading load file for table:%s: %s, %v", tableName, object.Location, err)
+		return "", err
+	}
+	return fileName, nil
+}
+
+// isTransientDownloadError returns whether the download error can be retried, e.g. a timeout or a 5xx response of the object storage.
+// Missing objects, 4xx responses other than timeouts and throttling, local file errors and cancellations are permanent.
+func isTransientDownloadError(err error) bool {
+	if errors.Is(err, context.Canceled) || filemanager.IsNotFound(err) {
+		return false
+	}
+
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		return false
+	}
+
+	if statusCode, ok := filemanager.StatusCode(err); ok {
+		if statusCode >= 400 && statusCode < 500 && statusCode != http.StatusRequestTimeout && statusCode != http.StatusTooManyRequests {
+			return false
+		}
+	}
+	return true
+}
+
+// inFlightRollbacks tracks the rollbacks still running across all the postgres instances.
+// Rollbacks which timed out keep running in the background and outlive the instance which started them.
+var inFlightRollbacks atomic.Int64
+
+func (pg *Postgres) handleRollbackTimeout(tags stats.Tags) {
+	pg.stats.NewTaggedStat("pg_rollback_timeout", stats.CountType, tags).Count(1)
+}
+
+func (pg *Postgres) gaugeInFlightRollbacks(inFlight int64) {
+	pg.stats.NewStat("pg_rollback_in_flight", stats.GaugeType).Gauge(inFlight)
+}
+
+// runRollbackWithTimeout runs the rollback in the background and waits for it to complete for at most d.
+// If the rollback times out, it keeps running in the background and its eventual completion is logged.
+// Once MaxInFlightRollbacksToAwait are running, new rollbacks are still issued for the transactions to release their connection and locks,
+// but they are not waited for.
+func (pg *Postgres) runRollbackWithTimeout(f func() error, onTimeout func(tags stats.Tags), d time.Duration, tags stats.Tags) {
+	inFlight := inFlightRollbacks.Add(1)
+	pg.gaugeInFlightRollbacks(inFlight)
+
+	var timedOut atomic.Bool
+	if inFlight > int64(pg.MaxInFlightRollbacksToAwait) {
+		pg.logger.Errorf("PG: Not waiting for rolling back transaction since %d rollbacks are already in-flight, tags: %v", pg.MaxInFlightRollbacksToAwait, tags)
+		pg.stats.NewTaggedStat("pg_rollback_not_awaited", stats.CountType, tags).Count(1)
+		timedOut.Store(true)
+	}
+
+	c := make(chan struct{})
+	go func() {
+		defer close(c)
+		defer func() {
+			pg.gaugeInFlightRollbacks(inFlightRollbacks.Add(-1))
+		}()
+
+		err := f()
+		if err != nil {
+			pg.logger.Errorf("PG: Error in rolling back transaction : %v, tags: %v", err, tags)
+		}
+		if timedOut.Load() {
+			pg.logger.Infof("PG: Rollback of transaction not waited for completed with error: %v, tags: %v", err, tags)
+		}
+	}()
+	if timedOut.Load() {
+		return
+	}
+
+	select {
+	case <-c:
+	case <-time.After(d):
+		timedOut.Store(true)
+		pg.logger.Errorf("PG: Timed out rolling back transaction after %v, tags: %v", d, tags)
+		onTimeout(tags)
+	}
+}
+
+func (pg *Postgres) loadTable(ctx context.Context, tableName string, tableSchemaInUpload model.TableSchema, skipTempTableDelete bool) (stagingTableName string, loadStats LoadStats, err error) {
+	fileNames, err := pg.DownloadLoadFiles(ctx, tableName)
+	defer misc.RemoveFilePaths(fileNames...)
+	if err != nil {
+		return
+	}
+
+	var loadID string
+	if pg.EnableLoadIDColumn {
+		var locations []string
+		for _, loadFile := range pg.Uploader.GetLoadFilesMetadata(ctx, warehouseutils.GetLoadFilesOptions{Table: tableName}) {
+			locations = append(locations, loadFile.Location)
+		}
+		loadID = loadIDOf(locations)
+	}
+
+	// the staging table is kept for further use, which requires all the files to be in it
+	if skipTempTableDelete {
+		stagingTableName, loadStats, err = pg.loadTableFromFiles(ctx, tableName, tableSchemaInUpload, fileNames, loadID, skipTempTableDelete, false)
+	} else {
+		loadStats, err = pg.loadTableFromFilesInBatches(ctx, tableName, tableSchemaInUpload, fileNames, loadID)
+	}
+	if err == nil && pg.AnalyzeAfterLoad {
+		pg.analyzeTable(ctx, tableName)
+	}
+	return
+}
+
+// analyzeTable refreshes the planner statistics of the table, which are stale after a big load until autovacuum catches up.
+// It is best-effort, since the load is already committed.
+func (pg *Postgres) analyzeTable(ctx context.Context, tableName string) {
+	sqlStatement := fmt.Sprintf(`ANALYZE "%[1]s"."%[2]s"`, pg.Namespace, pg.tableName(tableName))
+	pg.logger.Infof("PG: Analyzing table:%s : %v", tableName, sqlStatement)
+	if _, err := pg.connectedDB().ExecContext(ctx, sqlStatement); err != nil {
+		pg.logger.Warnf("PG: Error analyzing table:%s: %v", tableName, err)
+	}
+}
+
+// loadIDOf returns the id of the load of the given load files.
+// It only depends on the set of load files, so that a retried load gets the same id as the original one.
+func loadIDOf(loadFiles []string) string {
+	sorted := slices.Clone(loadFiles)
+	sort.Strings(sorted)
+
+	h := sha256.New()
+	for _, loadFile := range sorted {
+		h.Write([]byte(loadFile))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))[:32]
+}
+
+// loadTableFromFilesInBatches loads the files in batches of at most MaxFilesPerTxn files, with a transaction per batch.
+// This avoids holding a single transaction open while loading a large number of files.
+// The first batch is deduplicated against the table as a single transaction would be. The later batches keep the rows newer than their records
+// by the dedup order columns, so that an older record of a later batch doesn't replace a newer one committed by an earlier batch.
+// Unlike with a single transaction, this also keeps the rows of earlier uploads that are newer than the records of the later batches.
+func (pg *Postgres) loadTableFromFilesInBatches(ctx context.Context, tableName string, tableSchemaInUpload model.TableSchema, fileNames []string, loadID string) (LoadStats, error) {
+	batchSize := len(fileNames)
+	if pg.MaxFilesPerTxn > 0 && pg.MaxFilesPerTxn < batchSize {
+		batchSize = pg.MaxFilesPerTxn
+	}
+
+	var loadStats LoadStats
+	for start := 0; ; start += batchSize {
+		end := start + batchSize
+		if end > len(fileNames) {
+			end = len(fileNames)
+		}
+
+		_, batchStats, err := pg.loadTableFromFiles(ctx, tableName, tableSchemaInUpload, fileNames[start:end], loadID, false, start > 0)
+		if err != nil {
+			return LoadStats{}, err
+		}
+		loadStats = loadStats.add(batchStats)
+		if end >= len(fileNames) {
+			return loadStats, nil
+		}
+	}
+}
+
+// dedupOrderColumn returns the column ordering the records when deduplicating, the latest record winning.
+// It is received_at unless configured otherwise for the table, in which case the column must exist in the table.
+func (pg *Postgres) dedupOrderColumn(tableName string, tableSchema model.TableSchema) (string, error) {
+	orderColumn, ok := pg.DedupOrderColumns[tableName]
+	if !ok {
+		return "received_at", nil
+	}
+	if _, ok := tableSchema[orderColumn]; !ok {
+		return "", fmt.Errorf("dedup order column %s not found in table %s", orderColumn, tableName)
+	}
+	return orderColumn, nil
+}
+
+// dedupTieBreakColumn returns the column breaking the ties on the order column when deduplicating, the greatest value winning.
+// It is only set if configured for the table, in which case the column must exist in the table.
+func (pg *Postgres) dedupTieBreakColumn(tableName string, tableSchema model.TableSchema) (string, error) {
+	tieBreakColumn, ok := pg.DedupTieBreakColumns[tableName]
+	if !ok {
+		return "", nil
+	}
+	if _, ok := tableSchema[tieBreakColumn]; !ok {
+		return "", fmt.Errorf("dedup tie break column %s not found in table %s", tieBreakColumn, tableName)
+	}
+	return tieBreakColumn, nil
+}
+
+// dedupInsertStatement returns the statement inserting the latest record of every partition of the staging table into the table.
+// Records are ordered by the order columns, and the ones still tied are ordered by their position in the staging table, the record copied last winning.
+// The staging table is created and only appended to within the load transaction, so its ctid, or its stagingPositionColumn for compatible databases,
+// follows the order the records were copied in.
+// If a load id is given, the rows are stamped with it and the rows already inserted by the same load are skipped.
+// If upsert columns are given, the existing rows with the same conflict columns are updated instead, keeping the values of the upsert columns
+// the new rows have no value for.
+// If keepNewerRows is set, the records older than the existing row with the same conflict columns are skipped.
+func (pg *Postgres) dedupInsertStatement(targetTableName, stagingTableName, quotedColumnNames, partitionKey string, orderColumns []string, loadID string, conflictColumns, upsertColumns []string, keepNewerRows bool) string {
+	orderBy := pg.dedupOrderBy(orderColumns)
+
+	var where string
+	if keepNewerRows {
+		stagingTableRef := fmt.Sprintf(`"%[1]s"."%[2]s"`, pg.Namespace, stagingTableName)
+		var keysMatch []string
+		for _, column := range conflictColumns {
+			keysMatch = append(keysMatch, fmt.Sprintf(`_target.%[1]q = %[2]s.%[1]q`, column, stagingTableRef))
+		}
+		where = fmt.Sprintf(`WHERE NOT EXISTS (SELECT 1 FROM "%[1]s"."%[2]s" AS _target WHERE %[3]s AND %[4]s)`,
+			pg.Namespace, targetTableName, strings.Join(keysMatch, " AND "), newerRowCondition(`_target`, stagingTableRef, orderColumns))
+	}
+
+	insertColumns, selectColumns, onConflict := quotedColumnNames, quotedColumnNames, ""
+	if loadID != "" {
+		insertColumns += fmt.Sprintf(`, %q`, loadIDColumn)
+		selectColumns += `, ` + pq.QuoteLiteral(loadID)
+		onConflict = fmt.Sprintf(`ON CONFLICT (%s) DO NOTHING`, warehouseutils.DoubleQuoteAndJoinByComma(append(slices.Clone(conflictColumns), loadIDColumn)))
+	}
+	if len(upsertColumns) > 0 {
+		if loadID != "" {
+			upsertColumns = append(slices.Clone(upsertColumns), loadIDColumn)
+		}
+		onConflict = pg.coalesceOnConflict(targetTableName, conflictColumns, upsertColumns)
+	}
+
+	if pg.DedupQuery == dedupQueryDistinctOn {
+		return fmt.Sprintf(`INSERT INTO "%[1]s"."%[2]s" (%[3]s)
+									SELECT DISTINCT ON (%[6]s) %[4]s FROM "%[1]s"."%[5]s" %[9]s
+									ORDER BY %[6]s, %[7]s
+									%[8]s`, pg.Namespace, targetTableName, insertColumns, selectColumns, stagingTableName, partitionKey, strings.Join(orderBy, ", "), onConflict, where)
+	}
+	return fmt.Sprintf(`INSERT INTO "%[1]s"."%[2]s" (%[3]s)
+									SELECT %[4]s FROM (
+										SELECT *, row_number() OVER (PARTITION BY %[6]s ORDER BY %[7]s) AS _rudder_staging_row_number FROM "%[1]s"."%[5]s" %[9]s
+									) AS _ where _rudder_staging_row_number = 1
+									%[8]s`, pg.Namespace, targetTableName, insertColumns, selectColumns, stagingTableName, partitionKey, strings.Join(orderBy, ", "), onConflict, where)
+}
+
+// newerRowCondition returns the condition of the row of the table being newer than the record of the staging table by the order columns.
+// A row whose order columns can't be compared to the record's, e.g. for being null, isn't newer.
+func newerRowCondition(targetTableRef, stagingTableRef string, orderColumns []string) string {
+	var targetColumns, stagingColumns []string
+	for _, column := range orderColumns {
+		targetColumns = append(targetColumns, fmt.Sprintf(`%s.%q`, targetTableRef, column))
+		stagingColumns = append(stagingColumns, fmt.Sprintf(`%s.%q`, stagingTableRef, column))
+	}
+	return fmt.Sprintf(`((%s) > (%s)) IS TRUE`, strings.Join(targetColumns, ", "), strings.Join(stagingColumns, ", "))
+}
+
+// coalesceOnConflict returns the ON CONFLICT clause updating the existing rows with the values of the new rows,
+// keeping the existing values of the columns the new rows have null for.
+func (pg *Postgres) coalesceOnConflict(targetTableName string, conflictColumns, upsertColumns []string) string {
+	var set []string
+	for _, column := range upsertColumns {
+		if slices.Contains(conflictColumns, column) {
+			continue
+		}
+		set = append(set, fmt.Sprintf(`%[1]q = COALESCE(EXCLUDED.%[1]q, "%[2]s"."%[3]s".%[1]q)`, column, pg.Namespace, targetTableName))
+	}
+	conflict := warehouseutils.DoubleQuoteAndJoinByComma(conflictColumns)
+	if len(set) == 0 {
+		return fmt.Sprintf(`ON CONFLICT (%s) DO NOTHING`, conflict)
+	}
+	return fmt.Sprintf(`ON CONFLICT (%s) DO UPDATE SET %s`, conflict, strings.Join(set, ", "))
+}
+
+// dedupOrderBy returns the ordering of the records with the same dedup keys, the first of which is kept.
+// The records still tied are ordered by their position, the record copied last coming first.
+// Compatible databases have no ctid, so the position of the records of their staging tables is the stagingPositionColumn.
+func (pg *Postgres) dedupOrderBy(orderColumns []string) []string {
+	var orderBy []string
+	for _, orderColumn := range orderColumns {
+		orderBy = append(orderBy, fmt.Sprintf(`%q DESC`, orderColumn))
+	}
+	if pg.isVanillaPostgres() {
+		return append(orderBy, `ctid DESC`)
+	}
+	return append(orderBy, fmt.Sprintf(`%q DESC`, stagingPositionColumn))
+}
+
+// dedupInPlaceStatement returns the statement deleting the duplicates of the records copied directly into the table,
+// keeping the same records as the insert of dedupInsertStatement would.
+func (pg *Postgres) dedupInPlaceStatement(targetTableName, partitionKey string, orderColumns []string) string {
+	return fmt.Sprintf(`DELETE FROM "%[1]s"."%[2]s" WHERE ctid IN (
+									SELECT ctid FROM (
+										SELECT ctid, row_number() OVER (PARTITION BY %[3]s ORDER BY %[4]s) AS _rudder_staging_row_number FROM "%[1]s"."%[2]s"
+									) AS _ where _rudder_staging_row_number > 1
+								)`, pg.Namespace, targetTableName, partitionKey, strings.Join(pg.dedupOrderBy(orderColumns), ", "))
+}
+
+// truncateForFreeze returns whether the table is an empty regular table, truncating it so that it can be copied into with COPY FREEZE,
+// which requires the table to be created or truncated in the transaction.
+// The table is locked before truncating it, so that no rows committed meanwhile are lost, and stays locked until the end of the transaction.
+func (pg *Postgres) truncateForFreeze(ctx context.Context, txn *sqlmiddleware.Tx, targetTableName string) (bool, error) {
+	var relKind string
+	err := txn.QueryRowContext(ctx, `SELECT relkind FROM pg_catalog.pg_class WHERE oid = to_regclass($1);`,
+		fmt.Sprintf(`%q.%q`, pg.Namespace, targetTableName),
+	).Scan(&relKind)
+	if err != nil {
+		return false, fmt.Errorf("getting kind of table %s: %w", targetTableName, err)
+	}
+	// partitioned tables cannot be copied into with FREEZE
+	if relKind != "r" {
+		return false, nil
+	}
+
+	// the table is only locked once found empty, leaving the loads into non empty tables unaffected
+	if empty, err := pg.isTableEmpty(ctx, txn, targetTableName); err != nil || !empty {
+		return false, err
+	}
+	if _, err := txn.ExecContext(ctx, fmt.Sprintf(`LOCK TABLE "%[1]s"."%[2]s" IN ACCESS EXCLUSIVE MODE;`, pg.Namespace, targetTableName)); err != nil {
+		return false, fmt.Errorf("locking table %s: %w", targetTableName, err)
+	}
+	if empty, err := pg.isTableEmpty(ctx, txn, targetTableName); err != nil || !empty {
+		return false, err
+	}
+	if _, err := txn.ExecContext(ctx, fmt.Sprintf(`TRUNCATE TABLE "%[1]s"."%[2]s";`, pg.Namespace, targetTableName)); err != nil {
+		return false, fmt.Errorf("truncating table %s: %w", targetTableName, err)
+	}
+	return true, nil
+}
+
+// isTableEmpty returns whether the table has no rows, as seen by the transaction.
+func (pg *Postgres) isTableEmpty(ctx context.Context, txn *sqlmiddleware.Tx, targetTableName string) (bool, error) {
+	var empty bool
+	err := txn.QueryRowContext(ctx, fmt.Sprintf(`SELECT NOT EXISTS (SELECT 1 FROM "%[1]s"."%[2]s");`, pg.Namespace, targetTableName)).Scan(&empty)
+	return empty, err
+}
+
+// loadIDConflictColumns returns the columns which, together with the load id column, uniquely identify a row of the table.
+// These are the dedup keys if configured, and the partition key of the table otherwise.
+func (pg *Postgres) loadIDConflictColumns(tableName string) []string {
+	if dedupKeys := pg.DedupKeys[tableName]; len(dedupKeys) > 0 {
+		return dedupKeys
+	}
+	column, ok := partitionKeyMap[tableName]
+	if !ok {
+		return []string{"id"}
+	}
+
+	var columns []string
+	for _, c := range strings.Split(column, ",") {
+		columns = append(columns, strings.TrimSpace(c))
+	}
+	return columns
+}
+
+// coalesceUpserts returns whether the loads of the table upsert its rows, as configured with CoalesceUpsertTables,
+// keeping the values of the columns the new rows have no value for instead of replacing the rows.
+// The users, identifies and discards tables are always replaced.
+func (pg *Postgres) coalesceUpserts(tableName string) bool {
+	switch tableName {
+	case warehouseutils.UsersTable, warehouseutils.IdentifiesTable, warehouseutils.DiscardsTable:
+		return false
+	}
+	return slices.Contains(pg.CoalesceUpsertTables, tableName)
+}
+
+// createUpsertIndex creates the unique index on the conflict columns, which is required by ON CONFLICT for the upserts.
+// Creating it fails if the table already has rows with the same conflict columns, e.g. loaded before opting in.
+func (pg *Postgres) createUpsertIndex(ctx context.Context, tableName string) error {
+	sqlStatement := fmt.Sprintf(`CREATE UNIQUE INDEX IF NOT EXISTS %q ON %q.%q (%s)`,
+		indexName(pg.tableName(tableName), "upsert_key"),
+		pg.Namespace,
+		pg.tableName(tableName),
+		warehouseutils.DoubleQuoteAndJoinByComma(pg.loadIDConflictColumns(tableName)),
+	)
+	pg.logger.Infof("PG: Creating upsert index in postgres for PG:%s : %v", pg.Warehouse.Destination.ID, sqlStatement)
+	if err := pg.execDDL(ctx, sqlStatement); err != nil {
+		return fmt.Errorf("creating upsert index for table %s: %w", tableName, err)
+	}
+	return nil
+}
+
+// createLoadIDIndex creates the unique index on the conflict columns and the load id column, which is required by ON CONFLICT.
+func (pg *Postgres) createLoadIDIndex(ctx context.Context, tableName string) error {
+	sqlStatement := fmt.Sprintf(`CREATE UNIQUE INDEX IF NOT EXISTS %q ON %q.%q (%s)`,
+		indexName(pg.tableName(tableName), loadIDColumn),
+		pg.Namespace,
+		pg.tableName(tableName),
+		warehouseutils.DoubleQuoteAndJoinByComma(append(slices.Clone(pg.loadIDConflictColumns(tableName)), loadIDColumn)),
+	)
+	pg.logger.Infof("PG: Creating load id index in postgres for PG:%s : %v", pg.Warehouse.Destination.ID, sqlStatement)
+	if err := pg.execDDL(ctx, sqlStatement); err != nil {
+		return fmt.Errorf("creating load id index for table %s: %w", tableName, err)
+	}
+	return nil
+}
+
+// addLoadIDColumn adds the load id column to the table along with its index, for the tables created before EnableLoadIDColumn was set.
+func (pg *Postgres) addLoadIDColumn(ctx context.Context, tableName string) error {
+	pg.logger.Infof("PG: Adding load id column %s to table:%s for PG:%s", loadIDColumn, tableName, pg.Warehouse.Destination.ID)
+	if err := pg.AddColumns(ctx, tableName, []warehouseutils.ColumnInfo{{Name: loadIDColumn, Type: "string"}}); err != nil {
+		return fmt.Errorf("adding load id column to table %s: %w", tableName, err)
+	}
+	return pg.createLoadIDIndex(ctx, tableName)
+}
+
+// loadTableFromFiles loads the gzipped csv files into the table using a staging table for deduplication.
+// If a load id is given, the inserted rows are stamped with it, the load id column being added to the table if missing.
+// The rows inserted by a previous attempt of the same load are neither deleted nor inserted again, so that retrying a partially committed load is idempotent.
+func (pg *Postgres) loadTableFromFiles(ctx context.Context, tableName string, tableSchemaInUpload model.TableSchema, fileNames []string, loadID string, skipTempTableDelete, keepNewerRows bool) (stagingTableName string, loadStats LoadStats, err error) {
+	if err = pg.setSearchPath(ctx); err != nil {
+		return
+	}
+	pg.logger.Infof("PG: Starting load for table:%s", tableName)
+
+	// tags
+	tags := stats.Tags{
+		"workspaceId":   pg.Warehouse.WorkspaceID,
+		"namepsace":     pg.Namespace,
+		"destinationID": pg.Warehouse.Destination.ID,
+		"tableName":     tableName,
+		// the object storage of the load files, to tell its latency apart from the one of the load itself
+		"storageProvider": pg.ObjectStorage,
+		"region":          pg.storageRegion(),
+		connectionModeKey: pg.connectionMode(),
+	}
+	// sort column names
+	sortedColumnKeys := warehouseutils.SortColumnKeysFromColumnMap(tableSchemaInUpload)
+	// the columns of the load files are sorted by their names in the upload, which folding them must not reorder
+	if pg.LowercaseIdentifiers {
+		if tableSchemaInUpload, err = pg.foldTableSchema(tableName, tableSchemaInUpload); err != nil {
+			return
+		}
+		for i, column := range sortedColumnKeys {
+			sortedColumnKeys[i] = pg.foldIdentifier(column)
+		}
+	}
+	// the columns of the load files mapped to other columns of the table are loaded into these, keeping their positions
+	if tableSchemaInUpload, sortedColumnKeys, err = pg.mapColumns(tableName, tableSchemaInUpload, sortedColumnKeys); err != nil {
+		return
+	}
+
+	// values of the columns not present in the table are routed to the discards table
+	var (
+		copyColumnKeys = sortedColumnKeys
+		unknownColumns map[int]string
+		discards       [][]interface{}
+	)
+	if pg.RouteUnknownColumnsToDiscards && tableName != warehouseutils.DiscardsTable {
+		copyColumnKeys, unknownColumns = splitUnknownColumns(sortedColumnKeys, pg.tableSchemaInWarehouse(tableName))
+	}
+
+	// positions of the json columns in the load files, whose values are validated before copying them
+	jsonColumns := make(map[int]bool)
+	if pg.JSONValidation == jsonValidationFail || pg.JSONValidation == jsonValidationDiscard {
+		for i, column := range sortedColumnKeys {
+			if tableSchemaInUpload[column] == "json" {
+				jsonColumns[i] = true
+			}
+		}
+	}
+
+	// trim policies of the columns in the load files, by position
+	trimPolicies := make(map[int]string)
+	for i, column := range sortedColumnKeys {
+		if trimPolicy, ok := pg.TrimColumns[tableName][column]; ok {
+			trimPolicies[i] = trimPolicy
+		}
+	}
+
+	// positions of the datetime columns in the load files, whose out of range values are clamped or discarded
+	datetimeColumns := make(map[int]bool)
+	if pg.TimestampOverflow == timestampOverflowClamp || pg.TimestampOverflow == timestampOverflowDiscard {
+		for i, column := range sortedColumnKeys {
+			if tableSchemaInUpload[column] == "datetime" {
+				datetimeColumns[i] = true
+			}
+		}
+	}
+
+	// positions of the float columns in the load files, whose special values are nulled or discarded
+	floatColumns := make(map[int]bool)
+	if pg.FloatSpecialValues == floatSpecialValuesNull || pg.FloatSpecialValues == floatSpecialValuesDiscard {
+		for i, column := range sortedColumnKeys {
+			if tableSchemaInUpload[column] == "float" {
+				floatColumns[i] = true
+			}
+		}
+	}
+
+	// positions of the indexed text columns in the load files, whose values longer than IndexedTextMaxBytes are truncated, hashed or discarded
+	indexedTextColumns := make(map[int]bool)
+	if pg.LongIndexedText == longIndexedTextTruncate || pg.LongIndexedText == longIndexedTextHash || pg.LongIndexedText == longIndexedTextDiscard {
+		indexColumns := pg.indexColumns(tableName, tableSchemaInUpload)
+		for i, column := range sortedColumnKeys {
+			if dataType := tableSchemaInUpload[column]; (dataType == "string" || dataType == "text") && slices.Contains(indexColumns, column) {
+				indexedTextColumns[i] = true
+			}
+		}
+	}
+
+	// the rows over-split by unquoted delimiters in the trailing column are rejoined, if it is configured for rejoining
+	fieldsPerRecord := len(sortedColumnKeys)
+	var rejoinColumn string
+	if rejoinColumns := pg.RejoinTrailingColumns[tableName]; len(rejoinColumns) > 0 && len(sortedColumnKeys) > 0 {
+		if trailingColumn := sortedColumnKeys[len(sortedColumnKeys)-1]; slices.Contains(rejoinColumns, trailingColumn) {
+			rejoinColumn, fieldsPerRecord = trailingColumn, -1
+		} else {
+			pg.logger.Warnf("PG: Not rejoining columns %v of table:%s, as only the trailing column %s of the load files can be rejoined", rejoinColumns, tableName, trailingColumn)
+		}
+	}
+	var rowsRejoined int
+
+	// the rows with a wrong number of columns are skipped instead of failing the read, with the values postgres would reject
+	var (
+		typedColumns = make(map[int]string)
+		badRows      = make(map[string]int)
+	)
+	if pg.MaxBadRows > 0 {
+		fieldsPerRecord = -1
+		for i, column := range sortedColumnKeys {
+			if _, ok := unknownColumns[i]; ok {
+				continue
+			}
+			switch dataType := tableSchemaInUpload[column]; dataType {
+			case "int", "float", "boolean":
+				typedColumns[i] = dataType
+			}
+		}
+	}
+
+	orderColumn, err := pg.dedupOrderColumn(tableName, pg.tableSchemaInWarehouse(tableName))
+	if err != nil {
+		return
+	}
+	tieBreakColumn, err := pg.dedupTieBreakColumn(tableName, pg.tableSchemaInWarehouse(tableName))
+	if err != nil {
+		return
+	}
+	orderColumns := []string{orderColumn}
+	if tieBreakColumn != "" {
+		orderColumns = append(orderColumns, tieBreakColumn)
+	}
+
+	if err = pg.resolveDialect(ctx); err != nil {
+		return
+	}
+	if pg.CreateMissingTables {
+		if err = pg.createMissingTable(ctx, tableName, tableSchemaInUpload); err != nil {
+			return
+		}
+	}
+	// the load id column and its index are created by CreateTable, and here for the tables created before opting in
+	if _, ok := pg.tableSchemaInWarehouse(tableName)[loadIDColumn]; loadID != "" && !ok {
+		if err = pg.addLoadIDColumn(ctx, tableName); err != nil {
+			return
+		}
+	}
+	// the index is created by CreateTable, and here for the tables created before opting in
+	coalesceUpsert := pg.coalesceUpserts(tableName)
+	if coalesceUpsert {
+		if err = pg.createUpsertIndex(ctx, tableName); err != nil {
+			return
+		}
+	}
+
+	txn, err := pg.beginTx(ctx)
+	if err != nil {
+		pg.logger.Errorf("PG: Error while beginning a transaction in db for loading in table:%s: %v", tableName, err)
+		return
+	}
+	if err = pg.setLocalResourceLimits(ctx, txn); err != nil {
+		pg.logger.Errorf("PG: Error setting resource limits for table:%s: %v\n", tableName, err)
+		tags["stage"] = setResourceLimits
+		pg.runRollbackWithTimeout(txn.Rollback, pg.handleRollbackTimeout, pg.TxnRollbackTimeout, tags)
+		return
+	}
+	if err = pg.setLocalTimeZone(ctx, txn); err != nil {
+		pg.logger.Errorf("PG: Error setting time zone for table:%s: %v\n", tableName, err)
+		tags["stage"] = setTimeZone
+		pg.runRollbackWithTimeout(txn.Rollback, pg.handleRollbackTimeout, pg.TxnRollbackTimeout, tags)
+		return
+	}
+	if err = pg.setLocalSearchPath(ctx, txn); err != nil {
+		pg.logger.Errorf("PG: Error setting search path for table:%s: %v\n", tableName, err)
+		tags["stage"] = setSearchPath
+		pg.runRollbackWithTimeout(txn.Rollback, pg.handleRollbackTimeout, pg.TxnRollbackTimeout, tags)
+		return
+	}
+	// the table may have columns the upload does not mention, but it must have the ones loaded into and deduplicated on
+	loadColumns := append(append(slices.Clone(copyColumnKeys), pg.loadIDConflictColumns(tableName)...), orderColumns...)
+	if err = pg.verifyColumns(ctx, txn, tableName, loadColumns); err != nil {
+		pg.logger.Errorf("PG: Error verifying the columns of table:%s: %v\n", tableName, err)
+		tags["stage"] = verifyColumns
+		pg.runRollbackWithTimeout(txn.Rollback, pg.handleRollbackTimeout, pg.TxnRollbackTimeout, tags)
+		return
+	}
+	targetTableName := pg.tableName(tableName)
+	// the generated columns are computed by the database, so their values in the load files are not inserted
+	generatedColumns, err := pg.generatedColumns(ctx, txn, targetTableName)
+	if err != nil {
+		pg.logger.Errorf("PG: Error getting the generated columns of table:%s: %v\n", tableName, err)
+		tags["stage"] = excludeGeneratedColumns
+		pg.runRollbackWithTimeout(txn.Rollback, pg.handleRollbackTimeout, pg.TxnRollbackTimeout, tags)
+		return
+	}
+	insertColumnKeys := copyColumnKeys
+	if len(generatedColumns) > 0 {
+		insertColumnKeys = nil
+		for _, column := range copyColumnKeys {
+			if !slices.Contains(generatedColumns, column) {
+				insertColumnKeys = append(insertColumnKeys, column)
+			}
+		}
+	}
+	// the loads into empty tables are copied directly into them with FREEZE, unless the staging table is kept for further use
+	// or values are loaded for generated columns, which can't be copied into, or the rows are upserted or stamped with the load id,
+	// as duplicates violate the upsert and load id indexes
+	var freeze bool
+	if pg.FreezeInitialLoads && !skipTempTableDelete && !coalesceUpsert && loadID == "" && pg.isVanillaPostgres() && len(insertColumnKeys) == len(copyColumnKeys) {
+		if freeze, err = pg.truncateForFreeze(ctx, txn, targetTableName); err != nil {
+			pg.logger.Errorf("PG: Error truncating table:%s for COPY FREEZE: %v\n", tableName, err)
+			tags["stage"] = truncateForFreeze
+			pg.runRollbackWithTimeout(txn.Rollback, pg.handleRollbackTimeout, pg.TxnRollbackTimeout, tags)
+			return
+		}
+	}
+
+	var copyStatement string
+	if freeze {
+		pg.logger.Infof("PG: Loading empty table:%s with COPY FREEZE", tableName)
+		copyStatement = pq.CopyInSchema(pg.Namespace, targetTableName, copyColumnKeys...) + ` WITH (FREEZE)`
+	} else {
+		// create temporary table
+		stagingTableName = pg.newTrackedStagingTableName(tableName, targetTableName)
+		sqlStatement := pg.createStagingTableStatement(stagingTableName, targetTableName, pg.stagingTableSchema(tableName, tableSchemaInUpload, loadColumns))
+		pg.logger.Debugf("PG: Creating temporary table for table:%s at %s\n", tableName, sqlStatement)
+		_, err = txn.ExecContext(ctx, sqlStatement)
+		if err != nil {
+			pg.logger.Errorf("PG: Error creating temporary table for table:%s: %v\n", tableName, err)
+			tags["stage"] = createStagingTable
+			pg.runRollbackWithTimeout(txn.Rollback, pg.handleRollbackTimeout, pg.TxnRollbackTimeout, tags)
+			return
+		}
+		pg.countStagingTable(stagingTableCreated, tableName)
+		if !skipTempTableDelete {
+			defer pg.dropStagingTable(ctx, tableName, stagingTableName)
+		}
+		if err = pg.commentStagingTable(ctx, txn, stagingTableName); err != nil {
+			pg.logger.Errorf("PG: Error commenting temporary table for table:%s: %v\n", tableName, err)
+			tags["stage"] = commentStagingTable
+			pg.runRollbackWithTimeout(txn.Rollback, pg.handleRollbackTimeout, pg.TxnRollbackTimeout, tags)
+			return
+		}
+		if sqlStatement := pg.excludeGeneratedColumnsStatement(stagingTableName, generatedColumns, copyColumnKeys); sqlStatement != "" {
+			pg.logger.Debugf("PG: Excluding generated columns from temporary table for table:%s at %s\n", tableName, sqlStatement)
+			if _, err = txn.ExecContext(ctx, sqlStatement); err != nil {
+				pg.logger.Errorf("PG: Error excluding generated columns from temporary table for table:%s: %v\n", tableName, err)
+				tags["stage"] = excludeGeneratedColumns
+				pg.runRollbackWithTimeout(txn.Rollback, pg.handleRollbackTimeout, pg.TxnRollbackTimeout, tags)
+				return
+			}
+		}
+		copyStatement = pq.CopyInSchema(pg.Namespace, stagingTableName, copyColumnKeys...)
+	}
+
+	stmt, err := txn.PrepareContext(ctx, copyStatement)
+	if err != nil {
+		pg.logger.Errorf("PG: Error while preparing statement for  transaction in db for loading in staging table:%s: %v\nstmt: %v", stagingTableName, err, stmt)
+		tags["stage"] = copyInSchemaStagingTable
+		pg.runRollbackWithTimeout(txn.Rollback, pg.handleRollbackTimeout, pg.TxnRollbackTimeout, tags)
+		return
+	}
+	var rowsProcessed int64
+	progress := pg.newLoadProgress(tableName, len(fileNames))
+	var filesRead int
+	for _, fileGroup := range pg.loadFileGroups(fileNames) {
+		var loadFiles *loadFilesReader
+		loadFiles, err = newLoadFilesReader(fileGroup, pg.LoadFilesHaveHeader)
+		if err != nil {
+			var pathErr *os.PathError
+			if errors.As(err, &pathErr) {
+				pg.logger.Errorf("PG: Error opening file using os.Open for file:%s while loading to table %s", fileGroup[0], tableName)
+				tags["stage"] = openLoadFiles
+			} else {
+				pg.logger.Errorf("PG: Error reading file using gzip.NewReader for file:%s while loading to table %s", fileGroup[0], tableName)
+				tags["stage"] = readGzipLoadFiles
+			}
+			pg.runRollbackWithTimeout(txn.Rollback, pg.handleRollbackTimeout, pg.TxnRollbackTimeout, tags)
+			return
+		}
+		csvReader := newLoadFileReader(loadFiles, pg.NullMode, fieldsPerRecord, pg.MaxFieldSize)
+		var (
+			csvRowsProcessedCount int
+			csvFileIndex          int
+		)
+		for {
+			var (
+				record []string
+				quoted []bool
+			)
+			record, quoted, err = csvReader.Read()
+			// the rows processed are counted per file, which only changes within a group of merged files
+			if loadFiles.fileIndex() != csvFileIndex {
+				csvRowsProcessedCount, csvFileIndex = 0, loadFiles.fileIndex()
+			}
+			fileIndex, objectFileName := filesRead+csvFileIndex, loadFiles.fileName()
+			if err != nil {
+				if err == io.EOF {
+					pg.logger.Debugf("PG: File reading completed while reading csv file for loading in staging table:%s: %s", stagingTableName, objectFileName)
+					break
+				}
+				pg.logger.Errorf("PG: Error while reading csv file %s for loading in staging table:%s: %v", objectFileName, stagingTableName, err)
+				_ = loadFiles.Close()
+				tags["stage"] = readCsvLoadFiles
+				if errors.Is(err, csv.ErrFieldCount) {
+					tags["stage"] = csvColumnCountMismatch
+				}
+				pg.runRollbackWithTimeout(txn.Rollback, pg.handleRollbackTimeout, pg.TxnRollbackTimeout, tags)
+				return
+			}
+			if rejoinColumn != "" && len(record) > len(sortedColumnKeys) {
+				pg.logger.Debugf("PG: Rejoining %d fields over-split in column %s of row %d of file %s for table:%s", len(record)-len(sortedColumnKeys)+1, rejoinColumn, csvRowsProcessedCount+1, objectFileName, tableName)
+				record, quoted = rejoinTrailingFields(record, quoted, len(sortedColumnKeys))
+				rowsRejoined++
+			}
+			if pg.MaxBadRows > 0 {
+				if reason, discard := pg.badRow(tableName, sortedColumnKeys, typedColumns, trimPolicies, record, quoted); reason != "" {
+					pg.logger.Debugf("PG: Skipping malformed row %d of file %s for table:%s: %s", csvRowsProcessedCount+1, objectFileName, tableName, reason)
+					discards = append(discards, discard)
+					badRows[reason]++
+					if badRowCount := badRows[badRowColumnCountMismatch] + badRows[badRowInvalidValue]; badRowCount > pg.MaxBadRows {
+						err = &TooManyBadRowsError{TableName: tableName, MaxBadRows: pg.MaxBadRows}
+						pg.logger.Errorf("PG: Error skipping malformed rows for loading in staging table:%s: %v", stagingTableName, err)
+						_ = loadFiles.Close()
+						pg.countSkippedRows(tableName, badRows)
+						tags["stage"] = skipBadRows
+						pg.runRollbackWithTimeout(txn.Rollback, pg.handleRollbackTimeout, pg.TxnRollbackTimeout, tags)
+						return
+					}
+					continue
+				}
+			}
+			if len(sortedColumnKeys) != len(record) {
+				err = fmt.Errorf(`load file CSV columns for a row mismatch number found in upload schema. Columns in CSV row: %d, Columns in upload schema of table-%s: %d. Processed rows in csv file until mismatch: %d`, len(record), tableName, len(sortedColumnKeys), csvRowsProcessedCount)
+				pg.logger.Error(err)
+				tags["stage"] = csvColumnCountMismatch
+				pg.runRollbackWithTimeout(txn.Rollback, pg.handleRollbackTimeout, pg.TxnRollbackTimeout, tags)
+				return
+			}
+			var recordInterface []interface{}
+			for i, value := range record {
+				if columnName, ok := unknownColumns[i]; ok {
+					if discardValue := pg.loadValue(value, quoted[i], trimPolicies[i]); discardValue != nil {
+						discards = append(discards, discardRecord(tableName, sortedColumnKeys, record, columnName, discardValue))
+					}
+					continue
+				}
+				loadValue := pg.loadValue(value, quoted[i], trimPolicies[i])
+				if jsonColumns[i] && loadValue != nil && !json.Valid([]byte(value)) {
+					if pg.JSONValidation == jsonValidationFail {
+						err = &InvalidJSONError{TableName: tableName, ColumnName: sortedColumnKeys[i], FileName: objectFileName, Row: csvRowsProcessedCount + 1}
+						pg.logger.Errorf("PG: Error validating json for loading in staging table:%s: %v", stagingTableName, err)
+						_ = loadFiles.Close()
+						tags["stage"] = validateJSON
+						pg.runRollbackWithTimeout(txn.Rollback, pg.handleRollbackTimeout, pg.TxnRollbackTimeout, tags)
+						return
+					}
+					discards = append(discards, discardRecord(tableName, sortedColumnKeys, record, sortedColumnKeys[i], loadValue))
+					loadValue = nil
+				}
+				if datetimeColumns[i] && loadValue != nil {
+					if bound, overflow := timestampOverflow(value); overflow {
+						if pg.TimestampOverflow == timestampOverflowClamp {
+							loadValue = bound
+						} else {
+							discards = append(discards, discardRecord(tableName, sortedColumnKeys, record, sortedColumnKeys[i], loadValue))
+							loadValue = nil
+						}
+					}
+				}
+				if floatColumns[i] && loadValue != nil && isFloatSpecialValue(value) {
+					if pg.FloatSpecialValues == floatSpecialValuesDiscard {
+						discards = append(discards, discardRecord(tableName, sortedColumnKeys, record, sortedColumnKeys[i], loadValue))
+					}
+					loadValue = nil
+				}
+				// the value copied, e.g. trimmed by the trim policy of the column, is the one indexed
+				if text, ok := loadValue.(string); ok && indexedTextColumns[i] && len(text) > pg.IndexedTextMaxBytes {
+					switch pg.LongIndexedText {
+					case longIndexedTextTruncate:
+						loadValue = truncateUTF8(text, pg.IndexedTextMaxBytes)
+					case longIndexedTextHash:
+						sum := sha256.Sum256([]byte(text))
+						loadValue = hex.EncodeToString(sum[:])
+					default:
+						discards = append(discards, discardRecord(tableName, sortedColumnKeys, record, sortedColumnKeys[i], loadValue))
+						loadValue = nil
+					}
+				}
+				recordInterface = append(recordInterface, loadValue)
+			}
+			_, err = stmt.ExecContext(ctx, recordInterface...)
+			if err != nil {
+				pg.logger.Errorf("PG: Error in exec statement for loading in staging table:%s: %v", stagingTableName, err)
+				tags["stage"] = loadStagingTable
+				pg.runRollbackWithTimeout(txn.Rollback, pg.handleRollbackTimeout, pg.TxnRollbackTimeout, tags)
+				return
+			}
+			csvRowsProcessedCount++
+			rowsProcessed++
+			progress.rowProcessed(fileIndex)
+		}
+		_ = loadFiles.Close()
+		filesRead += len(fileGroup)
+	}
+
+	if len(badRows) > 0 {
+		pg.logger.Warnf("PG: Skipped malformed rows for table:%s, routing them to the discards table: %v", tableName, badRows)
+		pg.countSkippedRows(tableName, badRows)
+	}
+	if rowsRejoined > 0 {
+		pg.logger.Warnf("PG: Rejoined the over-split column %s of %d rows for table:%s, as it contained unquoted delimiters", rejoinColumn, rowsRejoined, tableName)
+	}
+
+	_, err = stmt.ExecContext(ctx)
+	if err != nil {
+		pg.logger.Errorf("PG: Rollback transaction as there was error while loading staging table:%s: %v", stagingTableName, err)
+		tags["stage"] = stagingTableloadStage
+		pg.runRollbackWithTimeout(txn.Rollback, pg.handleRollbackTimeout, pg.TxnRollbackTimeout, tags)
+		return
+
+	}
+	// the staging table is sampled if deduplicating it fails, which aborts the transaction, so it is rolled back to a savepoint first
+	if pg.StagingTableSampleRows > 0 && stagingTableName != "" {
+		if _, err = txn.ExecContext(ctx, `SAVEPOINT `+stagingTableSampleSavepoint); err != nil {
+			pg.logger.Errorf("PG: Error taking savepoint for sampling staging table:%s: %v", stagingTableName, err)
+			tags["stage"] = takeSampleSavepoint
+			pg.runRollbackWithTimeout(txn.Rollback, pg.handleRollbackTimeout, pg.TxnRollbackTimeout, tags)
+			return
+		}
+	}
+
+	// deduplication process
+	primaryKey := "id"
+	if column, ok := primaryKeyMap[tableName]; ok {
+		primaryKey = column
+	}
+	partitionKey := "id"
+	if column, ok := partitionKeyMap[tableName]; ok {
+		partitionKey = column
+	}
+	var additionalJoinClause string
+	if tableName == warehouseutils.DiscardsTable {
+		additionalJoinClause = fmt.Sprintf(`AND _source.%[3]s = "%[1]s"."%[2]s"."%[3]s" AND _source.%[4]s = "%[1]s"."%[2]s"."%[4]s"`, pg.Namespace, targetTableName, "table_name", "column_name")
+	}
+	// configured dedup keys take precedence over the defaults
+	if dedupKeys := pg.DedupKeys[tableName]; len(dedupKeys) > 0 {
+		primaryKey = dedupKeys[0]
+		partitionKey = warehouseutils.DoubleQuoteAndJoinByComma(dedupKeys)
+
+		additionalJoinClause = ""
+		for _, dedupKey := range dedupKeys[1:] {
+			additionalJoinClause += fmt.Sprintf(` AND _source.%[3]s = "%[1]s"."%[2]s"."%[3]s"`, pg.Namespace, targetTableName, dedupKey)
+		}
+	}
+	var rowsInserted int64
+	if freeze {
+		sqlStatement := pg.dedupInPlaceStatement(targetTableName, partitionKey, orderColumns)
+		pg.logger.Infof("PG: Deduplicate records copied into table:%s: %s\n", tableName, sqlStatement)
+		var result sql.Result
+		result, err = pg.execWithResult(ctx, &QueryParams{
+			txn:                 txn,
+			query:               sqlStatement,
+			enableWithQueryPlan: pg.EnableSQLStatementExecutionPlan || slices.Contains(pg.EnableSQLStatementExecutionPlanWorkspaceIDs, pg.Warehouse.WorkspaceID),
+		})
+		var rowsDeleted int64
+		if err == nil {
+			rowsDeleted, err = result.RowsAffected()
+		}
+		if err != nil {
+			pg.logger.Errorf("PG: Error deduplicating records copied into table:%s: %v\n", tableName, err)
+			tags["stage"] = deleteDedup
+			pg.runRollbackWithTimeout(txn.Rollback, pg.handleRollbackTimeout, pg.TxnRollbackTimeout, tags)
+			return
+		}
+		rowsInserted = rowsProcessed - rowsDeleted
+	} else {
+		// there is nothing to delete from empty tables, which is only checked for if configured as it costs a query for the other loads
+		var targetEmpty bool
+		if pg.SkipDedupForEmptyTables && !coalesceUpsert {
+			if targetEmpty, err = pg.isTableEmpty(ctx, txn, targetTableName); err != nil {
+				pg.logger.Errorf("PG: Error checking whether table:%s is empty: %v\n", tableName, err)
+				tags["stage"] = deleteDedup
+				pg.sampleStagingTable(ctx, txn, tableName, stagingTableName)
+				pg.runRollbackWithTimeout(txn.Rollback, pg.handleRollbackTimeout, pg.TxnRollbackTimeout, tags)
+				return
+			}
+		}
+		switch {
+		case coalesceUpsert:
+			pg.logger.Infof("PG: Skipping deleting the records to deduplicate from table:%s, as they are upserted\n", tableName)
+		case targetEmpty:
+			pg.logger.Infof("PG: Skipping deleting the records to deduplicate from empty table:%s\n", tableName)
+		default:
+			// for tables partitioned by received_at, the delete is constrained to the partitions of the staged records
+			var receivedAtRangeClause string
+			receivedAtRangeClause, err = pg.receivedAtRangeClause(ctx, txn, targetTableName, stagingTableName)
+			if err != nil {
+				pg.logger.Errorf("PG: Error getting received_at range for partitioned table:%s: %v\n", tableName, err)
+				tags["stage"] = deleteDedup
+				pg.sampleStagingTable(ctx, txn, tableName, stagingTableName)
+				pg.runRollbackWithTimeout(txn.Rollback, pg.handleRollbackTimeout, pg.TxnRollbackTimeout, tags)
+				return
+			}
+			additionalJoinClause += receivedAtRangeClause
+			// the rows inserted by a previous attempt of the same load are kept, for the insert to skip the records they were inserted from
+			if loadID != "" {
+				additionalJoinClause += fmt.Sprintf(` AND "%[1]s"."%[2]s".%[3]q IS DISTINCT FROM %[4]s`, pg.Namespace, targetTableName, loadIDColumn, pq.QuoteLiteral(loadID))
+			}
+			// the rows newer than the staged records are kept, for the insert to skip the records instead
+			if keepNewerRows {
+				additionalJoinClause += ` AND NOT ` + newerRowCondition(fmt.Sprintf(`"%[1]s"."%[2]s"`, pg.Namespace, targetTableName), `_source`, orderColumns)
+			}
+			sqlStatement := fmt.Sprintf(`DELETE FROM "%[1]s"."%[2]s" USING "%[1]s"."%[3]s" as  _source where (_source.%[4]s = "%[1]s"."%[2]s"."%[4]s" %[5]s)`, pg.Namespace, targetTableName, stagingTableName, primaryKey, additionalJoinClause)
+			pg.logger.Infof("PG: Deduplicate records for table:%s using staging table: %s\n", tableName, sqlStatement)
+			err = pg.handleExecContext(ctx, &QueryParams{
+				txn:                 txn,
+				query:               sqlStatement,
+				enableWithQueryPlan: pg.EnableSQLStatementExecutionPlan || slices.Contains(pg.EnableSQLStatementExecutionPlanWorkspaceIDs, pg.Warehouse.WorkspaceID),
+				explainAnalyze:      pg.EnableSQLStatementExplainAnalyze,
+			})
+			if err != nil {
+				pg.logger.Errorf("PG: Error deleting from original table for dedup: %v\n", err)
+				tags["stage"] = deleteDedup
+				pg.sampleStagingTable(ctx, txn, tableName, stagingTableName)
+				pg.runRollbackWithTimeout(txn.Rollback, pg.handleRollbackTimeout, pg.TxnRollbackTimeout, tags)
+				return
+			}
+		}
+
+		quotedColumnNames := warehouseutils.DoubleQuoteAndJoinByComma(insertColumnKeys)
+		var upsertColumns []string
+		if coalesceUpsert {
+			upsertColumns = insertColumnKeys
+		}
+		sqlStatement := pg.dedupInsertStatement(targetTableName, stagingTableName, quotedColumnNames, partitionKey, orderColumns, loadID, pg.loadIDConflictColumns(tableName), upsertColumns, keepNewerRows)
+		pg.logger.Infof("PG: Inserting records for table:%s using staging table: %s\n", tableName, sqlStatement)
+		var result sql.Result
+		result, err = pg.execWithResult(ctx, &QueryParams{
+			txn:                 txn,
+			query:               sqlStatement,
+			enableWithQueryPlan: pg.EnableSQLStatementExecutionPlan || slices.Contains(pg.EnableSQLStatementExecutionPlanWorkspaceIDs, pg.Warehouse.WorkspaceID),
+			explainAnalyze:      pg.EnableSQLStatementExplainAnalyze,
+		})
+
+		if err != nil {
+			pg.logger.Errorf("PG: Error inserting into original table: %v\n", err)
+			tags["stage"] = insertDedup
+			pg.sampleStagingTable(ctx, txn, tableName, stagingTableName)
+			pg.runRollbackWithTimeout(txn.Rollback, pg.handleRollbackTimeout, pg.TxnRollbackTimeout, tags)
+			return
+		}
+		rowsInserted, err = result.RowsAffected()
+		if err != nil {
+			pg.logger.Errorf("PG: Error getting the rows inserted into original table: %v\n", err)
+			tags["stage"] = insertDedup
+			pg.sampleStagingTable(ctx, txn, tableName, stagingTableName)
+			pg.runRollbackWithTimeout(txn.Rollback, pg.handleRollbackTimeout, pg.TxnRollbackTimeout, tags)
+			return
+		}
+	}
+
+	if len(discards) > 0 {
+		if err = pg.copyIntoDiscards(ctx, txn, discards); err != nil {
+			pg.logger.Errorf("PG: Error loading discards for table:%s: %v\n", tableName, err)
+			tags["stage"] = loadDiscards
+			pg.runRollbackWithTimeout(txn.Rollback, pg.handleRollbackTimeout, pg.TxnRollbackTimeout, tags)
+			return
+		}
+	}
+
+	if err = txn.Commit(); err != nil {
+		pg.logger.Errorf("PG: Error while committing transaction as there was error while loading staging table:%s: %v", stagingTableName, err)
+		tags["stage"] = dedupStage
+		pg.runRollbackWithTimeout(txn.Rollback, pg.handleRollbackTimeout, pg.TxnRollbackTimeout, tags)
+		return
+	}
+	// copying the discards creates the discards table if needed
+	if len(discards) > 0 {
+		pg.invalidateSharedSchemaCache()
+	}
+
+	loadStats = LoadStats{
+		FilesProcessed:   len(fileNames),
+		RowsProcessed:    rowsProcessed,
+		RowsInserted:     rowsInserted,
+		RowsDeduplicated: rowsProcessed - rowsInserted,
+		RowsSkipped:      int64(badRows[badRowColumnCountMismatch] + badRows[badRowInvalidValue]),
+	}
+	pg.logger.Infof("PG: Complete load for table:%s", tableName)
+	return
+}
+
+// sampleStagingTable writes the first StagingTableSampleRows rows of the staging table, whose deduplication failed, to a csv file in the tmp directory.
+// Sampling is best-effort, its errors are only logged.
+func (pg *Postgres) sampleStagingTable(ctx context.Context, txn *sqlmiddleware.Tx, tableName, stagingTableName string) {
+	if pg.StagingTableSampleRows <= 0 || stagingTableName == "" {
+		return
+	}
+	fileName, err := pg.writeStagingTableSample(ctx, txn, tableName, stagingTableName)
+	if err != nil {
+		pg.logger.Warnf("PG: Error sampling staging table:%s of table:%s: %v", stagingTableName, tableName, err)
+		return
+	}
+	pg.logger.Warnf("PG: Sampled staging table:%s of table:%s into %s", stagingTableName, tableName, fileName)
+}
+
+// writeStagingTableSample rolls the transaction back to the savepoint taken before deduplicating and writes the sample of the staging table,
+// with its column names as header and the nulls as empty values, returning the name of the written file.
+func (pg *Postgres) writeStagingTableSample(ctx context.Context, txn *sqlmiddleware.Tx, tableName, stagingTableName string) (_ string, err error) {
+	if _, err := txn.ExecContext(ctx, `ROLLBACK TO SAVEPOINT `+stagingTableSampleSavepoint); err != nil {
+		return "", fmt.Errorf("rolling back to savepoint: %w", err)
+	}
+
+	limit := pg.StagingTableSampleRows
+	if limit > maxStagingTableSampleRows {
+		limit = maxStagingTableSampleRows
+	}
+	rows, err := txn.QueryContext(ctx, fmt.Sprintf(`SELECT * FROM %q.%q LIMIT %d;`, pg.Namespace, stagingTableName, limit))
+	if err != nil {
+		return "", fmt.Errorf("querying staging table: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return "", fmt.Errorf("getting columns: %w", err)
+	}
+
+	tmpDirPath, err := pg.tmpDirPath()
+	if err != nil {
+		return "", fmt.Errorf("getting tmp directory: %w", err)
+	}
+	f, err := os.CreateTemp(tmpDirPath, stagingTableName+"_sample_*.csv")
+	if err != nil {
+		return "", fmt.Errorf("creating sample file: %w", err)
+	}
+	defer func() {
+		if closeErr := f.Close(); err == nil && closeErr != nil {
+			err = fmt.Errorf("closing sample file: %w", closeErr)
+		}
+		if err != nil {
+			misc.RemoveFilePaths(f.Name())
+		}
+	}()
+
+	w := csv.NewWriter(f)
+	if err := w.Write(columns); err != nil {
+		return "", fmt.Errorf("writing sample file: %w", err)
+	}
+
+	values := make([]sql.NullString, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	record := make([]string, len(columns))
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return "", fmt.Errorf("scanning staging table: %w", err)
+		}
+		for i, value := range values {
+			record[i] = value.String
+			if value.Valid && pg.RedactStagingTableSample != nil {
+				record[i] = pg.RedactStagingTableSample(tableName, columns[i], value.String)
+			}
+		}
+		if err := w.Write(record); err != nil {
+			return "", fmt.Errorf("writing sample file: %w", err)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return "", fmt.Errorf("iterating staging table: %w", err)
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return "", fmt.Errorf("writing sample file: %w", err)
+	}
+	return f.Name(), nil
+}
+
+// loadFileGroups returns the groups of load files read as a single stream.
+// With MergeLoadFiles, all the files are merged into a single stream, saving the setup of a reader per file, which dominates the load of many tiny files.
+func (pg *Postgres) loadFileGroups(fileNames []string) [][]string {
+	if pg.MergeLoadFiles && len(fileNames) > 0 {
+		return [][]string{fileNames}
+	}
+	fileGroups := make([][]string, 0, len(fileNames))
+	for _, fileName := range fileNames {
+		fileGroups = append(fileGroups, []string{fileName})
+	}
+	return fileGroups
+}
+
+// LoadStats are the counts of a table load.
+type LoadStats struct {
+	// FilesProcessed is the number of load files copied into the staging table.
+	FilesProcessed int
+	// RowsProcessed is the number of rows read from the load files.
+	RowsProcessed int64
+	// RowsInserted is the number of rows inserted into the table after deduplication.
+	RowsInserted int64
+	// RowsDeduplicated is the number of rows not inserted, being superseded by another row with the same dedup keys or already loaded by the same load.
+	RowsDeduplicated int64
+	// RowsSkipped is the number of malformed rows skipped with MaxBadRows, which were routed to the discards table.
+	RowsSkipped int64
+}
+
+func (s LoadStats) add(other LoadStats) LoadStats {
+	return LoadStats{
+		FilesProcessed:   s.FilesProcessed + other.FilesProcessed,
+		RowsProcessed:    s.RowsProcessed + other.RowsProcessed,
+		RowsInserted:     s.RowsInserted + other.RowsInserted,
+		RowsDeduplicated: s.RowsDeduplicated + other.RowsDeduplicated,
+		RowsSkipped:      s.RowsSkipped + other.RowsSkipped,
+	}
+}
+
+// resolveDialect resolves the dialect of the destination, detecting it from its version with dialectAuto.
+func (pg *Postgres) resolveDialect(ctx context.Context) error {
+	pg.dialectMu.Lock()
+	defer pg.dialectMu.Unlock()
+
+	if pg.dialect != "" {
+		return nil
+	}
+	if pg.Dialect != dialectAuto {
+		pg.dialect = pg.Dialect
+		return nil
+	}
+
+	var version string
+	if err := pg.connectedDB().QueryRowContext(ctx, `SELECT version();`).Scan(&version); err != nil {
+		return fmt.Errorf("detecting dialect: %w", err)
+	}
+	pg.dialect = dialectOf(version)
+	pg.logger.Infof("PG: Detected dialect %s for PG:%s from version: %s", pg.dialect, pg.Warehouse.Destination.ID, version)
+	return nil
+}
+
+// isVanillaPostgres returns whether the destination is vanilla postgres rather than a compatible database.
+func (pg *Postgres) isVanillaPostgres() bool {
+	return pg.dialect == "" || pg.dialect == dialectPostgres
+}
+
+// dialectOf returns the dialect of the database with the version, as returned by SELECT version().
+func dialectOf(version string) string {
+	switch {
+	case strings.Contains(version, "CockroachDB"):
+		return dialectCockroachDB
+	case strings.Contains(version, "-YB-"):
+		return dialectYugabyteDB
+	default:
+		return dialectPostgres
+	}
+}
+
+// stagingTableSchema returns the schema of the staging table for compatible databases, which only has the columns of the load.
+// The data types are the ones of the table, falling back to the ones of the upload for the columns not in the warehouse schema.
+func (pg *Postgres) stagingTableSchema(tableName string, tableSchemaInUpload model.TableSchema, loadColumns []string) model.TableSchema {
+	tableSchemaInWarehouse := pg.tableSchemaInWarehouse(tableName)
+
+	stagingSchema := make(model.TableSchema, len(loadColumns))
+	for _, column := range loadColumns {
+		if dataType, ok := tableSchemaInWarehouse[column]; ok {
+			stagingSchema[column] = dataType
+		} else {
+			stagingSchema[column] = tableSchemaInUpload[column]
+		}
+	}
+	return stagingSchema
+}
+
+// createStagingTableStatement returns the statement creating the staging table of the table.
+// Vanilla postgres copies the definition of the table, while the compatible databases, whose LIKE differs, get the columns of the load
+// along with the stagingPositionColumn, numbering the records as they are copied in.
+func (pg *Postgres) createStagingTableStatement(stagingTableName, targetTableName string, stagingSchema model.TableSchema) string {
+	if pg.isVanillaPostgres() {
+		return fmt.Sprintf(`CREATE TABLE "%[1]s"."%[2]s" (LIKE "%[1]s"."%[3]s")`, pg.Namespace, stagingTableName, targetTableName)
+	}
+	return fmt.Sprintf(`CREATE TABLE "%[1]s"."%[2]s" ( %[3]s, %[4]q BIGSERIAL )`, pg.Namespace, stagingTableName, ColumnsWithDataTypes(stagingSchema, ""), stagingPositionColumn)
+}
+
+// generatedColumns returns the columns of the table whose values are always generated by the database,
+// i.e. the generated columns and the identity columns generated always, which can't be inserted into.
+func (pg *Postgres) generatedColumns(ctx context.Context, txn *sqlmiddleware.Tx, tableName string) ([]string, error) {
+	sqlStatement := `
+		SELECT
+		  column_name
+		FROM
+		  information_schema.columns
+		WHERE
+		  table_schema = $1
+		  AND table_name = $2
+		  AND (is_generated = 'ALWAYS' OR identity_generation = 'ALWAYS')
+		ORDER BY
+		  ordinal_position;
+	`
+	rows, err := txn.QueryContext(ctx, sqlStatement, pg.Namespace, tableName)
+	if err != nil {
+		return nil, fmt.Errorf("querying generated columns: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var columns []string
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			return nil, fmt.Errorf("scanning generated columns: %w", err)
+		}
+		columns = append(columns, column)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating generated columns: %w", err)
+	}
+	return columns, nil
+}
+
+// excludeGeneratedColumnsStatement returns the statement excluding the generated columns of the table from its staging table, if any.
+// LIKE copies them as plain columns keeping the not null constraint of the identity ones, so the ones not loaded are dropped,
+// while the loaded ones are made nullable, their values only being staged as they are never inserted.
+// The staging tables of the compatible databases only have the columns of the load, without constraints, and are left as they are.
+func (pg *Postgres) excludeGeneratedColumnsStatement(stagingTableName string, generatedColumns, copyColumns []string) string {
+	if len(generatedColumns) == 0 || !pg.isVanillaPostgres() {
+		return ""
+	}
+	actions := make([]string, 0, len(generatedColumns))
+	for _, column := range generatedColumns {
+		if slices.Contains(copyColumns, column) {
+			actions = append(actions, fmt.Sprintf(`ALTER COLUMN %q DROP NOT NULL`, column))
+		} else {
+			actions = append(actions, fmt.Sprintf(`DROP COLUMN %q`, column))
+		}
+	}
+	return fmt.Sprintf(`ALTER TABLE "%[1]s"."%[2]s" %[3]s`, pg.Namespace, stagingTableName, strings.Join(actions, ", "))
+}
+
+// loadProgress reports the progress of a table load every LoadProgressInterval rows, so that long-running loads can be followed.
+type loadProgress struct {
+	pg         *Postgres
+	tableName  string
+	totalFiles int
+	interval   int
+	rows       int
+
+	rowsGauge  stats.Measurement
+	filesGauge stats.Measurement
+}
+
+func (pg *Postgres) newLoadProgress(tableName string, totalFiles int) *loadProgress {
+	tags := stats.Tags{
+		"workspaceId":     pg.Warehouse.WorkspaceID,
+		"destinationID":   pg.Warehouse.Destination.ID,
+		"tableName":       tableName,
+		"storageProvider": pg.ObjectStorage,
+		"region":          pg.storageRegion(),
+		connectionModeKey: pg.connectionMode(),
+	}
+	return &loadProgress{
+		pg:         pg,
+		tableName:  tableName,
+		totalFiles: totalFiles,
+		interval:   pg.LoadProgressInterval,
+		rowsGauge:  pg.stats.NewTaggedStat("pg_load_progress", stats.GaugeType, tags),
+		filesGauge: pg.stats.NewTaggedStat("pg_load_progress_files", stats.GaugeType, tags),
+	}
+}
+
+func (p *loadProgress) rowProcessed(fileIndex int) {
+	p.rows++
+	if p.interval <= 0 || p.rows%p.interval != 0 {
+		return
+	}
+
+	p.rowsGauge.Gauge(p.rows)
+	p.filesGauge.Gauge(fileIndex + 1)
+	p.pg.logger.Infof("PG: Load progress for table:%s: %d rows processed, file %d of %d", p.tableName, p.rows, fileIndex+1, p.totalFiles)
+}
+
+// partitionKeyColumns returns the partition key columns of the table, which are empty if the table is not partitioned.
+func (pg *Postgres) partitionKeyColumns(ctx context.Context, txn *sqlmiddleware.Tx, tableName string) ([]string, error) {
+	sqlStatement := `
+		SELECT
+		  a.attname
+		FROM
+		  pg_partitioned_table pt
+		  JOIN pg_attribute a ON a.attrelid = pt.partrelid
+		  AND a.attnum = ANY(pt.partattrs :: int2[])
+		WHERE
+		  pt.partrelid = to_regclass($1);
+	`
+	rows, err := txn.QueryContext(ctx, sqlStatement, fmt.Sprintf(`%q.%q`, pg.Namespace, tableName))
+	if err != nil {
+		return nil, fmt.Errorf("querying partition key columns: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var columns []string
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			return nil, fmt.Errorf("scanning partition key columns: %w", err)
+		}
+		columns = append(columns, column)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating partition key columns: %w", err)
+	}
+	return columns, nil
+}
+
+// verifyColumns returns a MissingColumnsError if any of the columns does not exist in the table, and a TableNotFoundError if the table does not exist.
+func (pg *Postgres) verifyColumns(ctx context.Context, txn *sqlmiddleware.Tx, tableName string, columns []string) error {
+	sqlStatement := `
+		SELECT
+		  column_name
+		FROM
+		  information_schema.columns
+		WHERE
+		  table_schema = $1
+		  AND table_name = $2;
+	`
+	rows, err := txn.QueryContext(ctx, sqlStatement, pg.Namespace, pg.tableName(tableName))
+	if err != nil {
+		return fmt.Errorf("querying columns: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	tableColumns := make(map[string]bool)
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			return fmt.Errorf("scanning columns: %w", err)
+		}
+		tableColumns[column] = true
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("iterating columns: %w", err)
+	}
+	if len(tableColumns) == 0 {
+		return &TableNotFoundError{Namespace: pg.Namespace, TableName: tableName}
+	}
+
+	var missingColumns []string
+	for _, column := range columns {
+		if !tableColumns[column] && !slices.Contains(missingColumns, column) {
+			missingColumns = append(missingColumns, column)
+		}
+	}
+	if len(missingColumns) > 0 {
+		return &MissingColumnsError{TableName: tableName, ColumnNames: missingColumns}
+	}
+	return nil
+}
+
+// createMissingTable creates the table from the upload schema if it doesn't exist, e.g. because it got dropped outside of rudder.
+func (pg *Postgres) createMissingTable(ctx context.Context, tableName string, tableSchemaInUpload model.TableSchema) error {
+	var exists bool
+	err := pg.connectedDB().QueryRowContext(ctx, `SELECT to_regclass($1) IS NOT NULL;`,
+		fmt.Sprintf(`%q.%q`, pg.Namespace, pg.tableName(tableName)),
+	).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("checking table %s exists: %w", tableName, err)
+	}
+	if exists {
+		return nil
+	}
+
+	pg.logger.Warnf("PG: Table %s not found in namespace %s for PG:%s, creating it from the upload schema", tableName, pg.Namespace, pg.Warehouse.Destination.ID)
+	if err := pg.CreateTable(ctx, tableName, tableSchemaInUpload); err != nil {
+		return fmt.Errorf("creating missing table %s: %w", tableName, err)
+	}
+	pg.stats.NewTaggedStat("pg_missing_table_created", stats.CountType, stats.Tags{
+		"workspaceId":   pg.Warehouse.WorkspaceID,
+		"namespace":     pg.Namespace,
+		"destinationID": pg.Warehouse.Destination.ID,
+		"tableName":     tableName,
+	}).Count(1)
+	return nil
+}
+
+// receivedAtRangeClause returns the clause constraining the received_at of the table to the range of the staged records, if the table is partitioned by received_at.
+// The range is inlined as constants, so that the planner can prune the partitions outside it.
+func (pg *Postgres) receivedAtRangeClause(ctx context.Context, txn *sqlmiddleware.Tx, tableName, stagingTableName string) (string, error) {
+	partitionKeyColumns, err := pg.partitionKeyColumns(ctx, txn, tableName)
+	if err != nil {
+		return "", err
+	}
+	if !slices.Contains(partitionKeyColumns, "received_at") {
+		return "", nil
+	}
+
+	var minReceivedAt, maxReceivedAt sql.NullTime
+	sqlStatement := fmt.Sprintf(`SELECT min(received_at), max(received_at) FROM "%[1]s"."%[2]s";`, pg.Namespace, stagingTableName)
+	if err := txn.QueryRowContext(ctx, sqlStatement).Scan(&minReceivedAt, &maxReceivedAt); err != nil {
+		return "", fmt.Errorf("querying received_at range: %w", err)
+	}
+	if !minReceivedAt.Valid || !maxReceivedAt.Valid {
+		return "", nil
+	}
+
+	return fmt.Sprintf(` AND "%[1]s"."%[2]s"."received_at" BETWEEN '%[3]s' AND '%[4]s'`,
+		pg.Namespace,
+		tableName,
+		minReceivedAt.Time.UTC().Format(time.RFC3339Nano),
+		maxReceivedAt.Time.UTC().Format(time.RFC3339Nano),
+	), nil
+}
+
+// splitUnknownColumns splits the columns of the load files into the ones present in the table and the unknown ones, keyed by their position in the load files.
+// An empty schema is the one of a table the uploader has no schema for yet, e.g. on its first load or with LoadTableFromFiles,
+// whose columns are all loaded.
+func splitUnknownColumns(sortedColumnKeys []string, tableSchemaInWarehouse model.TableSchema) (knownColumns []string, unknownColumns map[int]string) {
+	unknownColumns = make(map[int]string)
+	if len(tableSchemaInWarehouse) == 0 {
+		return sortedColumnKeys, unknownColumns
+	}
+	for i, column := range sortedColumnKeys {
+		if _, ok := tableSchemaInWarehouse[column]; ok {
+			knownColumns = append(knownColumns, column)
+		} else {
+			unknownColumns[i] = column
+		}
+	}
+	return
+}
+
+// timestampOverflow returns the bound of the supported range the timestamp is beyond, if it is out of range.
+// Timestamps are out of range if postgres rejects their year, which is signed, zero or past the timestamptz range, e.g. -0001-01-01T00:00:00Z or 300000-01-01T00:00:00Z.
+// The dates before the common era spelled with a BC suffix are in range.
+func timestampOverflow(value string) (bound string, overflow bool) {
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(value, "-") {
+		return minTimestamp, true
+	}
+	if strings.HasPrefix(value, "+") {
+		return maxTimestamp, true
+	}
+
+	digits := 0
+	for digits < len(value) && value[digits] >= '0' && value[digits] <= '9' {
+		digits++
+	}
+	if digits == len(value) || value[digits] != '-' {
+		return "", false
+	}
+	year := strings.TrimLeft(value[:digits], "0")
+	if year == "" {
+		return minTimestamp, true
+	}
+	if len(year) > len(strconv.Itoa(maxYear)) {
+		return maxTimestamp, true
+	}
+	if y, _ := strconv.Atoi(year); y > maxYear {
+		return maxTimestamp, true
+	}
+	return "", false
+}
+
+// truncateUTF8 returns the longest prefix of the value of at most maxBytes bytes, not splitting any character.
+func truncateUTF8(value string, maxBytes int) string {
+	if len(value) <= maxBytes {
+		return value
+	}
+	// the cut falls within a character when the next byte is not the start of one
+	for maxBytes > 0 && !utf8.RuneStart(value[maxBytes]) {
+		maxBytes--
+	}
+	return value[:maxBytes]
+}
+
+// isFloatSpecialValue returns whether the value is one of the special values NaN, Infinity or -Infinity, spelled as postgres accepts them.
+func isFloatSpecialValue(value string) bool {
+	value = strings.TrimSpace(value)
+	if strings.EqualFold(value, "nan") {
+		return true
+	}
+	if strings.HasPrefix(value, "+") || strings.HasPrefix(value, "-") {
+		value = value[1:]
+	}
+	return strings.EqualFold(value, "infinity") || strings.EqualFold(value, "inf")
+}
+
+var discardsColumns = []string{"table_name", "row_id", "column_name", "column_value", "received_at", "uuid_ts"}
+
+// discardRecord returns the discards table record, in the order of discardsColumns, for the value of the column of the load file record.
+func discardRecord(tableName string, sortedColumnKeys, record []string, columnName string, value interface{}) []interface{} {
+	var rowID, receivedAt interface{}
+	if i := slices.Index(sortedColumnKeys, "id"); i != -1 && record[i] != "" {
+		rowID = record[i]
+	}
+	if i := slices.Index(sortedColumnKeys, "received_at"); i != -1 && record[i] != "" {
+		if _, overflow := timestampOverflow(record[i]); !overflow {
+			receivedAt = record[i]
+		}
+	}
+	return []interface{}{tableName, rowID, columnName, value, receivedAt, time.Now().UTC().Format(time.RFC3339)}
+}
+
+// badRow returns why the record of the load file is malformed, along with its discards table record, or an empty reason if it is not.
+// Records with a wrong number of columns are discarded as a whole, encoded as csv, without a column name.
+func (pg *Postgres) badRow(tableName string, sortedColumnKeys []string, typedColumns, trimPolicies map[int]string, record []string, quoted []bool) (string, []interface{}) {
+	if len(record) != len(sortedColumnKeys) {
+		var value strings.Builder
+		w := csv.NewWriter(&value)
+		_ = w.Write(record)
+		w.Flush()
+		return badRowColumnCountMismatch, []interface{}{tableName, nil, "", strings.TrimSuffix(value.String(), "\n"), nil, time.Now().UTC().Format(time.RFC3339)}
+	}
+	for i, value := range record {
+		dataType, ok := typedColumns[i]
+		if !ok {
+			continue
+		}
+		if loadValue, ok := pg.loadValue(value, quoted[i], trimPolicies[i]).(string); ok && !isValidValue(dataType, loadValue) {
+			return badRowInvalidValue, discardRecord(tableName, sortedColumnKeys, record, sortedColumnKeys[i], loadValue)
+		}
+	}
+	return "", nil
+}
+
+// isValidValue returns whether postgres accepts the value for a column of the rudder data type, which is either int, float or boolean.
+func isValidValue(dataType, value string) bool {
+	value = strings.TrimSpace(value)
+	switch dataType {
+	case "int":
+		_, err := strconv.ParseInt(value, 10, 64)
+		return err == nil
+	case "float":
+		// numeric has no limit on its range, but does not accept hexadecimal values
+		_, err := strconv.ParseFloat(value, 64)
+		return (err == nil || errors.Is(err, strconv.ErrRange)) && !strings.ContainsAny(value, "xX")
+	case "boolean":
+		return isValidBool(value)
+	}
+	return true
+}
+
+// isValidBool returns whether postgres accepts the value as a boolean: one of true, false, yes, no, on, off, 1 and 0, ignoring case,
+// or an unambiguous prefix of them.
+func isValidBool(value string) bool {
+	value = strings.ToLower(value)
+	switch value {
+	case "":
+		return false
+	case "1", "0", "on", "of", "off":
+		return true
+	}
+	for _, word := range []string{"true", "false", "yes", "no"} {
+		if strings.HasPrefix(word, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func (pg *Postgres) countSkippedRows(tableName string, badRows map[string]int) {
+	for reason, count := range badRows {
+		pg.stats.NewTaggedStat("pg_skipped_rows", stats.CountType, stats.Tags{
+			"workspaceId":   pg.Warehouse.WorkspaceID,
+			"namespace":     pg.Namespace,
+			"destinationID": pg.Warehouse.Destination.ID,
+			"tableName":     tableName,
+			"reason":        reason,
+		}).Count(count)
+	}
+}
+
+// copyIntoDiscards copies the discarded values into the discards table, creating it if needed.
+func (pg *Postgres) copyIntoDiscards(ctx context.Context, txn *sqlmiddleware.Tx, discards [][]interface{}) error {
+	discardsTableName := pg.tableName(warehouseutils.DiscardsTable)
+
+	sqlStatement := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS "%[1]s"."%[2]s" ( %[3]v )`, pg.Namespace, discardsTableName, ColumnsWithDataTypes(warehouseutils.DiscardsSchema, ""))
+	if _, err := txn.ExecContext(ctx, sqlStatement); err != nil {
+		return fmt.Errorf("creating discards table: %w", err)
+	}
+
+	stmt, err := txn.PrepareContext(ctx, pq.CopyInSchema(pg.Namespace, discardsTableName, discardsColumns...))
+	if err != nil {
+		return fmt.Errorf("preparing copy into discards table: %w", err)
+	}
+	defer func() { _ = stmt.Close() }()
+
+	for _, discard := range discards {
+		if _, err := stmt.ExecContext(ctx, discard...); err != nil {
+			return fmt.Errorf("copying into discards table: %w", err)
+		}
+	}
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		return fmt.Errorf("copying into discards table: %w", err)
+	}
+
+	pg.logger.Infof("PG: Loaded %d discards into table:%s", len(discards), discardsTableName)
+	return nil
+}
+
+// DeleteBy Need to create a structure with delete parameters instead of simply adding a long list of params
+func (pg *Postgres) DeleteBy(ctx context.Context, tableNames []string, params warehouseutils.DeleteByParams) (err error) {
+	pg.logger.Infof("PG: Cleaning up the following tables in postgres for PG:%s : %+v", tableNames, params)
+	predicate := deleteByPredicate
+	if pg.DeleteByLegacyNulls {
+		predicate = legacyDeleteByPredicate
+	}
+	for _, tb := range tableNames {
+		sqlStatement := fmt.Sprintf(`DELETE FROM "%[1]s"."%[2]s" WHERE %[3]s`,
+			pg.Namespace,
+			pg.tableName(tb),
+			predicate,
+		)
+		if pg.DeleteByChunkSize > 0 {
+			sqlStatement = fmt.Sprintf(`DELETE FROM "%[1]s"."%[2]s" WHERE ctid IN (
+		SELECT ctid FROM "%[1]s"."%[2]s" WHERE %[3]s
+		LIMIT $5)`,
+				pg.Namespace,
+				pg.tableName(tb),
+				predicate,
+			)
+		}
+		pg.logger.Infof("PG: Deleting rows in table in postgres for PG:%s", pg.Warehouse.Destination.ID)
+		pg.logger.Debugf("PG: Executing the statement  %v", sqlStatement)
+		if pg.EnableDeleteByJobs || slices.Contains(pg.EnableDeleteByJobsWorkspaceIDs, pg.Warehouse.WorkspaceID) {
+			if pg.DeleteByChunkSize > 0 {
+				err = pg.deleteInChunks(ctx, tb, sqlStatement, params)
+			} else {
+				_, err = pg.connectedDB().ExecContext(ctx, sqlStatement,
+					params.JobRunId,
+					params.TaskRunId,
+					params.SourceId,
+					params.StartTime)
+			}
+			if err != nil {
+				pg.logger.Errorf("Error %s", err)
+				return err
+			}
+		}
+
+	}
+	return nil
+}
+
+// deleteByPredicate selects the rows of previous job runs of the source, received before the start time.
+// The rows without a job or task run id are not of the current run either, so they are compared using IS DISTINCT FROM, which unlike <> is true for nulls.
+const deleteByPredicate = `
+		context_sources_job_run_id IS DISTINCT FROM $1 AND
+		context_sources_task_run_id IS DISTINCT FROM $2 AND
+		context_source_id = $3 AND
+		received_at < $4`
+
+// legacyDeleteByPredicate is deleteByPredicate comparing with <>, which keeps the rows without a job or task run id.
+const legacyDeleteByPredicate = `
+		context_sources_job_run_id <> $1 AND
+		context_sources_task_run_id <> $2 AND
+		context_source_id = $3 AND
+		received_at < $4`
+
+// deleteInChunks repeats the delete of at most DeleteByChunkSize rows until no rows remain.
+// Every chunk is committed on its own, which avoids locking many rows and writing a large WAL record at once.
+func (pg *Postgres) deleteInChunks(ctx context.Context, tableName, sqlStatement string, params warehouseutils.DeleteByParams) error {
+	for {
+		result, err := pg.connectedDB().ExecContext(ctx, sqlStatement,
+			params.JobRunId,
+			params.TaskRunId,
+			params.SourceId,
+			params.StartTime,
+			pg.DeleteByChunkSize,
+		)
+		if err != nil {
+			return err
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		pg.logger.Debugf("PG: Deleted %d rows in chunk from table:%s", rowsAffected, tableName)
+
+		if rowsAffected < int64(pg.DeleteByChunkSize) {
+			return nil
+		}
+	}
+}
+
+// hasUserIDs returns whether the identifies staging table has any record with a user id.
+func (pg *Postgres) hasUserIDs(ctx context.Context, identifyStagingTable string) (bool, error) {
+	var exists bool
+	err := pg.connectedDB().QueryRowContext(ctx, fmt.Sprintf(`SELECT EXISTS (SELECT 1 FROM "%[1]s"."%[2]s" WHERE user_id IS NOT NULL);`, pg.Namespace, identifyStagingTable)).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("checking user ids in identifies staging table: %w", err)
+	}
+	return exists, nil
+}
+
+func (pg *Postgres) loadUserTables(ctx context.Context) (errorMap map[string]error) {
+	errorMap = map[string]error{warehouseutils.IdentifiesTable: nil}
+	if err := pg.setSearchPath(ctx); err != nil {
+		errorMap[warehouseutils.IdentifiesTable] = err
+		return
+	}
+	pg.logger.Infof("PG: Starting load for identifies and users tables\n")
+	identifyStagingTable, _, err := pg.loadTable(ctx, warehouseutils.IdentifiesTable, pg.Uploader.GetTableSchemaInUpload(warehouseutils.IdentifiesTable), true)
+	defer pg.dropStagingTable(ctx, warehouseutils.IdentifiesTable, identifyStagingTable)
+	if err != nil {
+		errorMap[warehouseutils.IdentifiesTable] = err
+		return
+	}
+
+	if len(pg.Uploader.GetTableSchemaInUpload(warehouseutils.UsersTable)) == 0 {
+		return
+	}
+	errorMap[warehouseutils.UsersTable] = nil
+
+	if pg.SkipComputingUserLatestTraits || slices.Contains(pg.SkipComputingUserLatestTraitsWorkspaceIDs, pg.Warehouse.WorkspaceID) {
+		_, _, err := pg.loadTable(ctx, warehouseutils.UsersTable, pg.Uploader.GetTableSchemaInUpload(warehouseutils.UsersTable), false)
+		if err != nil {
+			errorMap[warehouseutils.UsersTable] = err
+		}
+		return
+	}
+
+	// the users are recomputed from the identifies with a user id only, so there is nothing to recompute without any
+	hasUserIDs, err := pg.hasUserIDs(ctx, identifyStagingTable)
+	if err != nil {
+		errorMap[warehouseutils.UsersTable] = err
+		return
+	}
+	if !hasUserIDs {
+		pg.logger.Infof("PG: Skipping computing the users table, as no identifies with a user id were loaded\n")
+		return
+	}
+
+	usersTableName := pg.tableName(warehouseutils.UsersTable)
+	unionStagingTableName := pg.newTrackedStagingTableName(usersIdentifiesUnion, usersIdentifiesUnion)
+	stagingTableName := pg.newTrackedStagingTableName(warehouseutils.UsersTable, usersTableName)
+	defer pg.dropStagingTable(ctx, warehouseutils.UsersTable, stagingTableName)
+	defer pg.dropStagingTable(ctx, warehouseutils.UsersTable, unionStagingTableName)
+
+	userColMap := pg.tableSchemaInWarehouse(warehouseutils.UsersTable)
+	orderColumn, err := pg.dedupOrderColumn(warehouseutils.UsersTable, userColMap)
+	if err != nil {
+		errorMap[warehouseutils.UsersTable] = err
+		return
+	}
+	var userColNames, firstValProps []string
+	for colName := range userColMap {
+		if colName == "id" {
+			continue
+		}
+		userColNames = append(userColNames, fmt.Sprintf(`%q`, colName))
+		caseSubQuery := fmt.Sprintf(`case
+						  when (select true) then (
+						  	select "%[1]s" from "%[3]s"."%[2]s" as staging_table
+						  	where x.id = staging_table.id
+							  and "%[1]s" is not null
+							  order by "%[4]s" desc
+						  	limit 1)
+						  end`, colName, unionStagingTableName, pg.Namespace, orderColumn)
+		if pg.MergeUsersJSONTraits && userColMap[colName] == "json" {
+			// Shallow merge of all the json objects for the user, the latest value wins for a key.
+			// Falls back to the latest non-null value if none of the values are json objects.
+			caseSubQuery = fmt.Sprintf(`coalesce(
+						  (
+						  	select jsonb_object_agg(_kv.key, _kv.value order by staging_table."%[5]s" asc nulls first)
+						  	from "%[3]s"."%[2]s" as staging_table, jsonb_each(staging_table."%[1]s") as _kv
+						  	where x.id = staging_table.id
+							  and jsonb_typeof(staging_table."%[1]s") = 'object'
+						  ),
+						  %[4]s
+						  )`, colName, unionStagingTableName, pg.Namespace, caseSubQuery, orderColumn)
+		}
+		firstValProps = append(firstValProps, fmt.Sprintf(`%s as %q`, caseSubQuery, colName))
+	}
+
+	sqlStatement := fmt.Sprintf(`CREATE TABLE "%[1]s"."%[5]s" as (
+												(
+													SELECT id, %[4]s FROM "%[1]s"."%[2]s" WHERE id in (SELECT user_id FROM "%[1]s"."%[3]s" WHERE user_id IS NOT NULL)
+												) UNION
+												(
+													SELECT user_id, %[4]s FROM "%[1]s"."%[3]s"  WHERE user_id IS NOT NULL
+												)
+											)`, pg.Namespace, usersTableName, identifyStagingTable, strings.Join(userColNames, ","), unionStagingTableName)
+
+	// BEGIN TRANSACTION
+	// The staging tables are created within the transaction, so that they don't outlive a failed load.
+	tx, err := pg.beginTx(ctx)
+	if err != nil {
+		errorMap[warehouseutils.UsersTable] = err
+		return
+	}
+	// tags
+	tags := stats.Tags{
+		"workspaceId":     pg.Warehouse.WorkspaceID,
+		"destId":          pg.Warehouse.Destination.ID,
+		"tableName":       warehouseutils.UsersTable,
+		connectionModeKey: pg.connectionMode(),
+	}
+	if err = pg.setLocalResourceLimits(ctx, tx); err != nil {
+		pg.logger.Errorf("PG: Error setting resource limits for users table: %v\n", err)
+		tags["stage"] = setResourceLimits
+		pg.runRollbackWithTimeout(tx.Rollback, pg.handleRollbackTimeout, pg.TxnRollbackTimeout, tags)
+		errorMap[warehouseutils.UsersTable] = err
+		return
+	}
+	if err = pg.setLocalSearchPath(ctx, tx); err != nil {
+		pg.logger.Errorf("PG: Error setting search path for users table: %v\n", err)
+		tags["stage"] = setSearchPath
+		pg.runRollbackWithTimeout(tx.Rollback, pg.handleRollbackTimeout, pg.TxnRollbackTimeout, tags)
+		errorMap[warehouseutils.UsersTable] = err
+		return
+	}
+
+	pg.logger.Infof("PG: Creating staging table for union of users table with identify staging table: %s\n", sqlStatement)
+	_, err = tx.ExecContext(ctx, sqlStatement)
+	if err != nil {
+		pg.logger.Errorf("PG: Error creating union staging table for users table: %v\n", err)
+		tags["stage"] = createStagingTable
+		pg.runRollbackWithTimeout(tx.Rollback, pg.handleRollbackTimeout, pg.TxnRollbackTimeout, tags)
+		errorMap[warehouseutils.UsersTable] = err
+		return
+	}
+	pg.countStagingTable(stagingTableCreated, warehouseutils.UsersTable)
+	if err = pg.commentStagingTable(ctx, tx, unionStagingTableName); err != nil {
+		pg.logger.Errorf("PG: Error commenting union staging table for users table: %v\n", err)
+		tags["stage"] = commentStagingTable
+		pg.runRollbackWithTimeout(tx.Rollback, pg.handleRollbackTimeout, pg.TxnRollbackTimeout, tags)
+		errorMap[warehouseutils.UsersTable] = err
+		return
+	}
+
+	sqlStatement = fmt.Sprintf(`CREATE TABLE "%[4]s"."%[1]s" AS (SELECT DISTINCT * FROM
+										(
+											SELECT
+											x.id, %[2]s
+											FROM "%[4]s"."%[3]s" as x
+										) as xyz
+									)`,
+		stagingTableName,
+		strings.Join(firstValProps, ","),
+		unionStagingTableName,
+		pg.Namespace,
+	)
+
+	pg.logger.Debugf("PG: Creating staging table for users: %s\n", sqlStatement)
+	_, err = tx.ExecContext(ctx, sqlStatement)
+	if err != nil {
+		pg.logger.Errorf("PG: Error creating staging table for users table: %v\n", err)
+		tags["stage"] = createStagingTable
+		pg.runRollbackWithTimeout(tx.Rollback, pg.handleRollbackTimeout, pg.TxnRollbackTimeout, tags)
+		errorMap[warehouseutils.UsersTable] = err
+		return
+	}
+	pg.countStagingTable(stagingTableCreated, warehouseutils.UsersTable)
+	if err = pg.commentStagingTable(ctx, tx, stagingTableName); err != nil {
+		pg.logger.Errorf("PG: Error commenting staging table for users table: %v\n", err)
+		tags["stage"] = commentStagingTable
+		pg.runRollbackWithTimeout(tx.Rollback, pg.handleRollbackTimeout, pg.TxnRollbackTimeout, tags)
+		errorMap[warehouseutils.UsersTable] = err
+		return
+	}
+
+	primaryKey := "id"
+	sqlStatement = fmt.Sprintf(`DELETE FROM "%[1]s"."%[2]s" using "%[1]s"."%[3]s" _source where (_source.%[4]s = "%[1]s"."%[2]s".%[4]s)`, pg.Namespace, usersTableName, stagingTableName, primaryKey)
+	pg.logger.Infof("PG: Dedup records for table:%s using staging table: %s\n", warehouseutils.UsersTable, sqlStatement)
+	err = pg.handleExecContext(ctx, &QueryParams{
+		txn:                 tx,
+		query:               sqlStatement,
+		enableWithQueryPlan: pg.EnableSQLStatementExecutionPlan || slices.Contains(pg.EnableSQLStatementExecutionPlanWorkspaceIDs, pg.Warehouse.WorkspaceID),
+		explainAnalyze:      pg.EnableSQLStatementExplainAnalyze,
+	})
+	if err != nil {
+		pg.logger.Errorf("PG: Error deleting from original table for dedup: %v\n", err)
+		tags["stage"] = deleteDedup
+		pg.runRollbackWithTimeout(tx.Rollback, pg.handleRollbackTimeout, pg.TxnRollbackTimeout, tags)
+		errorMap[warehouseutils.UsersTable] = err
+		return
+	}
+
+	sqlStatement = fmt.Sprintf(`INSERT INTO "%[1]s"."%[2]s" (%[4]s) SELECT %[4]s FROM  "%[1]s"."%[3]s"`, pg.Namespace, usersTableName, stagingTableName, strings.Join(append([]string{"id"}, userColNames...), ","))
+	pg.logger.Infof("PG: Inserting records for table:%s using staging table: %s\n", warehouseutils.UsersTable, sqlStatement)
+	err = pg.handleExecContext(ctx, &QueryParams{
+		txn:                 tx,
+		query:               sqlStatement,
+		enableWithQueryPlan: pg.EnableSQLStatementExecutionPlan || slices.Contains(pg.EnableSQLStatementExecutionPlanWorkspaceIDs, pg.Warehouse.WorkspaceID),
+		explainAnalyze:      pg.EnableSQLStatementExplainAnalyze,
+	})
+
+	if err != nil {
+		pg.logger.Errorf("PG: Error inserting into users table from staging table: %v\n", err)
+		tags["stage"] = insertDedup
+		pg.runRollbackWithTimeout(tx.Rollback, pg.handleRollbackTimeout, pg.TxnRollbackTimeout, tags)
+		errorMap[warehouseutils.UsersTable] = err
+		return
+	}
+
+	sqlStatement = fmt.Sprintf(`DROP TABLE "%[1]s"."%[2]s", "%[1]s"."%[3]s"`, pg.Namespace, unionStagingTableName, stagingTableName)
+	pg.logger.Infof("PG: Dropping staging tables for users table: %s\n", sqlStatement)
+	_, err = tx.ExecContext(ctx, sqlStatement)
+	if err != nil {
+		pg.logger.Errorf("PG: Error dropping staging tables for users table: %v\n", err)
+		tags["stage"] = dropStagingTables
+		pg.runRollbackWithTimeout(tx.Rollback, pg.handleRollbackTimeout, pg.TxnRollbackTimeout, tags)
+		errorMap[warehouseutils.UsersTable] = err
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		pg.logger.Errorf("PG: Error in transaction commit for users table: %v\n", err)
+		tags["stage"] = dedupStage
+		pg.runRollbackWithTimeout(tx.Rollback, pg.handleRollbackTimeout, pg.TxnRollbackTimeout, tags)
+		errorMap[warehouseutils.UsersTable] = err
+		return
+	}
+	return
+}
+
+func (pg *Postgres) schemaExists(ctx context.Context) (exists bool, err error) {
+	sqlStatement := fmt.Sprintf(`SELECT EXISTS (SELECT 1 FROM pg_catalog.pg_namespace WHERE nspname = '%s');`, pg.Namespace)
+	err = pg.connectedDB().QueryRowContext(ctx, sqlStatement).Scan(&exists)
+	return
+}
+
+func (pg *Postgres) CreateSchema(ctx context.Context) (err error) {
+	var schemaExists bool
+	schemaExists, err = pg.schemaExists(ctx)
+	if err != nil {
+		pg.logger.Errorf("PG: Error checking if schema: %s exists: %v", pg.Namespace, err)
+		return err
+	}
+	if schemaExists {
+		pg.logger.Infof("PG: Skipping creating schema: %s since it already exists", pg.Namespace)
+		return
+	}
+	sqlStatement := fmt.Sprintf(`CREATE SCHEMA IF NOT EXISTS %q`, pg.Namespace)
+	pg.logger.Infof("PG: Creating schema name in postgres for PG:%s : %v", pg.Warehouse.Destination.ID, sqlStatement)
+	_, err = pg.connectedDB().ExecContext(ctx, sqlStatement)
+	return
+}
+
+// ErrDropSchemaNotAllowed is returned by DropSchema unless Warehouse.postgres.allowDropSchema is set.
+var ErrDropSchemaNotAllowed = errors.New("dropping schema is not allowed, set Warehouse.postgres.allowDropSchema to allow it")
+
+// DropSchema drops the namespace along with all its tables, for tearing down a disconnected destination.
+// To prevent accidental data loss, it requires AllowDropSchema to be set.
+func (pg *Postgres) DropSchema(ctx context.Context) error {
+	if !pg.AllowDropSchema {
+		return ErrDropSchemaNotAllowed
+	}
+
+	schemaExists, err := pg.schemaExists(ctx)
+	if err != nil {
+		return fmt.Errorf("checking if schema %s exists: %w", pg.Namespace, err)
+	}
+	if !schemaExists {
+		pg.logger.Infof("PG: Skipping dropping schema: %s since it does not exist", pg.Namespace)
+		return nil
+	}
+
+	sqlStatement := fmt.Sprintf(`DROP SCHEMA IF EXISTS %q CASCADE`, pg.Namespace)
+	pg.logger.Warnf("PG: Dropping schema %s with all its tables for PG:%s : %v", pg.Namespace, pg.Warehouse.Destination.ID, sqlStatement)
+	if err := pg.execDDL(ctx, sqlStatement); err != nil {
+		return fmt.Errorf("dropping schema %s: %w", pg.Namespace, err)
+	}
+
+	pg.stats.NewTaggedStat("pg_schema_dropped", stats.CountType, stats.Tags{
+		"workspaceId":   pg.Warehouse.WorkspaceID,
+		"namespace":     pg.Namespace,
+		"destinationID": pg.Warehouse.Destination.ID,
+	}).Count(1)
+	return nil
+}
+
+func (pg *Postgres) dropStagingTable(ctx context.Context, tableName, stagingTableName string) {
+	pg.logger.Infof("PG: dropping table %+v\n", stagingTableName)
+	_, err := pg.connectedDB().ExecContext(ctx, fmt.Sprintf(`DROP TABLE IF EXISTS "%[1]s"."%[2]s"`, pg.Namespace, stagingTableName))
+	if err != nil {
+		pg.logger.Errorf("PG:  Error dropping staging table %s in postgres: %v", stagingTableName, err)
+		pg.countStagingTable(stagingTableDropFailed, tableName)
+		return
+	}
+	pg.forgetStagingTable(stagingTableName)
+	pg.countStagingTable(stagingTableDropped, tableName)
+}
+
+// countStagingTable counts the lifecycle stat of a staging table of the table.
+func (pg *Postgres) countStagingTable(name, tableName string) {
+	pg.stats.NewTaggedStat(name, stats.CountType, stats.Tags{
+		"workspaceId":   pg.Warehouse.WorkspaceID,
+		"namespace":     pg.Namespace,
+		"destinationID": pg.Warehouse.Destination.ID,
+		"tableName":     tableName,
+	}).Count(1)
+}
+
+// newStagingTableName returns a new name for a staging table of the table, unique to the load attempt creating it.
+// Postgres truncates identifiers to identifierLimit bytes, which cut the random suffix of the staging tables of long table names,
+// making the retries of a load collide with the staging tables left over by the previous attempts. The table name is truncated instead.
+func newStagingTableName(tableName string) string {
+	prefix, suffix := warehouseutils.StagingTablePrefix(provider), "_"+warehouseutils.RandHex()
+	for len(prefix)+len(tableName)+len(suffix) > identifierLimit {
+		_, size := utf8.DecodeLastRuneInString(tableName)
+		tableName = tableName[:len(tableName)-size]
+	}
+	return prefix + tableName + suffix
+}
+
+// stagingTableOf returns the table the staging table was created for, as found in its name.
+// Staging tables whose name got truncated, or of tables without the configured prefix and suffix, are reported as is.
+func (pg *Postgres) stagingTableOf(stagingTableName string) string {
+	match := stagingTableNameRegex.FindStringSubmatch(stagingTableName)
+	if match == nil {
+		return stagingTableName
+	}
+	if tableName, ok := pg.unqualifiedTableName(match[1]); ok {
+		return tableName
+	}
+	return stagingTableName
+}
+
+// createTable creates the table along with the storage strategies of its columns, which are validated beforehand and set in the same transaction,
+// so that no table is left without them.
+func (pg *Postgres) createTable(ctx context.Context, name string, columns model.TableSchema) error {
+	columnStorageStatement, err := pg.columnStorageStatement(name, columns)
+	if err != nil {
+		return err
+	}
+
+	sqlStatement := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS "%[1]s"."%[2]s" ( %v )`, pg.Namespace, name, columnsWithDefaults(columns, pg.ColumnDefaults[name]))
+	if columnStorageStatement != "" {
+		// the statements of a simple query run in a single transaction
+		sqlStatement += "; " + columnStorageStatement
+	}
+	pg.logger.Infof("PG: Creating table in postgres for PG:%s : %v", pg.Warehouse.Destination.ID, sqlStatement)
+	return pg.execDDL(ctx, sqlStatement)
+}
+
+// columnsWithDefaults returns the column definitions of the table, with the defaults configured with ColumnDefaults, e.g. now() for _loaded_at.
+// The defaults are expressions used as is. The rows of the loads without the column get its default, as only the columns of the load are inserted.
+// The defaults are only set when creating the table, as adding a column with a volatile default rewrites the table.
+func columnsWithDefaults(columns model.TableSchema, defaults map[string]string) string {
+	if len(defaults) == 0 {
+		return ColumnsWithDataTypes(columns, "")
+	}
+
+	var arr []string
+	for name, dataType := range columns {
+		column := fmt.Sprintf(`%q %s`, name, rudderDataTypesMapToPostgres[dataType])
+		if expr, ok := defaults[name]; ok {
+			column += " DEFAULT " + expr
+		}
+		arr = append(arr, column)
+	}
+	return strings.Join(arr, ",")
+}
+
+// columnStorageStatement returns the statement setting the storage strategy configured with ColumnStorage for the text and jsonb columns of the table,
+// or an empty statement if none is configured for its columns.
+// E.g. EXTERNAL keeps wide columns uncompressed out of line, while MAIN keeps them compressed inline for as long as possible.
+func (pg *Postgres) columnStorageStatement(tableName string, columns model.TableSchema) (string, error) {
+	columnStorage := pg.ColumnStorage[tableName]
+	if len(columnStorage) == 0 {
+		return "", nil
+	}
+
+	var alterColumns []string
+	for columnName, columnType := range columns {
+		storage, ok := columnStorage[columnName]
+		if !ok {
+			continue
+		}
+		if dataType := rudderDataTypesMapToPostgres[columnType]; dataType != "text" && dataType != "jsonb" {
+			return "", fmt.Errorf("column storage of %s.%s: storage can only be set for text and jsonb columns, not %s", tableName, columnName, dataType)
+		}
+		storage = strings.ToUpper(storage)
+		if _, ok := columnStorages[storage]; !ok {
+			return "", fmt.Errorf("column storage of %s.%s: unknown storage %s", tableName, columnName, storage)
+		}
+		alterColumns = append(alterColumns, fmt.Sprintf(`ALTER COLUMN %q SET STORAGE %s`, columnName, storage))
+	}
+	if len(alterColumns) == 0 {
+		return "", nil
+	}
+	sort.Strings(alterColumns)
+
+	return fmt.Sprintf(`ALTER TABLE %q.%q %s`, pg.Namespace, tableName, strings.Join(alterColumns, ", ")), nil
+}
+
+// acquireConn acquires a connection from the pool, waiting at most AcquireTimeout for one to be available.
+// The connection must be closed to return it to the pool.
+// Only the transactions and DDL statements acquire their connections with it, the other queries waiting for a connection until their context is done.
+func (pg *Postgres) acquireConn(ctx context.Context) (*sqlmiddleware.Conn, error) {
+	if pg.AcquireTimeout <= 0 {
+		return pg.connectedDB().Conn(ctx)
+	}
+
+	acquireCtx, cancel := context.WithTimeout(ctx, pg.AcquireTimeout)
+	defer cancel()
+
+	conn, err := pg.connectedDB().Conn(acquireCtx)
+	if err != nil && errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+		return nil, &ResourceExhaustedError{Timeout: pg.AcquireTimeout}
+	}
+	return conn, err
+}
+
+// beginTx begins a transaction on a connection acquired within the AcquireTimeout.
+func (pg *Postgres) beginTx(ctx context.Context) (*sqlmiddleware.Tx, error) {
+	isolationLevel, ok := isolationLevels[pg.IsolationLevel]
+	if !ok {
+		return nil, fmt.Errorf("unknown isolation level %s", pg.IsolationLevel)
+	}
+	opts := &sql.TxOptions{Isolation: isolationLevel}
+
+	if pg.AcquireTimeout <= 0 {
+		return pg.connectedDB().BeginTx(ctx, opts)
+	}
+
+	conn, err := pg.acquireConn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return conn.BeginTxAndRelease(ctx, opts)
+}
+
+// execDDL executes the DDL statement with the configured lock_timeout.
+// This makes the statement fail fast, rather than waiting indefinitely for a lock held by a long-running query.
+func (pg *Postgres) execDDL(ctx context.Context, sqlStatement string) error {
+	// deferred first, so that it runs once the statement is committed
+	defer pg.invalidateSharedSchemaCache()
+
+	if pg.LockTimeout <= 0 {
+		conn, err := pg.acquireConn(ctx)
 		if err != nil {
-			pg.logger.Errorf("PG: Error reading file using gzip.NewReader for file:%s while loading to table %s", gzipFile, tableName)
-			gzipFile.Close()
-			tags["stage"] = readGzipLoadFiles
-			pg.runRollbackWithTimeout(txn.Rollback, handleRollbackTimeout, pg.TxnRollbackTimeout, tags)
-			return
+			return err
 		}
-		csvReader := csv.NewReader(gzipReader)
-		var csvRowsProcessedCount int
-		for {
-			var record []string
-			record, err = csvReader.Read()
-			if err != nil {
-				if err == io.EOF {
-					pg.logger.Debugf("PG: File reading completed while reading csv file for loading in staging table:%s: %s", stagingTableName, objectFileName)
-					break
-				}
-				pg.logger.Errorf("PG: Error while reading csv file %s for loading in staging table:%s: %v", objectFileName, stagingTableName, err)
-				tags["stage"] = readCsvLoadFiles
-				pg.runRollbackWithTimeout(txn.Rollback, handleRollbackTimeout, pg.TxnRollbackTimeout, tags)
-				return
-			}
-			if len(sortedColumnKeys) != len(record) {
-				err = fmt.Errorf(`load file CSV columns for a row mismatch number found in upload schema. Columns in CSV row: %d, Columns in upload schema of table-%s: %d. Processed rows in csv file until mismatch: %d`, len(record), tableName, len(sortedColumnKeys), csvRowsProcessedCount)
-				pg.logger.Error(err)
-				tags["stage"] = csvColumnCountMismatch
-				pg.runRollbackWithTimeout(txn.Rollback, handleRollbackTimeout, pg.TxnRollbackTimeout, tags)
-				return
-			}
-			var recordInterface []interface{}
-			for _, value := range record {
-				if strings.TrimSpace(value) == "" {
-					recordInterface = append(recordInterface, nil)
-				} else {
-					recordInterface = append(recordInterface, value)
-				}
-			}
-			_, err = stmt.ExecContext(ctx, recordInterface...)
-			if err != nil {
-				pg.logger.Errorf("PG: Error in exec statement for loading in staging table:%s: %v", stagingTableName, err)
-				tags["stage"] = loadStagingTable
-				pg.runRollbackWithTimeout(txn.Rollback, handleRollbackTimeout, pg.TxnRollbackTimeout, tags)
-				return
+		defer func() { _ = conn.Close() }()
+
+		_, err = conn.ExecContext(ctx, sqlStatement)
+		return err
+	}
+
+	// SET LOCAL requires a transaction, which also keeps the setting off the pooled connection
+	txn, err := pg.beginTx(ctx)
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %w", err)
+	}
+	defer func() { _ = txn.Rollback() }()
+
+	if _, err := txn.ExecContext(ctx, fmt.Sprintf(`SET LOCAL lock_timeout = %d`, pg.LockTimeout.Milliseconds())); err != nil {
+		return fmt.Errorf("setting lock_timeout: %w", err)
+	}
+	if _, err := txn.ExecContext(ctx, sqlStatement); err != nil {
+		return err
+	}
+	return txn.Commit()
+}
+
+func (pg *Postgres) CreateTable(ctx context.Context, tableName string, columnMap model.TableSchema) (err error) {
+	if err = pg.setSearchPath(ctx); err != nil {
+		return err
+	}
+	// the load id column is expected by the loads once enabled
+	if pg.EnableLoadIDColumn {
+		columns := make(model.TableSchema, len(columnMap)+1)
+		for columnName, columnType := range columnMap {
+			columns[columnName] = columnType
+		}
+		columns[loadIDColumn] = "string"
+		columnMap = columns
+	}
+	if columnMap, err = pg.foldTableSchema(tableName, columnMap); err != nil {
+		return err
+	}
+	err = pg.createTable(ctx, pg.tableName(tableName), columnMap)
+	if err != nil {
+		return err
+	}
+	if pg.EnableLoadIDColumn {
+		if err = pg.createLoadIDIndex(ctx, tableName); err != nil {
+			return err
+		}
+	}
+	if pg.coalesceUpserts(tableName) {
+		if err = pg.createUpsertIndex(ctx, tableName); err != nil {
+			return err
+		}
+	}
+	if pg.CreateIndexes {
+		err = pg.createIndexes(ctx, tableName, columnMap)
+	}
+	return err
+}
+
+// setSearchPath sets the schema in the search path of the session with the session search path mode,
+// so that tables can be queried with their unqualified names.
+func (pg *Postgres) setSearchPath(ctx context.Context) error {
+	if pg.SearchPathMode != searchPathModeSession {
+		return nil
+	}
+	sqlStatement := fmt.Sprintf(`SET search_path to %q`, pg.Namespace)
+	if _, err := pg.connectedDB().ExecContext(ctx, sqlStatement); err != nil {
+		return err
+	}
+	pg.logger.Infof("PG: Updated search_path to %s in postgres for PG:%s : %v", pg.Namespace, pg.Warehouse.Destination.ID, sqlStatement)
+	return nil
+}
+
+// setLocalSearchPath sets the schema in the search path for the transaction with the local search path mode.
+// SET LOCAL only lasts until the end of the transaction, so it does not leak to the other clients of a transaction pooler.
+func (pg *Postgres) setLocalSearchPath(ctx context.Context, txn *sqlmiddleware.Tx) error {
+	if pg.SearchPathMode != searchPathModeLocal {
+		return nil
+	}
+	sqlStatement := fmt.Sprintf(`SET LOCAL search_path to %q`, pg.Namespace)
+	pg.logger.Infof("PG: Setting search_path for PG:%s : %v", pg.Warehouse.Destination.ID, sqlStatement)
+	if _, err := txn.ExecContext(ctx, sqlStatement); err != nil {
+		return fmt.Errorf("setting search_path: %w", err)
+	}
+	return nil
+}
+
+// setLocalResourceLimits sets the configured work_mem and temp_file_limit for the transaction.
+// SET LOCAL only lasts until the end of the transaction, so the pooled connections keep the server defaults.
+func (pg *Postgres) setLocalResourceLimits(ctx context.Context, txn *sqlmiddleware.Tx) error {
+	for _, setting := range []struct{ name, value string }{
+		{name: "work_mem", value: pg.WorkMem},
+		{name: "temp_file_limit", value: pg.TempFileLimit},
+	} {
+		if setting.value == "" {
+			continue
+		}
+		sqlStatement := fmt.Sprintf(`SET LOCAL %s = '%s'`, setting.name, strings.ReplaceAll(setting.value, "'", "''"))
+		pg.logger.Infof("PG: Setting %s for PG:%s : %v", setting.name, pg.Warehouse.Destination.ID, sqlStatement)
+		if _, err := txn.ExecContext(ctx, sqlStatement); err != nil {
+			return fmt.Errorf("setting %s: %w", setting.name, err)
+		}
+	}
+	return nil
+}
+
+// setLocalTimeZone sets the configured TimeZone for the transaction.
+// Timestamps without a time zone in the load files are interpreted in it, rather than in the time zone of the server.
+func (pg *Postgres) setLocalTimeZone(ctx context.Context, txn *sqlmiddleware.Tx) error {
+	if pg.LoadTimeZone == "" {
+		return nil
+	}
+	sqlStatement := fmt.Sprintf(`SET LOCAL TimeZone = '%s'`, strings.ReplaceAll(pg.LoadTimeZone, "'", "''"))
+	pg.logger.Infof("PG: Setting TimeZone for PG:%s : %v", pg.Warehouse.Destination.ID, sqlStatement)
+	if _, err := txn.ExecContext(ctx, sqlStatement); err != nil {
+		return fmt.Errorf("setting TimeZone: %w", err)
+	}
+	return nil
+}
+
+// indexColumns returns the columns of the table to be indexed, which are the dedup keys, received_at and the configured index columns for the table.
+// Columns not present in the table are skipped.
+func (pg *Postgres) indexColumns(tableName string, columns model.TableSchema) []string {
+	primaryKeys := []string{"id"}
+	if column, ok := primaryKeyMap[tableName]; ok {
+		primaryKeys = []string{column}
+	}
+	if dedupKeys := pg.DedupKeys[tableName]; len(dedupKeys) > 0 {
+		primaryKeys = dedupKeys
+	}
+
+	columnsToIndex := append(append(slices.Clone(primaryKeys), "received_at"), pg.IndexColumns[tableName]...)
+
+	var indexColumns []string
+	for _, column := range columnsToIndex {
+		if _, ok := columns[column]; !ok || slices.Contains(indexColumns, column) {
+			continue
+		}
+		indexColumns = append(indexColumns, column)
+	}
+	return indexColumns
+}
+
+func (pg *Postgres) createIndexes(ctx context.Context, tableName string, columns model.TableSchema) error {
+	for _, column := range pg.indexColumns(tableName, columns) {
+		sqlStatement := fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %q ON %q.%q (%q)`,
+			indexName(pg.tableName(tableName), column),
+			pg.Namespace,
+			pg.tableName(tableName),
+			column,
+		)
+		pg.logger.Infof("PG: Creating index in postgres for PG:%s : %v", pg.Warehouse.Destination.ID, sqlStatement)
+		if err := pg.execDDL(ctx, sqlStatement); err != nil {
+			return fmt.Errorf("creating index on %s for table %s: %w", column, tableName, err)
+		}
+	}
+	return nil
+}
+
+// indexName returns the name of the index on the column of the table.
+// Names exceeding identifierLimit bytes are truncated along with a hash of the whole name, which keeps the indexes of long names sharing a prefix apart.
+func indexName(tableName, column string) string {
+	name := fmt.Sprintf(`%s_%s_idx`, tableName, column)
+	if len(name) <= identifierLimit {
+		return name
+	}
+	sum := sha256.Sum256([]byte(name))
+	suffix := "_" + hex.EncodeToString(sum[:4])
+	for len(name)+len(suffix) > identifierLimit {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	return name + suffix
+}
+
+// tableName returns the name of the table in the warehouse, with the prefix and suffix configured for the destination.
+func (pg *Postgres) tableName(name string) string {
+	return warehouseutils.GetConfigValue(tablePrefix, pg.Warehouse) + pg.foldIdentifier(name) + warehouseutils.GetConfigValue(tableSuffix, pg.Warehouse)
+}
+
+// foldIdentifier returns the name of the table or column in the warehouse, which is lowercased with LowercaseIdentifiers.
+func (pg *Postgres) foldIdentifier(name string) string {
+	if !pg.LowercaseIdentifiers {
+		return name
+	}
+	return strings.ToLower(name)
+}
+
+// foldTableSchema returns the schema of the table with its column names folded, see foldIdentifier.
+// It returns an IdentifierCollisionError if several columns are folded to the same name, keeping the first of them in sorted order.
+func (pg *Postgres) foldTableSchema(tableName string, tableSchema model.TableSchema) (model.TableSchema, error) {
+	if !pg.LowercaseIdentifiers {
+		return tableSchema, nil
+	}
+
+	var (
+		foldedSchema = make(model.TableSchema, len(tableSchema))
+		foldedFrom   = make(map[string]string, len(tableSchema))
+		collisions   []string
+	)
+	for _, columnName := range warehouseutils.SortColumnKeysFromColumnMap(tableSchema) {
+		foldedName := pg.foldIdentifier(columnName)
+		if firstName, ok := foldedFrom[foldedName]; ok {
+			if !slices.Contains(collisions, firstName) {
+				collisions = append(collisions, firstName)
 			}
-			csvRowsProcessedCount++
+			collisions = append(collisions, columnName)
+			continue
+		}
+		foldedFrom[foldedName] = columnName
+		foldedSchema[foldedName] = tableSchema[columnName]
+	}
+	if len(collisions) > 0 {
+		return foldedSchema, &IdentifierCollisionError{TableName: tableName, ColumnNames: collisions}
+	}
+	return foldedSchema, nil
+}
+
+// mapColumns renames the columns of the load files of the table to the columns of the table they are mapped to by ColumnMappings,
+// e.g. while a column is being renamed, returning the upload schema and the column names by position of the load files with the mapped names.
+// The other per column settings, e.g. TrimColumns, apply to the mapped names.
+// The mappings of the columns absent from the upload are skipped, as not every upload has every column.
+// The columns must be of the same type in the upload and in the warehouse, and no two columns can be loaded into the same one.
+func (pg *Postgres) mapColumns(tableName string, tableSchemaInUpload model.TableSchema, sortedColumnKeys []string) (model.TableSchema, []string, error) {
+	columnMapping := pg.ColumnMappings[tableName]
+	if len(columnMapping) == 0 {
+		return tableSchemaInUpload, sortedColumnKeys, nil
+	}
+
+	tableSchemaInWarehouse := pg.tableSchemaInWarehouse(tableName)
+	foldedMapping := make(map[string]string, len(columnMapping))
+	for sourceColumn, targetColumn := range columnMapping {
+		sourceColumn, targetColumn = pg.foldIdentifier(sourceColumn), pg.foldIdentifier(targetColumn)
+
+		sourceType, ok := tableSchemaInUpload[sourceColumn]
+		if !ok {
+			continue
+		}
+		foldedMapping[sourceColumn] = targetColumn
+
+		targetType, ok := tableSchemaInWarehouse[targetColumn]
+		if !ok {
+			return nil, nil, fmt.Errorf("mapping column %s of table %s: column %s not found in warehouse schema", sourceColumn, tableName, targetColumn)
+		}
+		if sourceType != targetType {
+			return nil, nil, fmt.Errorf("mapping column %s of table %s: type %s does not match type %s of column %s", sourceColumn, tableName, sourceType, targetType, targetColumn)
+		}
+	}
+
+	mappedSchema := make(model.TableSchema, len(tableSchemaInUpload))
+	mappedColumnKeys := make([]string, len(sortedColumnKeys))
+	for i, column := range sortedColumnKeys {
+		mappedColumn := column
+		if targetColumn, ok := foldedMapping[column]; ok {
+			mappedColumn = targetColumn
+		}
+		if _, ok := mappedSchema[mappedColumn]; ok {
+			return nil, nil, fmt.Errorf("mapping column %s of table %s: column %s is loaded from several columns", column, tableName, mappedColumn)
+		}
+		mappedSchema[mappedColumn] = tableSchemaInUpload[column]
+		mappedColumnKeys[i] = mappedColumn
+	}
+	return mappedSchema, mappedColumnKeys, nil
+}
+
+// tableSchemaInWarehouse returns the schema of the table in the warehouse as known to the uploader, with its column names folded.
+// The schema is fetched with folded names, so that colliding columns are only expected from tables created with mixed case names beforehand.
+func (pg *Postgres) tableSchemaInWarehouse(tableName string) model.TableSchema {
+	tableSchema, _ := pg.foldTableSchema(tableName, pg.Uploader.GetTableSchemaInWarehouse(pg.foldIdentifier(tableName)))
+	return tableSchema
+}
+
+// unqualifiedTableName strips the prefix and suffix configured for the destination from the name of the table in the warehouse.
+// It returns false if the table does not have the prefix or suffix.
+func (pg *Postgres) unqualifiedTableName(name string) (string, bool) {
+	prefix := warehouseutils.GetConfigValue(tablePrefix, pg.Warehouse)
+	suffix := warehouseutils.GetConfigValue(tableSuffix, pg.Warehouse)
+
+	if len(name) <= len(prefix)+len(suffix) || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, suffix) {
+		return "", false
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(name, prefix), suffix), true
+}
+
+func (pg *Postgres) DropTable(ctx context.Context, tableName string) (err error) {
+	sqlStatement := `DROP TABLE "%[1]s"."%[2]s"`
+	pg.logger.Infof("PG: Dropping table in postgres for PG:%s : %v", pg.Warehouse.Destination.ID, sqlStatement)
+	err = pg.execDDL(ctx, fmt.Sprintf(sqlStatement, pg.Namespace, pg.tableName(tableName)))
+	return
+}
+
+// RenameTable renames the table, preserving its data.
+// It returns a TableAlreadyExistsError if a table with the new name already exists.
+func (pg *Postgres) RenameTable(ctx context.Context, oldName, newName string) error {
+	if err := pg.setSearchPath(ctx); err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf(`ALTER TABLE %q.%q RENAME TO %q;`, pg.Namespace, pg.tableName(oldName), pg.tableName(newName))
+	pg.logger.Infof("PG: Renaming table for destinationID: %s, tableName: %s with query: %v", pg.Warehouse.Destination.ID, oldName, query)
+	err := pg.execDDL(ctx, query)
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code {
+		case undefinedTableCode:
+			return &TableNotFoundError{Namespace: pg.Namespace, TableName: oldName}
+		case duplicateTableCode:
+			return &TableAlreadyExistsError{Namespace: pg.Namespace, TableName: newName}
 		}
-		_ = gzipReader.Close()
-		gzipFile.Close()
 	}
+	if err != nil {
+		return fmt.Errorf("renaming table %s to %s: %w", oldName, newName, err)
+	}
+	return nil
+}
 
-	_, err = stmt.ExecContext(ctx)
+// RenameColumn renames the column of the table, preserving its data.
+// It returns a ColumnAlreadyExistsError if a column with the new name already exists in the table.
+func (pg *Postgres) RenameColumn(ctx context.Context, tableName, oldName, newName string) error {
+	if err := pg.setSearchPath(ctx); err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf(`ALTER TABLE %q.%q RENAME COLUMN %q TO %q;`, pg.Namespace, pg.tableName(tableName), oldName, newName)
+	pg.logger.Infof("PG: Renaming column for destinationID: %s, tableName: %s with query: %v", pg.Warehouse.Destination.ID, tableName, query)
+	err := pg.execDDL(ctx, query)
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code {
+		case undefinedTableCode:
+			return &TableNotFoundError{Namespace: pg.Namespace, TableName: tableName}
+		case undefinedColumnCode:
+			return &ColumnNotFoundError{TableName: tableName, ColumnName: oldName}
+		case duplicateColumnCode:
+			return &ColumnAlreadyExistsError{TableName: tableName, ColumnName: newName}
+		}
+	}
 	if err != nil {
-		pg.logger.Errorf("PG: Rollback transaction as there was error while loading staging table:%s: %v", stagingTableName, err)
-		tags["stage"] = stagingTableloadStage
-		pg.runRollbackWithTimeout(txn.Rollback, handleRollbackTimeout, pg.TxnRollbackTimeout, tags)
+		return fmt.Errorf("renaming column %s to %s in table %s: %w", oldName, newName, tableName, err)
+	}
+	return nil
+}
+
+func (pg *Postgres) AddColumns(ctx context.Context, tableName string, columnsInfo []warehouseutils.ColumnInfo) (err error) {
+	var (
+		query        string
+		queryBuilder strings.Builder
+	)
+
+	if err = pg.setSearchPath(ctx); err != nil {
 		return
+	}
 
+	columnMap := make(model.TableSchema, len(columnsInfo))
+	for _, columnInfo := range columnsInfo {
+		columnMap[columnInfo.Name] = columnInfo.Type
 	}
-	// deduplication process
-	primaryKey := "id"
-	if column, ok := primaryKeyMap[tableName]; ok {
-		primaryKey = column
+	if _, err = pg.foldTableSchema(tableName, columnMap); err != nil {
+		return
 	}
-	partitionKey := "id"
-	if column, ok := partitionKeyMap[tableName]; ok {
-		partitionKey = column
+	pg.warnColumnCount(ctx, tableName, len(columnsInfo))
+
+	queryBuilder.WriteString(fmt.Sprintf(`
+		ALTER TABLE
+		  %q.%q`,
+		pg.Namespace,
+		pg.tableName(tableName),
+	))
+
+	for _, columnInfo := range columnsInfo {
+		queryBuilder.WriteString(fmt.Sprintf(` ADD COLUMN IF NOT EXISTS %q %s,`, pg.foldIdentifier(columnInfo.Name), rudderDataTypesMapToPostgres[columnInfo.Type]))
 	}
-	var additionalJoinClause string
-	if tableName == warehouseutils.DiscardsTable {
-		additionalJoinClause = fmt.Sprintf(`AND _source.%[3]s = "%[1]s"."%[2]s"."%[3]s" AND _source.%[4]s = "%[1]s"."%[2]s"."%[4]s"`, pg.Namespace, tableName, "table_name", "column_name")
+
+	query = strings.TrimSuffix(queryBuilder.String(), ",")
+	query += ";"
+
+	pg.logger.Infof("PG: Adding columns for destinationID: %s, tableName: %s with query: %v", pg.Warehouse.Destination.ID, tableName, query)
+	err = pg.execDDL(ctx, query)
+	return
+}
+
+// warnColumnCount warns if adding the columns to the table would take it above ColumnCountWarningThreshold columns,
+// so that the table can be cleaned up before reaching the limit of 1600 columns. It is best-effort, not failing AddColumns.
+// The dropped columns still count towards the limit, until the table is rewritten.
+func (pg *Postgres) warnColumnCount(ctx context.Context, tableName string, columnsToAdd int) {
+	if pg.ColumnCountWarningThreshold <= 0 {
+		return
 	}
-	sqlStatement = fmt.Sprintf(`DELETE FROM "%[1]s"."%[2]s" USING "%[1]s"."%[3]s" as  _source where (_source.%[4]s = "%[1]s"."%[2]s"."%[4]s" %[5]s)`, pg.Namespace, tableName, stagingTableName, primaryKey, additionalJoinClause)
-	pg.logger.Infof("PG: Deduplicate records for table:%s using staging table: %s\n", tableName, sqlStatement)
-	err = pg.handleExecContext(ctx, &QueryParams{
-		txn:                 txn,
-		query:               sqlStatement,
-		enableWithQueryPlan: pg.EnableSQLStatementExecutionPlan || slices.Contains(pg.EnableSQLStatementExecutionPlanWorkspaceIDs, pg.Warehouse.WorkspaceID),
-	})
+
+	var columnCount int
+	err := pg.connectedDB().QueryRowContext(ctx, `SELECT count(*) FROM pg_catalog.pg_attribute WHERE attrelid = to_regclass($1) AND attnum > 0;`,
+		fmt.Sprintf(`%q.%q`, pg.Namespace, pg.tableName(tableName)),
+	).Scan(&columnCount)
 	if err != nil {
-		pg.logger.Errorf("PG: Error deleting from original table for dedup: %v\n", err)
-		tags["stage"] = deleteDedup
-		pg.runRollbackWithTimeout(txn.Rollback, handleRollbackTimeout, pg.TxnRollbackTimeout, tags)
+		pg.logger.Warnf("PG: Error counting the columns of table:%s: %v", tableName, err)
+		return
+	}
+	if columnCount+columnsToAdd <= pg.ColumnCountWarningThreshold {
 		return
 	}
 
-	quotedColumnNames := warehouseutils.DoubleQuoteAndJoinByComma(sortedColumnKeys)
-	sqlStatement = fmt.Sprintf(`INSERT INTO "%[1]s"."%[2]s" (%[3]s)
-									SELECT %[3]s FROM (
-										SELECT *, row_number() OVER (PARTITION BY %[5]s ORDER BY received_at DESC) AS _rudder_staging_row_number FROM "%[1]s"."%[4]s"
-									) AS _ where _rudder_staging_row_number = 1
-									`, pg.Namespace, tableName, quotedColumnNames, stagingTableName, partitionKey)
-	pg.logger.Infof("PG: Inserting records for table:%s using staging table: %s\n", tableName, sqlStatement)
-	err = pg.handleExecContext(ctx, &QueryParams{
-		txn:                 txn,
-		query:               sqlStatement,
-		enableWithQueryPlan: pg.EnableSQLStatementExecutionPlan || slices.Contains(pg.EnableSQLStatementExecutionPlanWorkspaceIDs, pg.Warehouse.WorkspaceID),
-	})
+	pg.logger.Warnf("PG: Table:%s in namespace %s for PG:%s would have %d columns, above the warning threshold of %d ahead of the limit of 1600 columns",
+		tableName, pg.Namespace, pg.Warehouse.Destination.ID, columnCount+columnsToAdd, pg.ColumnCountWarningThreshold,
+	)
+	pg.stats.NewTaggedStat("pg_column_count_high", stats.GaugeType, stats.Tags{
+		"workspaceId":   pg.Warehouse.WorkspaceID,
+		"namespace":     pg.Namespace,
+		"destinationID": pg.Warehouse.Destination.ID,
+		"tableName":     tableName,
+	}).Gauge(columnCount + columnsToAdd)
+}
+
+func (*Postgres) AlterColumn(context.Context, string, string, string) (model.AlterTableResponse, error) {
+	return model.AlterTableResponse{}, nil
+}
+
+func (pg *Postgres) TestConnection(ctx context.Context, warehouse model.Warehouse) error {
+	// ephemeral ssl keys are written when connecting
+	if warehouse.Destination.Config["sslMode"] == verifyCA && !pg.EphemeralSSLKeys && !warehouseutils.ReadAsBool(sslInline, warehouse.Destination.Config) {
+		if sslKeyError := warehouseutils.WriteSSLKeys(warehouse.Destination); sslKeyError.IsError() {
+			return fmt.Errorf("writing ssl keys: %s", sslKeyError.Error())
+		}
+	}
 
+	err := pg.redactError(pg.connectedDB().PingContext(ctx))
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("connection timeout: %w", err)
+	}
 	if err != nil {
-		pg.logger.Errorf("PG: Error inserting into original table: %v\n", err)
-		tags["stage"] = insertDedup
-		pg.runRollbackWithTimeout(txn.Rollback, handleRollbackTimeout, pg.TxnRollbackTimeout, tags)
-		return
+		return fmt.Errorf("pinging: %w", err)
 	}
 
-	if err = txn.Commit(); err != nil {
-		pg.logger.Errorf("PG: Error while committing transaction as there was error while loading staging table:%s: %v", stagingTableName, err)
-		tags["stage"] = dedupStage
-		pg.runRollbackWithTimeout(txn.Rollback, handleRollbackTimeout, pg.TxnRollbackTimeout, tags)
+	return nil
+}
+
+// HealthStatus is the outcome of a Healthcheck against the destination.
+type HealthStatus struct {
+	Healthy bool
+	// Latency is the round-trip time of the health check query.
+	Latency time.Duration
+	// IsReplica is set if the destination is in recovery, e.g. a hot standby.
+	IsReplica bool
+	// ReplicationLag is the time since the last transaction replayed on a replica.
+	ReplicationLag time.Duration
+	Err            error
+}
+
+// Healthcheck runs a lightweight query against the destination and reports its latency along with the replication lag if the destination is a replica.
+// Unlike TestConnection, it does not write the SSL keys, so it can be polled periodically.
+func (pg *Postgres) Healthcheck(ctx context.Context) HealthStatus {
+	var (
+		status HealthStatus
+		one    int
+	)
+
+	start := time.Now()
+	err := pg.connectedDB().QueryRowContext(ctx, `SELECT 1;`).Scan(&one)
+	status.Latency = time.Since(start)
+	if err != nil {
+		status.Err = fmt.Errorf("checking connection: %w", pg.redactError(err))
+		return status
+	}
+
+	if err := pg.connectedDB().QueryRowContext(ctx, `SELECT pg_is_in_recovery();`).Scan(&status.IsReplica); err != nil {
+		status.Err = fmt.Errorf("checking recovery: %w", err)
+		return status
+	}
+
+	if status.IsReplica {
+		// pg_last_xact_replay_timestamp is null until the replica has replayed a transaction
+		var lagSeconds sql.NullFloat64
+		if err := pg.connectedDB().QueryRowContext(ctx, `SELECT EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp());`).Scan(&lagSeconds); err != nil {
+			status.Err = fmt.Errorf("checking replication lag: %w", err)
+			return status
+		}
+		status.ReplicationLag = time.Duration(lagSeconds.Float64 * float64(time.Second))
+	}
+
+	status.Healthy = true
+	return status
+}
+
+// ConnectionInfo is the version and the settings of the server connected to.
+type ConnectionInfo struct {
+	// Version is the version as returned by SELECT version(), which also tells the compatible databases apart.
+	Version string
+	// VersionNum is the server_version_num setting, e.g. 150004 for 15.4.
+	VersionNum     int
+	TimeZone       string
+	MaxConnections int
+	// IsReplica is set if the server is in recovery, e.g. a hot standby.
+	IsReplica bool
+}
+
+// ConnectionInfo returns the version and the settings of the server connected to.
+// They are only queried once per connection, until the connection is replaced, e.g. by Reconnect.
+func (pg *Postgres) ConnectionInfo(ctx context.Context) (ConnectionInfo, error) {
+	if err := pg.ensureConnected(ctx); err != nil {
+		return ConnectionInfo{}, err
+	}
+
+	pg.connectionInfoMu.Lock()
+	defer pg.connectionInfoMu.Unlock()
+
+	db := pg.connectedDB()
+	if pg.connectionInfo != nil && pg.connectionInfoDB == db {
+		return *pg.connectionInfo, nil
+	}
+
+	var info ConnectionInfo
+	err := db.QueryRowContext(ctx, `
+		SELECT
+		  version(),
+		  current_setting('server_version_num')::int,
+		  current_setting('TimeZone'),
+		  current_setting('max_connections')::int,
+		  pg_is_in_recovery();
+	`).Scan(&info.Version, &info.VersionNum, &info.TimeZone, &info.MaxConnections, &info.IsReplica)
+	if err != nil {
+		return ConnectionInfo{}, fmt.Errorf("querying connection info: %w", err)
+	}
+	pg.connectionInfo, pg.connectionInfoDB = &info, db
+	return info, nil
+}
+
+func (pg *Postgres) Setup(_ context.Context, warehouse model.Warehouse, uploader warehouseutils.Uploader) (err error) {
+	pg.Warehouse = warehouse
+	pg.Namespace = pg.resolveNamespace(warehouse)
+	pg.Uploader = uploader
+	pg.ObjectStorage = warehouseutils.ObjectStorageType(warehouseutils.POSTGRES, warehouse.Destination.Config, pg.Uploader.UseRudderStorage())
+
+	if pg.TmpDir != "" {
+		if err = validateTmpDir(pg.TmpDir); err != nil {
+			return err
+		}
+	}
+
+	pg.reconnectMu.Lock()
+	defer pg.reconnectMu.Unlock()
+
+	if pg.DB, err = pg.connect(); err != nil {
+		return err
+	}
+
+	pg.replicaDB, err = pg.connectReplica()
+	return err
+}
+
+// storageRegion returns the region of the object storage holding the load files, if configured for the destination.
+func (pg *Postgres) storageRegion() string {
+	return warehouseutils.GetConfigValue(storageRegion, pg.Warehouse)
+}
+
+// connectionMode returns whether the destination is connected to through the ssh tunnel or directly.
+func (pg *Postgres) connectionMode() string {
+	if warehouseutils.ExtractTunnelInfoFromDestinationConfig(pg.Warehouse.Destination.Config) != nil {
+		return connectionModeTunnel
+	}
+	return connectionModeDirect
+}
+
+// resolveNamespace returns the namespace of the warehouse.
+// If a NamespaceTemplate is configured, every source gets its own namespace derived from its id instead.
+// Warehouses without a source, such as the ones used for validating the destination, keep their namespace.
+func (pg *Postgres) resolveNamespace(warehouse model.Warehouse) string {
+	if pg.NamespaceTemplate == "" || warehouse.Source.ID == "" {
+		return warehouse.Namespace
+	}
+	namespace := strings.ReplaceAll(pg.NamespaceTemplate, sourceIDPlaceholder, warehouse.Source.ID)
+	return misc.TruncateStr(warehouseutils.ToSafeNamespace(provider, namespace), identifierLimit)
+}
+
+func (pg *Postgres) CrashRecover(ctx context.Context) {
+	pg.cleanupDanglingStagingTables(ctx)
+}
+
+// cleanupDanglingStagingTables drops the dangling staging tables, or only reports them with CrashRecoveryReportOnly.
+func (pg *Postgres) cleanupDanglingStagingTables(ctx context.Context) {
+	if pg.CrashRecoveryReportOnly {
+		pg.reportDanglingStagingTables(ctx)
 		return
 	}
+	pg.dropDanglingStagingTables(ctx)
+}
 
-	pg.logger.Infof("PG: Complete load for table:%s", tableName)
-	return
+func (pg *Postgres) dropDanglingStagingTables(ctx context.Context) bool {
+	stagingTableNames, err := pg.danglingStagingTables(ctx)
+	if err != nil {
+		pg.logger.Errorf("WH: PG: Error dropping dangling staging tables in PG: %v\n", err)
+		return false
+	}
+	pg.logger.Infof("WH: PG: Dropping dangling staging tables: %+v  %+v\n", len(stagingTableNames), stagingTableNames)
+	delSuccess := true
+	for _, stagingTableName := range stagingTableNames {
+		_, err := pg.connectedDB().ExecContext(ctx, fmt.Sprintf(`DROP TABLE "%[1]s"."%[2]s"`, pg.Namespace, stagingTableName))
+		if err != nil {
+			pg.logger.Errorf("WH: PG:  Error dropping dangling staging table: %s in PG: %v\n", stagingTableName, err)
+			pg.countStagingTable(stagingTableDropFailed, pg.stagingTableOf(stagingTableName))
+			delSuccess = false
+			continue
+		}
+		pg.countStagingTable(stagingTableDropped, pg.stagingTableOf(stagingTableName))
+	}
+	return delSuccess
 }
 
-// DeleteBy Need to create a structure with delete parameters instead of simply adding a long list of params
-func (pg *Postgres) DeleteBy(ctx context.Context, tableNames []string, params warehouseutils.DeleteByParams) (err error) {
-	pg.logger.Infof("PG: Cleaning up the following tables in postgres for PG:%s : %+v", tableNames, params)
-	for _, tb := range tableNames {
-		sqlStatement := fmt.Sprintf(`DELETE FROM "%[1]s"."%[2]s" WHERE
-		context_sources_job_run_id <> $1 AND
-		context_sources_task_run_id <> $2 AND
-		context_source_id = $3 AND
-		received_at < $4`,
+// reportDanglingStagingTables logs the dangling staging tables dropDanglingStagingTables would drop, keeping them,
+// and reports their number with the pg_dangling_staging_tables gauge.
+func (pg *Postgres) reportDanglingStagingTables(ctx context.Context) bool {
+	stagingTableNames, err := pg.danglingStagingTables(ctx)
+	if err != nil {
+		pg.logger.Errorf("WH: PG: Error listing dangling staging tables in PG: %v\n", err)
+		return false
+	}
+	pg.logger.Infof("WH: PG: Not dropping dangling staging tables in report only mode: %+v  %+v\n", len(stagingTableNames), stagingTableNames)
+	pg.stats.NewTaggedStat("pg_dangling_staging_tables", stats.GaugeType, stats.Tags{
+		"workspaceId":   pg.Warehouse.WorkspaceID,
+		"namespace":     pg.Namespace,
+		"destinationID": pg.Warehouse.Destination.ID,
+	}).Gauge(len(stagingTableNames))
+	return true
+}
+
+// danglingStagingTables returns the names of the staging tables of the namespace which are dangling, see isDanglingStagingTable.
+func (pg *Postgres) danglingStagingTables(ctx context.Context) ([]string, error) {
+	sqlStatement := `
+			SELECT
+			  table_name,
+			  obj_description(format('%I.%I', table_schema, table_name)::regclass, 'pg_class')
+			FROM
+			  information_schema.tables
+			WHERE
+			  table_schema = $1 AND
+			  table_name like $2;
+	`
+	now := time.Now()
+	var stagingTableNames []string
+	err := pg.queryRows(ctx, pg.connectedDB(),
+		sqlStatement,
+		[]interface{}{
 			pg.Namespace,
-			tb,
-		)
-		pg.logger.Infof("PG: Deleting rows in table in postgres for PG:%s", pg.Warehouse.Destination.ID)
-		pg.logger.Debugf("PG: Executing the statement  %v", sqlStatement)
-		if pg.EnableDeleteByJobs {
-			_, err = pg.DB.ExecContext(ctx, sqlStatement,
-				params.JobRunId,
-				params.TaskRunId,
-				params.SourceId,
-				params.StartTime)
-			if err != nil {
-				pg.logger.Errorf("Error %s", err)
-				return err
+			fmt.Sprintf(`%s%%`, warehouseutils.StagingTablePrefix(provider)),
+		},
+		func(rows *sql.Rows) error {
+			var (
+				tableName string
+				comment   sql.NullString
+			)
+			if err := rows.Scan(&tableName, &comment); err != nil {
+				return fmt.Errorf("scan result from query: %s\nwith Error : %w", sqlStatement, err)
+			}
+			if !pg.isDanglingStagingTable(comment, now) {
+				pg.logger.Infof("WH: PG: Keeping staging table %s of another load, commented: %s", tableName, comment.String)
+				return nil
+			}
+			stagingTableNames = append(stagingTableNames, tableName)
+			return nil
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w\nQuery: %s", err, sqlStatement)
+	}
+	return stagingTableNames, nil
+}
+
+// stagingTableComment is the comment of the staging tables, recording when and by whom they were created.
+type stagingTableComment struct {
+	CreatedAt time.Time `json:"created_at"`
+	Owner     string    `json:"owner"`
+}
+
+// commentStagingTable comments the staging table with its stagingTableComment, for dropDanglingStagingTables to tell its age and owner.
+func (pg *Postgres) commentStagingTable(ctx context.Context, txn *sqlmiddleware.Tx, stagingTableName string) error {
+	comment, err := json.Marshal(stagingTableComment{CreatedAt: time.Now().UTC(), Owner: pg.owner})
+	if err != nil {
+		return fmt.Errorf("marshalling comment: %w", err)
+	}
+	_, err = txn.ExecContext(ctx, fmt.Sprintf(`COMMENT ON TABLE "%[1]s"."%[2]s" IS %[3]s`, pg.Namespace, stagingTableName, pq.QuoteLiteral(string(comment))))
+	return err
+}
+
+// isDanglingStagingTable returns whether the staging table with the comment is to be dropped as dangling.
+// Staging tables of other owners are kept until they are older than StagingTableRetention, unless their comment is missing or invalid.
+func (pg *Postgres) isDanglingStagingTable(comment sql.NullString, now time.Time) bool {
+	if pg.StagingTableRetention <= 0 || !comment.Valid {
+		return true
+	}
+	var stagingComment stagingTableComment
+	if err := json.Unmarshal([]byte(comment.String), &stagingComment); err != nil || stagingComment.CreatedAt.IsZero() {
+		return true
+	}
+	if stagingComment.Owner == pg.owner {
+		return true
+	}
+	return now.Sub(stagingComment.CreatedAt) >= pg.StagingTableRetention
+}
+
+// FetchSchema returns the schema of the tables managed by rudder in the namespace, along with the one of the columns of unrecognized types,
+// reading them from the read replica if configured.
+// With SharedSchemaCacheTTL, the columns are taken from the cache shared by the destinations of the process if fetched within the TTL.
+// Otherwise with CacheSchema, the schema is only fetched again if the columns of the namespace changed since the destination cached it.
+func (pg *Postgres) FetchSchema(ctx context.Context) (model.Schema, model.Schema, error) {
+	if pg.SharedSchemaCacheTTL > 0 {
+		return pg.fetchSharedCachedSchema(ctx)
+	}
+	return pg.fetchUncachedSchema(ctx)
+}
+
+// fetchUncachedSchema returns the schema of the namespace, bypassing the shared schema cache.
+func (pg *Postgres) fetchUncachedSchema(ctx context.Context) (model.Schema, model.Schema, error) {
+	if pg.CacheSchema {
+		return pg.fetchCachedSchema(ctx)
+	}
+	return pg.fetchSchema(ctx)
+}
+
+// fetchSchema returns the schema of the namespace, scanning all its columns.
+func (pg *Postgres) fetchSchema(ctx context.Context) (model.Schema, model.Schema, error) {
+	columns, err := pg.fetchSchemaColumns(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	schema, unrecognizedSchema := pg.schemaOf(columns, true)
+	return schema, unrecognizedSchema, nil
+}
+
+// schemaOf returns the schema of the columns of the tables managed by rudder, along with the one of the columns of unrecognized types.
+// The columns of unrecognized types are counted if countMissingDatatypes is set, which is only the case for freshly fetched columns.
+func (pg *Postgres) schemaOf(columns []schemaColumn, countMissingDatatypes bool) (model.Schema, model.Schema) {
+	schema := make(model.Schema)
+	unrecognizedSchema := make(model.Schema)
+
+	pg.mapSchemaColumns(columns, countMissingDatatypes, func(tableName, columnName, datatype string, recognized bool) {
+		if !recognized {
+			if _, ok := unrecognizedSchema[tableName]; !ok {
+				unrecognizedSchema[tableName] = make(model.TableSchema)
 			}
+			unrecognizedSchema[tableName][columnName] = datatype
+			return
+		}
+		if _, ok := schema[tableName]; !ok {
+			schema[tableName] = make(model.TableSchema)
 		}
+		schema[tableName][columnName] = datatype
+	})
+	return schema, unrecognizedSchema
+}
 
+// FetchOrderedSchema returns the columns of the tables in the namespace sorted by their ordinal position, for the callers needing a deterministic column order, e.g. to build binary COPY statements.
+// Columns of unrecognized types are included with the missing datatype, so that the positions of the other columns are preserved.
+// Unlike FetchSchema, the schema cache is never used.
+func (pg *Postgres) FetchOrderedSchema(ctx context.Context) (map[string][]warehouseutils.ColumnInfo, error) {
+	schema := make(map[string][]warehouseutils.ColumnInfo)
+
+	err := pg.scanSchemaColumns(ctx, func(tableName, columnName, datatype string, _ bool) {
+		schema[tableName] = append(schema[tableName], warehouseutils.ColumnInfo{Name: columnName, Type: datatype})
+	})
+	if err != nil {
+		return nil, err
 	}
-	return nil
+
+	return schema, nil
 }
 
-func (pg *Postgres) loadUserTables(ctx context.Context) (errorMap map[string]error) {
-	errorMap = map[string]error{warehouseutils.IdentifiesTable: nil}
-	sqlStatement := fmt.Sprintf(`SET search_path to %q`, pg.Namespace)
-	_, err := pg.DB.ExecContext(ctx, sqlStatement)
+// schemaColumn is a column of a table of the namespace as found in the information schema,
+// before the table and column names and the datatype are mapped for the destination.
+type schemaColumn struct {
+	tableName  string
+	columnName string
+	columnType string
+}
+
+// scanSchemaColumns calls fn for every column of the tables managed by rudder in the namespace, in the ordinal position order of every table.
+// Columns of unrecognized types are reported with the missing datatype.
+func (pg *Postgres) scanSchemaColumns(ctx context.Context, fn func(tableName, columnName, datatype string, recognized bool)) error {
+	columns, err := pg.fetchSchemaColumns(ctx)
 	if err != nil {
-		errorMap[warehouseutils.IdentifiesTable] = err
-		return
+		return err
 	}
-	pg.logger.Infof("PG: Updated search_path to %s in postgres for PG:%s : %v", pg.Namespace, pg.Warehouse.Destination.ID, sqlStatement)
-	pg.logger.Infof("PG: Starting load for identifies and users tables\n")
-	identifyStagingTable, err := pg.loadTable(ctx, warehouseutils.IdentifiesTable, pg.Uploader.GetTableSchemaInUpload(warehouseutils.IdentifiesTable), true)
-	defer pg.dropStagingTable(ctx, identifyStagingTable)
+	pg.mapSchemaColumns(columns, true, fn)
+	return nil
+}
+
+// fetchSchemaColumns returns the columns of the tables in the namespace, staging tables excluded, in the ordinal position order of every table.
+func (pg *Postgres) fetchSchemaColumns(ctx context.Context) ([]schemaColumn, error) {
+	sqlStatement := `
+		SELECT
+		  table_name,
+		  column_name,
+		  data_type
+		FROM
+		  INFORMATION_SCHEMA.COLUMNS
+		WHERE
+		  table_schema = $1
+		  AND table_name NOT LIKE $2
+		ORDER BY
+		  table_name,
+		  ordinal_position;
+	`
+	var columns []schemaColumn
+	err := pg.queryReadDB(ctx, func(db *sqlmiddleware.DB) error {
+		columns = nil
+
+		return pg.queryRows(
+			ctx,
+			db,
+			sqlStatement,
+			[]interface{}{
+				pg.Namespace,
+				fmt.Sprintf(`%s%%`, warehouseutils.StagingTablePrefix(provider)),
+			},
+			func(rows *sql.Rows) error {
+				var column schemaColumn
+				if err := rows.Scan(&column.tableName, &column.columnName, &column.columnType); err != nil {
+					return fmt.Errorf("scanning schema: %w", err)
+				}
+				columns = append(columns, column)
+				return nil
+			},
+		)
+	})
 	if err != nil {
-		errorMap[warehouseutils.IdentifiesTable] = err
-		return
+		return nil, fmt.Errorf("fetching schema: %w", err)
 	}
+	return columns, nil
+}
 
-	if len(pg.Uploader.GetTableSchemaInUpload(warehouseutils.UsersTable)) == 0 {
-		return
-	}
-	errorMap[warehouseutils.UsersTable] = nil
+// mapSchemaColumns calls fn for every column of the tables managed by rudder, with the table and column names and the datatype of the destination.
+// The columns of unrecognized types are counted if countMissingDatatypes is set.
+func (pg *Postgres) mapSchemaColumns(columns []schemaColumn, countMissingDatatypes bool, fn func(tableName, columnName, datatype string, recognized bool)) {
+	for _, column := range columns {
+		// tables without the configured prefix and suffix are not managed by rudder
+		tableName, ok := pg.unqualifiedTableName(column.tableName)
+		if !ok {
+			continue
+		}
+		tableName, columnName := pg.foldIdentifier(tableName), pg.foldIdentifier(column.columnName)
 
-	if pg.SkipComputingUserLatestTraits || slices.Contains(pg.SkipComputingUserLatestTraitsWorkspaceIDs, pg.Warehouse.WorkspaceID) {
-		_, err := pg.loadTable(ctx, warehouseutils.UsersTable, pg.Uploader.GetTableSchemaInUpload(warehouseutils.UsersTable), false)
-		if err != nil {
-			errorMap[warehouseutils.UsersTable] = err
+		datatype, ok := pg.rudderDataType(column.columnType)
+		if !ok {
+			datatype = warehouseutils.MISSING_DATATYPE
+
+			if countMissingDatatypes {
+				warehouseutils.WHCounterStat(warehouseutils.RUDDER_MISSING_DATATYPE, &pg.Warehouse, warehouseutils.Tag{Name: "datatype", Value: column.columnType}).Count(1)
+			}
 		}
-		return
+		fn(tableName, columnName, datatype, ok)
 	}
+}
 
-	unionStagingTableName := warehouseutils.StagingTableName(provider, "users_identifies_union", tableNameLimit)
-	stagingTableName := warehouseutils.StagingTableName(provider, warehouseutils.UsersTable, tableNameLimit)
-	defer pg.dropStagingTable(ctx, stagingTableName)
-	defer pg.dropStagingTable(ctx, unionStagingTableName)
+// ListTables returns the names of the tables in the namespace, without fetching their columns.
+// Staging tables and tables without the configured prefix and suffix are excluded.
+func (pg *Postgres) ListTables(ctx context.Context) ([]string, error) {
+	sqlStatement := `
+		SELECT
+		  table_name
+		FROM
+		  INFORMATION_SCHEMA.TABLES
+		WHERE
+		  table_schema = $1
+		  AND table_type = 'BASE TABLE'
+		  AND table_name NOT LIKE $2
+		ORDER BY
+		  table_name;
+	`
+	var tableNames []string
+	err := pg.queryReadDB(ctx, func(db *sqlmiddleware.DB) error {
+		tableNames = nil
 
-	userColMap := pg.Uploader.GetTableSchemaInWarehouse(warehouseutils.UsersTable)
-	var userColNames, firstValProps []string
-	for colName := range userColMap {
-		if colName == "id" {
-			continue
+		rows, err := db.QueryContext(
+			ctx,
+			sqlStatement,
+			pg.Namespace,
+			fmt.Sprintf(`%s%%`, warehouseutils.StagingTablePrefix(provider)),
+		)
+		if err != nil {
+			return fmt.Errorf("listing tables: %w", err)
 		}
-		userColNames = append(userColNames, fmt.Sprintf(`%q`, colName))
-		caseSubQuery := fmt.Sprintf(`case
-						  when (select true) then (
-						  	select "%[1]s" from "%[3]s"."%[2]s" as staging_table
-						  	where x.id = staging_table.id
-							  and "%[1]s" is not null
-							  order by received_at desc
-						  	limit 1)
-						  end as "%[1]s"`, colName, unionStagingTableName, pg.Namespace)
-		firstValProps = append(firstValProps, caseSubQuery)
-	}
+		defer func() { _ = rows.Close() }()
 
-	sqlStatement = fmt.Sprintf(`CREATE TABLE "%[1]s".%[5]s as (
-												(
-													SELECT id, %[4]s FROM "%[1]s"."%[2]s" WHERE id in (SELECT user_id FROM "%[1]s"."%[3]s" WHERE user_id IS NOT NULL)
-												) UNION
-												(
-													SELECT user_id, %[4]s FROM "%[1]s"."%[3]s"  WHERE user_id IS NOT NULL
-												)
-											)`, pg.Namespace, warehouseutils.UsersTable, identifyStagingTable, strings.Join(userColNames, ","), unionStagingTableName)
+		for rows.Next() {
+			var tableName string
+			if err := rows.Scan(&tableName); err != nil {
+				return fmt.Errorf("scanning tables: %w", err)
+			}
 
-	pg.logger.Infof("PG: Creating staging table for union of users table with identify staging table: %s\n", sqlStatement)
-	_, err = pg.DB.ExecContext(ctx, sqlStatement)
+			var ok bool
+			if tableName, ok = pg.unqualifiedTableName(tableName); !ok {
+				continue
+			}
+			tableNames = append(tableNames, tableName)
+		}
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("listing tables: %w", err)
+		}
+		return nil
+	})
 	if err != nil {
-		errorMap[warehouseutils.UsersTable] = err
-		return
+		return nil, err
 	}
+	return tableNames, nil
+}
 
-	sqlStatement = fmt.Sprintf(`CREATE TABLE %[4]s.%[1]s AS (SELECT DISTINCT * FROM
-										(
-											SELECT
-											x.id, %[2]s
-											FROM %[4]s.%[3]s as x
-										) as xyz
-									)`,
-		stagingTableName,
-		strings.Join(firstValProps, ","),
-		unionStagingTableName,
-		pg.Namespace,
-	)
+// loadPrivileges are the privileges on a table required for loading into it.
+var loadPrivileges = []string{"DELETE", "INSERT", "SELECT"}
 
-	pg.logger.Debugf("PG: Creating staging table for users: %s\n", sqlStatement)
-	_, err = pg.DB.ExecContext(ctx, sqlStatement)
-	if err != nil {
-		errorMap[warehouseutils.UsersTable] = err
-		return
+// CheckGrants returns an InsufficientPrivilegesError if the connected user lacks any of the privileges required for loading into the tables,
+// so that revoked grants can be reported before a load fails on them. Tables which do not exist lack all the privileges.
+func (pg *Postgres) CheckGrants(ctx context.Context, tableNames []string) error {
+	qualifiedTableNames := make([]string, 0, len(tableNames))
+	for _, tableName := range tableNames {
+		qualifiedTableNames = append(qualifiedTableNames, pg.tableName(tableName))
 	}
 
-	// BEGIN TRANSACTION
-	tx, err := pg.DB.BeginTx(ctx, &sql.TxOptions{})
+	sqlStatement := `
+		SELECT
+		  table_name,
+		  privilege_type
+		FROM
+		  information_schema.role_table_grants
+		WHERE
+		  table_schema = $1
+		  AND table_name = ANY($2)
+		  AND (
+		    grantee = 'PUBLIC'
+		    OR grantee IN (
+		      SELECT
+		        role_name
+		      FROM
+		        information_schema.enabled_roles
+		    )
+		  );
+	`
+	rows, err := pg.connectedDB().QueryContext(ctx, sqlStatement, pg.Namespace, pq.Array(qualifiedTableNames))
 	if err != nil {
-		errorMap[warehouseutils.UsersTable] = err
-		return
+		return fmt.Errorf("querying grants: %w", err)
 	}
+	defer func() { _ = rows.Close() }()
 
-	primaryKey := "id"
-	sqlStatement = fmt.Sprintf(`DELETE FROM "%[1]s"."%[2]s" using "%[1]s"."%[3]s" _source where (_source.%[4]s = %[1]s.%[2]s.%[4]s)`, pg.Namespace, warehouseutils.UsersTable, stagingTableName, primaryKey)
-	pg.logger.Infof("PG: Dedup records for table:%s using staging table: %s\n", warehouseutils.UsersTable, sqlStatement)
-	// tags
-	tags := stats.Tags{
-		"workspaceId": pg.Warehouse.WorkspaceID,
-		"destId":      pg.Warehouse.Destination.ID,
-		"tableName":   warehouseutils.UsersTable,
+	granted := make(map[string]map[string]bool)
+	for rows.Next() {
+		var tableName, privilege string
+		if err := rows.Scan(&tableName, &privilege); err != nil {
+			return fmt.Errorf("scanning grants: %w", err)
+		}
+		if granted[tableName] == nil {
+			granted[tableName] = make(map[string]bool)
+		}
+		granted[tableName][privilege] = true
 	}
-	err = pg.handleExecContext(ctx, &QueryParams{
-		txn:                 tx,
-		query:               sqlStatement,
-		enableWithQueryPlan: pg.EnableSQLStatementExecutionPlan || slices.Contains(pg.EnableSQLStatementExecutionPlanWorkspaceIDs, pg.Warehouse.WorkspaceID),
-	})
-	if err != nil {
-		pg.logger.Errorf("PG: Error deleting from original table for dedup: %v\n", err)
-		tags["stage"] = deleteDedup
-		pg.runRollbackWithTimeout(tx.Rollback, handleRollbackTimeout, pg.TxnRollbackTimeout, tags)
-		errorMap[warehouseutils.UsersTable] = err
-		return
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("iterating grants: %w", err)
 	}
 
-	sqlStatement = fmt.Sprintf(`INSERT INTO "%[1]s"."%[2]s" (%[4]s) SELECT %[4]s FROM  "%[1]s"."%[3]s"`, pg.Namespace, warehouseutils.UsersTable, stagingTableName, strings.Join(append([]string{"id"}, userColNames...), ","))
-	pg.logger.Infof("PG: Inserting records for table:%s using staging table: %s\n", warehouseutils.UsersTable, sqlStatement)
-	err = pg.handleExecContext(ctx, &QueryParams{
-		txn:                 tx,
-		query:               sqlStatement,
-		enableWithQueryPlan: pg.EnableSQLStatementExecutionPlan || slices.Contains(pg.EnableSQLStatementExecutionPlanWorkspaceIDs, pg.Warehouse.WorkspaceID),
-	})
-
-	if err != nil {
-		pg.logger.Errorf("PG: Error inserting into users table from staging table: %v\n", err)
-		tags["stage"] = insertDedup
-		pg.runRollbackWithTimeout(tx.Rollback, handleRollbackTimeout, pg.TxnRollbackTimeout, tags)
-		errorMap[warehouseutils.UsersTable] = err
-		return
+	missingPrivileges := make(map[string][]string)
+	for i, tableName := range tableNames {
+		for _, privilege := range loadPrivileges {
+			if !granted[qualifiedTableNames[i]][privilege] {
+				missingPrivileges[tableName] = append(missingPrivileges[tableName], privilege)
+			}
+		}
 	}
-
-	err = tx.Commit()
-	if err != nil {
-		pg.logger.Errorf("PG: Error in transaction commit for users table: %v\n", err)
-		tags["stage"] = dedupStage
-		pg.runRollbackWithTimeout(tx.Rollback, handleRollbackTimeout, pg.TxnRollbackTimeout, tags)
-		errorMap[warehouseutils.UsersTable] = err
-		return
+	if len(missingPrivileges) > 0 {
+		return &InsufficientPrivilegesError{Namespace: pg.Namespace, MissingPrivileges: missingPrivileges}
 	}
-	return
-}
-
-func (pg *Postgres) schemaExists(ctx context.Context) (exists bool, err error) {
-	sqlStatement := fmt.Sprintf(`SELECT EXISTS (SELECT 1 FROM pg_catalog.pg_namespace WHERE nspname = '%s');`, pg.Namespace)
-	err = pg.DB.QueryRowContext(ctx, sqlStatement).Scan(&exists)
-	return
+	return nil
 }
 
-func (pg *Postgres) CreateSchema(ctx context.Context) (err error) {
-	var schemaExists bool
-	schemaExists, err = pg.schemaExists(ctx)
-	if err != nil {
-		pg.logger.Errorf("PG: Error checking if schema: %s exists: %v", pg.Namespace, err)
-		return err
-	}
-	if schemaExists {
-		pg.logger.Infof("PG: Skipping creating schema: %s since it already exists", pg.Namespace)
-		return
+func (pg *Postgres) LoadUserTables(ctx context.Context) map[string]error {
+	if err := pg.ensureConnected(ctx); err != nil {
+		return map[string]error{warehouseutils.IdentifiesTable: err}
 	}
-	sqlStatement := fmt.Sprintf(`CREATE SCHEMA IF NOT EXISTS %q`, pg.Namespace)
-	pg.logger.Infof("PG: Creating schema name in postgres for PG:%s : %v", pg.Warehouse.Destination.ID, sqlStatement)
-	_, err = pg.DB.ExecContext(ctx, sqlStatement)
-	return
-}
 
-func (pg *Postgres) dropStagingTable(ctx context.Context, stagingTableName string) {
-	pg.logger.Infof("PG: dropping table %+v\n", stagingTableName)
-	_, err := pg.DB.ExecContext(ctx, fmt.Sprintf(`DROP TABLE IF EXISTS "%[1]s"."%[2]s"`, pg.Namespace, stagingTableName))
-	if err != nil {
-		pg.logger.Errorf("PG:  Error dropping staging table %s in postgres: %v", stagingTableName, err)
+	errorMap := pg.loadUserTablesRetryingSerializationFailures(ctx)
+	for _, err := range errorMap {
+		if isBadConnection(err) {
+			pg.logger.Warnf("PG: Bad connection while loading identifies and users tables, retrying with a fresh connection: %v", err)
+			if err := pg.prepareRetry(ctx, warehouseutils.IdentifiesTable, warehouseutils.UsersTable, usersIdentifiesUnion); err != nil {
+				return map[string]error{warehouseutils.IdentifiesTable: err}
+			}
+			return pg.loadUserTablesRetryingSerializationFailures(ctx)
+		}
 	}
+	return errorMap
 }
 
-func (pg *Postgres) createTable(ctx context.Context, name string, columns model.TableSchema) (err error) {
-	sqlStatement := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS "%[1]s"."%[2]s" ( %v )`, pg.Namespace, name, ColumnsWithDataTypes(columns, ""))
-	pg.logger.Infof("PG: Creating table in postgres for PG:%s : %v", pg.Warehouse.Destination.ID, sqlStatement)
-	_, err = pg.DB.ExecContext(ctx, sqlStatement)
-	return
+// loadUserTablesRetryingSerializationFailures loads the identifies and users tables, retrying the whole load up to SerializationFailureRetries times
+// when one of their transactions could not be serialized with the concurrent ones. Reloading the committed identifies is deduplicated.
+func (pg *Postgres) loadUserTablesRetryingSerializationFailures(ctx context.Context) map[string]error {
+	var errorMap map[string]error
+	_ = pg.retryConflicts(ctx, warehouseutils.UsersTable, func() error {
+		errorMap = pg.loadUserTables(ctx)
+		for _, err := range errorMap {
+			if isSerializationFailure(err) {
+				return err
+			}
+		}
+		return nil
+	}, warehouseutils.IdentifiesTable, warehouseutils.UsersTable, usersIdentifiesUnion)
+	return errorMap
 }
 
-func (pg *Postgres) CreateTable(ctx context.Context, tableName string, columnMap model.TableSchema) (err error) {
-	// set the schema in search path. so that we can query table with unqualified name which is just the table name rather than using schema.table in queries
-	sqlStatement := fmt.Sprintf(`SET search_path to %q`, pg.Namespace)
-	_, err = pg.DB.ExecContext(ctx, sqlStatement)
-	if err != nil {
-		return err
-	}
-	pg.logger.Infof("PG: Updated search_path to %s in postgres for PG:%s : %v", pg.Namespace, pg.Warehouse.Destination.ID, sqlStatement)
-	err = pg.createTable(ctx, tableName, columnMap)
-	return err
+// LoadTables loads the tables, with at most TableLoadConcurrency of them loading at once, returning the error of loading every table.
+// The identifies and users tables are loaded together with LoadUserTables, as the users table is merged from the identifies one.
+func (pg *Postgres) LoadTables(ctx context.Context, tableNames []string) map[string]error {
+	return loadTables(ctx, tableNames, pg.TableLoadConcurrency, pg.LoadTable, pg.LoadUserTables)
 }
 
-func (pg *Postgres) DropTable(ctx context.Context, tableName string) (err error) {
-	sqlStatement := `DROP TABLE "%[1]s"."%[2]s"`
-	pg.logger.Infof("PG: Dropping table in postgres for PG:%s : %v", pg.Warehouse.Destination.ID, sqlStatement)
-	_, err = pg.DB.ExecContext(ctx, fmt.Sprintf(sqlStatement, pg.Namespace, tableName))
-	return
-}
+// loadTables loads the independent tables with loadTable and the user tables with loadUserTables, with at most concurrency loads running at once.
+// Tables are loaded independently of each other, so a failing load doesn't stop the others.
+func loadTables(ctx context.Context, tableNames []string, concurrency int, loadTable func(context.Context, string) error, loadUserTables func(context.Context) map[string]error) map[string]error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
 
-func (pg *Postgres) AddColumns(ctx context.Context, tableName string, columnsInfo []warehouseutils.ColumnInfo) (err error) {
 	var (
-		query        string
-		queryBuilder strings.Builder
+		errorMap   = make(map[string]error, len(tableNames))
+		errorMapMu sync.Mutex
+		userTables bool
 	)
+	g := new(errgroup.Group)
+	g.SetLimit(concurrency)
+	for _, tableName := range tableNames {
+		tableName := tableName
 
-	// set the schema in search path. so that we can query table with unqualified name which is just the table name rather than using schema.table in queries
-	query = fmt.Sprintf(`SET search_path to %q`, pg.Namespace)
-	if _, err = pg.DB.ExecContext(ctx, query); err != nil {
-		return
+		if tableName == warehouseutils.IdentifiesTable || tableName == warehouseutils.UsersTable {
+			if !userTables {
+				userTables = true
+				g.Go(func() error {
+					userErrorMap := loadUserTables(ctx)
+
+					errorMapMu.Lock()
+					defer errorMapMu.Unlock()
+					for userTableName, err := range userErrorMap {
+						errorMap[userTableName] = err
+					}
+					return nil
+				})
+			}
+			continue
+		}
+		g.Go(func() error {
+			err := loadTable(ctx, tableName)
+
+			errorMapMu.Lock()
+			defer errorMapMu.Unlock()
+			errorMap[tableName] = err
+			return nil
+		})
 	}
-	pg.logger.Infof("PG: Updated search_path to %s in postgres for PG:%s : %v", pg.Namespace, pg.Warehouse.Destination.ID, query)
+	_ = g.Wait()
+	return errorMap
+}
 
-	queryBuilder.WriteString(fmt.Sprintf(`
-		ALTER TABLE
-		  %s.%s`,
-		pg.Namespace,
-		tableName,
-	))
+func (pg *Postgres) LoadTable(ctx context.Context, tableName string) error {
+	_, err := pg.LoadTableWithStats(ctx, tableName)
+	return err
+}
 
-	for _, columnInfo := range columnsInfo {
-		queryBuilder.WriteString(fmt.Sprintf(` ADD COLUMN IF NOT EXISTS %q %s,`, columnInfo.Name, rudderDataTypesMapToPostgres[columnInfo.Type]))
+// LoadTableWithStats loads the table like LoadTable, returning the counts of the load.
+func (pg *Postgres) LoadTableWithStats(ctx context.Context, tableName string) (LoadStats, error) {
+	if err := pg.ensureConnected(ctx); err != nil {
+		return LoadStats{}, err
 	}
 
-	query = strings.TrimSuffix(queryBuilder.String(), ",")
-	query += ";"
+	loadStats, err := pg.loadTableRetryingConflicts(ctx, tableName)
+	if !isBadConnection(err) {
+		return loadStats, err
+	}
 
-	pg.logger.Infof("PG: Adding columns for destinationID: %s, tableName: %s with query: %v", pg.Warehouse.Destination.ID, tableName, query)
-	_, err = pg.DB.ExecContext(ctx, query)
-	return
+	pg.logger.Warnf("PG: Bad connection while loading table:%s, retrying with a fresh connection: %v", tableName, err)
+	if err := pg.prepareRetry(ctx, tableName); err != nil {
+		return LoadStats{}, err
+	}
+	return pg.loadTableRetryingConflicts(ctx, tableName)
 }
 
-func (*Postgres) AlterColumn(context.Context, string, string, string) (model.AlterTableResponse, error) {
-	return model.AlterTableResponse{}, nil
+// loadTableRetryingConflicts loads the table, retrying the whole load with a jittered exponential backoff when its transaction got aborted
+// because of a concurrent one: up to DeadlockRetries times to resolve a deadlock, e.g. with a concurrent load deduplicating against a related table,
+// and up to SerializationFailureRetries times when it could not be serialized, under the repeatable read and serializable isolation levels.
+func (pg *Postgres) loadTableRetryingConflicts(ctx context.Context, tableName string) (LoadStats, error) {
+	var loadStats LoadStats
+	err := pg.retryConflicts(ctx, tableName, func() error {
+		var err error
+		_, loadStats, err = pg.loadTable(ctx, tableName, pg.Uploader.GetTableSchemaInUpload(tableName), false)
+		return err
+	}, tableName)
+	if err != nil {
+		return LoadStats{}, err
+	}
+	return loadStats, nil
 }
 
-func (pg *Postgres) TestConnection(ctx context.Context, warehouse model.Warehouse) error {
-	if warehouse.Destination.Config["sslMode"] == verifyCA {
-		if sslKeyError := warehouseutils.WriteSSLKeys(warehouse.Destination); sslKeyError.IsError() {
-			return fmt.Errorf("writing ssl keys: %s", sslKeyError.Error())
+// retryConflicts runs the load, retrying it when its transaction got aborted because of a concurrent one, see loadTableRetryingConflicts.
+// The staging tables of the tables left over by the aborted load are dropped before retrying.
+func (pg *Postgres) retryConflicts(ctx context.Context, statsTableName string, load func() error, tableNames ...string) error {
+	b := backoff.NewExponentialBackOff()
+	b.InitialInterval = pg.DeadlockRetryInterval
+	b.MaxElapsedTime = 0
+
+	var (
+		retrying                         bool
+		deadlocks, serializationFailures int
+	)
+	operation := func() error {
+		if retrying {
+			if err := pg.dropStagingTablesOf(ctx, tableNames...); err != nil {
+				return backoff.Permanent(fmt.Errorf("dropping staging tables of the aborted load: %w", err))
+			}
 		}
-	}
+		retrying = true
 
-	err := pg.DB.PingContext(ctx)
-	if errors.Is(err, context.DeadlineExceeded) {
-		return fmt.Errorf("connection timeout: %w", err)
-	}
-	if err != nil {
-		return fmt.Errorf("pinging: %w", err)
+		err := load()
+		switch {
+		case err == nil:
+			return nil
+		case isDeadlock(err):
+			if deadlocks++; deadlocks > pg.DeadlockRetries {
+				return backoff.Permanent(err)
+			}
+			return err
+		case isSerializationFailure(err):
+			if serializationFailures++; serializationFailures > pg.SerializationFailureRetries {
+				return backoff.Permanent(err)
+			}
+			return err
+		default:
+			return backoff.Permanent(err)
+		}
 	}
+	return backoff.RetryNotify(
+		operation,
+		backoff.WithContext(b, ctx),
+		func(err error, d time.Duration) {
+			statName, reason := "pg_deadlock_retries", "Deadlock"
+			if isSerializationFailure(err) {
+				statName, reason = "pg_serialization_failure_retries", "Serialization failure"
+			}
+			pg.logger.Warnf("PG: %s while loading table:%s, retrying in %v: %v", reason, statsTableName, d, err)
+			pg.stats.NewTaggedStat(statName, stats.CountType, stats.Tags{
+				"workspaceId":   pg.Warehouse.WorkspaceID,
+				"namespace":     pg.Namespace,
+				"destinationID": pg.Warehouse.Destination.ID,
+				"tableName":     statsTableName,
+			}).Count(1)
+		},
+	)
+}
 
-	return nil
+// isBadConnection returns true if the error is caused by a connection dropped by the server, e.g. after a restart.
+func isBadConnection(err error) bool {
+	return errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone)
 }
 
-func (pg *Postgres) Setup(_ context.Context, warehouse model.Warehouse, uploader warehouseutils.Uploader) (err error) {
-	pg.Warehouse = warehouse
-	pg.Namespace = warehouse.Namespace
-	pg.Uploader = uploader
-	pg.ObjectStorage = warehouseutils.ObjectStorageType(warehouseutils.POSTGRES, warehouse.Destination.Config, pg.Uploader.UseRudderStorage())
+// isConnectionError returns true if the error is caused by the connection to the server rather than by the query,
+// i.e. a bad connection, a network error or a postgres error of the connection exception class.
+func isConnectionError(err error) bool {
+	var (
+		netErr net.Error
+		pqErr  *pq.Error
+	)
+	return isBadConnection(err) || errors.As(err, &netErr) || errors.As(err, &pqErr) && pqErr.Code.Class() == connectionExceptionClass
+}
 
-	pg.DB, err = pg.connect()
-	return err
+// isDeadlock returns true if the error is caused by postgres aborting the transaction to resolve a deadlock.
+func isDeadlock(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == deadlockDetectedCode
+}
+
+// isSerializationFailure returns true if the error is caused by postgres aborting the transaction as it could not be serialized with the concurrent ones.
+func isSerializationFailure(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == serializationFailureCode
+}
+
+// prepareRetry reconnects and drops the staging tables left behind by the failed load of the tables.
+// With TableLoadConcurrency, the connection pools are shared with the concurrent loads, which would fail once they are closed,
+// so they are kept instead: database/sql discards the bad connections and opens new ones for the retry.
+func (pg *Postgres) prepareRetry(ctx context.Context, tableNames ...string) error {
+	if pg.TableLoadConcurrency <= 1 {
+		if err := pg.Reconnect(ctx); err != nil {
+			return err
+		}
+	}
+	if err := pg.dropStagingTablesOf(ctx, tableNames...); err != nil {
+		return fmt.Errorf("dropping staging tables of the failed load: %w", err)
+	}
+	return nil
 }
 
-func (pg *Postgres) CrashRecover(ctx context.Context) {
-	pg.dropDanglingStagingTables(ctx)
+// Reconnect replaces the connection pools with fresh ones, closing the previous ones if any.
+// It allows reusing the instance after Cleanup.
+// The queries and transactions already running on the previous pools complete, as database/sql only closes their connections once released.
+func (pg *Postgres) Reconnect(_ context.Context) error {
+	pg.reconnectMu.Lock()
+	defer pg.reconnectMu.Unlock()
+
+	return pg.reconnect()
 }
 
-func (pg *Postgres) dropDanglingStagingTables(ctx context.Context) bool {
-	sqlStatement := `
-			SELECT
-			  table_name
-			FROM
-			  information_schema.tables
-			WHERE
-			  table_schema = $1 AND
-			  table_name like $2;
-	`
-	rows, err := pg.DB.QueryContext(ctx,
-		sqlStatement,
-		pg.Namespace,
-		fmt.Sprintf(`%s%%`, warehouseutils.StagingTablePrefix(provider)),
-	)
+func (pg *Postgres) reconnect() error {
+	db, err := pg.connect()
 	if err != nil {
-		pg.logger.Errorf("WH: PG: Error dropping dangling staging tables in PG: %v\nQuery: %s\n", err, sqlStatement)
-		return false
+		return fmt.Errorf("reconnecting: %w", err)
 	}
-	defer func() { _ = rows.Close() }()
-
-	var stagingTableNames []string
-	for rows.Next() {
-		var tableName string
-		err := rows.Scan(&tableName)
-		if err != nil {
-			panic(fmt.Errorf("scan result from query: %s\nwith Error : %w", sqlStatement, err))
-		}
-		stagingTableNames = append(stagingTableNames, tableName)
+	replicaDB, err := pg.connectReplica()
+	if err != nil {
+		_ = db.Close()
+		return fmt.Errorf("reconnecting to read replica: %w", err)
 	}
-	if err := rows.Err(); err != nil {
-		panic(fmt.Errorf("iterate result from query: %s\nwith Error : %w", sqlStatement, err))
+
+	previousDB, previousReplicaDB := pg.DB, pg.replicaDB
+	pg.DB, pg.replicaDB, pg.closed = db, replicaDB, false
+	if previousDB != nil {
+		_ = previousDB.Close()
 	}
-	pg.logger.Infof("WH: PG: Dropping dangling staging tables: %+v  %+v\n", len(stagingTableNames), stagingTableNames)
-	delSuccess := true
-	for _, stagingTableName := range stagingTableNames {
-		_, err := pg.DB.ExecContext(ctx, fmt.Sprintf(`DROP TABLE "%[1]s"."%[2]s"`, pg.Namespace, stagingTableName))
-		if err != nil {
-			pg.logger.Errorf("WH: PG:  Error dropping dangling staging table: %s in PG: %v\n", stagingTableName, err)
-			delSuccess = false
-		}
+	if previousReplicaDB != nil {
+		_ = previousReplicaDB.Close()
 	}
-	return delSuccess
+	return nil
 }
 
-// FetchSchema queries postgres and returns the schema associated with provided namespace
-func (pg *Postgres) FetchSchema(ctx context.Context) (model.Schema, model.Schema, error) {
-	schema := make(model.Schema)
-	unrecognizedSchema := make(model.Schema)
+// ensureConnected reconnects if there is no connection pool or if it was closed by Cleanup.
+func (pg *Postgres) ensureConnected(_ context.Context) error {
+	pg.reconnectMu.Lock()
+	defer pg.reconnectMu.Unlock()
 
-	sqlStatement := `
-		SELECT
-		  table_name,
-		  column_name,
-		  data_type
-		FROM
-		  INFORMATION_SCHEMA.COLUMNS
-		WHERE
-		  table_schema = $1
-		  AND table_name NOT LIKE $2;
-	`
-	rows, err := pg.DB.QueryContext(
-		ctx,
-		sqlStatement,
-		pg.Namespace,
-		fmt.Sprintf(`%s%%`, warehouseutils.StagingTablePrefix(provider)),
-	)
-	if errors.Is(err, sql.ErrNoRows) {
-		return schema, unrecognizedSchema, nil
+	if pg.DB != nil && !pg.closed {
+		return nil
 	}
-	if err != nil {
-		return nil, nil, fmt.Errorf("fetching schema: %w", err)
+	pg.logger.Infof("PG: Reconnecting for PG:%s since the connection is closed", pg.Warehouse.Destination.ID)
+	return pg.reconnect()
+}
+
+// newTrackedStagingTableName returns a new name for a staging table of the table like newStagingTableName,
+// recording it for dropStagingTablesOf to drop it if the load creating it fails.
+func (pg *Postgres) newTrackedStagingTableName(tableName, targetTableName string) string {
+	stagingTableName := newStagingTableName(targetTableName)
+
+	pg.stagingTablesMu.Lock()
+	defer pg.stagingTablesMu.Unlock()
+	if pg.stagingTables == nil {
+		pg.stagingTables = make(map[string]string)
 	}
-	defer func() { _ = rows.Close() }()
+	pg.stagingTables[stagingTableName] = tableName
+	return stagingTableName
+}
 
-	for rows.Next() {
-		var tableName, columnName, columnType string
+// forgetStagingTable stops recording the staging table once it is dropped.
+func (pg *Postgres) forgetStagingTable(stagingTableName string) {
+	pg.stagingTablesMu.Lock()
+	defer pg.stagingTablesMu.Unlock()
+	delete(pg.stagingTables, stagingTableName)
+}
+
+// createdStagingTablesOf returns the staging tables created by this instance for loading the tables which weren't dropped yet, along with their table.
+func (pg *Postgres) createdStagingTablesOf(tableNames ...string) map[string]string {
+	pg.stagingTablesMu.Lock()
+	defer pg.stagingTablesMu.Unlock()
 
-		if err := rows.Scan(&tableName, &columnName, &columnType); err != nil {
-			return nil, nil, fmt.Errorf("scanning schema: %w", err)
+	stagingTables := make(map[string]string)
+	for stagingTableName, tableName := range pg.stagingTables {
+		if slices.Contains(tableNames, tableName) {
+			stagingTables[stagingTableName] = tableName
 		}
+	}
+	return stagingTables
+}
 
-		if _, ok := schema[tableName]; !ok {
-			schema[tableName] = make(model.TableSchema)
+// dropStagingTablesOf drops the staging tables created by this instance for loading the tables, left behind by their failed loads.
+// Only the staging tables created by the failed loads are dropped, leaving alone the ones of the concurrent loads, of this instance or others.
+func (pg *Postgres) dropStagingTablesOf(ctx context.Context, tableNames ...string) error {
+	for stagingTableName, tableName := range pg.createdStagingTablesOf(tableNames...) {
+		if _, err := pg.connectedDB().ExecContext(ctx, fmt.Sprintf(`DROP TABLE IF EXISTS "%[1]s"."%[2]s"`, pg.Namespace, stagingTableName)); err != nil {
+			pg.countStagingTable(stagingTableDropFailed, tableName)
+			return fmt.Errorf("dropping staging table %s: %w", stagingTableName, err)
 		}
-		if datatype, ok := postgresDataTypesMapToRudder[columnType]; ok {
-			schema[tableName][columnName] = datatype
-		} else {
-			if _, ok := unrecognizedSchema[tableName]; !ok {
-				unrecognizedSchema[tableName] = make(model.TableSchema)
-			}
-			unrecognizedSchema[tableName][columnName] = warehouseutils.MISSING_DATATYPE
+		pg.forgetStagingTable(stagingTableName)
+		pg.countStagingTable(stagingTableDropped, tableName)
+	}
+	return nil
+}
 
-			warehouseutils.WHCounterStat(warehouseutils.RUDDER_MISSING_DATATYPE, &pg.Warehouse, warehouseutils.Tag{Name: "datatype", Value: columnType}).Count(1)
+// LoadTableFromFiles loads the table from gzipped csv load files already present on disk, skipping the download step.
+// The files are left untouched after the load.
+func (pg *Postgres) LoadTableFromFiles(ctx context.Context, tableName string, filePaths []string) error {
+	for _, filePath := range filePaths {
+		if err := checkReadableFile(filePath); err != nil {
+			return fmt.Errorf("validating load file: %w", err)
 		}
 	}
-	if err := rows.Err(); err != nil {
-		return nil, nil, fmt.Errorf("fetching schema: %w", err)
+
+	if err := pg.ensureConnected(ctx); err != nil {
+		return err
 	}
 
-	return schema, unrecognizedSchema, nil
+	var loadID string
+	if pg.EnableLoadIDColumn {
+		loadID = loadIDOf(filePaths)
+	}
+	_, err := pg.loadTableFromFilesInBatches(ctx, tableName, pg.Uploader.GetTableSchemaInUpload(tableName), filePaths, loadID)
+	return err
 }
 
-func (pg *Postgres) LoadUserTables(ctx context.Context) map[string]error {
-	return pg.loadUserTables(ctx)
+func checkReadableFile(filePath string) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%s is a directory", filePath)
+	}
+	return nil
 }
 
-func (pg *Postgres) LoadTable(ctx context.Context, tableName string) error {
-	_, err := pg.loadTable(ctx, tableName, pg.Uploader.GetTableSchemaInUpload(tableName), false)
-	return err
+// connectedDB returns the connection pool, which Reconnect and Cleanup replace and close concurrently with the loads.
+func (pg *Postgres) connectedDB() *sqlmiddleware.DB {
+	pg.reconnectMu.RLock()
+	defer pg.reconnectMu.RUnlock()
+	return pg.DB
 }
 
 func (pg *Postgres) Cleanup(ctx context.Context) {
+	pg.unregisterGSSProviders()
+	if pg.connectedDB() != nil {
+		pg.cleanupDanglingStagingTables(ctx)
+	}
+
+	pg.reconnectMu.Lock()
+	defer pg.reconnectMu.Unlock()
+
 	if pg.DB != nil {
-		pg.dropDanglingStagingTables(ctx)
 		_ = pg.DB.Close()
 	}
+	if pg.replicaDB != nil {
+		_ = pg.replicaDB.Close()
+	}
+	if pg.sslDir != "" {
+		if err := os.RemoveAll(pg.sslDir); err != nil {
+			pg.logger.Warnf("PG: Error removing ssl keys directory %s: %v", pg.sslDir, err)
+		}
+		pg.sslDir = ""
+	}
+	pg.closed = true
 }
 
 func (*Postgres) LoadIdentityMergeRulesTable(context.Context) (err error) {
@@ -979,21 +5331,262 @@ func (pg *Postgres) GetTotalCountInTable(ctx context.Context, tableName string)
 		SELECT count(*) FROM "%[1]s"."%[2]s";
 	`,
 		pg.Namespace,
-		tableName,
+		pg.tableName(tableName),
 	)
-	err = pg.DB.QueryRowContext(ctx, sqlStatement).Scan(&total)
+	err = pg.queryReadDB(ctx, func(db *sqlmiddleware.DB) error {
+		return db.QueryRowContext(ctx, sqlStatement).Scan(&total)
+	})
 	return total, err
 }
 
+// ExportTable writes the rows of the table to w as csv with a header, as COPY (SELECT * FROM table) TO STDOUT WITH CSV HEADER would.
+// lib/pq does not support COPY TO, so the columns are selected as text, which is their output format in COPY too, and written in its csv format.
+// The rows are streamed to w, fetched FetchSize rows at a time if configured.
+func (pg *Postgres) ExportTable(ctx context.Context, tableName string, w io.Writer) error {
+	if err := pg.ensureConnected(ctx); err != nil {
+		return err
+	}
+
+	rows, err := pg.connectedDB().QueryContext(ctx, fmt.Sprintf(`SELECT * FROM "%[1]s"."%[2]s" LIMIT 0;`, pg.Namespace, pg.tableName(tableName)))
+	if err != nil {
+		return fmt.Errorf("getting columns of table %s: %w", tableName, err)
+	}
+	columns, err := rows.Columns()
+	_ = rows.Close()
+	if err != nil {
+		return fmt.Errorf("getting columns of table %s: %w", tableName, err)
+	}
+
+	bw := bufio.NewWriter(w)
+	header := make([]sql.NullString, len(columns))
+	textColumns := make([]string, len(columns))
+	for i, column := range columns {
+		header[i] = sql.NullString{String: column, Valid: true}
+		textColumns[i] = fmt.Sprintf(`%q::text`, column)
+	}
+	if err := writeCopyCSVRecord(bw, header); err != nil {
+		return fmt.Errorf("writing header of table %s: %w", tableName, err)
+	}
+
+	record := make([]sql.NullString, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range record {
+		dest[i] = &record[i]
+	}
+	sqlStatement := fmt.Sprintf(`SELECT %[3]s FROM "%[1]s"."%[2]s";`, pg.Namespace, pg.tableName(tableName), strings.Join(textColumns, ", "))
+	err = pg.queryRows(ctx, pg.connectedDB(), sqlStatement, nil, func(rows *sql.Rows) error {
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+		return writeCopyCSVRecord(bw, record)
+	})
+	if err != nil {
+		return fmt.Errorf("exporting table %s: %w", tableName, err)
+	}
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("exporting table %s: %w", tableName, err)
+	}
+	return nil
+}
+
+// TableNotFoundError is returned when the table does not exist in the namespace.
+type TableNotFoundError struct {
+	Namespace string
+	TableName string
+}
+
+func (e *TableNotFoundError) Error() string {
+	return fmt.Sprintf("table %s not found in namespace %s", e.TableName, e.Namespace)
+}
+
+// ColumnNotFoundError is returned when the column does not exist in the table.
+type ColumnNotFoundError struct {
+	TableName  string
+	ColumnName string
+}
+
+func (e *ColumnNotFoundError) Error() string {
+	return fmt.Sprintf("column %s not found in table %s", e.ColumnName, e.TableName)
+}
+
+// InsufficientPrivilegesError is returned when the user lacks privileges on tables, with the missing privileges by table.
+type InsufficientPrivilegesError struct {
+	Namespace         string
+	MissingPrivileges map[string][]string
+}
+
+func (e *InsufficientPrivilegesError) Error() string {
+	tableNames := make([]string, 0, len(e.MissingPrivileges))
+	for tableName := range e.MissingPrivileges {
+		tableNames = append(tableNames, tableName)
+	}
+	sort.Strings(tableNames)
+
+	missing := make([]string, 0, len(tableNames))
+	for _, tableName := range tableNames {
+		missing = append(missing, fmt.Sprintf("%s (%s)", tableName, strings.Join(e.MissingPrivileges[tableName], ", ")))
+	}
+	return fmt.Sprintf("insufficient privileges on tables in namespace %s: %s", e.Namespace, strings.Join(missing, ", "))
+}
+
+// MissingColumnsError is returned when columns required by the load do not exist in the table.
+type MissingColumnsError struct {
+	TableName   string
+	ColumnNames []string
+}
+
+func (e *MissingColumnsError) Error() string {
+	return fmt.Sprintf("columns %s not found in table %s", strings.Join(e.ColumnNames, ", "), e.TableName)
+}
+
+// IdentifierCollisionError is returned with LowercaseIdentifiers when columns of a table only differ in case, so they would be the same column.
+type IdentifierCollisionError struct {
+	TableName   string
+	ColumnNames []string
+}
+
+func (e *IdentifierCollisionError) Error() string {
+	return fmt.Sprintf("columns %s of table %s collide when lowercased", strings.Join(e.ColumnNames, ", "), e.TableName)
+}
+
+// InvalidJSONError is returned when a value of a json column is not valid json, identifying the row of the load file by its 1-based position.
+type InvalidJSONError struct {
+	TableName  string
+	ColumnName string
+	FileName   string
+	Row        int
+}
+
+func (e *InvalidJSONError) Error() string {
+	return fmt.Sprintf("invalid json in column %s of table %s at row %d of load file %s", e.ColumnName, e.TableName, e.Row, e.FileName)
+}
+
+// TooManyBadRowsError is returned when the load files of a table have more malformed rows than MaxBadRows allows skipping.
+type TooManyBadRowsError struct {
+	TableName  string
+	MaxBadRows int
+}
+
+func (e *TooManyBadRowsError) Error() string {
+	return fmt.Sprintf("more than %d malformed rows in the load files of table %s", e.MaxBadRows, e.TableName)
+}
+
+// TableAlreadyExistsError is returned when renaming a table to the name of an existing table.
+type TableAlreadyExistsError struct {
+	Namespace string
+	TableName string
+}
+
+func (e *TableAlreadyExistsError) Error() string {
+	return fmt.Sprintf("table %s already exists in namespace %s", e.TableName, e.Namespace)
+}
+
+// ColumnAlreadyExistsError is returned when renaming a column to the name of an existing column of the table.
+type ColumnAlreadyExistsError struct {
+	TableName  string
+	ColumnName string
+}
+
+func (e *ColumnAlreadyExistsError) Error() string {
+	return fmt.Sprintf("column %s already exists in table %s", e.ColumnName, e.TableName)
+}
+
+// CountDistinct returns the number of distinct non-null values of the column in the table.
+// For a deduplicated table, it is equal to the total count for the primary key.
+func (pg *Postgres) CountDistinct(ctx context.Context, tableName, columnName string) (int64, error) {
+	var total int64
+
+	sqlStatement := fmt.Sprintf(`
+		SELECT count(DISTINCT %[3]q) FROM "%[1]s"."%[2]s";
+	`,
+		pg.Namespace,
+		pg.tableName(tableName),
+		columnName,
+	)
+	err := pg.queryReadDB(ctx, func(db *sqlmiddleware.DB) error {
+		return db.QueryRowContext(ctx, sqlStatement).Scan(&total)
+	})
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code {
+		case undefinedTableCode:
+			return 0, &TableNotFoundError{Namespace: pg.Namespace, TableName: tableName}
+		case undefinedColumnCode:
+			return 0, &ColumnNotFoundError{TableName: tableName, ColumnName: columnName}
+		}
+	}
+	if err != nil {
+		return 0, fmt.Errorf("counting distinct %s in table %s: %w", columnName, tableName, err)
+	}
+	return total, nil
+}
+
+// TableSize is the storage used by a table, in bytes.
+type TableSize struct {
+	// Table is the size of the main data of the table.
+	Table int64
+	// Indexes is the size of all the indexes of the table.
+	Indexes int64
+	// Toast is the size of the TOAST table along with its index.
+	Toast int64
+	// Total is the size of the table including its indexes, TOAST and auxiliary forks.
+	Total int64
+}
+
+// GetTableSizeBytes returns the total storage used by the table, including indexes and TOAST.
+func (pg *Postgres) GetTableSizeBytes(ctx context.Context, tableName string) (int64, error) {
+	var size sql.NullInt64
+
+	err := pg.connectedDB().QueryRowContext(ctx, `SELECT pg_total_relation_size(to_regclass($1));`,
+		fmt.Sprintf(`%q.%q`, pg.Namespace, pg.tableName(tableName)),
+	).Scan(&size)
+	if err != nil {
+		return 0, fmt.Errorf("getting size of table %s: %w", tableName, err)
+	}
+	if !size.Valid {
+		return 0, &TableNotFoundError{Namespace: pg.Namespace, TableName: tableName}
+	}
+	return size.Int64, nil
+}
+
+// GetTableSizeBreakdown returns the storage used by the table, broken down into table, indexes and TOAST.
+func (pg *Postgres) GetTableSizeBreakdown(ctx context.Context, tableName string) (TableSize, error) {
+	var size TableSize
+
+	sqlStatement := `
+		SELECT
+		  pg_relation_size(c.oid),
+		  pg_indexes_size(c.oid),
+		  COALESCE(pg_total_relation_size(NULLIF(c.reltoastrelid, 0)), 0),
+		  pg_total_relation_size(c.oid)
+		FROM
+		  pg_class c
+		WHERE
+		  c.oid = to_regclass($1);
+	`
+	err := pg.connectedDB().QueryRowContext(ctx, sqlStatement,
+		fmt.Sprintf(`%q.%q`, pg.Namespace, pg.tableName(tableName)),
+	).Scan(&size.Table, &size.Indexes, &size.Toast, &size.Total)
+	if errors.Is(err, sql.ErrNoRows) {
+		return TableSize{}, &TableNotFoundError{Namespace: pg.Namespace, TableName: tableName}
+	}
+	if err != nil {
+		return TableSize{}, fmt.Errorf("getting size breakdown of table %s: %w", tableName, err)
+	}
+	return size, nil
+}
+
 func (pg *Postgres) Connect(_ context.Context, warehouse model.Warehouse) (client.Client, error) {
-	if warehouse.Destination.Config["sslMode"] == "verify-ca" {
+	// ephemeral ssl keys are written when connecting
+	if warehouse.Destination.Config["sslMode"] == "verify-ca" && !pg.EphemeralSSLKeys && !warehouseutils.ReadAsBool(sslInline, warehouse.Destination.Config) {
 		if err := warehouseutils.WriteSSLKeys(warehouse.Destination); err.IsError() {
 			pg.logger.Error(err.Error())
 			return client.Client{}, fmt.Errorf(err.Error())
 		}
 	}
 	pg.Warehouse = warehouse
-	pg.Namespace = warehouse.Namespace
+	pg.Namespace = pg.resolveNamespace(warehouse)
 	pg.ObjectStorage = warehouseutils.ObjectStorageType(
 		warehouseutils.POSTGRES,
 		warehouse.Destination.Config,
@@ -1010,11 +5603,11 @@ func (pg *Postgres) Connect(_ context.Context, warehouse model.Warehouse) (clien
 func (pg *Postgres) LoadTestTable(ctx context.Context, _, tableName string, payloadMap map[string]interface{}, _ string) (err error) {
 	sqlStatement := fmt.Sprintf(`INSERT INTO %q.%q (%v) VALUES (%s)`,
 		pg.Namespace,
-		tableName,
+		pg.tableName(tableName),
 		fmt.Sprintf(`%q, %q`, "id", "val"),
 		fmt.Sprintf(`'%d', '%s'`, payloadMap["id"], payloadMap["val"]),
 	)
-	_, err = pg.DB.ExecContext(ctx, sqlStatement)
+	_, err = pg.connectedDB().ExecContext(ctx, sqlStatement)
 	return
 }
 
@@ -1027,6 +5620,8 @@ type QueryParams struct {
 	db                  *sqlmiddleware.DB
 	query               string
 	enableWithQueryPlan bool
+	// explainAnalyze executes the statement under EXPLAIN ANALYZE beforehand, rolling it back, to capture its actual timings.
+	explainAnalyze bool
 }
 
 func (q *QueryParams) validate() (err error) {
@@ -1040,51 +5635,162 @@ func (q *QueryParams) validate() (err error) {
 // Print execution plan if enableWithQueryPlan is set to true else return result set.
 // Currently, these statements are supported by EXPLAIN
 // Any INSERT, UPDATE, DELETE whose execution plan you wish to see.
-func (pg *Postgres) handleExecContext(ctx context.Context, e *QueryParams) (err error) {
+func (pg *Postgres) handleExecContext(ctx context.Context, e *QueryParams) error {
+	_, err := pg.execWithResult(ctx, e)
+	return err
+}
+
+// execWithResult executes the query like handleExecContext, returning its result.
+func (pg *Postgres) execWithResult(ctx context.Context, e *QueryParams) (result sql.Result, err error) {
 	sqlStatement := e.query
 
+	defer func() {
+		if isStatementCanceled(err) {
+			err = fmt.Errorf("%w: %w", ErrStatementCanceled, err)
+		}
+	}()
+
 	if err = e.validate(); err != nil {
 		err = fmt.Errorf("[WH][POSTGRES] Not able to handle query execution for statement: %s as both txn and db are nil", sqlStatement)
 		return
 	}
 
+	// the execution plan is best-effort, the statement is executed even if it cannot be explained
 	if e.enableWithQueryPlan {
-		sqlStatement := "EXPLAIN " + e.query
-
-		var rows *sql.Rows
-		if e.txn != nil {
-			rows, err = e.txn.QueryContext(ctx, sqlStatement)
-		} else if e.db != nil {
-			rows, err = e.db.QueryContext(ctx, sqlStatement)
+		if planErr := pg.logQueryPlan(ctx, e); planErr != nil {
+			pg.logger.Warnf("[WH][POSTGRES] Error getting execution query plan for statement: %s, executing it anyway: %v", sqlStatement, planErr)
 		}
-		if err != nil {
-			err = fmt.Errorf("[WH][POSTGRES] error occurred while handling transaction for query: %s with err: %w", sqlStatement, err)
-			return
+	}
+	if e.explainAnalyze {
+		if planErr := pg.analyzeQueryPlan(ctx, e); planErr != nil {
+			pg.logger.Warnf("[WH][POSTGRES] Error analyzing execution query plan for statement: %s, executing it anyway: %v", sqlStatement, planErr)
 		}
-		defer func() { _ = rows.Close() }()
+	}
+	if e.txn != nil {
+		result, err = e.txn.ExecContext(ctx, sqlStatement)
+	} else if e.db != nil {
+		result, err = e.db.ExecContext(ctx, sqlStatement)
+	}
+	return
+}
 
-		var response []string
-		for rows.Next() {
-			var s string
-			if err = rows.Scan(&s); err != nil {
-				err = fmt.Errorf("[WH][POSTGRES] Error occurred while processing destination revisionID query %+v with err: %w", e, err)
+// logQueryPlan logs the execution plan of the statement.
+// Within a transaction, EXPLAIN runs in a savepoint, so that its failure does not abort the transaction.
+func (pg *Postgres) logQueryPlan(ctx context.Context, e *QueryParams) (err error) {
+	sqlStatement := "EXPLAIN " + e.query
+
+	if e.txn != nil {
+		if _, err = e.txn.ExecContext(ctx, `SAVEPOINT explain_query_plan`); err != nil {
+			return fmt.Errorf("creating savepoint: %w", err)
+		}
+		defer func() {
+			if err == nil {
+				_, err = e.txn.ExecContext(ctx, `RELEASE SAVEPOINT explain_query_plan`)
 				return
 			}
-			response = append(response, s)
+			if _, rollbackErr := e.txn.ExecContext(ctx, `ROLLBACK TO SAVEPOINT explain_query_plan`); rollbackErr != nil {
+				err = fmt.Errorf("%w, rolling back to savepoint: %w", err, rollbackErr)
+			}
+		}()
+	}
+
+	var rows *sql.Rows
+	if e.txn != nil {
+		rows, err = e.txn.QueryContext(ctx, sqlStatement)
+	} else if e.db != nil {
+		rows, err = e.db.QueryContext(ctx, sqlStatement)
+	}
+	if err != nil {
+		return fmt.Errorf("[WH][POSTGRES] error occurred while handling transaction for query: %s with err: %w", sqlStatement, err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var response []string
+	for rows.Next() {
+		var s string
+		if err = rows.Scan(&s); err != nil {
+			return fmt.Errorf("[WH][POSTGRES] Error occurred while processing destination revisionID query %+v with err: %w", e, err)
+		}
+		response = append(response, s)
+	}
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("[WH][POSTGRES] Error occurred while processing destination revisionID query %+v with err: %w", e, err)
+	}
+	pg.logger.Infof(fmt.Sprintf(`[WH][POSTGRES] Execution Query plan for statement: %s is %s`, sqlStatement, strings.Join(response, `
+`)))
+	return nil
+}
+
+// analyzedQueryPlan is the output of EXPLAIN (ANALYZE, BUFFERS, FORMAT JSON), along with the actual timings.
+type analyzedQueryPlan struct {
+	Plan struct {
+		SharedHitBlocks  int64 `json:"Shared Hit Blocks"`
+		SharedReadBlocks int64 `json:"Shared Read Blocks"`
+	} `json:"Plan"`
+	PlanningTime  float64 `json:"Planning Time"`
+	ExecutionTime float64 `json:"Execution Time"`
+}
+
+// analyzeQueryPlan executes the statement under EXPLAIN ANALYZE, logging its plan and reporting its actual timings and buffer usage.
+// As EXPLAIN ANALYZE executes the statement, it runs in a savepoint which is always rolled back, leaving the data untouched.
+// Outside a transaction, it runs in one which is rolled back.
+func (pg *Postgres) analyzeQueryPlan(ctx context.Context, e *QueryParams) (err error) {
+	sqlStatement := "EXPLAIN (ANALYZE, BUFFERS, FORMAT JSON) " + e.query
+
+	txn := e.txn
+	if txn == nil {
+		if txn, err = e.db.BeginTx(ctx, &sql.TxOptions{}); err != nil {
+			return fmt.Errorf("beginning transaction: %w", err)
 		}
-		if err = rows.Err(); err != nil {
-			err = fmt.Errorf("[WH][POSTGRES] Error occurred while processing destination revisionID query %+v with err: %w", e, err)
+		defer func() { _ = txn.Rollback() }()
+	}
+
+	if _, err = txn.ExecContext(ctx, `SAVEPOINT explain_analyze_query_plan`); err != nil {
+		return fmt.Errorf("creating savepoint: %w", err)
+	}
+	defer func() {
+		if _, rollbackErr := txn.ExecContext(ctx, `ROLLBACK TO SAVEPOINT explain_analyze_query_plan`); rollbackErr != nil {
+			err = errors.Join(err, fmt.Errorf("rolling back to savepoint: %w", rollbackErr))
 			return
 		}
-		pg.logger.Infof(fmt.Sprintf(`[WH][POSTGRES] Execution Query plan for statement: %s is %s`, sqlStatement, strings.Join(response, `
-`)))
+		if _, releaseErr := txn.ExecContext(ctx, `RELEASE SAVEPOINT explain_analyze_query_plan`); releaseErr != nil {
+			err = errors.Join(err, fmt.Errorf("releasing savepoint: %w", releaseErr))
+		}
+	}()
+
+	var response []byte
+	if err = txn.QueryRowContext(ctx, sqlStatement).Scan(&response); err != nil {
+		return fmt.Errorf("analyzing query plan: %w", err)
 	}
-	if e.txn != nil {
-		_, err = e.txn.ExecContext(ctx, sqlStatement)
-	} else if e.db != nil {
-		_, err = e.db.ExecContext(ctx, sqlStatement)
+	var plans []analyzedQueryPlan
+	if err = json.Unmarshal(response, &plans); err != nil {
+		return fmt.Errorf("unmarshalling query plan: %w", err)
 	}
-	return
+	if len(plans) == 0 {
+		return fmt.Errorf("no query plan for statement: %s", e.query)
+	}
+	plan := plans[0]
+
+	pg.logger.Infof("[WH][POSTGRES] Analyzed Execution Query plan for statement: %s is %s", sqlStatement, response)
+
+	tags := stats.Tags{
+		"workspaceId":   pg.Warehouse.WorkspaceID,
+		"namespace":     pg.Namespace,
+		"destinationID": pg.Warehouse.Destination.ID,
+	}
+	pg.stats.NewTaggedStat("pg_explain_analyze_planning_time", stats.TimerType, tags).SendTiming(time.Duration(plan.PlanningTime * float64(time.Millisecond)))
+	pg.stats.NewTaggedStat("pg_explain_analyze_execution_time", stats.TimerType, tags).SendTiming(time.Duration(plan.ExecutionTime * float64(time.Millisecond)))
+	pg.stats.NewTaggedStat("pg_explain_analyze_shared_hit_blocks", stats.CountType, tags).Count(int(plan.Plan.SharedHitBlocks))
+	pg.stats.NewTaggedStat("pg_explain_analyze_shared_read_blocks", stats.CountType, tags).Count(int(plan.Plan.SharedReadBlocks))
+	return nil
+}
+
+func isStatementCanceled(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == queryCanceledCode {
+		return true
+	}
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
 }
 
 func (*Postgres) ErrorMappings() []model.JobError {
@@ -0,0 +1,387 @@
+package postgreslegacy
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/golang-lru/v2/simplelru"
+	"golang.org/x/exp/slices"
+
+	"github.com/rudderlabs/rudder-go-kit/stats"
+	sqlmiddleware "github.com/rudderlabs/rudder-server/warehouse/integrations/middleware/sqlquerywrapper"
+	"github.com/rudderlabs/rudder-server/warehouse/internal/model"
+	"github.com/rudderlabs/rudder-server/warehouse/tunnelling"
+	warehouseutils "github.com/rudderlabs/rudder-server/warehouse/utils"
+)
+
+// TypeConflict is a column whose data type in the warehouse differs from the one in the upload.
+type TypeConflict struct {
+	UploadType    string
+	WarehouseType string
+}
+
+// SchemaDiff is the drift between the schema of a table in the upload and in the warehouse.
+type SchemaDiff struct {
+	// MissingColumns are the columns in the upload which do not exist in the warehouse.
+	MissingColumns model.TableSchema
+	// TypeConflicts are the columns whose data types differ between the upload and the warehouse.
+	TypeConflicts map[string]TypeConflict
+	// ExtraColumns are the columns in the warehouse which are not in the upload.
+	ExtraColumns model.TableSchema
+}
+
+// IsEmpty returns true if there is no drift.
+func (d SchemaDiff) IsEmpty() bool {
+	return len(d.MissingColumns) == 0 && len(d.TypeConflicts) == 0 && len(d.ExtraColumns) == 0
+}
+
+// ValidateSchema compares the schema of the table in the upload with the live schema of the table in the warehouse.
+// Nothing is changed in the warehouse, leaving it to the caller to decide whether to migrate the table or alert.
+func (pg *Postgres) ValidateSchema(ctx context.Context, tableName string, uploadSchema model.TableSchema) (SchemaDiff, error) {
+	warehouseSchema, err := pg.fetchTableSchema(ctx, tableName)
+	if err != nil {
+		return SchemaDiff{}, err
+	}
+	return diffSchema(uploadSchema, warehouseSchema), nil
+}
+
+// fetchTableSchema returns the schema of the table in the warehouse.
+// Columns with unrecognized data types are returned with warehouseutils.MISSING_DATATYPE.
+func (pg *Postgres) fetchTableSchema(ctx context.Context, tableName string) (model.TableSchema, error) {
+	sqlStatement := `
+		SELECT
+		  column_name,
+		  data_type
+		FROM
+		  INFORMATION_SCHEMA.COLUMNS
+		WHERE
+		  table_schema = $1
+		  AND table_name = $2;
+	`
+	rows, err := pg.connectedDB().QueryContext(ctx, sqlStatement, pg.Namespace, pg.tableName(tableName))
+	if err != nil {
+		return nil, fmt.Errorf("fetching schema for table %s: %w", tableName, err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	tableSchema := make(model.TableSchema)
+	for rows.Next() {
+		var columnName, columnType string
+
+		if err := rows.Scan(&columnName, &columnType); err != nil {
+			return nil, fmt.Errorf("scanning schema for table %s: %w", tableName, err)
+		}
+
+		if datatype, ok := pg.rudderDataType(columnType); ok {
+			tableSchema[columnName] = datatype
+		} else {
+			tableSchema[columnName] = warehouseutils.MISSING_DATATYPE
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("fetching schema for table %s: %w", tableName, err)
+	}
+	return tableSchema, nil
+}
+
+func diffSchema(uploadSchema, warehouseSchema model.TableSchema) SchemaDiff {
+	diff := SchemaDiff{
+		MissingColumns: make(model.TableSchema),
+		TypeConflicts:  make(map[string]TypeConflict),
+		ExtraColumns:   make(model.TableSchema),
+	}
+
+	for columnName, uploadType := range uploadSchema {
+		warehouseType, ok := warehouseSchema[columnName]
+		if !ok {
+			diff.MissingColumns[columnName] = uploadType
+			continue
+		}
+		if warehouseType != uploadType {
+			diff.TypeConflicts[columnName] = TypeConflict{
+				UploadType:    uploadType,
+				WarehouseType: warehouseType,
+			}
+		}
+	}
+	for columnName, warehouseType := range warehouseSchema {
+		if _, ok := uploadSchema[columnName]; !ok {
+			diff.ExtraColumns[columnName] = warehouseType
+		}
+	}
+	return diff
+}
+
+// schemaCache is the schema of a namespace, along with the checksum of its columns when it was fetched.
+type schemaCache struct {
+	namespace          string
+	checksum           string
+	schema             model.Schema
+	unrecognizedSchema model.Schema
+}
+
+// fetchCachedSchema returns the cached schema of the namespace, fetching it again if the checksum of its columns changed.
+func (pg *Postgres) fetchCachedSchema(ctx context.Context) (model.Schema, model.Schema, error) {
+	pg.schemaCacheMu.Lock()
+	defer pg.schemaCacheMu.Unlock()
+
+	checksum, err := pg.schemaChecksum(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	if c := pg.schemaCache; c != nil && c.namespace == pg.Namespace && c.checksum == checksum {
+		pg.countSchemaCache("hit")
+		return cloneSchema(c.schema), cloneSchema(c.unrecognizedSchema), nil
+	}
+	pg.countSchemaCache("miss")
+	return pg.refreshSchema(ctx, checksum)
+}
+
+// RefreshSchema fetches the schema of the namespace regardless of the cached one, which it replaces.
+func (pg *Postgres) RefreshSchema(ctx context.Context) (model.Schema, model.Schema, error) {
+	// the shared schema cache is fetched again on the next FetchSchema
+	pg.invalidateSharedSchemaCache()
+
+	pg.schemaCacheMu.Lock()
+	defer pg.schemaCacheMu.Unlock()
+
+	checksum, err := pg.schemaChecksum(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pg.refreshSchema(ctx, checksum)
+}
+
+// refreshSchema fetches the schema and caches it with the checksum.
+// The checksum is computed before fetching the schema, so that a change in between is detected by the next fetch.
+func (pg *Postgres) refreshSchema(ctx context.Context, checksum string) (model.Schema, model.Schema, error) {
+	schema, unrecognizedSchema, err := pg.fetchSchema(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	pg.schemaCache = &schemaCache{
+		namespace:          pg.Namespace,
+		checksum:           checksum,
+		schema:             cloneSchema(schema),
+		unrecognizedSchema: cloneSchema(unrecognizedSchema),
+	}
+	return schema, unrecognizedSchema, nil
+}
+
+// schemaChecksum returns a checksum of the columns of the tables in the namespace, which changes whenever a column is added, dropped, renamed or retyped.
+// It only reads the catalog, which is much cheaper than scanning INFORMATION_SCHEMA.COLUMNS.
+func (pg *Postgres) schemaChecksum(ctx context.Context) (string, error) {
+	sqlStatement := `
+		SELECT
+		  coalesce(
+		    md5(
+		      string_agg(
+		        c.relname :: text || '.' || a.attname :: text || ':' || a.atttypid :: text || ':' || a.atttypmod :: text,
+		        ',' ORDER BY c.relname, a.attnum
+		      )
+		    ),
+		    ''
+		  )
+		FROM
+		  pg_attribute a
+		  JOIN pg_class c ON c.oid = a.attrelid
+		  JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE
+		  n.nspname = $1
+		  AND c.relkind IN ('r', 'p', 'v', 'f')
+		  AND c.relname NOT LIKE $2
+		  AND a.attnum > 0
+		  AND NOT a.attisdropped;
+	`
+	var checksum string
+	err := pg.queryReadDB(ctx, func(db *sqlmiddleware.DB) error {
+		return db.QueryRowContext(
+			ctx,
+			sqlStatement,
+			pg.Namespace,
+			fmt.Sprintf(`%s%%`, warehouseutils.StagingTablePrefix(provider)),
+		).Scan(&checksum)
+	})
+	if err != nil {
+		return "", fmt.Errorf("computing schema checksum: %w", err)
+	}
+	return checksum, nil
+}
+
+func (pg *Postgres) countSchemaCache(result string) {
+	pg.stats.NewTaggedStat("pg_schema_cache", stats.CountType, stats.Tags{
+		"workspaceId":   pg.Warehouse.WorkspaceID,
+		"namespace":     pg.Namespace,
+		"destinationID": pg.Warehouse.Destination.ID,
+		"result":        result,
+	}).Count(1)
+}
+
+// cloneSchema returns a copy of the schema, so that callers cannot modify the cached one.
+func cloneSchema(schema model.Schema) model.Schema {
+	clone := make(model.Schema, len(schema))
+	for tableName, tableSchema := range schema {
+		clone[tableName] = make(model.TableSchema, len(tableSchema))
+		for columnName, columnType := range tableSchema {
+			clone[tableName][columnName] = columnType
+		}
+	}
+	return clone
+}
+
+// sharedSchemaCacheKey identifies a namespace across the destinations of the process.
+// The host is resolved by the ssh server for the connections through a tunnel, which is identified by its user and address.
+type sharedSchemaCacheKey struct {
+	tunnel    string
+	host      string
+	database  string
+	namespace string
+}
+
+// sharedSchemaCacheEntry is the columns of a namespace in the sharedSchemaCache, along with when they were fetched.
+type sharedSchemaCacheEntry struct {
+	fetchedAt time.Time
+	columns   []schemaColumn
+}
+
+// sharedSchemaCache is a size bounded LRU cache of the columns of the namespaces, shared by the destinations of the process.
+// The columns are cached as found in the information schema, as the schema of every destination depends on its settings,
+// e.g. the table prefix and suffix, LowercaseIdentifiers and the datatype mappings.
+// Every invalidation increments its generation, so that columns fetched concurrently with it are not cached.
+type sharedSchemaCache struct {
+	mu         sync.Mutex
+	entries    *simplelru.LRU[sharedSchemaCacheKey, sharedSchemaCacheEntry]
+	generation uint64
+}
+
+func newSharedSchemaCache(size int) *sharedSchemaCache {
+	if size <= 0 {
+		size = 1
+	}
+	entries, _ := simplelru.NewLRU[sharedSchemaCacheKey, sharedSchemaCacheEntry](size, nil)
+	return &sharedSchemaCache{entries: entries}
+}
+
+var (
+	processSchemaCache     *sharedSchemaCache
+	processSchemaCacheOnce sync.Once
+)
+
+// getProcessSchemaCache returns the schema cache of the process, whose size is the SharedSchemaCacheSize of the first destination using it.
+func getProcessSchemaCache(size int) *sharedSchemaCache {
+	processSchemaCacheOnce.Do(func() {
+		processSchemaCache = newSharedSchemaCache(size)
+	})
+	return processSchemaCache
+}
+
+// get returns a copy of the cached columns of the namespace, if they were fetched within the ttl, along with the current generation.
+func (c *sharedSchemaCache) get(key sharedSchemaCacheKey, ttl time.Duration, now time.Time) ([]schemaColumn, uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries.Get(key)
+	if !ok {
+		return nil, c.generation, false
+	}
+	if now.Sub(entry.fetchedAt) >= ttl {
+		c.entries.Remove(key)
+		return nil, c.generation, false
+	}
+	return slices.Clone(entry.columns), c.generation, true
+}
+
+// put caches a copy of the columns of the namespace, unless the cache was invalidated since the generation the columns were fetched at.
+func (c *sharedSchemaCache) put(key sharedSchemaCacheKey, generation uint64, columns []schemaColumn, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation {
+		return false
+	}
+	c.entries.Add(key, sharedSchemaCacheEntry{
+		fetchedAt: now,
+		columns:   slices.Clone(columns),
+	})
+	return true
+}
+
+// invalidate removes the cached columns of the namespace.
+func (c *sharedSchemaCache) invalidate(key sharedSchemaCacheKey) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	c.entries.Remove(key)
+}
+
+// sharedSchemas returns the shared schema cache, which is the one of the process unless replaced.
+func (pg *Postgres) sharedSchemas() *sharedSchemaCache {
+	if pg.sharedSchemaCache != nil {
+		return pg.sharedSchemaCache
+	}
+	return getProcessSchemaCache(pg.SharedSchemaCacheSize)
+}
+
+// sharedSchemaCacheKey returns the key of the namespace in the shared schema cache, from the host and database of the dsn override if set.
+func (pg *Postgres) sharedSchemaCacheKey() sharedSchemaCacheKey {
+	cred := pg.getConnectionCredentials()
+	key := sharedSchemaCacheKey{
+		host:      cred.Host + ":" + cred.Port,
+		database:  cred.DBName,
+		namespace: pg.Namespace,
+	}
+	if cred.DSN != "" {
+		if u, err := url.Parse(cred.DSN); err == nil {
+			key.host, key.database = u.Host, u.Path
+		}
+	}
+	if cred.TunnelInfo != nil {
+		if tunnelConfig, err := tunnelling.ReadSSHTunnelConfig(cred.TunnelInfo.Config); err == nil {
+			key.tunnel = fmt.Sprintf("%s@%s:%d", tunnelConfig.User, tunnelConfig.Host, tunnelConfig.Port)
+		}
+	}
+	return key
+}
+
+// fetchSharedCachedSchema returns the schema of the namespace from the columns in the shared schema cache if they were fetched within SharedSchemaCacheTTL,
+// fetching and caching them otherwise.
+func (pg *Postgres) fetchSharedCachedSchema(ctx context.Context) (model.Schema, model.Schema, error) {
+	cache, key := pg.sharedSchemas(), pg.sharedSchemaCacheKey()
+
+	columns, generation, ok := cache.get(key, pg.SharedSchemaCacheTTL, time.Now())
+	if ok {
+		pg.countSharedSchemaCache("hit")
+		schema, unrecognizedSchema := pg.schemaOf(columns, false)
+		return schema, unrecognizedSchema, nil
+	}
+	pg.countSharedSchemaCache("miss")
+
+	columns, err := pg.fetchSchemaColumns(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	cache.put(key, generation, columns, time.Now())
+	schema, unrecognizedSchema := pg.schemaOf(columns, true)
+	return schema, unrecognizedSchema, nil
+}
+
+// invalidateSharedSchemaCache removes the schema of the namespace from the shared schema cache, after changing it.
+func (pg *Postgres) invalidateSharedSchemaCache() {
+	if pg.SharedSchemaCacheTTL <= 0 {
+		return
+	}
+	pg.sharedSchemas().invalidate(pg.sharedSchemaCacheKey())
+}
+
+func (pg *Postgres) countSharedSchemaCache(result string) {
+	pg.stats.NewTaggedStat("pg_shared_schema_cache", stats.CountType, stats.Tags{
+		"workspaceId":   pg.Warehouse.WorkspaceID,
+		"namespace":     pg.Namespace,
+		"destinationID": pg.Warehouse.Destination.ID,
+		"result":        result,
+	}).Count(1)
+}
@@ -0,0 +1,525 @@
+package postgreslegacy
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rudderlabs/rudder-go-kit/config"
+	"github.com/rudderlabs/rudder-go-kit/stats"
+	"github.com/rudderlabs/rudder-go-kit/stats/memstats"
+	"github.com/rudderlabs/rudder-server/utils/misc"
+	"github.com/rudderlabs/rudder-server/warehouse/internal/model"
+	warehouseutils "github.com/rudderlabs/rudder-server/warehouse/utils"
+)
+
+func TestDiffSchema(t *testing.T) {
+	testCases := []struct {
+		name            string
+		uploadSchema    model.TableSchema
+		warehouseSchema model.TableSchema
+		want            SchemaDiff
+		wantEmpty       bool
+	}{
+		{
+			name:            "no drift",
+			uploadSchema:    model.TableSchema{"id": "string", "received_at": "datetime"},
+			warehouseSchema: model.TableSchema{"id": "string", "received_at": "datetime"},
+			want: SchemaDiff{
+				MissingColumns: model.TableSchema{},
+				TypeConflicts:  map[string]TypeConflict{},
+				ExtraColumns:   model.TableSchema{},
+			},
+			wantEmpty: true,
+		},
+		{
+			name:            "missing columns",
+			uploadSchema:    model.TableSchema{"id": "string", "test_int": "int"},
+			warehouseSchema: model.TableSchema{"id": "string"},
+			want: SchemaDiff{
+				MissingColumns: model.TableSchema{"test_int": "int"},
+				TypeConflicts:  map[string]TypeConflict{},
+				ExtraColumns:   model.TableSchema{},
+			},
+		},
+		{
+			name:            "type conflicts",
+			uploadSchema:    model.TableSchema{"id": "string", "test_int": "int", "test_json": "json"},
+			warehouseSchema: model.TableSchema{"id": "string", "test_int": "string", "test_json": warehouseutils.MISSING_DATATYPE},
+			want: SchemaDiff{
+				MissingColumns: model.TableSchema{},
+				TypeConflicts: map[string]TypeConflict{
+					"test_int":  {UploadType: "int", WarehouseType: "string"},
+					"test_json": {UploadType: "json", WarehouseType: warehouseutils.MISSING_DATATYPE},
+				},
+				ExtraColumns: model.TableSchema{},
+			},
+		},
+		{
+			name:            "extra columns",
+			uploadSchema:    model.TableSchema{"id": "string"},
+			warehouseSchema: model.TableSchema{"id": "string", "test_bool": "boolean"},
+			want: SchemaDiff{
+				MissingColumns: model.TableSchema{},
+				TypeConflicts:  map[string]TypeConflict{},
+				ExtraColumns:   model.TableSchema{"test_bool": "boolean"},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			diff := diffSchema(tc.uploadSchema, tc.warehouseSchema)
+			require.Equal(t, tc.want, diff)
+			require.Equal(t, tc.wantEmpty, diff.IsEmpty())
+		})
+	}
+}
+
+func TestValidateSchema(t *testing.T) {
+	misc.Init()
+	warehouseutils.Init()
+
+	const tableName = "test_table"
+
+	db := setupDB(t)
+	ctx := context.Background()
+
+	pg := newTestPostgres(db, config.New())
+	require.NoError(t, pg.CreateSchema(ctx))
+
+	_, err := db.ExecContext(ctx, fmt.Sprintf(`
+		CREATE TABLE %q.%q (
+		  id text,
+		  test_int text,
+		  test_bool boolean,
+		  document tsvector
+		);
+	`,
+		testNamespace,
+		tableName,
+	))
+	require.NoError(t, err)
+
+	uploadSchema := model.TableSchema{
+		"id":          "string",
+		"received_at": "datetime",
+		"test_int":    "int",
+		"document":    "string",
+	}
+
+	diff, err := pg.ValidateSchema(ctx, tableName, uploadSchema)
+	require.NoError(t, err)
+	require.Equal(t, SchemaDiff{
+		MissingColumns: model.TableSchema{"received_at": "datetime"},
+		TypeConflicts: map[string]TypeConflict{
+			"test_int": {UploadType: "int", WarehouseType: "string"},
+			"document": {UploadType: "string", WarehouseType: warehouseutils.MISSING_DATATYPE},
+		},
+		ExtraColumns: model.TableSchema{"test_bool": "boolean"},
+	}, diff)
+
+	t.Run("missing table", func(t *testing.T) {
+		diff, err := pg.ValidateSchema(ctx, "missing_table", uploadSchema)
+		require.NoError(t, err)
+		require.Equal(t, uploadSchema, diff.MissingColumns)
+		require.Empty(t, diff.TypeConflicts)
+		require.Empty(t, diff.ExtraColumns)
+	})
+
+	t.Run("nothing is changed", func(t *testing.T) {
+		schema, _, err := pg.FetchSchema(ctx)
+		require.NoError(t, err)
+		require.NotContains(t, schema[tableName], "received_at")
+		require.NotContains(t, schema, "missing_table")
+	})
+}
+
+func TestFetchSchema_Cache(t *testing.T) {
+	misc.Init()
+	warehouseutils.Init()
+
+	const tableName = "test_table"
+
+	db := setupDB(t)
+	ctx := context.Background()
+
+	c := config.New()
+	c.Set("Warehouse.postgres.cacheSchema", true)
+
+	store := memstats.New()
+
+	pg := newTestPostgres(db, c)
+	pg.stats = store
+	createTestTable(t, pg, tableName)
+
+	cacheCount := func(result string) float64 {
+		m := store.Get("pg_schema_cache", stats.Tags{
+			"workspaceId":   testWorkspaceID,
+			"namespace":     testNamespace,
+			"destinationID": testDestID,
+			"result":        result,
+		})
+		if m == nil {
+			return 0
+		}
+		return m.LastValue()
+	}
+
+	schema, _, err := pg.FetchSchema(ctx)
+	require.NoError(t, err)
+	require.Equal(t, model.Schema{tableName: testTableSchema}, schema)
+	require.EqualValues(t, 1, cacheCount("miss"))
+
+	t.Run("reused when nothing changed", func(t *testing.T) {
+		// the returned schema is a copy of the cached one
+		schema["other_table"] = model.TableSchema{"id": "string"}
+
+		// staging tables do not invalidate the cache
+		_, err := db.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE %q.%q (id text);`, testNamespace, warehouseutils.StagingTableName(provider, tableName, tableNameLimit)))
+		require.NoError(t, err)
+
+		schema, _, err := pg.FetchSchema(ctx)
+		require.NoError(t, err)
+		require.Equal(t, model.Schema{tableName: testTableSchema}, schema)
+		require.EqualValues(t, 1, cacheCount("miss"))
+		require.EqualValues(t, 1, cacheCount("hit"))
+	})
+
+	t.Run("invalidated after AddColumns", func(t *testing.T) {
+		require.NoError(t, pg.AddColumns(ctx, tableName, []warehouseutils.ColumnInfo{{Name: "test_bool", Type: "boolean"}}))
+
+		schema, _, err := pg.FetchSchema(ctx)
+		require.NoError(t, err)
+		require.Equal(t, "boolean", schema[tableName]["test_bool"])
+		require.EqualValues(t, 2, cacheCount("miss"))
+
+		_, _, err = pg.FetchSchema(ctx)
+		require.NoError(t, err)
+		require.EqualValues(t, 2, cacheCount("hit"))
+	})
+
+	t.Run("force refresh", func(t *testing.T) {
+		schema, _, err := pg.RefreshSchema(ctx)
+		require.NoError(t, err)
+		require.Equal(t, "boolean", schema[tableName]["test_bool"])
+
+		_, _, err = pg.FetchSchema(ctx)
+		require.NoError(t, err)
+		require.EqualValues(t, 2, cacheCount("miss"))
+		require.EqualValues(t, 3, cacheCount("hit"))
+	})
+}
+
+func TestSharedSchemaCache(t *testing.T) {
+	var (
+		now   = time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+		key   = sharedSchemaCacheKey{host: "localhost:5432", database: "db", namespace: "namespace"}
+		other = sharedSchemaCacheKey{host: "localhost:5432", database: "db", namespace: "other_namespace"}
+
+		columns = []schemaColumn{
+			{tableName: "tracks", columnName: "id", columnType: "text"},
+			{tableName: "tracks", columnName: "document", columnType: "xml"},
+		}
+	)
+
+	t.Run("ttl", func(t *testing.T) {
+		cache := newSharedSchemaCache(10)
+
+		_, generation, ok := cache.get(key, time.Minute, now)
+		require.False(t, ok)
+		require.True(t, cache.put(key, generation, columns, now))
+
+		gotColumns, _, ok := cache.get(key, time.Minute, now.Add(59*time.Second))
+		require.True(t, ok)
+		require.Equal(t, columns, gotColumns)
+
+		// the returned columns are a copy of the cached ones
+		gotColumns[0].columnName = "name"
+		gotColumns, _, ok = cache.get(key, time.Minute, now)
+		require.True(t, ok)
+		require.Equal(t, columns, gotColumns)
+
+		_, _, ok = cache.get(key, time.Minute, now.Add(time.Minute))
+		require.False(t, ok)
+	})
+
+	t.Run("evicts the least recently used", func(t *testing.T) {
+		cache := newSharedSchemaCache(2)
+
+		third := sharedSchemaCacheKey{host: "localhost:5432", database: "other_db", namespace: "namespace"}
+		require.True(t, cache.put(key, 0, columns, now))
+		require.True(t, cache.put(other, 0, columns, now))
+		_, _, ok := cache.get(key, time.Minute, now)
+		require.True(t, ok)
+		require.True(t, cache.put(third, 0, columns, now))
+
+		_, _, ok = cache.get(key, time.Minute, now)
+		require.True(t, ok)
+		_, _, ok = cache.get(other, time.Minute, now)
+		require.False(t, ok)
+		_, _, ok = cache.get(third, time.Minute, now)
+		require.True(t, ok)
+	})
+
+	t.Run("invalidate", func(t *testing.T) {
+		cache := newSharedSchemaCache(10)
+
+		require.True(t, cache.put(key, 0, columns, now))
+		require.True(t, cache.put(other, 0, columns, now))
+
+		_, generation, _ := cache.get(key, time.Minute, now)
+		cache.invalidate(key)
+
+		_, _, ok := cache.get(key, time.Minute, now)
+		require.False(t, ok)
+		_, _, ok = cache.get(other, time.Minute, now)
+		require.True(t, ok)
+
+		// the columns fetched before the invalidation are not cached
+		require.False(t, cache.put(key, generation, columns, now))
+		_, _, ok = cache.get(key, time.Minute, now)
+		require.False(t, ok)
+	})
+}
+
+func TestSharedSchemaCacheKey(t *testing.T) {
+	misc.Init()
+	warehouseutils.Init()
+
+	keyOf := func(destinationConfig map[string]interface{}) sharedSchemaCacheKey {
+		pg := newTestPostgres(nil, config.New())
+		pg.Namespace = testNamespace
+		pg.Warehouse.Destination.Config = destinationConfig
+		return pg.sharedSchemaCacheKey()
+	}
+	tunnelConfig := func(sshHost string) map[string]interface{} {
+		return map[string]interface{}{
+			"host":          "localhost",
+			"port":          "5432",
+			"database":      "db",
+			"useSSH":        true,
+			"sshUser":       "user",
+			"sshHost":       sshHost,
+			"sshPort":       "22",
+			"sshPrivateKey": "key",
+		}
+	}
+
+	direct := keyOf(map[string]interface{}{"host": "localhost", "port": "5432", "database": "db"})
+	require.Equal(t, sharedSchemaCacheKey{host: "localhost:5432", database: "db", namespace: testNamespace}, direct)
+
+	// the same host is another server behind every tunnel
+	tunnel := keyOf(tunnelConfig("bastion-1"))
+	require.Equal(t, "user@bastion-1:22", tunnel.tunnel)
+	require.NotEqual(t, direct, tunnel)
+	require.NotEqual(t, tunnel, keyOf(tunnelConfig("bastion-2")))
+	require.Equal(t, tunnel, keyOf(tunnelConfig("bastion-1")))
+}
+
+func TestSharedSchemaCache_Concurrency(t *testing.T) {
+	const (
+		goroutines = 16
+		iterations = 1000
+	)
+
+	cache := newSharedSchemaCache(4)
+	keys := make([]sharedSchemaCacheKey, 8)
+	for i := range keys {
+		keys[i] = sharedSchemaCacheKey{host: "localhost:5432", database: "db", namespace: "namespace_" + strconv.Itoa(i)}
+	}
+	// every key has its own columns, so that columns cached under another key would be noticed
+	columnsOf := func(key sharedSchemaCacheKey) []schemaColumn {
+		return []schemaColumn{{tableName: key.namespace, columnName: "id", columnType: "text"}}
+	}
+
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		g := g
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for i := 0; i < iterations; i++ {
+				key := keys[(g+i)%len(keys)]
+				if i%10 == 0 {
+					cache.invalidate(key)
+					continue
+				}
+				columns, generation, ok := cache.get(key, time.Hour, time.Now())
+				if ok {
+					if !assert.Equal(t, columnsOf(key), columns) {
+						return
+					}
+					// callers can modify the returned columns
+					columns[0].columnName = "name"
+					continue
+				}
+				cache.put(key, generation, columnsOf(key), time.Now())
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, key := range keys {
+		cache.invalidate(key)
+		_, _, ok := cache.get(key, time.Hour, time.Now())
+		require.False(t, ok)
+	}
+}
+
+func TestSchemaOf(t *testing.T) {
+	columns := []schemaColumn{
+		{tableName: "tracks", columnName: "ID", columnType: "text"},
+		{tableName: "tracks", columnName: "document", columnType: "xml"},
+		{tableName: "rs_pages", columnName: "id", columnType: "text"},
+	}
+
+	pg := New()
+	WithConfig(pg, config.New())
+	schema, unrecognizedSchema := pg.schemaOf(columns, false)
+	require.Equal(t, model.Schema{
+		"tracks":   {"ID": "string"},
+		"rs_pages": {"id": "string"},
+	}, schema)
+	require.Equal(t, model.Schema{
+		"tracks": {"document": warehouseutils.MISSING_DATATYPE},
+	}, unrecognizedSchema)
+
+	// the same columns shared with another destination are mapped with its own settings
+	c := config.New()
+	c.Set("Warehouse.postgres.lowercaseIdentifiers", true)
+	other := New()
+	WithConfig(other, c)
+	other.Warehouse.Destination.Config = map[string]interface{}{
+		"tablePrefix": "rs_",
+	}
+	schema, _ = other.schemaOf(columns, false)
+	require.Equal(t, model.Schema{
+		"pages": {"id": "string"},
+	}, schema)
+}
+
+func TestFetchSchema_SharedCache(t *testing.T) {
+	misc.Init()
+	warehouseutils.Init()
+
+	const tableName = "test_table"
+
+	db := setupDB(t)
+	ctx := context.Background()
+
+	c := config.New()
+	c.Set("Warehouse.postgres.sharedSchemaCacheTTL", "1h")
+
+	cache := newSharedSchemaCache(10)
+
+	// two destinations loading into the same namespace
+	newDestination := func(destinationID string) (*Postgres, *memstats.Store) {
+		store := memstats.New()
+
+		pg := newTestPostgres(db, c)
+		pg.Warehouse.Destination.ID = destinationID
+		pg.stats = store
+		pg.sharedSchemaCache = cache
+		return pg, store
+	}
+	pg, store := newDestination(testDestID)
+	other, otherStore := newDestination("other_destination")
+	createTestTable(t, pg, tableName)
+
+	cacheResult := func(t *testing.T, pg *Postgres, store *memstats.Store) string {
+		t.Helper()
+
+		var results []string
+		for _, result := range []string{"hit", "miss"} {
+			if store.Get("pg_shared_schema_cache", stats.Tags{
+				"workspaceId":   testWorkspaceID,
+				"namespace":     testNamespace,
+				"destinationID": pg.Warehouse.Destination.ID,
+				"result":        result,
+			}) != nil {
+				results = append(results, result)
+			}
+		}
+		require.Len(t, results, 1)
+		return results[0]
+	}
+	fetchSchema := func(t *testing.T, pg *Postgres, store **memstats.Store) (model.Schema, string) {
+		t.Helper()
+
+		*store = memstats.New()
+		pg.stats = *store
+		schema, _, err := pg.FetchSchema(ctx)
+		require.NoError(t, err)
+		return schema, cacheResult(t, pg, *store)
+	}
+
+	schema, result := fetchSchema(t, pg, &store)
+	require.Equal(t, model.Schema{tableName: testTableSchema}, schema)
+	require.Equal(t, "miss", result)
+
+	t.Run("shared across destinations", func(t *testing.T) {
+		schema, result := fetchSchema(t, other, &otherStore)
+		require.Equal(t, model.Schema{tableName: testTableSchema}, schema)
+		require.Equal(t, "hit", result)
+	})
+
+	t.Run("invalidated after AddColumns", func(t *testing.T) {
+		require.NoError(t, other.AddColumns(ctx, tableName, []warehouseutils.ColumnInfo{{Name: "test_bool", Type: "boolean"}}))
+
+		schema, result := fetchSchema(t, pg, &store)
+		require.Equal(t, "boolean", schema[tableName]["test_bool"])
+		require.Equal(t, "miss", result)
+
+		_, result = fetchSchema(t, other, &otherStore)
+		require.Equal(t, "hit", result)
+	})
+
+	t.Run("invalidated after CreateTable", func(t *testing.T) {
+		require.NoError(t, pg.CreateTable(ctx, "other_table", model.TableSchema{"id": "string"}))
+
+		schema, result := fetchSchema(t, other, &otherStore)
+		require.Equal(t, model.TableSchema{"id": "string"}, schema["other_table"])
+		require.Equal(t, "miss", result)
+	})
+
+	t.Run("concurrent fetches and changes", func(t *testing.T) {
+		const columns = 10
+
+		var wg sync.WaitGroup
+		for i := 0; i < columns; i++ {
+			i := i
+
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				assert.NoError(t, pg.AddColumns(ctx, tableName, []warehouseutils.ColumnInfo{{Name: fmt.Sprintf("column_%d", i), Type: "int"}}))
+			}()
+			go func() {
+				defer wg.Done()
+				_, _, err := other.FetchSchema(ctx)
+				assert.NoError(t, err)
+			}()
+		}
+		wg.Wait()
+
+		// the schema cached by the fetches concurrent with the changes is never stale
+		for _, pg := range []*Postgres{pg, other} {
+			schema, _, err := pg.FetchSchema(ctx)
+			require.NoError(t, err)
+			for i := 0; i < columns; i++ {
+				require.Equal(t, "int", schema[tableName][fmt.Sprintf("column_%d", i)])
+			}
+		}
+	})
+}
@@ -0,0 +1,41 @@
+package postgreslegacy
+
+import (
+	"net"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// setTCPTimeouts sets the user timeout and the keepalive interval and count of the tcp socket, leaving the ones not positive as is.
+func setTCPTimeouts(conn *net.TCPConn, userTimeout, keepalivesInterval time.Duration, keepalivesCount int) error {
+	options := map[int]int{}
+	if userTimeout > 0 {
+		options[unix.TCP_USER_TIMEOUT] = int(userTimeout / time.Millisecond)
+	}
+	if keepalivesInterval > 0 {
+		options[unix.TCP_KEEPINTVL] = int(keepalivesInterval / time.Second)
+	}
+	if keepalivesCount > 0 {
+		options[unix.TCP_KEEPCNT] = keepalivesCount
+	}
+	if len(options) == 0 {
+		return nil
+	}
+
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var setErr error
+	if err := rawConn.Control(func(fd uintptr) {
+		for option, value := range options {
+			if setErr = unix.SetsockoptInt(int(fd), unix.IPPROTO_TCP, option, value); setErr != nil {
+				return
+			}
+		}
+	}); err != nil {
+		return err
+	}
+	return setErr
+}
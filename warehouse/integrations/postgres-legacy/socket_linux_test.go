@@ -0,0 +1,57 @@
+package postgreslegacy
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestSocketDialer_Timeouts(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() { _ = listener.Close() }()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.Close()
+		}
+	}()
+
+	tcpOption := func(conn net.Conn, opt int) int {
+		rawConn, err := conn.(*net.TCPConn).SyscallConn()
+		require.NoError(t, err)
+
+		var value int
+		require.NoError(t, rawConn.Control(func(fd uintptr) {
+			value, err = unix.GetsockoptInt(int(fd), unix.IPPROTO_TCP, opt)
+		}))
+		require.NoError(t, err)
+		return value
+	}
+
+	defaultConn, err := socketDialer{}.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	defer func() { _ = defaultConn.Close() }()
+	require.Zero(t, tcpOption(defaultConn, unix.TCP_USER_TIMEOUT))
+
+	conn, err := socketDialer{
+		dialer:             net.Dialer{KeepAlive: 20 * time.Second},
+		userTimeout:        30 * time.Second,
+		keepalivesInterval: 5 * time.Second,
+		keepalivesCount:    3,
+	}.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	defer func() { _ = conn.Close() }()
+
+	require.Equal(t, 30000, tcpOption(conn, unix.TCP_USER_TIMEOUT))
+	require.Equal(t, 20, tcpOption(conn, unix.TCP_KEEPIDLE))
+	require.Equal(t, 5, tcpOption(conn, unix.TCP_KEEPINTVL))
+	require.Equal(t, 3, tcpOption(conn, unix.TCP_KEEPCNT))
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+
+package postgreslegacy
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// setTCPTimeouts fails if any of the user timeout and the keepalive interval and count are set, as they are only supported on linux.
+func setTCPTimeouts(_ *net.TCPConn, userTimeout, keepalivesInterval time.Duration, keepalivesCount int) error {
+	if userTimeout > 0 || keepalivesInterval > 0 || keepalivesCount > 0 {
+		return errors.New("tcp user timeout and keepalive interval and count are only supported on linux")
+	}
+	return nil
+}
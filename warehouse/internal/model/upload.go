@@ -32,6 +32,8 @@ const (
 	ColumnSizeError           JobErrorType = "column_size_error"
 	InsufficientResourceError JobErrorType = "insufficient_resource_error"
 	ConcurrentQueriesError    JobErrorType = "concurrent_queries_error"
+	StatementTimeoutError     JobErrorType = "statement_timeout_error"
+	ResourceExhaustedError    JobErrorType = "resource_exhausted_error"
 	UnknownError              JobErrorType = "unknown_error"
 	Noop                      JobErrorType = "noop"
 )
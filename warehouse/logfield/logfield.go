@@ -24,6 +24,7 @@ const (
 	ErrorMapping               = "errorMapping"
 	DestinationCredsValid      = "destinationCredsValid"
 	Provider                   = "provider"
+	Region                     = "region"
 	Query                      = "query"
 	QueryExecutionTime         = "queryExecutionTime"
 	StagingTableName           = "stagingTableName"
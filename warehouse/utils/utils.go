@@ -799,6 +799,11 @@ func WHCounterStat(name string, warehouse *model.Warehouse, extraTags ...Tag) st
 	return stats.Default.NewTaggedStat(name, stats.CountType, tags)
 }
 
+// FormatSSLFile formats the ssl key or certificate of the destination config as written to the files by WriteSSLKeys.
+func FormatSSLFile(content string) string {
+	return formatSSLFile(content)
+}
+
 func formatSSLFile(content string) (formattedContent string) {
 	formattedContent = strings.ReplaceAll(content, "\n", "")
 	// Add new line at the end of -----BEGIN CERTIFICATE-----
@@ -834,12 +839,18 @@ func (err *WriteSSLKeyError) GetErrTag() string {
 // already written to the file system, writes to the file system if the
 // content is not already written
 func WriteSSLKeys(destination backendconfig.DestinationT) WriteSSLKeyError {
-	var err error
-	var existingChecksum string
-	var directoryName string
-	if directoryName, err = misc.CreateTMPDIR(); err != nil {
+	directoryName, err := misc.CreateTMPDIR()
+	if err != nil {
 		return WriteSSLKeyError{fmt.Sprintf("Error creating SSL root TMP directory for destination %v", err), "tmp_dir_failure"}
 	}
+	return WriteSSLKeysToDir(destination, fmt.Sprintf("%s/dest-ssls/%s", directoryName, destination.ID))
+}
+
+// WriteSSLKeysToDir writes the ssl key(s) present in the destination config
+// to the given directory, creating it if needed
+func WriteSSLKeysToDir(destination backendconfig.DestinationT, sslDirPath string) WriteSSLKeyError {
+	var err error
+	var existingChecksum string
 	clientKeyConfig := destination.Config["clientKey"]
 	clientCertConfig := destination.Config["clientCert"]
 	serverCAConfig := destination.Config["serverCA"]
@@ -849,7 +860,6 @@ func WriteSSLKeys(destination backendconfig.DestinationT) WriteSSLKeyError {
 	clientKey := formatSSLFile(clientKeyConfig.(string))
 	clientCert := formatSSLFile(clientCertConfig.(string))
 	serverCert := formatSSLFile(serverCAConfig.(string))
-	sslDirPath := fmt.Sprintf("%s/dest-ssls/%s", directoryName, destination.ID)
 	if err = os.MkdirAll(sslDirPath, 0o700); err != nil {
 		return WriteSSLKeyError{fmt.Sprintf("Error creating SSL root directory for destination %s %v", destination.ID, err), "dest_ssl_create_err"}
 	}